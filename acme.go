@@ -0,0 +1,354 @@
+//go:build acme
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Certificates from an ACME CA, for -acme-domains. Wikis reachable from
+// the internet get them from Let's Encrypt with the TLS-ALPN-01 challenge,
+// on the HTTPS port itself, or HTTP-01 with -acme-http-addr. Those that
+// aren't use DNS-01: -acme-dns-hook is run as
+//
+//	hook present _acme-challenge.wiki.example.com. <value>
+//	hook cleanup _acme-challenge.wiki.example.com. <value>
+//
+// to add and remove the TXT record, the way lego's exec provider does, so
+// any DNS provider can be scripted. -acme-directory points at another CA,
+// such as an internal step-ca, and -acme-ca at the roots to trust it by.
+
+var acmeFlags struct {
+	domains   string
+	email     string
+	cacheDir  string
+	directory string
+	caFile    string
+	httpAddr  string
+	dnsHook   string
+	dnsWait   time.Duration
+}
+
+func init() {
+	flag.StringVar(&acmeFlags.domains, "acme-domains", "", "comma-separated host names to get an ACME certificate for; enables HTTPS")
+	flag.StringVar(&acmeFlags.email, "acme-email", "", "contact address of the ACME account")
+	flag.StringVar(&acmeFlags.cacheDir, "acme-cache", "acme", "directory keeping the ACME account and certificates")
+	flag.StringVar(&acmeFlags.directory, "acme-directory", "", "directory URL of the ACME CA; Let's Encrypt by default")
+	flag.StringVar(&acmeFlags.caFile, "acme-ca", "", "PEM file of the roots the ACME CA's own certificate is checked against")
+	flag.StringVar(&acmeFlags.httpAddr, "acme-http-addr", "", "address to answer HTTP-01 challenges on, e.g. :80")
+	flag.StringVar(&acmeFlags.dnsHook, "acme-dns-hook", "", "command setting the TXT records of DNS-01 challenges")
+	flag.DurationVar(&acmeFlags.dnsWait, "acme-dns-wait", 30*time.Second, "how long DNS-01 records get to propagate")
+	acmeTLS = acmeConfig
+}
+
+// acmeRenewBefore is how long before it expires a certificate is renewed.
+const acmeRenewBefore = 30 * 24 * time.Hour
+
+// acmeConfig returns the TLS configuration getting certificates from the
+// CA, or nil without -acme-domains.
+func acmeConfig() (*tls.Config, error) {
+	if acmeFlags.domains == "" {
+		return nil, nil
+	}
+	var domains []string
+	for _, d := range strings.Split(acmeFlags.domains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	if strings.Contains(acmeFlags.domains, "*") && acmeFlags.dnsHook == "" {
+		return nil, errors.New("wildcard certificates need -acme-dns-hook")
+	}
+	if err := os.MkdirAll(acmeFlags.cacheDir, 0700); err != nil {
+		return nil, err
+	}
+	client, err := acmeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if acmeFlags.dnsHook == "" {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(acmeFlags.cacheDir),
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Email:      acmeFlags.email,
+			Client:     client,
+		}
+		if acmeFlags.httpAddr != "" {
+			go func() {
+				log.Fatal(http.ListenAndServe(acmeFlags.httpAddr, m.HTTPHandler(nil)))
+			}()
+		}
+		return m.TLSConfig(), nil
+	}
+
+	dm := &dnsManager{client: client, domains: domains}
+	if err := dm.start(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetCertificate: dm.getCertificate}, nil
+}
+
+// acmeClient returns the client of the CA, trusting the roots of -acme-ca
+// if given. Its account key is kept in the cache directory.
+func acmeClient() (*acme.Client, error) {
+	client := &acme.Client{DirectoryURL: acmeFlags.directory, UserAgent: "gowiki"}
+	if acmeFlags.caFile != "" {
+		data, err := os.ReadFile(acmeFlags.caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", acmeFlags.caFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+		client.HTTPClient = &http.Client{Transport: transport, Timeout: time.Minute}
+	}
+	return client, nil
+}
+
+// dnsManager gets a certificate for its domains with DNS-01 challenges and
+// renews it in the background.
+type dnsManager struct {
+	client  *acme.Client
+	domains []string
+	cert    atomic.Pointer[tls.Certificate]
+}
+
+func (dm *dnsManager) certPath() string { return filepath.Join(acmeFlags.cacheDir, "dns01.pem") }
+
+// start uses the certificate in the cache, or gets one before the wiki
+// starts serving, then keeps renewing it.
+func (dm *dnsManager) start() error {
+	if cert, err := loadCertificate(dm.certPath()); err == nil && sameNames(cert.Leaf, dm.domains) {
+		dm.cert.Store(cert)
+	}
+	if dm.renewDue() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := dm.obtain(ctx); err != nil {
+			if dm.cert.Load() == nil {
+				return fmt.Errorf("acme: %v", err)
+			}
+			log.Printf("acme: renewing the certificate, trying again later: %v", err)
+		}
+	}
+	go func() {
+		for range time.Tick(12 * time.Hour) {
+			if !dm.renewDue() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			if err := dm.obtain(ctx); err != nil {
+				log.Printf("acme: renewing the certificate, trying again later: %v", err)
+			}
+			cancel()
+		}
+	}()
+	return nil
+}
+
+func (dm *dnsManager) renewDue() bool {
+	cert := dm.cert.Load()
+	return cert == nil || time.Until(cert.Leaf.NotAfter) < acmeRenewBefore
+}
+
+func (dm *dnsManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return dm.cert.Load(), nil
+}
+
+// obtain orders a certificate, answering the challenges with TXT records
+// set by the hook, and keeps it in the cache.
+func (dm *dnsManager) obtain(ctx context.Context) error {
+	key, err := accountKey(filepath.Join(acmeFlags.cacheDir, "account.key"))
+	if err != nil {
+		return err
+	}
+	dm.client.Key = key
+	account := &acme.Account{}
+	if acmeFlags.email != "" {
+		account.Contact = []string{"mailto:" + acmeFlags.email}
+	}
+	if _, err := dm.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("registering: %v", err)
+	}
+
+	order, err := dm.client.AuthorizeOrder(ctx, acme.DomainIDs(dm.domains...))
+	if err != nil {
+		return err
+	}
+	for _, u := range order.AuthzURLs {
+		if err := dm.authorize(ctx, u); err != nil {
+			return err
+		}
+	}
+	if order, err = dm.client.WaitOrder(ctx, order.URI); err != nil {
+		return err
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: dm.domains}, certKey)
+	if err != nil {
+		return err
+	}
+	der, _, err := dm.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+	cert, err := saveCertificate(dm.certPath(), der, certKey)
+	if err != nil {
+		return err
+	}
+	dm.cert.Store(cert)
+	log.Printf("acme: certificate for %s valid until %s", strings.Join(dm.domains, ", "), cert.Leaf.NotAfter.Format(time.DateOnly))
+	return nil
+}
+
+// authorize answers the DNS-01 challenge of an authorization.
+func (dm *dnsManager) authorize(ctx context.Context, u string) error {
+	z, err := dm.client.GetAuthorization(ctx, u)
+	if err != nil {
+		return err
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("the CA offers no DNS-01 challenge for %s", z.Identifier.Value)
+	}
+	value, err := dm.client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	name := "_acme-challenge." + strings.TrimPrefix(z.Identifier.Value, "*.") + "."
+	if err := runDNSHook(ctx, "present", name, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := runDNSHook(context.Background(), "cleanup", name, value); err != nil {
+			log.Printf("acme: %v", err)
+		}
+	}()
+
+	select {
+	case <-time.After(acmeFlags.dnsWait):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if _, err := dm.client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = dm.client.WaitAuthorization(ctx, z.URI)
+	return err
+}
+
+func runDNSHook(ctx context.Context, action, name, value string) error {
+	out, err := exec.CommandContext(ctx, acmeFlags.dnsHook, action, name, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s %s: %v: %s", acmeFlags.dnsHook, action, name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// accountKey loads the account's key, creating it the first time.
+func accountKey(file string) (crypto.Signer, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return key, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no key found", file)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// saveCertificate writes the key and chain to file, as one PEM file.
+func saveCertificate(file string, der [][]byte, key *ecdsa.PrivateKey) (*tls.Certificate, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, b := range der {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return nil, err
+	}
+	return loadCertificate(file)
+}
+
+func loadCertificate(file string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	// X509KeyPair fills in Leaf since Go 1.23
+	return &cert, nil
+}
+
+// sameNames reports whether cert is for exactly the domains.
+func sameNames(cert *x509.Certificate, domains []string) bool {
+	if cert == nil || len(cert.DNSNames) != len(domains) {
+		return false
+	}
+	for i, d := range domains {
+		if cert.DNSNames[i] != d {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultTemplates is a copy of the stock theme, written out on first run
+// when a wiki has no templates of its own.
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+var starterPage = []byte(`Welcome to your new wiki!
+
+This is the Home page. Use the edit link above to change it, or visit
+/view/SomeTitle to start a new page.`)
+
+// bootstrap prepares a wiki's files on first run: it creates the data
+// directory with a starter Home page, and writes the default templates into
+// any template directory that has none, unless the wiki is headless and
+// loads none.
+func bootstrap(wc WikiConfig) error {
+	if !wc.Headless {
+		if err := writeDefaultTemplates("templates/layouts", wc.TemplateLayoutPath, ""); err != nil {
+			return err
+		}
+		if err := writeDefaultTemplates("templates", wc.TemplateIncludePath, "templates/layouts"); err != nil {
+			return err
+		}
+	}
+
+	pages, err := filepath.Glob(filepath.Join(wc.DataDir, "*.txt"))
+	if err != nil {
+		return err
+	}
+	if len(pages) > 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(wc.DataDir, 0700); err != nil {
+		return err
+	}
+	log.Printf("%s: no pages found, writing a starter Home page to %s", wc.Name, wc.DataDir)
+	return os.WriteFile(filepath.Join(wc.DataDir, "Home.txt"), starterPage, 0600)
+}
+
+// writeDefaultTemplates copies the embedded templates of src into dir,
+// subdirectories included, unless dir already holds templates. skip names
+// an embedded directory to leave out, the layouts being copied separately.
+func writeDefaultTemplates(src, dir, skip string) error {
+	existing, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+
+	log.Printf("no templates found in %s, writing the defaults", dir)
+
+	return fs.WalkDir(defaultTemplates, src, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(file, src), "/")
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+
+		if d.IsDir() {
+			if file == skip {
+				return fs.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+
+		data, err := defaultTemplates.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
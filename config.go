@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ondoheer/gowiki/wiki"
+)
+
+// Config is everything the process needs to start: how to listen, and which
+// wikis to serve.
+type Config struct {
+	Server ServerConfig
+	Wikis  []WikiConfig `json:"wikis"`
+}
+
+// ServerConfig holds the settings that shape how the process is exposed over HTTP.
+type ServerConfig struct {
+	Addr string
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+
+	// ShutdownTimeout is how long in-flight requests get to finish on SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile enable HTTPS, and with it HTTP/2.
+	TLSCertFile string
+	TLSKeyFile  string
+	// H2C accepts cleartext HTTP/2 (with prior knowledge) on a plain
+	// listener. Only enable it behind a trusted proxy that terminates TLS.
+	H2C bool
+}
+
+// WikiConfig is the configuration block of a single wiki. Several of them
+// can share one process, each picked by host name and/or path prefix.
+type WikiConfig struct {
+	Name string `json:"name"`
+	// Host selects this wiki by the request's host name. Empty matches any host.
+	Host string `json:"host"`
+
+	wiki.Config
+}
+
+// loadConfiguration reads the command line flags and, when -config is
+// given, the JSON file listing the wikis to serve. Without a file a single
+// wiki is built from the flags.
+func loadConfiguration() *Config {
+	var cfg Config
+	var configFile string
+	var defaults WikiConfig
+
+	flag.StringVar(&configFile, "config", "", "JSON file describing the wikis to serve")
+	flag.StringVar(&cfg.Server.Addr, "addr", ":8080", "address to listen on")
+	flag.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", 10*time.Second, "maximum duration for reading a request")
+	flag.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", 30*time.Second, "maximum duration before timing out writes of a response")
+	flag.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", 120*time.Second, "how long keep-alive connections are kept open while idle")
+	flag.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests when stopping")
+	flag.StringVar(&cfg.Server.TLSCertFile, "tls-cert", "", "TLS certificate file; enables HTTPS and HTTP/2")
+	flag.StringVar(&cfg.Server.TLSKeyFile, "tls-key", "", "TLS private key file")
+	flag.BoolVar(&cfg.Server.H2C, "h2c", false, "accept cleartext HTTP/2 from a trusted proxy")
+	flag.StringVar(&defaults.BasePath, "base", "", "path prefix the wiki is served under, e.g. /wiki")
+	flag.StringVar(&defaults.DataDir, "data", "data", "directory the pages are stored in")
+	flag.StringVar(&defaults.StaticDir, "static", "static", "directory served under /static/")
+	flag.Int64Var(&defaults.MaxBodyBytes, "max-body", 1<<20, "maximum size in bytes of a submitted page")
+	flag.Int64Var(&defaults.MaxPageBytes, "max-page", 8<<20, "maximum size in bytes of a page shown in the browser")
+	flag.BoolVar(&defaults.ReadOnly, "read-only", false, "start every wiki in read-only mode")
+	flag.BoolVar(&defaults.Headless, "headless", false, "serve only the JSON API of every wiki, without templates")
+	flag.IntVar(&defaults.Limits.MaxLinks, "max-links", 10000, "maximum number of links in a page")
+	flag.IntVar(&defaults.Limits.MaxDirectives, "max-directives", 50, "maximum number of query and pages directives in a page")
+	renderTimeout := flag.Duration("render-timeout", 5*time.Second, "maximum duration for rendering a page")
+	flag.Parse()
+	defaults.Limits.RenderMillis = int(renderTimeout.Milliseconds())
+
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+
+	defaults.Name = "default"
+	defaults.TemplateLayoutPath = "templates/layouts/"
+	defaults.TemplateIncludePath = "templates/"
+
+	if configFile == "" {
+		defaults.JobDir = filepath.Join(defaults.DataDir, ".jobs")
+		cfg.Wikis = []WikiConfig{defaults}
+		return &cfg
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Fatalf("%s: %v", configFile, err)
+	}
+	if len(cfg.Wikis) == 0 {
+		log.Fatalf("%s: no wikis configured", configFile)
+	}
+
+	seen := make(map[string]string)
+	for i := range cfg.Wikis {
+		wc := &cfg.Wikis[i]
+		wc.fillDefaults(defaults)
+
+		key := wc.Host + wiki.CleanBasePath(wc.BasePath)
+		if other, ok := seen[key]; ok {
+			log.Fatalf("%s: wikis %q and %q are both mounted at %q", configFile, other, wc.Name, key)
+		}
+		seen[key] = wc.Name
+	}
+
+	return &cfg
+}
+
+// fillDefaults completes a wiki block from the file with the values given
+// on the command line. Each wiki gets its own data directory by default.
+func (wc *WikiConfig) fillDefaults(defaults WikiConfig) {
+	if wc.Name == "" {
+		wc.Name = fmt.Sprintf("%s%s", wc.Host, wc.BasePath)
+	}
+	if wc.DataDir == "" {
+		wc.DataDir = filepath.Join(defaults.DataDir, wc.Name)
+	}
+	if wc.TemplateLayoutPath == "" {
+		wc.TemplateLayoutPath = defaults.TemplateLayoutPath
+	}
+	if wc.TemplateIncludePath == "" {
+		wc.TemplateIncludePath = defaults.TemplateIncludePath
+	}
+	if wc.StaticDir == "" {
+		wc.StaticDir = defaults.StaticDir
+	}
+	if wc.JobDir == "" {
+		wc.JobDir = filepath.Join(wc.DataDir, ".jobs")
+	}
+	if wc.MaxBodyBytes == 0 {
+		wc.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+	if wc.MaxPageBytes == 0 {
+		wc.MaxPageBytes = defaults.MaxPageBytes
+	}
+	if wc.Limits.MaxLinks == 0 {
+		wc.Limits.MaxLinks = defaults.Limits.MaxLinks
+	}
+	if wc.Limits.MaxDirectives == 0 {
+		wc.Limits.MaxDirectives = defaults.Limits.MaxDirectives
+	}
+	if wc.Limits.RenderMillis == 0 {
+		wc.Limits.RenderMillis = defaults.Limits.RenderMillis
+	}
+	wc.ReadOnly = wc.ReadOnly || defaults.ReadOnly
+	wc.Headless = wc.Headless || defaults.Headless
+}
@@ -3,213 +3,90 @@
 package main
 
 import (
-	"errors"
-	"fmt"
-	"html/template"
-	"io/ioutil"
+	"crypto/tls"
+	"expvar"
 	"log"
 	"net/http"
-	"path/filepath"
-	"regexp"
+	// time zones work on hosts without a zone database too
+	_ "time/tzdata"
 
-	"github.com/oxtoacart/bpool" // A common use case for this package is to use buffers to execute HTML templates against (via ExecuteTemplate)
-	//or encode JSON into (via json.NewEncoder).
-	//This allows you to catch any rendering or marshalling errors prior to writing to a http.ResponseWriter,
-	//which helps to avoid writing incomplete or malformed data to the response.
+	"github.com/ondoheer/gowiki/wiki"
 )
 
-// var templateBaseDir = "templates"
-var dataBaseDir = "data"
+// tracer is handed to every wiki; it stays nil unless built with -tags otel.
+var tracer wiki.Tracer
 
-var templates map[string]*template.Template
-var bufpool *bpool.BufferPool
+// startGRPC serves the gRPC API of the wikis and returns the function
+// stopping it; it stays nil unless built with -tags grpc.
+var startGRPC func(cfg ServerConfig, servers map[string]*wiki.Server) (stop func())
 
-type Page struct {
-	Title string
-	Body  []byte
-}
-
-type TemplateConfig struct {
-	TemplateLayoutPath  string
-	TemplateIncludePath string
-}
-
-var mainTempl = `{{define "main" }} {{ template "base" . }} {{ end }}`
-var templateConfig TemplateConfig
-
-func loadConfiguration() {
-	templateConfig.TemplateLayoutPath = "templates/layouts/"
-	templateConfig.TemplateIncludePath = "templates/"
-}
-
-func loadTemplates() {
-	if templates == nil {
-		templates = make(map[string]*template.Template)
-	}
-
-	layoutFiles, err := filepath.Glob(templateConfig.TemplateLayoutPath + "*.html")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	includeFiles, err := filepath.Glob(templateConfig.TemplateIncludePath + "*.html")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	mainTemplate := template.New("main")
+// acmeTLS returns the TLS configuration getting certificates from an ACME
+// CA, or nil when none is asked for; it stays nil unless built with
+// -tags acme.
+var acmeTLS func() (*tls.Config, error)
 
-	mainTemplate, err = mainTemplate.Parse(mainTempl)
-
-	if err != nil {
-		log.Fatal(err)
-	}
+func main() {
 
-	for _, file := range includeFiles {
-		fileName := filepath.Base(file)
-		files := append(layoutFiles, file)
+	cfg := loadConfiguration()
 
-		templates[fileName], err = mainTemplate.Clone()
+	router := &tenantRouter{}
+	servers := make(map[string]*wiki.Server)
+	for _, wc := range cfg.Wikis {
+		if err := bootstrap(wc); err != nil {
+			log.Fatalf("%s: %v", wc.Name, err)
+		}
 
+		wc.Tracer = tracer
+		srv, err := wiki.New(wc.Config)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("%s: %v", wc.Name, err)
 		}
-
-		templates[fileName] = template.Must(templates[fileName].ParseFiles(files...))
-	}
-	log.Println("Templates loades successfully")
-
-	bufpool = bpool.NewBufferPool(64)
-	log.Println("buffer allocation succesful")
-
-}
-
-func renderTemplate(w http.ResponseWriter, name string, data interface{}) {
-	tmpl, ok := templates[name]
-
-	if !ok {
-		http.Error(w, fmt.Sprintf("the template %s does not exist", name),
-			http.StatusInternalServerError)
+		router.add(wc, srv)
+		servers[wc.Name] = srv
 	}
 
-	buf := bufpool.Get()
-	defer bufpool.Put(buf)
-
-	err := tmpl.Execute(buf, data)
-
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	buf.WriteTo(w)
-}
-
-func generateArticlePath(title string) string {
-	return filepath.Join(dataBaseDir, title+".txt")
-}
-
-// Globals
-
-var validPath = regexp.MustCompile("^/(edit|save|view)/([a-zA-Z0-9]+)$")
-
-func (p *Page) save() error {
-
-	filename := generateArticlePath(p.Title)
-
-	return ioutil.WriteFile(filename, p.Body, 0600)
-
-}
-
-func getTitle(w http.ResponseWriter, r *http.Request) (string, error) {
-	m := validPath.FindStringSubmatch(r.URL.Path)
-
-	if m == nil {
-		http.NotFound(w, r)
-		return "", errors.New("Invalid Page Title")
-	}
-
-	return m[2], nil // the title is the second subexpression
-}
-
-func loadPage(title string) (*Page, error) {
-
-	filename := generateArticlePath(title)
-
-	body, err := ioutil.ReadFile(filename)
-
-	if err != nil {
-
-		return nil, err
-
+	reload := func() {
+		for name, srv := range servers {
+			if err := srv.ReloadTemplates(); err != nil {
+				log.Printf("%s: keeping the current templates: %v", name, err)
+			}
+		}
 	}
 
-	return &Page{Title: title, Body: body}, nil
-
-}
-
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, "index.html", nil)
-}
-
-func viewHandler(w http.ResponseWriter, r *http.Request, title string) {
-
-	p, err := loadPage(title)
-
-	// if this page does not exists, go to the editor to create it
-	if err != nil {
-		http.Redirect(w, r, "/edit/"+title, http.StatusFound)
-		return
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		for name, srv := range servers {
+			if !srv.Ready() {
+				http.Error(w, name+" is warming up", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("/", router)
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           wiki.Chain(mux, wiki.RequestID, wiki.LogRequests),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 	}
 
-	renderTemplate(w, "view.html", p)
-
-}
-
-func editHandler(w http.ResponseWriter, r *http.Request, title string) {
-
-	p, err := loadPage(title)
-	if err != nil {
-		p = &Page{Title: title}
+	stopGRPC := func() {}
+	if startGRPC != nil {
+		stopGRPC = startGRPC(cfg.Server, servers)
 	}
-	renderTemplate(w, "edit.html", p)
-}
-
-func saveHandler(w http.ResponseWriter, r *http.Request, title string) {
-
-	body := r.FormValue("body")
-	p := &Page{Title: title, Body: []byte(body)}
-	err := p.save()
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := serve(srv, cfg.Server, reload); err != nil {
+		log.Fatal(err)
 	}
-	http.Redirect(w, r, "/view/"+title, http.StatusFound)
-}
+	stopGRPC()
 
-func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Here we will extract the page title from the Request,
-		// and call the provided handler 'fn'
-		m := validPath.FindStringSubmatch(r.URL.Path)
-		if m == nil {
-			http.NotFound(w, r)
-			return
-		}
-		fn(w, r, m[2])
+	for _, srv := range servers {
+		srv.Close()
 	}
-}
-
-func main() {
-
-	loadConfiguration()
-	loadTemplates()
-
-	http.HandleFunc("/", indexHandler)
-	http.HandleFunc("/view/", makeHandler(viewHandler))
-	http.HandleFunc("/edit/", makeHandler(editHandler))
-	http.HandleFunc("/save/", makeHandler(saveHandler))
-
-	http.ListenAndServe(":8080", nil)
 
 }
@@ -0,0 +1,509 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/ondoheer/gowiki/wiki"
+)
+
+// The gRPC API of proto/gowiki/v1/wiki.proto, for internal services. It
+// listens on -grpc-addr, with the TLS certificate of the HTTP server if
+// there is one, and calls the same wiki.Server methods the web pages use.
+// The messages are encoded by hand with protowire, so the build needs no
+// generated code; clients generate theirs from the .proto file.
+
+var grpcAddr string
+
+func init() {
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "address to serve the gRPC API on; needs GOWIKI_GRPC_TOKEN")
+	startGRPC = serveGRPC
+}
+
+// serveGRPC starts the gRPC server when -grpc-addr is given, and returns
+// the function stopping it.
+func serveGRPC(cfg ServerConfig, servers map[string]*wiki.Server) func() {
+	if grpcAddr == "" {
+		return func() {}
+	}
+	token := os.Getenv("GOWIKI_GRPC_TOKEN")
+	if token == "" {
+		log.Fatal("-grpc-addr needs GOWIKI_GRPC_TOKEN to be set")
+	}
+	user := os.Getenv("GOWIKI_GRPC_USER")
+	if user == "" {
+		user = "grpc"
+	}
+
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(wireCodec{}), grpc.UnaryInterceptor(logCalls)}
+	if cfg.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	gs := grpc.NewServer(opts...)
+	gs.RegisterService(&wikiServiceDesc, &grpcService{servers: servers, token: token, user: user})
+
+	ln, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving gRPC on %s", ln.Addr())
+	go func() {
+		if err := gs.Serve(ln); err != nil {
+			log.Printf("gRPC: %v", err)
+		}
+	}()
+	return gs.GracefulStop
+}
+
+func logCalls(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Printf("grpc %s %s %s", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+type grpcService struct {
+	servers map[string]*wiki.Server
+	token   string
+	user    string
+}
+
+// wiki checks the token of a call and returns the wiki it is for, with
+// the context of its user.
+func (g *grpcService) wiki(ctx context.Context) (*wiki.Server, context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := ""
+	if v := md.Get("authorization"); len(v) > 0 {
+		auth = v[0]
+	}
+	given, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(g.token)) != 1 {
+		return nil, nil, status.Error(codes.Unauthenticated, "a valid bearer token is needed")
+	}
+
+	var srv *wiki.Server
+	if names := md.Get("wiki"); len(names) > 0 {
+		srv = g.servers[names[0]]
+	} else if len(g.servers) == 1 {
+		for _, s := range g.servers {
+			srv = s
+		}
+	}
+	if srv == nil {
+		return nil, nil, status.Error(codes.NotFound, "name one of the wikis served in the wiki metadata")
+	}
+	return srv, wiki.WithUser(ctx, &wiki.User{Name: g.user, Role: wiki.RoleEditor}), nil
+}
+
+// grpcError turns the errors of wiki.Server into statuses.
+func grpcError(err error) error {
+	var e *wiki.Error
+	if !errors.As(err, &e) || e.Status >= http.StatusInternalServerError && e.Status != http.StatusServiceUnavailable && e.Status != http.StatusInsufficientStorage {
+		log.Printf("grpc: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+	code := codes.Unknown
+	switch e.Status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized, http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.Aborted
+	case http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		code = codes.ResourceExhausted
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, e.Message)
+}
+
+func (g *grpcService) getPage(ctx context.Context, req *titleRequest) (*pageMessage, error) {
+	srv, ctx, err := g.wiki(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := srv.GetPage(ctx, req.Title)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pageMessage{p}, nil
+}
+
+func (g *grpcService) listPages(ctx context.Context, req *emptyMessage) (*pageList, error) {
+	srv, ctx, err := g.wiki(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := srv.ListPages(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pageList{pages}, nil
+}
+
+// writePage makes the method creating or updating pages, as op says.
+func writePage(op string) func(*grpcService, context.Context, *writeRequest) (*revisionMessage, error) {
+	return func(g *grpcService, ctx context.Context, req *writeRequest) (*revisionMessage, error) {
+		srv, ctx, err := g.wiki(ctx)
+		if err != nil {
+			return nil, err
+		}
+		bop := &wiki.BatchOperation{Op: op, Title: req.Title, Body: req.Body, Tags: req.Tags, BaseRevision: int(req.BaseRevision)}
+		if req.SetTags && bop.Tags == nil {
+			bop.Tags = []string{}
+		}
+		rev, err := srv.ChangePage(ctx, bop)
+		if err != nil {
+			if op == wiki.OpCreate && status.Code(grpcError(err)) == codes.Aborted {
+				return nil, status.Error(codes.AlreadyExists, "The page already exists.")
+			}
+			return nil, grpcError(err)
+		}
+		return &revisionMessage{int64(rev)}, nil
+	}
+}
+
+func (g *grpcService) deletePage(ctx context.Context, req *titleRequest) (*emptyMessage, error) {
+	srv, ctx, err := g.wiki(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := srv.ChangePage(ctx, &wiki.BatchOperation{Op: wiki.OpDelete, Title: req.Title}); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptyMessage{}, nil
+}
+
+func (g *grpcService) listRevisions(ctx context.Context, req *titleRequest) (*pageList, error) {
+	srv, ctx, err := g.wiki(ctx)
+	if err != nil {
+		return nil, err
+	}
+	revs, err := srv.PageRevisions(ctx, req.Title)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pageList{revs}, nil
+}
+
+func (g *grpcService) getRevision(ctx context.Context, req *revisionRequest) (*pageMessage, error) {
+	srv, ctx, err := g.wiki(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := srv.PageRevision(ctx, req.Title, int(req.Revision))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pageMessage{p}, nil
+}
+
+func (g *grpcService) search(ctx context.Context, req *searchRequest) (*searchResponse, error) {
+	srv, ctx, err := g.wiki(ctx)
+	if err != nil {
+		return nil, err
+	}
+	q := wiki.SearchQuery{Text: req.Query, Tags: req.Tags, Sort: req.Sort}
+	results, total, err := srv.Search(ctx, q, int(req.Limit))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &searchResponse{Total: total, Results: results}, nil
+}
+
+var wikiServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gowiki.v1.Wiki",
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unary("GetPage", (*grpcService).getPage),
+		unary("ListPages", (*grpcService).listPages),
+		unary("CreatePage", writePage(wiki.OpCreate)),
+		unary("UpdatePage", writePage(wiki.OpUpdate)),
+		unary("DeletePage", (*grpcService).deletePage),
+		unary("Search", (*grpcService).search),
+		unary("ListRevisions", (*grpcService).listRevisions),
+		unary("GetRevision", (*grpcService).getRevision),
+	},
+	Metadata: "proto/gowiki/v1/wiki.proto",
+}
+
+// unary describes a method taking a Req and answering with a Resp.
+func unary[Req any, PReq interface {
+	*Req
+	unmarshaler
+}, Resp marshaler](name string, call func(*grpcService, context.Context, PReq) (Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := PReq(new(Req))
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return call(srv.(*grpcService), ctx, req.(PReq))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/gowiki.v1.Wiki/" + name}, handler)
+		},
+	}
+}
+
+// The messages. Requests only decode and responses only encode, which is
+// all the server needs.
+
+type marshaler interface{ marshal() []byte }
+type unmarshaler interface{ unmarshal([]byte) error }
+
+// wireCodec encodes the messages in the protobuf wire format.
+type wireCodec struct{}
+
+func (wireCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(marshaler)
+	if !ok {
+		return nil, fmt.Errorf("grpc: can't encode %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (wireCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(unmarshaler)
+	if !ok {
+		return fmt.Errorf("grpc: can't decode %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (wireCodec) Name() string { return "proto" }
+
+// eachField calls fn with the fields of a message, skipping those of
+// other wire types than varints and bytes.
+func eachField(b []byte, fn func(num protowire.Number, varint uint64, bytes []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var varint uint64
+		var bytes []byte
+		switch typ {
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.VarintType || typ == protowire.BytesType {
+			fn(num, varint, bytes)
+		}
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(b, num, protowire.BytesType), m)
+}
+
+// appendTime appends a google.protobuf.Timestamp.
+func appendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	ts := appendVarint(nil, 1, uint64(t.Unix()))
+	ts = appendVarint(ts, 2, uint64(t.Nanosecond()))
+	return appendMessage(b, num, ts)
+}
+
+func boolVarint(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// titleRequest is GetPageRequest, DeletePageRequest and
+// ListRevisionsRequest.
+type titleRequest struct{ Title string }
+
+func (m *titleRequest) unmarshal(b []byte) error {
+	return eachField(b, func(num protowire.Number, _ uint64, bytes []byte) {
+		if num == 1 {
+			m.Title = string(bytes)
+		}
+	})
+}
+
+// emptyMessage is ListPagesRequest and DeletePageResponse.
+type emptyMessage struct{}
+
+func (*emptyMessage) unmarshal(b []byte) error {
+	return eachField(b, func(protowire.Number, uint64, []byte) {})
+}
+func (*emptyMessage) marshal() []byte { return nil }
+
+// writeRequest is WritePageRequest.
+type writeRequest struct {
+	Title, Body  string
+	Tags         []string
+	SetTags      bool
+	BaseRevision int64
+}
+
+func (m *writeRequest) unmarshal(b []byte) error {
+	return eachField(b, func(num protowire.Number, varint uint64, bytes []byte) {
+		switch num {
+		case 1:
+			m.Title = string(bytes)
+		case 2:
+			m.Body = string(bytes)
+		case 3:
+			m.Tags = append(m.Tags, string(bytes))
+		case 4:
+			m.SetTags = varint != 0
+		case 5:
+			m.BaseRevision = int64(varint)
+		}
+	})
+}
+
+// revisionRequest is GetRevisionRequest.
+type revisionRequest struct {
+	Title    string
+	Revision int64
+}
+
+func (m *revisionRequest) unmarshal(b []byte) error {
+	return eachField(b, func(num protowire.Number, varint uint64, bytes []byte) {
+		switch num {
+		case 1:
+			m.Title = string(bytes)
+		case 2:
+			m.Revision = int64(varint)
+		}
+	})
+}
+
+// searchRequest is SearchRequest.
+type searchRequest struct {
+	Query, Sort string
+	Tags        []string
+	Limit       int32
+}
+
+func (m *searchRequest) unmarshal(b []byte) error {
+	return eachField(b, func(num protowire.Number, varint uint64, bytes []byte) {
+		switch num {
+		case 1:
+			m.Query = string(bytes)
+		case 2:
+			m.Tags = append(m.Tags, string(bytes))
+		case 3:
+			m.Sort = string(bytes)
+		case 4:
+			m.Limit = int32(varint)
+		}
+	})
+}
+
+// pageMessage is Page.
+type pageMessage struct{ *wiki.Page }
+
+func (m *pageMessage) marshal() []byte {
+	p := m.Page
+	b := appendString(nil, 1, p.Title)
+	b = appendString(b, 2, string(p.Body))
+	b = appendString(b, 3, p.ID)
+	b = appendTime(b, 4, p.CreatedAt)
+	b = appendTime(b, 5, p.UpdatedAt)
+	b = appendString(b, 6, p.Author)
+	b = appendVarint(b, 7, uint64(p.Revision))
+	for _, t := range p.Tags {
+		b = appendString(b, 8, t)
+	}
+	return appendVarint(b, 9, boolVarint(p.Archived))
+}
+
+// pageList is ListPagesResponse and ListRevisionsResponse.
+type pageList struct{ pages []*wiki.Page }
+
+func (m *pageList) marshal() []byte {
+	var b []byte
+	for _, p := range m.pages {
+		b = appendMessage(b, 1, (&pageMessage{p}).marshal())
+	}
+	return b
+}
+
+// revisionMessage is WritePageResponse.
+type revisionMessage struct{ Revision int64 }
+
+func (m *revisionMessage) marshal() []byte {
+	return appendVarint(nil, 1, uint64(m.Revision))
+}
+
+// searchResponse is SearchResponse.
+type searchResponse struct {
+	Total   int
+	Results []*wiki.SearchResult
+}
+
+func (m *searchResponse) marshal() []byte {
+	b := appendVarint(nil, 1, uint64(m.Total))
+	for _, res := range m.Results {
+		r := appendString(nil, 1, res.Title)
+		r = appendVarint(r, 2, uint64(res.Score))
+		for _, t := range res.Tags {
+			r = appendString(r, 3, t)
+		}
+		r = appendTime(r, 4, res.UpdatedAt)
+		r = appendString(r, 5, string(res.Snippet))
+		b = appendMessage(b, 2, r)
+	}
+	return b
+}
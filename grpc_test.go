@@ -0,0 +1,118 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+// call is the context of a call with the token and, unless empty, the
+// wiki named.
+func call(token, name string) context.Context {
+	md := metadata.Pairs("authorization", "Bearer "+token)
+	if name != "" {
+		md.Set("wiki", name)
+	}
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestGRPCWrites(t *testing.T) {
+	w := wikitest.New(t)
+	g := &grpcService{servers: map[string]*wiki.Server{"main": w.Server}, token: "tok", user: "bot"}
+	create, update := writePage(wiki.OpCreate), writePage(wiki.OpUpdate)
+
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"without a token", metadata.NewIncomingContext(context.Background(), metadata.MD{}), codes.Unauthenticated},
+		{"with a wrong token", call("bad", ""), codes.Unauthenticated},
+		{"to another wiki", call("tok", "other"), codes.NotFound},
+	} {
+		if _, err := create(g, tc.ctx, &writeRequest{Title: "One", Body: "x"}); status.Code(err) != tc.want {
+			t.Errorf("writing %s: %v, want %s", tc.name, err, tc.want)
+		}
+	}
+
+	ctx := call("tok", "main")
+	if rev, err := create(g, ctx, &writeRequest{Title: "One", Body: "first", Tags: []string{"a"}}); err != nil || rev.Revision != 1 {
+		t.Fatalf("creating One: %v, %v", rev, err)
+	}
+	if _, err := create(g, ctx, &writeRequest{Title: "One", Body: "again"}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("creating One again: %v, want AlreadyExists", err)
+	}
+	if rev, err := update(g, ctx, &writeRequest{Title: "One", Body: "second", BaseRevision: 1}); err != nil || rev.Revision != 2 {
+		t.Errorf("updating One: %v, %v", rev, err)
+	}
+	if _, err := update(g, ctx, &writeRequest{Title: "One", Body: "stale", BaseRevision: 1}); status.Code(err) != codes.Aborted {
+		t.Errorf("updating a stale revision: %v, want Aborted", err)
+	}
+	if _, err := update(g, ctx, &writeRequest{Title: "Missing", Body: "x"}); status.Code(err) != codes.NotFound {
+		t.Errorf("updating a missing page: %v, want NotFound", err)
+	}
+	if _, err := update(g, ctx, &writeRequest{Title: "bad title!", Body: "x"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("writing a bad title: %v, want InvalidArgument", err)
+	}
+
+	p, err := g.getPage(ctx, &titleRequest{Title: "One"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Body) != "second" || p.Author != "bot" || len(p.Tags) != 1 || p.Tags[0] != "a" {
+		t.Errorf("One is %q by %s with tags %v", p.Body, p.Author, p.Tags)
+	}
+	if _, err := update(g, ctx, &writeRequest{Title: "One", Body: "third", SetTags: true}); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := g.getPage(ctx, &titleRequest{Title: "One"}); p == nil || len(p.Tags) != 0 {
+		t.Errorf("set_tags without tags left %v", p.Tags)
+	}
+
+	w.Server.SetReadOnly(true)
+	if _, err := update(g, ctx, &writeRequest{Title: "One", Body: "read-only"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("writing in read-only mode: %v, want Unavailable", err)
+	}
+	if _, err := g.deletePage(ctx, &titleRequest{Title: "One"}); status.Code(err) != codes.Unavailable {
+		t.Errorf("deleting in read-only mode: %v, want Unavailable", err)
+	}
+	w.Server.SetReadOnly(false)
+	if _, err := g.deletePage(ctx, &titleRequest{Title: "One"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.getPage(ctx, &titleRequest{Title: "One"}); status.Code(err) != codes.NotFound {
+		t.Errorf("getting a deleted page: %v, want NotFound", err)
+	}
+}
+
+func TestWriteRequestWire(t *testing.T) {
+	var b []byte
+	b = appendString(b, 1, "One")
+	b = appendString(b, 2, "body")
+	b = appendString(b, 3, "a")
+	b = appendString(b, 3, "b")
+	b = appendVarint(b, 4, 1)
+	b = appendVarint(b, 5, 7)
+	b = protowire.AppendTag(b, 99, protowire.BytesType)
+	b = protowire.AppendString(b, "unknown fields are skipped")
+
+	var req writeRequest
+	if err := req.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if req.Title != "One" || req.Body != "body" || len(req.Tags) != 2 || req.Tags[1] != "b" || !req.SetTags || req.BaseRevision != 7 {
+		t.Errorf("decoded %+v", req)
+	}
+	if err := req.unmarshal(b[:len(b)-3]); err == nil {
+		t.Error("a truncated message decoded")
+	}
+}
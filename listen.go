@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor systemd passes to an
+// activated service (SD_LISTEN_FDS_START).
+const listenFdsStart = 3
+
+// listen returns the socket handed over by systemd socket activation when
+// there is one, and otherwise opens addr itself.
+func listen(addr string) (net.Listener, error) {
+	ln, err := systemdListener()
+	if err != nil || ln != nil {
+		return ln, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+// systemdListener implements the LISTEN_PID/LISTEN_FDS protocol described
+// in sd_listen_fds(3). It returns nil, nil when no socket was passed.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, expected one", fds)
+	}
+
+	// don't let the variables leak into child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	syscall.CloseOnExec(listenFdsStart)
+	f := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %v", err)
+	}
+	log.Printf("using socket %s passed by systemd", ln.Addr())
+	return ln, nil
+}
+
+// certFiles serves the certificate of -tls-cert and -tls-key. They are
+// read again on SIGHUP, so a certificate renewed by the agent of an
+// internal CA is picked up without a restart.
+type certFiles struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+}
+
+func (c *certFiles) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *certFiles) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// serverTLS returns the TLS configuration of the server, nil for plain
+// HTTP, and the function reloading its certificate if it has one to.
+func serverTLS(cfg ServerConfig) (*tls.Config, func() error, error) {
+	if acmeTLS != nil {
+		tc, err := acmeTLS()
+		if err != nil || tc != nil {
+			return tc, nil, err
+		}
+	}
+	if cfg.TLSCertFile == "" {
+		return nil, nil, nil
+	}
+	files := &certFiles{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile}
+	if err := files.load(); err != nil {
+		return nil, nil, err
+	}
+	return &tls.Config{GetCertificate: files.getCertificate}, files.load, nil
+}
+
+// serve runs srv until it receives SIGINT or SIGTERM, then stops accepting
+// connections and waits up to cfg.ShutdownTimeout for in-flight requests.
+// With socket activation the listening socket stays open in systemd, so a
+// restart doesn't refuse any connection. SIGHUP calls reload, and reads
+// the certificate files again.
+func serve(srv *http.Server, cfg ServerConfig, reload func()) error {
+	ln, err := listen(cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, reloadCert, err := serverTLS(cfg)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+	setProtocols(srv, cfg, tlsConfig != nil)
+
+	errc := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errc <- srv.ServeTLS(ln, "", "")
+			return
+		}
+		errc <- srv.Serve(ln)
+	}()
+
+	reloadc := make(chan os.Signal, 1)
+	signal.Notify(reloadc, syscall.SIGHUP)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+wait:
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-reloadc:
+			log.Println("SIGHUP received, reloading templates")
+			reload()
+			if reloadCert != nil {
+				if err := reloadCert(); err != nil {
+					log.Printf("keeping the current certificate: %v", err)
+				}
+			}
+		case sig := <-stop:
+			log.Printf("%s received, shutting down", sig)
+			break wait
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
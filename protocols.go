@@ -0,0 +1,19 @@
+//go:build go1.24
+
+package main
+
+import "net/http"
+
+// setProtocols picks the HTTP versions srv offers: HTTP/2 is negotiated
+// over TLS, and cleartext HTTP/2 is only spoken when explicitly enabled.
+func setProtocols(srv *http.Server, cfg ServerConfig, useTLS bool) {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	if useTLS {
+		p.SetHTTP2(true)
+	}
+	if cfg.H2C {
+		p.SetUnencryptedHTTP2(true)
+	}
+	srv.Protocols = p
+}
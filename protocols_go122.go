@@ -0,0 +1,16 @@
+//go:build !go1.24
+
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// setProtocols leaves srv to net/http, which negotiates HTTP/2 over TLS by
+// itself. Cleartext HTTP/2 takes http.Protocols, new in Go 1.24.
+func setProtocols(srv *http.Server, cfg ServerConfig, useTLS bool) {
+	if cfg.H2C {
+		log.Fatal("-h2c needs gowiki built with Go 1.24 or later")
+	}
+}
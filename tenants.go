@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ondoheer/gowiki/wiki"
+)
+
+// tenant is one wiki mounted in the process.
+type tenant struct {
+	host     string
+	basePath string
+	handler  http.Handler
+}
+
+// tenantRouter dispatches each request to the wiki it belongs to, matching
+// on host name first and then on the longest base path.
+type tenantRouter struct {
+	tenants []tenant
+}
+
+func (t *tenantRouter) add(wc WikiConfig, srv *wiki.Server) {
+	t.tenants = append(t.tenants, tenant{
+		host:     wc.Host,
+		basePath: srv.BasePath(),
+		handler:  srv.Handler(),
+	})
+}
+
+// match returns the tenant serving r, or nil.
+func (t *tenantRouter) match(r *http.Request) *tenant {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	var best *tenant
+	for i := range t.tenants {
+		tn := &t.tenants[i]
+		if tn.host != "" && !strings.EqualFold(tn.host, host) {
+			continue
+		}
+		if r.URL.Path != tn.basePath && !strings.HasPrefix(r.URL.Path, tn.basePath+"/") {
+			continue
+		}
+		if best == nil || betterMatch(tn, best) {
+			best = tn
+		}
+	}
+	return best
+}
+
+// betterMatch reports whether a is more specific than b: a named host beats
+// a catch-all, then a longer base path beats a shorter one.
+func betterMatch(a, b *tenant) bool {
+	if (a.host != "") != (b.host != "") {
+		return a.host != ""
+	}
+	return len(a.basePath) > len(b.basePath)
+}
+
+func (t *tenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tn := t.match(r)
+	if tn == nil {
+		http.NotFound(w, r)
+		return
+	}
+	tn.handler.ServeHTTP(w, r)
+}
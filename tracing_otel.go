@@ -0,0 +1,40 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ondoheer/gowiki/wiki"
+)
+
+// The exporter is configured through the standard OTEL_EXPORTER_OTLP_*
+// environment variables.
+func init() {
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter)))
+	tracer = otelTracer{otel.Tracer("github.com/ondoheer/gowiki")}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t otelTracer) Start(ctx context.Context, name string) (context.Context, func()) {
+	ctx, span := t.tracer.Start(ctx, name)
+	if id := wiki.RequestIDFrom(ctx); id != "" {
+		span.SetAttributes(attribute.String("request.id", id))
+	}
+	return ctx, func() { span.End() }
+}
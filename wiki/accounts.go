@@ -0,0 +1,377 @@
+package wiki
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccountsConfig enables the wiki's own user accounts, for programs that
+// don't authenticate users themselves with WithUser.
+type AccountsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Signup lets anyone create an editor account.
+	Signup bool `json:"signup"`
+	// VerifyEmail makes signing up ask for an email address, and keeps the
+	// new account from changing anything until its owner opens the link
+	// mailed there. It needs mail and Config.PublicURL.
+	VerifyEmail bool `json:"verify_email"`
+	// Admin is the account created when there are none, with a random
+	// password written to the log. Empty means "admin".
+	Admin string `json:"admin"`
+}
+
+// Account is a user known to the wiki.
+type Account struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	Role  Role   `json:"role"`
+	// Password is the password's hash, see hashPassword.
+	Password string `json:"password"`
+	// Disabled accounts can't sign in.
+	Disabled bool `json:"disabled,omitempty"`
+	// MustReset makes the user choose a new password before anything else.
+	MustReset bool `json:"must_reset,omitempty"`
+	// Unverified accounts signed up but didn't confirm Email yet, see
+	// AccountsConfig.VerifyEmail.
+	Unverified bool       `json:"unverified,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastLogin  *time.Time `json:"last_login,omitempty"`
+}
+
+// AccountStore is implemented by storage that keeps user accounts. Account
+// and UpdateAccount report an unknown name with fs.ErrNotExist, and
+// CreateAccount a taken one with fs.ErrExist.
+//
+// Wikis whose storage doesn't implement it keep accounts in memory, which
+// is only useful for trying things out.
+type AccountStore interface {
+	// Accounts returns every account, sorted by name.
+	Accounts(ctx context.Context) ([]*Account, error)
+	Account(ctx context.Context, name string) (*Account, error)
+	CreateAccount(ctx context.Context, a *Account) error
+	UpdateAccount(ctx context.Context, a *Account) error
+	DeleteAccount(ctx context.Context, name string) error
+}
+
+const (
+	minPasswordLength = 8
+	maxUserNameLength = 64
+
+	// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-SHA256.
+	pbkdf2Iterations = 600000
+)
+
+var validUserName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func checkUserName(name string) error {
+	if len(name) > maxUserNameLength || !validUserName.MatchString(name) {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("User names are up to %d letters, digits, dots, dashes and underscores.", maxUserNameLength))
+	}
+	return nil
+}
+
+func checkPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("Passwords need at least %d characters.", minPasswordLength))
+	}
+	return nil
+}
+
+// hashPassword returns "pbkdf2-sha256$<iterations>$<salt>$<key>", salt
+// and key in hex.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key := pbkdf2Key(password, salt, pbkdf2Iterations, 32)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%x$%x", pbkdf2Iterations, salt, key), nil
+}
+
+// dummyHash is checked against when the account doesn't exist, so a failed
+// sign-in takes as long whether or not the name is taken.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("not a password")
+	return hash
+})
+
+// checkPasswordHash reports whether password matches a hash made by
+// hashPassword.
+func checkPasswordHash(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	if iter < 1 {
+		return false
+	}
+	got := pbkdf2Key(password, salt, iter, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2Key derives a key of keyLen bytes from password with
+// PBKDF2-HMAC-SHA256 (RFC 8018). crypto/pbkdf2 only came with Go 1.24.
+func pbkdf2Key(password string, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, []byte(password))
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := slices.Clone(u)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// randomPassword is for accounts created without one, like the first admin.
+func randomPassword() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// createFirstAdmin makes sure a fresh wiki with accounts enabled has
+// somebody able to manage them.
+func (s *Server) createFirstAdmin(ctx context.Context) error {
+	accounts, err := s.accounts.Accounts(ctx)
+	if err != nil || len(accounts) > 0 {
+		return err
+	}
+
+	name := s.cfg.Accounts.Admin
+	if name == "" {
+		name = "admin"
+	}
+	if err := checkUserName(name); err != nil {
+		return fmt.Errorf("accounts: admin %q: %v", name, err)
+	}
+	password := randomPassword()
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	a := &Account{Name: name, Role: RoleAdmin, Password: hash, MustReset: true, CreatedAt: time.Now().UTC()}
+	if err := s.accounts.CreateAccount(ctx, a); err != nil {
+		return err
+	}
+	s.publish(ctx, &Event{Kind: EventUserCreated, User: name})
+	log.Printf("accounts: created %q with password %s, to be changed on first sign-in", name, password)
+	return nil
+}
+
+// accountList keeps accounts in memory.
+type accountList struct {
+	mu       sync.Mutex
+	accounts map[string]*Account
+}
+
+func (al *accountList) Accounts(ctx context.Context) ([]*Account, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	list := make([]*Account, 0, len(al.accounts))
+	for _, a := range al.accounts {
+		c := *a
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (al *accountList) Account(ctx context.Context, name string) (*Account, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	a, ok := al.accounts[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	c := *a
+	return &c, nil
+}
+
+func (al *accountList) CreateAccount(ctx context.Context, a *Account) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if _, ok := al.accounts[a.Name]; ok {
+		return fs.ErrExist
+	}
+	if al.accounts == nil {
+		al.accounts = make(map[string]*Account)
+	}
+	c := *a
+	al.accounts[a.Name] = &c
+	return nil
+}
+
+func (al *accountList) UpdateAccount(ctx context.Context, a *Account) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if _, ok := al.accounts[a.Name]; !ok {
+		return fs.ErrNotExist
+	}
+	c := *a
+	al.accounts[a.Name] = &c
+	return nil
+}
+
+func (al *accountList) DeleteAccount(ctx context.Context, name string) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if _, ok := al.accounts[name]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(al.accounts, name)
+	return nil
+}
+
+func (st *FileStorage) accountsPath() string {
+	return filepath.Join(st.dir, ".accounts.json")
+}
+
+// loadAccounts reads .accounts.json the first time accounts are used; the
+// copy in memory is written back on every change.
+func (st *FileStorage) loadAccounts() (*accountList, error) {
+	st.accountsMu.Lock()
+	defer st.accountsMu.Unlock()
+
+	if st.accounts != nil {
+		return st.accounts, nil
+	}
+	al := &accountList{accounts: make(map[string]*Account)}
+	data, err := os.ReadFile(st.accountsPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var list []*Account
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, a := range list {
+			al.accounts[a.Name] = a
+		}
+	}
+	st.accounts = al
+	return al, nil
+}
+
+func (st *FileStorage) saveAccounts(ctx context.Context, al *accountList) error {
+	list, err := al.Accounts(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(st.dir, 0700); err != nil {
+		return err
+	}
+	// write then rename, so a crash never leaves a truncated file
+	tmp := st.accountsPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.accountsPath())
+}
+
+func (st *FileStorage) Accounts(ctx context.Context) ([]*Account, error) {
+	al, err := st.loadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	return al.Accounts(ctx)
+}
+
+func (st *FileStorage) Account(ctx context.Context, name string) (*Account, error) {
+	al, err := st.loadAccounts()
+	if err != nil {
+		return nil, err
+	}
+	return al.Account(ctx, name)
+}
+
+func (st *FileStorage) CreateAccount(ctx context.Context, a *Account) error {
+	al, err := st.loadAccounts()
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := al.CreateAccount(ctx, a); err != nil {
+		return err
+	}
+	return st.saveAccounts(ctx, al)
+}
+
+func (st *FileStorage) UpdateAccount(ctx context.Context, a *Account) error {
+	al, err := st.loadAccounts()
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := al.UpdateAccount(ctx, a); err != nil {
+		return err
+	}
+	return st.saveAccounts(ctx, al)
+}
+
+func (st *FileStorage) DeleteAccount(ctx context.Context, name string) error {
+	al, err := st.loadAccounts()
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := al.DeleteAccount(ctx, name); err != nil {
+		return err
+	}
+	return st.saveAccounts(ctx, al)
+}
@@ -0,0 +1,286 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Annotation is a reader's comment on a passage of a page. The passage is
+// found again by its text and a little context on each side, so notes
+// survive edits elsewhere on the page.
+type Annotation struct {
+	ID     string `json:"id"`
+	Quote  string `json:"quote"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+
+	// ReplyTo is the ID of the note this one answers, on the same passage.
+	ReplyTo string `json:"reply_to,omitempty"`
+
+	Comment   string    `json:"comment"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AnnotationStore is implemented by storages that keep annotations.
+// Servers whose storage doesn't implement it keep them in memory.
+type AnnotationStore interface {
+	// Annotations returns the notes on a page, oldest first.
+	Annotations(ctx context.Context, title string) ([]*Annotation, error)
+	Annotate(ctx context.Context, title string, a *Annotation) error
+	// Resolve removes a note, or reports fs.ErrNotExist.
+	Resolve(ctx context.Context, title, id string) error
+}
+
+const (
+	maxQuoteLength   = 1000
+	maxContextLength = 64
+	maxCommentLength = 4000
+)
+
+// annotateHandler adds a note to the passage described by the form.
+func (s *Server) annotateHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	if _, err := s.loadPage(r.Context(), title); err != nil {
+		return err
+	}
+
+	a := &Annotation{
+		ID:        newID(),
+		Quote:     strings.TrimSpace(r.FormValue("quote")),
+		Prefix:    lastRunes(r.FormValue("prefix"), maxContextLength),
+		Suffix:    firstRunes(r.FormValue("suffix"), maxContextLength),
+		Comment:   strings.TrimSpace(r.FormValue("comment")),
+		Author:    UserFrom(r.Context()),
+		CreatedAt: time.Now().UTC(),
+	}
+	// errors go next to the form of the note, or that of the reply
+	field := "note"
+	var replyTo string
+	if id := r.FormValue("reply_to"); id != "" {
+		field = "reply-" + id
+		parent, err := s.findNote(r.Context(), title, id)
+		if err != nil {
+			return err
+		}
+		a.ReplyTo = parent.ID
+		a.Quote, a.Prefix, a.Suffix = parent.Quote, parent.Prefix, parent.Suffix
+		replyTo = parent.Author
+	}
+	switch {
+	case a.Quote == "" || a.Comment == "":
+		return s.pageFormFailed(w, r, title, field, NewError(http.StatusBadRequest, "A note needs a passage and a comment."))
+	case utf8.RuneCountInString(a.Quote) > maxQuoteLength:
+		return s.pageFormFailed(w, r, title, field, NewError(http.StatusBadRequest, "The passage is too long; select less text."))
+	case utf8.RuneCountInString(a.Comment) > maxCommentLength:
+		return s.pageFormFailed(w, r, title, field, NewError(http.StatusBadRequest,
+			fmt.Sprintf("The comment is too long; notes are limited to %d characters.", maxCommentLength)))
+	}
+
+	if err := s.annotations.Annotate(r.Context(), title, a); err != nil {
+		return err
+	}
+	s.enqueue(r.Context(), JobNoteAdded, NoteEvent{
+		Title:    title,
+		ID:       a.ID,
+		Author:   a.Author,
+		ReplyTo:  replyTo,
+		Mentions: s.knownUsers(r.Context(), extractMentions(a.Comment)),
+	})
+
+	http.Redirect(w, r, s.pagePath("view", title)+"#note-"+a.ID, http.StatusFound)
+	return nil
+}
+
+// resolveHandler removes a note once it has been dealt with. Authors
+// resolve their own notes and admins anyone's; anonymous notes can be
+// resolved by any signed-in user.
+func (s *Server) resolveHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	u := CurrentUser(r.Context())
+	if u == nil {
+		return Forbidden("Sign in to resolve notes.")
+	}
+
+	id := r.FormValue("id")
+	note, err := s.findNote(r.Context(), title, id)
+	if err != nil {
+		return err
+	}
+	if note.Author != "" && note.Author != u.Name && !u.IsAdmin() {
+		return Forbidden("Only the author of a note or an administrator can resolve it.")
+	}
+
+	if err := s.annotations.Resolve(r.Context(), title, id); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	http.Redirect(w, r, s.pagePath("view", title), http.StatusFound)
+	return nil
+}
+
+func (s *Server) findNote(ctx context.Context, title, id string) (*Annotation, error) {
+	notes, err := s.annotations.Annotations(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range notes {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return nil, NotFound("This note was already resolved.")
+}
+
+// annotate fills in the notes of a page about to be shown. Like view
+// counting, it is best effort.
+func (s *Server) annotate(ctx context.Context, p *Page) {
+	if s.namespace(p.Title).DisableNotes {
+		return
+	}
+	notes, err := s.annotations.Annotations(ctx, p.Title)
+	if err != nil {
+		logf(ctx, "loading notes of %s: %v", p.Title, err)
+		return
+	}
+	p.Annotations = notes
+}
+
+func firstRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func lastRunes(s string, n int) string {
+	for i := len(s); i > 0; {
+		if n == 0 {
+			return s[i:]
+		}
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+		n--
+	}
+	return s
+}
+
+// pageNotes is an in-memory AnnotationStore.
+type pageNotes struct {
+	mu    sync.Mutex
+	notes map[string][]*Annotation
+}
+
+func (pn *pageNotes) Annotations(ctx context.Context, title string) ([]*Annotation, error) {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+
+	return append([]*Annotation(nil), pn.notes[title]...), nil
+}
+
+func (pn *pageNotes) Annotate(ctx context.Context, title string, a *Annotation) error {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+
+	if pn.notes == nil {
+		pn.notes = make(map[string][]*Annotation)
+	}
+	pn.notes[title] = append(pn.notes[title], a)
+	return nil
+}
+
+func (pn *pageNotes) Resolve(ctx context.Context, title, id string) error {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+
+	notes, ok := removeNote(pn.notes[title], id)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	pn.notes[title] = notes
+	return nil
+}
+
+func removeNote(notes []*Annotation, id string) ([]*Annotation, bool) {
+	for i, a := range notes {
+		if a.ID == id {
+			return append(notes[:i:i], notes[i+1:]...), true
+		}
+	}
+	return notes, false
+}
+
+func (st *FileStorage) notesPath(title string) string {
+	return filepath.Join(st.dir, title+".notes.json")
+}
+
+func (st *FileStorage) loadNotes(title string) ([]*Annotation, error) {
+	data, err := os.ReadFile(st.notesPath(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var notes []*Annotation
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return nil, err
+	}
+	return notes, nil
+}
+
+func (st *FileStorage) saveNotes(title string, notes []*Annotation) error {
+	if len(notes) == 0 {
+		err := os.Remove(st.notesPath(title))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(st.notesPath(title), data, 0600)
+}
+
+func (st *FileStorage) Annotations(ctx context.Context, title string) ([]*Annotation, error) {
+	return st.loadNotes(title)
+}
+
+func (st *FileStorage) Annotate(ctx context.Context, title string, a *Annotation) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	notes, err := st.loadNotes(title)
+	if err != nil {
+		return err
+	}
+	return st.saveNotes(title, append(notes, a))
+}
+
+func (st *FileStorage) Resolve(ctx context.Context, title, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	notes, err := st.loadNotes(title)
+	if err != nil {
+		return err
+	}
+	notes, ok := removeNote(notes, id)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	return st.saveNotes(title, notes)
+}
@@ -0,0 +1,105 @@
+package wiki
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Ways of attributing anonymous edits, for AnonymousConfig.Attribution.
+const (
+	// AttributeNone leaves them without an author.
+	AttributeNone = "none"
+	// AttributeHash names them after a keyed hash of the address, so edits
+	// from one address can be told apart from others without showing it.
+	AttributeHash = "hash"
+	// AttributeNetwork names them after the network of the address, its
+	// last bits zeroed.
+	AttributeNetwork = "network"
+)
+
+// anonPrefix starts the names given to anonymous authors. User names can't
+// have a colon, so they never clash with accounts.
+const anonPrefix = "anon:"
+
+// AnonymousConfig sets how edits made without signing in are attributed.
+type AnonymousConfig struct {
+	// Attribution is AttributeNone (the default), AttributeHash or
+	// AttributeNetwork.
+	Attribution string `json:"attribution"`
+	// Salt keys the hashes. Without it a random one is picked at start,
+	// so the names change with every restart.
+	Salt string `json:"salt"`
+	// IPv4Bits and IPv6Bits are the network sizes kept, 24 and 48 by
+	// default.
+	IPv4Bits int `json:"ipv4_bits"`
+	IPv6Bits int `json:"ipv6_bits"`
+}
+
+// attributor returns the function naming an anonymous author from the
+// address of the request, or nil when they are left unnamed.
+func (c AnonymousConfig) attributor() (func(addr netip.Addr) string, error) {
+	switch c.Attribution {
+	case "", AttributeNone:
+		return nil, nil
+	case AttributeHash:
+		salt := []byte(c.Salt)
+		if len(salt) == 0 {
+			salt = make([]byte, 32)
+			rand.Read(salt)
+		}
+		return func(addr netip.Addr) string {
+			mac := hmac.New(sha256.New, salt)
+			mac.Write(addr.AsSlice())
+			return anonPrefix + hex.EncodeToString(mac.Sum(nil)[:5])
+		}, nil
+	case AttributeNetwork:
+		v4, v6 := c.IPv4Bits, c.IPv6Bits
+		if v4 == 0 {
+			v4 = 24
+		}
+		if v6 == 0 {
+			v6 = 48
+		}
+		if v4 < 0 || v4 > 32 || v6 < 0 || v6 > 128 {
+			return nil, fmt.Errorf("anonymous attribution keeps 0 to 32 bits of IPv4 and 0 to 128 of IPv6 addresses")
+		}
+		return func(addr netip.Addr) string {
+			bits := v6
+			if addr.Is4() {
+				bits = v4
+			}
+			prefix, _ := addr.Prefix(bits)
+			return anonPrefix + prefix.String()
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown anonymous attribution %q, want none, hash or network", c.Attribution)
+}
+
+// isAnonymousAuthor reports whether name was given to an anonymous author.
+func isAnonymousAuthor(name string) bool {
+	return strings.HasPrefix(name, anonPrefix)
+}
+
+// authorOf returns who to record as the author of a change made by r: the
+// signed-in user, or what AnonymousConfig names anonymous ones.
+func (s *Server) authorOf(r *http.Request) string {
+	if user := UserFrom(r.Context()); user != "" || s.anonAuthor == nil {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	return s.anonAuthor(addr.Unmap().WithZone(""))
+}
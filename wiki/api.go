@@ -0,0 +1,74 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// apiPrefix is where the JSON API lives, under the base path.
+const apiPrefix = "/api/v1"
+
+// maxAPIRequestBytes caps the JSON bodies the API reads.
+const maxAPIRequestBytes = 1 << 20
+
+// apiError is the body of failed API responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// handleAPI is handle for the JSON API: errors are answered with an
+// apiError rather than the themed error page.
+func (s *Server) handleAPI(fn appHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := fn(w, r)
+		if err == nil {
+			return
+		}
+		ctx := r.Context()
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			logf(ctx, "%s %s: %v", r.Method, r.URL.Path, err)
+			return
+		}
+
+		e := userError(err)
+		if e.Err != nil || e.Status >= http.StatusInternalServerError {
+			logf(ctx, "%s %s: %v", r.Method, r.URL.Path, err)
+		}
+		writeJSON(w, e.Status, apiError{Error: e.Message})
+	}
+}
+
+// userError returns err as an *Error, hiding errors that aren't meant for
+// users behind a generic message.
+func userError(err error) *Error {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Status: http.StatusInternalServerError, Message: "Something went wrong while handling your request.", Err: err}
+	}
+	return e
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// readJSON decodes the request body into v, rejecting unknown fields so
+// typos don't go unnoticed.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return readJSONLimit(w, r, v, maxAPIRequestBytes)
+}
+
+// readJSONLimit is readJSON for bodies of up to limit bytes.
+func readJSONLimit(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return NewError(http.StatusBadRequest, "The request body is not valid JSON: "+err.Error())
+	}
+	return nil
+}
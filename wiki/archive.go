@@ -0,0 +1,93 @@
+package wiki
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultArchiveReportDays is the staleness threshold of the archive report
+// when Config.ArchiveAfterDays is not set.
+const defaultArchiveReportDays = 180
+
+// isArchived reports whether readers should see p as archived: either it
+// was archived by hand, or it hasn't been edited for ArchiveAfterDays.
+func (s *Server) isArchived(p *Page, now time.Time) bool {
+	if p.Archived {
+		return true
+	}
+	after := s.cfg.ArchiveAfterDays
+	return after > 0 && now.Sub(p.UpdatedAt) > days(after)
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// ArchiveReport is the data handed to the admin/archive.html template.
+type ArchiveReport struct {
+	Common
+	Days       int
+	Candidates []*ArchiveCandidate
+}
+
+// ArchiveCandidate is a page that went unedited for the report's period.
+type ArchiveCandidate struct {
+	*Page
+	// AutoArchived is set when readers already see the page as archived
+	// because of ArchiveAfterDays.
+	AutoArchived bool
+}
+
+// archiveReportHandler lists published pages not archived by hand that
+// haven't been edited for ?days=N days, oldest first.
+func (s *Server) archiveReportHandler(w http.ResponseWriter, r *http.Request) error {
+	n := s.cfg.ArchiveAfterDays
+	if n == 0 {
+		n = defaultArchiveReportDays
+	}
+	if v := r.FormValue("days"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			return NewError(http.StatusBadRequest, "days must be a positive number.")
+		}
+	}
+
+	pages, err := s.listPages(r.Context())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	report := &ArchiveReport{Days: n}
+	for _, p := range pages {
+		if now.Sub(p.UpdatedAt) <= days(n) {
+			continue
+		}
+		report.Candidates = append(report.Candidates, &ArchiveCandidate{Page: p, AutoArchived: s.isArchived(p, now)})
+	}
+	sort.Slice(report.Candidates, func(i, j int) bool {
+		return report.Candidates[i].UpdatedAt.Before(report.Candidates[j].UpdatedAt)
+	})
+
+	return s.renderTemplate(r.Context(), w, "admin/archive.html", report)
+}
+
+// archiveHandler archives a page by hand.
+func (s *Server) archiveHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	p, err := s.loadPage(r.Context(), title)
+	if err != nil {
+		return err
+	}
+
+	p.Archived = true
+	p.Author = UserFrom(r.Context())
+	if err := s.savePage(r.Context(), p); err != nil {
+		return err
+	}
+
+	s.flash(w, r, FlashInfo, "Archived "+title+".")
+	http.Redirect(w, r, s.pagePath("admin", "archive"), http.StatusFound)
+	return nil
+}
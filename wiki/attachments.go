@@ -0,0 +1,413 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Attachment is a file uploaded to a page.
+type Attachment struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Uploader is the user who uploaded the file, if the storage keeps
+	// track.
+	Uploader string
+}
+
+// AttachmentStore is implemented by storages that keep files uploaded to
+// pages. Wikis whose storage doesn't implement it have no attachments.
+type AttachmentStore interface {
+	// Attach stores content as the named file of a page, replacing any
+	// file with that name. Storages may record the user of ctx as its
+	// uploader.
+	Attach(ctx context.Context, title, name string, content io.Reader) error
+	// Attachments lists the files of a page, sorted by name.
+	Attachments(ctx context.Context, title string) ([]*Attachment, error)
+	// OpenAttachment opens a file for serving, or reports fs.ErrNotExist.
+	OpenAttachment(ctx context.Context, title, name string) (io.ReadSeekCloser, *Attachment, error)
+	DeleteAttachment(ctx context.Context, title, name string) error
+}
+
+// AttachmentConfig limits and checks uploads.
+type AttachmentConfig struct {
+	// MaxBytes caps the size of an upload. Zero means 10 MiB.
+	MaxBytes int64 `json:"max_bytes"`
+
+	// Types are the files that may be attached: extensions such as ".pdf",
+	// which the name has to end in, and media types such as "image/png" or
+	// "image/*", which the content has to look like. Empty allows any.
+	Types []string `json:"types"`
+
+	// ClamAV is the address of a clamd daemon scanning every upload, as
+	// "unix:/run/clamav/clamd.ctl" or "tcp:localhost:3310".
+	ClamAV string `json:"clamav"`
+	// ScanCommand is run with each upload on stdin; a non-zero exit status
+	// rejects it.
+	ScanCommand []string `json:"scan_command"`
+}
+
+const (
+	defaultMaxUploadBytes = 10 << 20
+	maxAttachmentName     = 255
+)
+
+// scanners returns the built-in upload scanners the configuration enables.
+func (c AttachmentConfig) scanners() ([]UploadHook, error) {
+	var hooks []UploadHook
+	if c.ClamAV != "" {
+		network, addr, ok := strings.Cut(c.ClamAV, ":")
+		if !ok || (network != "unix" && network != "tcp") {
+			return nil, fmt.Errorf("clamav address %q: want unix:<path> or tcp:<host:port>", c.ClamAV)
+		}
+		hooks = append(hooks, ClamAV(network, addr))
+	}
+	if len(c.ScanCommand) > 0 {
+		hooks = append(hooks, ScanCommand(c.ScanCommand[0], c.ScanCommand[1:]...))
+	}
+	return hooks, nil
+}
+
+// checkType refuses files of types not in c.Types, sniffing the start of
+// content and seeking back to where it was.
+func (c AttachmentConfig) checkType(name string, content io.ReadSeeker) error {
+	if len(c.Types) == 0 {
+		return nil
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	ext := strings.ToLower(path.Ext(name))
+	for _, t := range c.Types {
+		t = strings.ToLower(t)
+		switch {
+		case strings.HasPrefix(t, "."):
+			if ext == t {
+				return nil
+			}
+		case strings.HasSuffix(t, "/*"):
+			if strings.HasPrefix(sniffed, t[:len(t)-1]) {
+				return nil
+			}
+		case sniffed == t:
+			return nil
+		}
+	}
+	return NewError(http.StatusUnsupportedMediaType,
+		"Files of this type can't be attached; allowed are "+strings.Join(c.Types, ", ")+".")
+}
+
+func (c AttachmentConfig) maxBytes() int64 {
+	if c.MaxBytes > 0 {
+		return c.MaxBytes
+	}
+	return defaultMaxUploadBytes
+}
+
+// cleanAttachmentName checks an uploaded file name, keeping only its last
+// element since browsers used to send full paths.
+func cleanAttachmentName(name string) (string, error) {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	switch {
+	case name == "" || name == "." || name == "/" || strings.HasPrefix(name, "."):
+		return "", errors.New("the file needs a name not starting with a dot")
+	case len(name) > maxAttachmentName:
+		return "", fmt.Errorf("file names are limited to %d bytes", maxAttachmentName)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return "", errors.New("the file name contains control characters")
+	}
+	return name, nil
+}
+
+func (s *Server) attachmentStore() (AttachmentStore, error) {
+	as, ok := s.store.(AttachmentStore)
+	if !ok {
+		return nil, NewError(http.StatusNotImplemented, "This wiki does not keep attachments.")
+	}
+	return as, nil
+}
+
+// uploadHandler stores a file sent as the "file" field of a multipart
+// form, once every upload hook accepted it.
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	as, err := s.attachmentStore()
+	if err != nil {
+		return err
+	}
+	_, err = s.loadPage(r.Context(), title)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no page called " + title + ".")
+	}
+	if err != nil {
+		return err
+	}
+
+	limit := s.cfg.Attachments.maxBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return s.pageFormFailed(w, r, title, "file", NewError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("The file is too large; the limit is %d bytes.", tooLarge.Limit)))
+		}
+		return s.pageFormFailed(w, r, title, "file", NewError(http.StatusBadRequest, "No file was uploaded."))
+	}
+	defer file.Close()
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	name, err := cleanAttachmentName(header.Filename)
+	if err != nil {
+		return s.pageFormFailed(w, r, title, "file", NewError(http.StatusBadRequest, "The file can't be attached: "+err.Error()+"."))
+	}
+	if err := s.cfg.Attachments.checkType(name, file); err != nil {
+		return s.pageFormFailed(w, r, title, "file", err)
+	}
+
+	if err := s.checkQuota(r.Context(), usageItem{Title: title, File: name, Owner: UserFrom(r.Context()), Bytes: header.Size}); err != nil {
+		return s.pageFormFailed(w, r, title, "file", err)
+	}
+	u := &Upload{Title: title, Name: name, Size: header.Size, Content: file}
+	if err := s.uploading(r.Context(), u); err != nil {
+		return s.pageFormFailed(w, r, title, "file", err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := as.Attach(r.Context(), title, name, file); err != nil {
+		return err
+	}
+	logf(r.Context(), "%s attached to %s by %q", name, title, UserFrom(r.Context()))
+	if err := s.recountAttachments(r.Context(), title); err != nil {
+		logf(r.Context(), "counting attachments of %s: %v", title, err)
+	}
+
+	s.flash(w, r, FlashInfo, "Attached "+name+".")
+	http.Redirect(w, r, s.pagePath("view", title)+"#attachments", http.StatusFound)
+	return nil
+}
+
+// inlineTypes are served for display in the browser. Everything else,
+// notably HTML and SVG, is sent as a download so uploads can't run
+// scripts on the wiki's origin.
+var inlineTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// attachmentHandler serves /attachment/<title>/<name>.
+func (s *Server) attachmentHandler(w http.ResponseWriter, r *http.Request) error {
+	as, err := s.attachmentStore()
+	if err != nil {
+		return err
+	}
+
+	title, name := path.Split(r.PathValue("path"))
+	title = strings.TrimSuffix(title, "/")
+	if err := s.titles.check(title); err != nil {
+		return NotFound("Invalid Page Title")
+	}
+	if err := s.checkRead(r, title); err != nil {
+		return err
+	}
+	if name, err = cleanAttachmentName(name); err != nil {
+		return NotFound("There is no such attachment.")
+	}
+
+	f, a, err := as.OpenAttachment(r.Context(), title, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no such attachment.")
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctype, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(name)), ";")
+	if !inlineTypes[ctype] {
+		ctype = "application/octet-stream"
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	http.ServeContent(w, r, name, a.ModTime, f)
+	return nil
+}
+
+// detachHandler deletes the attachment named in the form.
+func (s *Server) detachHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	as, err := s.attachmentStore()
+	if err != nil {
+		return err
+	}
+	name, err := cleanAttachmentName(r.FormValue("name"))
+	if err != nil {
+		return NotFound("There is no such attachment.")
+	}
+
+	err = as.DeleteAttachment(r.Context(), title, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no such attachment.")
+	}
+	if err != nil {
+		return err
+	}
+	logf(r.Context(), "%s detached from %s by %q", name, title, UserFrom(r.Context()))
+	if err := s.recountAttachments(r.Context(), title); err != nil {
+		logf(r.Context(), "counting attachments of %s: %v", title, err)
+	}
+
+	s.flash(w, r, FlashInfo, "Deleted the attachment "+name+".")
+	http.Redirect(w, r, s.pagePath("view", title)+"#attachments", http.StatusFound)
+	return nil
+}
+
+// attachmentPath links to a file of a page; it is the attachment template
+// function.
+func (s *Server) attachmentPath(title, name string) string {
+	return s.pagePath("attachment", title) + "/" + url.PathEscape(name)
+}
+
+// listAttachments fills in the files of a page about to be shown.
+func (s *Server) listAttachments(ctx context.Context, p *Page) {
+	as, ok := s.store.(AttachmentStore)
+	if !ok {
+		return
+	}
+	files, err := as.Attachments(ctx, p.Title)
+	if err != nil {
+		logf(ctx, "listing attachments of %s: %v", p.Title, err)
+		return
+	}
+	p.Attachments = files
+}
+
+// attachmentDir is where the files of a page live. The suffix keeps the
+// directories of pages A and A/B apart.
+func (st *FileStorage) attachmentDir(title string) string {
+	return filepath.Join(st.dir, ".attachments", filepath.FromSlash(title)+".files")
+}
+
+func (st *FileStorage) Attach(ctx context.Context, title, name string, content io.Reader) error {
+	if err := os.MkdirAll(st.attachmentDir(title), 0700); err != nil {
+		return err
+	}
+
+	// write aside and move in place, so readers never see half a file
+	tmp, ref, err := st.writeBlob(content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := st.attachBlob(title, name, tmp, ref); err != nil {
+		return err
+	}
+	return st.setUploader(title, name, UserFrom(ctx))
+}
+
+func (st *FileStorage) Attachments(ctx context.Context, title string) ([]*Attachment, error) {
+	entries, err := os.ReadDir(st.attachmentDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*Attachment
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &Attachment{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	st.mu.Lock()
+	index, err := st.loadBlobIndex(title)
+	st.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for name, ref := range index {
+		files = append(files, &Attachment{Name: name, Size: ref.Size, ModTime: ref.ModTime})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, st.fillUploaders(title, files)
+}
+
+func (st *FileStorage) OpenAttachment(ctx context.Context, title, name string) (io.ReadSeekCloser, *Attachment, error) {
+	st.mu.Lock()
+	index, err := st.loadBlobIndex(title)
+	var f *os.File
+	if ref := index[name]; err == nil && ref != nil {
+		// opened under the lock, so the blob isn't released meanwhile
+		f, err = os.Open(st.blobPath(ref.Hash))
+		st.mu.Unlock()
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, &Attachment{Name: name, Size: ref.Size, ModTime: ref.ModTime}, nil
+	}
+	st.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err = os.Open(filepath.Join(st.attachmentDir(title), name))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, fs.ErrNotExist
+	}
+	return f, &Attachment{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (st *FileStorage) DeleteAttachment(ctx context.Context, title, name string) error {
+	err := st.detachBlob(title, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = os.Remove(filepath.Join(st.attachmentDir(title), name))
+	}
+	if err != nil {
+		return err
+	}
+	return st.setUploader(title, name, "")
+}
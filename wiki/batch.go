@@ -0,0 +1,146 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// Batch operations, for scripts that change many pages at once.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+const (
+	maxBatchOperations = 1000
+	maxBatchBytes      = 32 << 20
+)
+
+// BatchOperation is one change of a batch. Create fails if the page
+// exists, update and delete if it doesn't. An update with BaseRevision
+// fails if the page moved past that revision, like a conflicting edit, and
+// one without Tags keeps those of the page.
+type BatchOperation struct {
+	Op           string   `json:"op"`
+	Title        string   `json:"title"`
+	Body         string   `json:"body,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	BaseRevision int      `json:"base_revision,omitempty"`
+}
+
+// BatchResult is the outcome of one operation, at the same index as the
+// operation in the request. Status is the HTTP status the operation would
+// have had on its own.
+type BatchResult struct {
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Revision int    `json:"revision,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// batchHandler applies the operations of a batch in order. Each succeeds or
+// fails on its own, so the response is 200 unless the batch as a whole is
+// refused.
+func (s *Server) batchHandler(w http.ResponseWriter, r *http.Request) error {
+	if CurrentUser(r.Context()) == nil {
+		return Forbidden("Sign in to use the batch API.")
+	}
+	if !canEdit(r.Context()) {
+		return errReader
+	}
+	var batch struct {
+		Operations []*BatchOperation `json:"operations"`
+	}
+	if err := readJSONLimit(w, r, &batch, maxBatchBytes); err != nil {
+		return err
+	}
+	if len(batch.Operations) > maxBatchOperations {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("A batch can have at most %d operations.", maxBatchOperations))
+	}
+
+	results := make([]*BatchResult, len(batch.Operations))
+	for i, op := range batch.Operations {
+		res := &BatchResult{Title: op.Title, Status: http.StatusOK}
+		rev, err := s.applyOperation(r.Context(), op)
+		if err != nil {
+			e := userError(err)
+			if e.Err != nil || e.Status >= http.StatusInternalServerError {
+				logf(r.Context(), "batch %s of %s: %v", op.Op, op.Title, err)
+			}
+			res.Status, res.Error = e.Status, e.Message
+		} else if op.Op == OpCreate {
+			res.Status = http.StatusCreated
+		}
+		res.Revision = rev
+		results[i] = res
+	}
+	return writeJSON(w, http.StatusOK, struct {
+		Results []*BatchResult `json:"results"`
+	}{results})
+}
+
+// applyOperation carries out op as the forms would, hooks and follow-up
+// jobs included, and returns the revision saved.
+func (s *Server) applyOperation(ctx context.Context, op *BatchOperation) (int, error) {
+	if err := s.titles.check(op.Title); err != nil {
+		return 0, NewError(http.StatusBadRequest, "Invalid page title: "+err.Error()+".")
+	}
+	current, err := s.loadPage(ctx, op.Title)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+
+	switch op.Op {
+	case OpCreate, OpUpdate:
+		switch {
+		case op.Op == OpCreate && exists:
+			return 0, NewError(http.StatusConflict, "The page already exists.")
+		case op.Op == OpUpdate && !exists:
+			return 0, NotFound("There is no such page.")
+		case op.Op == OpUpdate && op.BaseRevision != 0 && current.Revision != op.BaseRevision:
+			return current.Revision, NewError(http.StatusConflict, "The page changed since the base revision.")
+		}
+		p := &Page{Title: op.Title, Body: []byte(op.Body), Author: UserFrom(ctx), Tags: parseTags(strings.Join(op.Tags, ","))}
+		if exists {
+			p.PublishAt, p.Archived = current.PublishAt, current.Archived
+			if op.Tags == nil {
+				p.Tags = current.Tags
+			}
+		}
+		if err := s.checkLimits(p.Body); err != nil {
+			return 0, err
+		}
+		if err := s.checkPageQuota(ctx, p.Title, p.Author, p.Body); err != nil {
+			return 0, err
+		}
+		if err := s.hooks.pageSaving(ctx, p); err != nil {
+			return 0, err
+		}
+		mentions := s.newMentions(ctx, p.Title, p.Body)
+		if err := s.savePage(ctx, p); err != nil {
+			return 0, err
+		}
+		s.publish(ctx, &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision, Author: p.Author, Mentions: mentions})
+		return p.Revision, nil
+
+	case OpDelete:
+		if !exists {
+			return 0, NotFound("There is no such page.")
+		}
+		if err := s.hooks.pageDeleting(ctx, op.Title); err != nil {
+			return 0, err
+		}
+		if err := s.deletePage(ctx, op.Title); err != nil {
+			return 0, err
+		}
+		s.publish(ctx, &Event{Kind: EventPageDeleted, Title: op.Title, Author: UserFrom(ctx)})
+		return 0, nil
+	}
+	return 0, NewError(http.StatusBadRequest, fmt.Sprintf("Unknown operation %q; use create, update or delete.", op.Op))
+}
@@ -0,0 +1,127 @@
+package wiki_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+// postBatch posts the operations to /api/v1/batch and returns the status
+// and results.
+func postBatch(t *testing.T, c *wikitest.Client, ops ...*wiki.BatchOperation) (int, []*wiki.BatchResult) {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"operations": ops})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.HTTP.Post(c.URL("/api/v1/batch"), "application/json", strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := wikitest.ReadBody(t, resp)
+	var batch struct {
+		Results []*wiki.BatchResult `json:"results"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal([]byte(body), &batch); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+	}
+	return resp.StatusCode, batch.Results
+}
+
+func TestBatch(t *testing.T) {
+	w := wikitest.New(t)
+	ed := w.Login("ed", wiki.RoleEditor)
+
+	status, results := postBatch(t, ed,
+		&wiki.BatchOperation{Op: wiki.OpCreate, Title: "One", Body: "first", Tags: []string{"a"}},
+		&wiki.BatchOperation{Op: wiki.OpCreate, Title: "Two", Body: "second"},
+		&wiki.BatchOperation{Op: wiki.OpUpdate, Title: "One", Body: "first again", BaseRevision: 1},
+		&wiki.BatchOperation{Op: wiki.OpUpdate, Title: "One", Body: "stale", BaseRevision: 1},
+		&wiki.BatchOperation{Op: wiki.OpCreate, Title: "Two", Body: "twice"},
+		&wiki.BatchOperation{Op: wiki.OpUpdate, Title: "Three", Body: "missing"},
+		&wiki.BatchOperation{Op: wiki.OpCreate, Title: "bad title!", Body: "x"},
+		&wiki.BatchOperation{Op: wiki.OpDelete, Title: "Two"},
+	)
+	if status != http.StatusOK || len(results) != 8 {
+		t.Fatalf("batch: %d, %d results", status, len(results))
+	}
+	for i, want := range []struct {
+		status   int
+		revision int
+	}{
+		{http.StatusCreated, 1},
+		{http.StatusCreated, 1},
+		{http.StatusOK, 2},
+		{http.StatusConflict, 2},
+		{http.StatusConflict, 0},
+		{http.StatusNotFound, 0},
+		{http.StatusBadRequest, 0},
+		{http.StatusOK, 0},
+	} {
+		if r := results[i]; r.Status != want.status || r.Revision != want.revision {
+			t.Errorf("operation %d on %s: %d at revision %d (%s), want %d at %d", i, r.Title, r.Status, r.Revision, r.Error, want.status, want.revision)
+		}
+	}
+
+	ctx := w.Context(nil)
+	if p, err := w.Server.GetPage(ctx, "One"); err != nil || string(p.Body) != "first again" || strings.Join(p.Tags, " ") != "a" || p.Author != "ed" {
+		t.Errorf("One is %+v, %v", p, err)
+	}
+	if _, err := w.Server.GetPage(ctx, "Two"); err == nil {
+		t.Error("Two wasn't deleted")
+	}
+
+	if status, _ := postBatch(t, w.Login("rita", wiki.RoleReader), &wiki.BatchOperation{Op: wiki.OpCreate, Title: "Mine", Body: "x"}); status != http.StatusForbidden {
+		t.Errorf("batch by a reader: %d, want 403", status)
+	}
+	if status, _ := postBatch(t, w.Client(), &wiki.BatchOperation{Op: wiki.OpCreate, Title: "Mine", Body: "x"}); status != http.StatusForbidden {
+		t.Errorf("batch by nobody: %d, want 403", status)
+	}
+	w.Server.SetReadOnly(true)
+	if status, _ := postBatch(t, ed, &wiki.BatchOperation{Op: wiki.OpCreate, Title: "Mine", Body: "x"}); status != http.StatusServiceUnavailable {
+		t.Errorf("batch in read-only mode: %d, want 503", status)
+	}
+	if _, err := w.Server.GetPage(ctx, "Mine"); err == nil {
+		t.Error("a refused batch saved Mine")
+	}
+}
+
+// ChangePage is what the gRPC service writes with, outside any request.
+func TestChangePage(t *testing.T) {
+	w := wikitest.New(t)
+	op := &wiki.BatchOperation{Op: wiki.OpCreate, Title: "One", Body: "first"}
+
+	for _, tc := range []struct {
+		name string
+		user *wiki.User
+		want int
+	}{
+		{"nobody", nil, http.StatusForbidden},
+		{"a reader", &wiki.User{Name: "rita", Role: wiki.RoleReader}, http.StatusForbidden},
+	} {
+		_, err := w.Server.ChangePage(w.Context(tc.user), op)
+		if e := (*wiki.Error)(nil); !errors.As(err, &e) || e.Status != tc.want {
+			t.Errorf("ChangePage as %s: %v, want %d", tc.name, err, tc.want)
+		}
+	}
+
+	ctx := w.Context(&wiki.User{Name: "ed", Role: wiki.RoleEditor})
+	if rev, err := w.Server.ChangePage(ctx, op); err != nil || rev != 1 {
+		t.Fatalf("ChangePage as an editor: %d, %v", rev, err)
+	}
+	w.Server.SetReadOnly(true)
+	_, err := w.Server.ChangePage(ctx, &wiki.BatchOperation{Op: wiki.OpUpdate, Title: "One", Body: "second"})
+	if e := (*wiki.Error)(nil); !errors.As(err, &e) || e.Status != http.StatusServiceUnavailable {
+		t.Errorf("ChangePage in read-only mode: %v, want 503", err)
+	}
+	if p, err := w.Server.GetPage(ctx, "One"); err != nil || p.Revision != 1 {
+		t.Errorf("One is %+v, %v; want it unchanged", p, err)
+	}
+}
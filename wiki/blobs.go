@@ -0,0 +1,163 @@
+package wiki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStorage keeps the content of attachments by its SHA-256 in
+// .attachments/.blobs/, so a file uploaded to many pages is stored once.
+// Each page's directory maps its file names to blobs in .blobs.json, and
+// .blobs/refs.json counts the names pointing at each blob; a blob is
+// deleted with the last of them. Files uploaded before blobs existed stay
+// plain files in the page's directory and are still served.
+
+// blobRef is a file of a page stored as a blob.
+type blobRef struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func (st *FileStorage) blobDir() string {
+	return filepath.Join(st.dir, ".attachments", ".blobs")
+}
+
+func (st *FileStorage) blobPath(hash string) string {
+	return filepath.Join(st.blobDir(), hash)
+}
+
+func (st *FileStorage) blobRefsPath() string {
+	return filepath.Join(st.blobDir(), "refs.json")
+}
+
+// blobIndexPath maps the names of a page's files to blobs.
+func (st *FileStorage) blobIndexPath(title string) string {
+	return filepath.Join(st.attachmentDir(title), ".blobs.json")
+}
+
+func (st *FileStorage) loadBlobIndex(title string) (map[string]*blobRef, error) {
+	index := make(map[string]*blobRef)
+	return index, readJSONFile(st.blobIndexPath(title), &index)
+}
+
+// writeBlob writes content to a temporary file in the blob directory and
+// returns its name, hash and size. attachBlob moves it in place.
+func (st *FileStorage) writeBlob(content io.Reader) (tmp string, ref *blobRef, err error) {
+	if err := os.MkdirAll(st.blobDir(), 0700); err != nil {
+		return "", nil, err
+	}
+	f, err := os.CreateTemp(st.blobDir(), ".upload-*")
+	if err != nil {
+		return "", nil, err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), content)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), &blobRef{Hash: hex.EncodeToString(h.Sum(nil)), Size: size, ModTime: time.Now().UTC()}, nil
+}
+
+// addBlobRefs changes the reference counts of blobs by delta each,
+// deleting the blobs nothing points at any more. Callers hold st.mu.
+func (st *FileStorage) addBlobRefs(hashes map[string]int) error {
+	refs := make(map[string]int)
+	if err := readJSONFile(st.blobRefsPath(), &refs); err != nil {
+		return err
+	}
+	for hash, delta := range hashes {
+		refs[hash] += delta
+		if refs[hash] > 0 {
+			continue
+		}
+		delete(refs, hash)
+		if err := os.Remove(st.blobPath(hash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return writeJSONFile(st.blobRefsPath(), refs)
+}
+
+// attachBlob points the named file of a page at the blob written to tmp,
+// which is dropped if the blob is stored already, and releases the blob or
+// plain file it replaces.
+func (st *FileStorage) attachBlob(title, name, tmp string, ref *blobRef) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	index, err := st.loadBlobIndex(title)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(st.blobPath(ref.Hash)); errors.Is(err, fs.ErrNotExist) {
+		if err := os.Rename(tmp, st.blobPath(ref.Hash)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	deltas := map[string]int{ref.Hash: 1}
+	if old := index[name]; old != nil {
+		deltas[old.Hash]--
+	}
+	if err := os.Remove(filepath.Join(st.attachmentDir(title), name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	index[name] = ref
+	if err := writeJSONFile(st.blobIndexPath(title), index); err != nil {
+		return err
+	}
+	return st.addBlobRefs(deltas)
+}
+
+// detachBlob removes the named file of a page if it is a blob, reporting
+// fs.ErrNotExist if it isn't.
+func (st *FileStorage) detachBlob(title, name string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	index, err := st.loadBlobIndex(title)
+	if err != nil {
+		return err
+	}
+	ref := index[name]
+	if ref == nil {
+		return fs.ErrNotExist
+	}
+	delete(index, name)
+	if err := writeJSONFile(st.blobIndexPath(title), index); err != nil {
+		return err
+	}
+	return st.addBlobRefs(map[string]int{ref.Hash: -1})
+}
+
+// releaseBlobs drops the references of all files of a page, which is
+// about to be deleted.
+func (st *FileStorage) releaseBlobs(title string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	index, err := st.loadBlobIndex(title)
+	if err != nil || len(index) == 0 {
+		return err
+	}
+	deltas := make(map[string]int)
+	for _, ref := range index {
+		deltas[ref.Hash]--
+	}
+	return st.addBlobRefs(deltas)
+}
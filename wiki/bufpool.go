@@ -0,0 +1,72 @@
+package wiki
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/oxtoacart/bpool" // A common use case for this package is to use buffers to execute HTML templates against (via ExecuteTemplate)
+	//or encode JSON into (via json.NewEncoder).
+	//This allows you to catch any rendering or marshalling errors prior to writing to a http.ResponseWriter,
+	//which helps to avoid writing incomplete or malformed data to the response.
+)
+
+// RenderConfig tunes the buffers pages are rendered into before they are
+// sent.
+type RenderConfig struct {
+	// Pool is "bpool" (the default), a fixed number of buffers, or "sync",
+	// a sync.Pool that grows with the load and shrinks when idle.
+	Pool string `json:"pool"`
+	// Buffers is how many buffers the bpool keeps, 64 by default.
+	Buffers int `json:"buffers"`
+	// BufferBytes is the capacity buffers start with. Buffers grown past
+	// it are dropped rather than kept for reuse. Zero keeps them all,
+	// however large.
+	BufferBytes int `json:"buffer_bytes"`
+}
+
+const defaultRenderBuffers = 64
+
+// bufferPool hands out the buffers templates render into.
+type bufferPool interface {
+	Get() *bytes.Buffer
+	Put(b *bytes.Buffer)
+}
+
+func (c RenderConfig) pool() (bufferPool, error) {
+	switch c.Pool {
+	case "", "bpool":
+		n := c.Buffers
+		if n <= 0 {
+			n = defaultRenderBuffers
+		}
+		if c.BufferBytes > 0 {
+			return bpool.NewSizedBufferPool(n, c.BufferBytes), nil
+		}
+		return bpool.NewBufferPool(n), nil
+	case "sync":
+		return &syncBufferPool{size: c.BufferBytes}, nil
+	}
+	return nil, fmt.Errorf("unknown render pool %q, want bpool or sync", c.Pool)
+}
+
+// syncBufferPool is a bufferPool on top of sync.Pool.
+type syncBufferPool struct {
+	pool sync.Pool
+	size int
+}
+
+func (sp *syncBufferPool) Get() *bytes.Buffer {
+	if b, ok := sp.pool.Get().(*bytes.Buffer); ok {
+		return b
+	}
+	return bytes.NewBuffer(make([]byte, 0, sp.size))
+}
+
+func (sp *syncBufferPool) Put(b *bytes.Buffer) {
+	if sp.size > 0 && b.Cap() > sp.size {
+		return
+	}
+	b.Reset()
+	sp.pool.Put(b)
+}
@@ -0,0 +1,50 @@
+package wiki
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"testing"
+)
+
+var benchTemplate = template.Must(template.New("page").Parse(`<article><h1>{{.Title}}</h1>{{range .Paragraphs}}<p>{{.}}</p>{{end}}</article>`))
+
+// BenchmarkRender renders a page into the buffers of each pool, from as
+// many goroutines as GOMAXPROCS, as concurrent requests would.
+func BenchmarkRender(b *testing.B) {
+	data := struct {
+		Title      string
+		Paragraphs []string
+	}{"Benchmark", make([]string, 200)}
+	for i := range data.Paragraphs {
+		data.Paragraphs[i] = strings.Repeat("Some text of the page & more. ", 10)
+	}
+
+	for _, c := range []struct {
+		name string
+		cfg  RenderConfig
+	}{
+		{"bpool", RenderConfig{Pool: "bpool"}},
+		{"bpool-sized", RenderConfig{Pool: "bpool", BufferBytes: 128 << 10}},
+		{"sync", RenderConfig{Pool: "sync"}},
+		{"sync-sized", RenderConfig{Pool: "sync", BufferBytes: 128 << 10}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			pool, err := c.cfg.pool()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					buf := pool.Get()
+					if err := benchTemplate.Execute(buf, data); err != nil {
+						b.Error(err)
+					}
+					buf.WriteTo(io.Discard)
+					pool.Put(buf)
+				}
+			})
+		})
+	}
+}
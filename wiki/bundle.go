@@ -0,0 +1,288 @@
+package wiki
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A bundle is a zip of everything the wiki holds, for teams that must
+// archive it and later show that the archive wasn't touched: the text of
+// every page in pages/<title>.md, its kept revisions in
+// revisions/<title>/<revision>.md and its attachments in
+// attachments/<title>/. manifest.json lists the SHA-256 of each file, and
+// manifest.sig is the HMAC of manifest.json with the wiki's first secret.
+// /api/v1/bundle/verify checks a bundle against both, so verifying takes
+// the wiki that made it, or another with its secret among Config.Secrets.
+
+// bundleFormat marks manifests, so other JSON isn't verified by mistake.
+const bundleFormat = "gowiki-bundle/1"
+
+const (
+	bundleManifest  = "manifest.json"
+	bundleSignature = "manifest.sig"
+	// maxBundleManifestBytes is the largest manifest verified.
+	maxBundleManifestBytes = 64 << 20
+)
+
+// BundleManifest is manifest.json of a bundle.
+type BundleManifest struct {
+	Format    string        `json:"format"`
+	CreatedAt time.Time     `json:"created_at"`
+	CreatedBy string        `json:"created_by"`
+	Files     []*BundleFile `json:"files"`
+}
+
+// BundleFile is a file of a bundle.
+type BundleFile struct {
+	Path  string `json:"path"`
+	Title string `json:"title"`
+	// Revision is that of the text, zero for attachments.
+	Revision   int    `json:"revision,omitempty"`
+	Attachment string `json:"attachment,omitempty"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+}
+
+// BundleVerification is the answer of /api/v1/bundle/verify. Valid is set
+// when the signature is right and every file is as the manifest says.
+type BundleVerification struct {
+	Valid     bool       `json:"valid"`
+	Signed    bool       `json:"signed"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	Files     int        `json:"files"`
+	// Altered files don't have the hash of the manifest, Missing ones are
+	// listed but not in the zip, and Extra ones are in the zip but not
+	// listed.
+	Altered []string `json:"altered"`
+	Missing []string `json:"missing"`
+	Extra   []string `json:"extra"`
+}
+
+// bundleWriter adds files to a bundle, noting them in its manifest.
+type bundleWriter struct {
+	zw       *zip.Writer
+	manifest *BundleManifest
+}
+
+func (bw *bundleWriter) add(f *BundleFile, modified time.Time, content io.Reader) error {
+	fw, err := bw.zw.CreateHeader(&zip.FileHeader{Name: f.Path, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if f.Size, err = io.Copy(io.MultiWriter(fw, h), content); err != nil {
+		return err
+	}
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+	bw.manifest.Files = append(bw.manifest.Files, f)
+	return nil
+}
+
+// bundleHandler sends the bundle of the whole wiki.
+func (s *Server) bundleHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	pages, err := s.allPages(ctx)
+	if err != nil {
+		return err
+	}
+	manifest := &BundleManifest{Format: bundleFormat, CreatedAt: time.Now().UTC(), CreatedBy: UserFrom(ctx)}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=wiki-"+manifest.CreatedAt.Format("20060102-150405")+".zip")
+	w.Header().Set("Cache-Control", "no-store")
+
+	bw := &bundleWriter{zw: zip.NewWriter(w), manifest: manifest}
+	for _, meta := range pages {
+		if err := s.bundlePage(ctx, bw, meta.Title); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	sig := s.keys.sign("bundle", string(data)) + "\n"
+	for _, f := range []struct {
+		name    string
+		content []byte
+	}{{bundleManifest, data}, {bundleSignature, []byte(sig)}} {
+		fw, err := bw.zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: manifest.CreatedAt})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	if err := bw.zw.Close(); err != nil {
+		return err
+	}
+	logf(ctx, "bundle of %d files made by %q", len(manifest.Files), UserFrom(ctx))
+	return nil
+}
+
+// bundlePage adds the text, kept revisions and attachments of a page,
+// unless it was deleted meanwhile.
+func (s *Server) bundlePage(ctx context.Context, bw *bundleWriter, title string) error {
+	p, err := s.loadPage(ctx, title)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	page := &BundleFile{Path: "pages/" + title + ".md", Title: title, Revision: p.Revision}
+	if err := bw.add(page, p.UpdatedAt, strings.NewReader(string(p.Body))); err != nil {
+		return err
+	}
+	if s.mountOf(title) != nil {
+		return nil
+	}
+
+	if rs, ok := s.store.(RevisionStore); ok {
+		kept, err := rs.Revisions(ctx, title)
+		if err != nil {
+			return err
+		}
+		for _, meta := range kept {
+			old, err := rs.LoadRevision(ctx, title, meta.Revision)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			f := &BundleFile{Path: "revisions/" + title + "/" + strconv.Itoa(old.Revision) + ".md", Title: title, Revision: old.Revision}
+			if err := bw.add(f, old.UpdatedAt, strings.NewReader(string(old.Body))); err != nil {
+				return err
+			}
+		}
+	}
+
+	as, ok := s.store.(AttachmentStore)
+	if !ok {
+		return nil
+	}
+	files, err := as.Attachments(ctx, title)
+	if err != nil {
+		return err
+	}
+	for _, a := range files {
+		rc, _, err := as.OpenAttachment(ctx, title, a.Name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f := &BundleFile{Path: "attachments/" + title + "/" + a.Name, Title: title, Attachment: a.Name}
+		err = bw.add(f, a.ModTime, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyBundleHandler checks the bundle in the body against its manifest
+// and the manifest against its signature.
+func (s *Server) verifyBundleHandler(w http.ResponseWriter, r *http.Request) error {
+	tmp, err := os.CreateTemp("", "gowiki-bundle-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, r.Body)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return NewError(http.StatusBadRequest, "The body is not a zip file.")
+	}
+
+	entries := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		if !f.FileInfo().IsDir() {
+			entries[f.Name] = f
+		}
+	}
+	mf := entries[bundleManifest]
+	if mf == nil {
+		return NewError(http.StatusBadRequest, "The zip has no "+bundleManifest+"; it is not a bundle.")
+	}
+	data, err := readZipFile(mf, maxBundleManifestBytes)
+	if err != nil {
+		return err
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Format != bundleFormat {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("%s is not the manifest of a bundle; the format should be %q.", bundleManifest, bundleFormat))
+	}
+
+	v := &BundleVerification{
+		CreatedAt: optionalTime(manifest.CreatedAt),
+		CreatedBy: manifest.CreatedBy,
+		Files:     len(manifest.Files),
+		Altered:   []string{},
+		Missing:   []string{},
+		Extra:     []string{},
+	}
+	if sf := entries[bundleSignature]; sf != nil {
+		if sig, err := readZipFile(sf, 1<<10); err == nil {
+			v.Signed, _ = s.keys.verify("bundle", string(data), strings.TrimSpace(string(sig)))
+		}
+	}
+	listed := map[string]bool{bundleManifest: true, bundleSignature: true}
+	for _, bf := range manifest.Files {
+		listed[bf.Path] = true
+		f := entries[bf.Path]
+		if f == nil {
+			v.Missing = append(v.Missing, bf.Path)
+			continue
+		}
+		sum, n, err := hashZipFile(f)
+		if err != nil || n != bf.Size || sum != bf.SHA256 {
+			v.Altered = append(v.Altered, bf.Path)
+		}
+	}
+	for name := range entries {
+		if !listed[name] {
+			v.Extra = append(v.Extra, name)
+		}
+	}
+	sort.Strings(v.Extra)
+	v.Valid = v.Signed && len(v.Altered) == 0 && len(v.Missing) == 0 && len(v.Extra) == 0
+	logf(r.Context(), "bundle of %s verified by %q: valid %t", manifest.CreatedAt.Format(time.RFC3339), UserFrom(r.Context()), v.Valid)
+	return writeJSON(w, http.StatusOK, v)
+}
+
+func hashZipFile(f *zip.File) (sum string, size int64, err error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", 0, err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if size, err = io.Copy(h, rc); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,122 @@
+package wiki
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache holds what the replicas of a wiki share: sign-in sessions, the
+// rendered markdown of pages and rate-limit counters. Config.Redis sets
+// one up; without it each server keeps its own in memory.
+type Cache interface {
+	// Get reports false for keys that aren't set or have expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl, or for good if ttl is zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Incr adds one to the counter at key and returns it. A new counter
+	// expires after ttl, however often it is incremented meanwhile.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+// maxCacheEntries bounds the in-memory cache of rendered pages and
+// counters. Sessions are kept in a cache of their own, so filling this one
+// never signs anyone out.
+const maxCacheEntries = 10000
+
+// memoryCache is the Cache of a single server.
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// max is how many entries are kept, zero for no limit
+	max int
+}
+
+type cacheEntry struct {
+	value   []byte
+	expires time.Time // zero for never
+}
+
+func (e cacheEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+func (mc *memoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e, ok := mc.entries[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (mc *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.set(key, value, ttl)
+	return nil
+}
+
+func (mc *memoryCache) set(key string, value []byte, ttl time.Duration) {
+	now := time.Now()
+	if mc.entries == nil {
+		mc.entries = make(map[string]cacheEntry)
+	}
+	if _, ok := mc.entries[key]; !ok {
+		mc.makeRoom(now)
+	}
+	e := cacheEntry{value: value}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	mc.entries[key] = e
+}
+
+// makeRoom drops the expired entries every so often, and any entry when
+// the cache is full.
+func (mc *memoryCache) makeRoom(now time.Time) {
+	if len(mc.entries)%1000 == 0 || (mc.max > 0 && len(mc.entries) >= mc.max) {
+		for k, e := range mc.entries {
+			if e.expired(now) {
+				delete(mc.entries, k)
+			}
+		}
+	}
+	for k := range mc.entries {
+		if mc.max == 0 || len(mc.entries) < mc.max {
+			break
+		}
+		delete(mc.entries, k)
+	}
+}
+
+func (mc *memoryCache) Delete(ctx context.Context, keys ...string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for _, k := range keys {
+		delete(mc.entries, k)
+	}
+	return nil
+}
+
+func (mc *memoryCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	e, ok := mc.entries[key]
+	if !ok || e.expired(time.Now()) {
+		mc.set(key, []byte("1"), ttl)
+		return 1, nil
+	}
+	n, _ := strconv.ParseInt(string(e.value), 10, 64)
+	n++
+	e.value = strconv.AppendInt(nil, n, 10)
+	mc.entries[key] = e
+	return n, nil
+}
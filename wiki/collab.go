@@ -0,0 +1,359 @@
+package wiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// Collaborative editing keeps one session per page being edited. Editors
+// send their changes as operations over a WebSocket; the session
+// transforms them against whatever was applied since the editor last
+// heard from it, applies them and relays them to the other editors, so
+// everyone converges on the same text. Saving writes the session's text
+// as a new revision.
+
+const (
+	collabPingInterval = 30 * time.Second
+	collabOutboxSize   = 64
+	// maxCollabHistory is how far behind an editor may fall before it is
+	// sent the text afresh, so the operations it may still need are
+	// dropped.
+	maxCollabHistory = 1000
+)
+
+// collabMessage is what goes over the socket in both directions.
+type collabMessage struct {
+	Type string `json:"type"`
+	// Rev counts the operations applied in the session.
+	Rev int    `json:"rev"`
+	Op  textOp `json:"op"`
+
+	Text     string `json:"text,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+	Author   string `json:"author,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type collabSession struct {
+	s     *Server
+	title string
+
+	mu  sync.Mutex
+	doc []uint16
+	// history holds the operations from the rev base on; those before
+	// were seen by every editor.
+	history []textOp
+	base    int
+	// revision is the stored revision the text is based on.
+	revision int
+	clients  map[*collabClient]bool
+}
+
+type collabClient struct {
+	user string
+	conn *wsConn
+	out  chan []byte
+	// rev is the oldest rev the client may still send an operation
+	// against: that of its last snapshot or ack. Guarded by the mu of the
+	// session.
+	rev int
+}
+
+// send queues msg for the client. A client that can't keep up is dropped
+// rather than holding up everyone else.
+func (c *collabClient) send(msg *collabMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case c.out <- data:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *collabClient) writeLoop() {
+	ping := time.NewTicker(collabPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case data, ok := <-c.out:
+			if !ok {
+				return
+			}
+			if err := c.conn.WriteMessage(data); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.writeFrame(wsPing, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// collabHandler connects an editor to the page's session.
+func (s *Server) collabHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	u := CurrentUser(r.Context())
+	if u == nil {
+		return Forbidden("Sign in to edit together with others.")
+	}
+	if !canEdit(r.Context()) {
+		return errReader
+	}
+
+	conn, err := upgradeWebSocket(w, r, s.cfg.MaxBodyBytes)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	c := &collabClient{user: u.Name, conn: conn, out: make(chan []byte, collabOutboxSize)}
+	go c.writeLoop()
+	defer close(c.out)
+
+	session, err := s.joinSession(r, title, c)
+	if err != nil {
+		logf(r.Context(), "collaborating on %s: %v", title, err)
+		c.send(&collabMessage{Type: "error", Message: "The page could not be loaded."})
+		return nil
+	}
+	defer s.leaveSession(session, c)
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if !errors.Is(err, errWebSocketClosed) {
+				logf(r.Context(), "collaborating on %s: %v", title, err)
+			}
+			return nil
+		}
+
+		var msg collabMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.send(&collabMessage{Type: "error", Message: "malformed message"})
+			continue
+		}
+		switch msg.Type {
+		case "op":
+			session.apply(c, &msg)
+		case "save":
+			session.save(r, c)
+		default:
+			c.send(&collabMessage{Type: "error", Message: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+// joinSession adds c to the session of a page, starting the session from
+// the stored text if nobody is editing the page yet.
+func (s *Server) joinSession(r *http.Request, title string, c *collabClient) (*collabSession, error) {
+	s.collabMu.Lock()
+	defer s.collabMu.Unlock()
+
+	session, ok := s.sessions[title]
+	if !ok {
+		session = &collabSession{s: s, title: title, clients: make(map[*collabClient]bool)}
+		p, err := s.loadPage(r.Context(), title)
+		switch {
+		case err == nil:
+			session.doc = utf16.Encode([]rune(string(p.Body)))
+			session.revision = p.Revision
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+
+		if s.sessions == nil {
+			s.sessions = make(map[string]*collabSession)
+		}
+		s.sessions[title] = session
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.clients[c] = true
+	session.sendSnapshot(c)
+	return session, nil
+}
+
+// leaveSession removes c and ends the session when the last editor leaves.
+// Unsaved changes are only kept in the editors' text areas.
+func (s *Server) leaveSession(session *collabSession, c *collabClient) {
+	s.collabMu.Lock()
+	defer s.collabMu.Unlock()
+
+	session.mu.Lock()
+	delete(session.clients, c)
+	empty := len(session.clients) == 0
+	session.mu.Unlock()
+
+	if empty {
+		delete(s.sessions, session.title)
+	}
+}
+
+// closeSessions disconnects every editor.
+func (s *Server) closeSessions() {
+	s.collabMu.Lock()
+	defer s.collabMu.Unlock()
+
+	for _, session := range s.sessions {
+		session.mu.Lock()
+		for c := range session.clients {
+			c.conn.Close()
+		}
+		session.mu.Unlock()
+	}
+}
+
+// rev counts the operations applied in the session. Must be called with
+// cs.mu held, as the methods below.
+func (cs *collabSession) rev() int {
+	return cs.base + len(cs.history)
+}
+
+// sendSnapshot brings an editor up to date, starting it over from the
+// session's text.
+func (cs *collabSession) sendSnapshot(c *collabClient) {
+	c.rev = cs.rev()
+	c.send(&collabMessage{
+		Type:     "init",
+		Rev:      c.rev,
+		Text:     string(utf16.Decode(cs.doc)),
+		Revision: cs.revision,
+	})
+}
+
+// compact drops the operations every editor has seen. Editors that fell
+// maxCollabHistory behind, as those only watching do, are sent the text
+// afresh first, so the history stays bounded.
+func (cs *collabSession) compact() {
+	rev := cs.rev()
+	oldest := rev
+	for c := range cs.clients {
+		if rev-c.rev > maxCollabHistory {
+			cs.sendSnapshot(c)
+		}
+		oldest = min(oldest, c.rev)
+	}
+	cs.history = cs.history[oldest-cs.base:]
+	cs.base = oldest
+}
+
+func (cs *collabSession) apply(from *collabClient, msg *collabMessage) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	// sessions opened before read-only mode was switched on stay open
+	if cs.s.ReadOnly() {
+		from.send(&collabMessage{Type: "error", Message: readOnlyMessage})
+		cs.sendSnapshot(from)
+		return
+	}
+	if msg.Rev < cs.base || msg.Rev > cs.rev() {
+		cs.sendSnapshot(from)
+		return
+	}
+
+	op := msg.Op
+	var err error
+	for _, applied := range cs.history[msg.Rev-cs.base:] {
+		if op, _, err = transformOps(op, applied); err != nil {
+			break
+		}
+	}
+	var doc []uint16
+	if err == nil {
+		doc, err = op.Apply(cs.doc)
+	}
+	if err == nil && int64(len(doc)) > cs.s.cfg.MaxBodyBytes {
+		err = fmt.Errorf("the page would exceed %d characters", cs.s.cfg.MaxBodyBytes)
+	}
+	if err != nil {
+		// start the editor over from the session's text
+		from.send(&collabMessage{Type: "error", Message: err.Error()})
+		cs.sendSnapshot(from)
+		return
+	}
+
+	cs.doc = doc
+	cs.history = append(cs.history, op)
+	rev := cs.rev()
+	for c := range cs.clients {
+		if c == from {
+			c.rev = rev
+			c.send(&collabMessage{Type: "ack", Rev: rev})
+		} else {
+			c.send(&collabMessage{Type: "op", Rev: rev, Op: op})
+		}
+	}
+	cs.compact()
+}
+
+// save stores the session's text as a new revision, as long as nobody
+// saved the page outside the session in the meantime.
+func (cs *collabSession) save(r *http.Request, from *collabClient) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.s.ReadOnly() {
+		from.send(&collabMessage{Type: "error", Message: readOnlyMessage})
+		return
+	}
+	ctx := r.Context()
+	p := &Page{Title: cs.title, Author: from.user}
+	stored, err := cs.s.loadPage(ctx, cs.title)
+	switch {
+	case err == nil:
+		if stored.Revision != cs.revision {
+			from.send(&collabMessage{Type: "error", Message: "The page was saved outside this session; reload the editor to continue."})
+			return
+		}
+		p.PublishAt = stored.PublishAt
+		p.Archived = stored.Archived
+		p.Tags = stored.Tags
+	case !errors.Is(err, fs.ErrNotExist):
+		from.send(&collabMessage{Type: "error", Message: "The page could not be saved."})
+		logf(ctx, "saving %s: %v", cs.title, err)
+		return
+	}
+	p.Body = []byte(string(utf16.Decode(cs.doc)))
+
+	mentions := cs.s.newMentions(ctx, p.Title, p.Body)
+	err = cs.s.checkLimits(p.Body)
+	if err == nil {
+		err = cs.s.checkPageQuota(ctx, p.Title, p.Author, p.Body)
+	}
+	if err == nil {
+		err = cs.s.hooks.pageSaving(ctx, p)
+	}
+	if err == nil {
+		err = cs.s.savePage(ctx, p)
+	}
+	if err != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			logf(ctx, "saving %s: %v", cs.title, err)
+			e = &Error{Message: "The page could not be saved."}
+		}
+		from.send(&collabMessage{Type: "error", Message: e.Message})
+		return
+	}
+	cs.s.publish(ctx, &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision, Author: p.Author, Mentions: mentions})
+
+	cs.revision = p.Revision
+	for c := range cs.clients {
+		c.send(&collabMessage{Type: "saved", Rev: cs.rev(), Revision: p.Revision, Author: p.Author})
+	}
+}
@@ -0,0 +1,72 @@
+package wiki
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// compareContext is how many unchanged lines are kept around the
+// differences of two pages, unless the whole diff is asked for.
+const compareContext = 3
+
+// CompareData is the data handed to the compare.html template.
+type CompareData struct {
+	Common
+	// A and B are the titles asked for, and the pages once both are found.
+	A, B         string
+	PageA, PageB *Page
+	Diff         []DiffLine
+	// Full shows the unchanged lines too.
+	Full bool
+	// Same is set when the pages have the same text.
+	Same bool
+}
+
+// compareHandler answers GET /compare?a=...&b=..., a diff from the text of
+// page a to that of page b. Without both titles it only shows the form.
+func (s *Server) compareHandler(w http.ResponseWriter, r *http.Request) error {
+	data := &CompareData{A: r.FormValue("a"), B: r.FormValue("b"), Full: r.FormValue("full") != ""}
+	if data.A == "" || data.B == "" {
+		return s.renderTemplate(r.Context(), w, "compare.html", data)
+	}
+
+	var err error
+	if data.PageA, err = s.pageToCompare(r, data.A); err != nil {
+		return err
+	}
+	if data.PageB, err = s.pageToCompare(r, data.B); err != nil {
+		return err
+	}
+
+	data.Diff = diffLines(string(data.PageA.Body), string(data.PageB.Body))
+	data.Same = !slices.ContainsFunc(data.Diff, func(l DiffLine) bool { return l.Kind != "same" })
+	if !data.Full {
+		data.Diff = contextDiff(data.Diff, compareContext)
+	}
+	return s.renderTemplate(r.Context(), w, "compare.html", data)
+}
+
+// pageToCompare loads a page for compareHandler, as long as readers can
+// see it and it is small enough to show.
+func (s *Server) pageToCompare(r *http.Request, title string) (*Page, error) {
+	if err := s.titles.check(title); err != nil {
+		return nil, NewError(http.StatusBadRequest, err.Error())
+	}
+	if err := s.checkRead(r, title); err != nil {
+		return nil, err
+	}
+	p, err := s.loadPageToShow(r.Context(), title)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !p.Published(time.Now())) {
+		return nil, NotFound("There is no page called " + title + ".")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.TooLarge {
+		return nil, NewError(http.StatusRequestEntityTooLarge, title+" is too large to compare in the browser.")
+	}
+	return p, nil
+}
@@ -0,0 +1,61 @@
+package wiki
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Requests that change something and are signed in with a session cookie
+// must come from the wiki's own pages. Browsers say where a request comes
+// from in Sec-Fetch-Site or Origin; when neither shows it is the wiki, the
+// request must carry the CSRF token, the session token signed for it. The
+// token is in the wiki_csrf cookie, which the layout's script copies into
+// forms as csrf_token and scripts send as X-CSRF-Token.
+
+const (
+	csrfCookie = "wiki_csrf"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken is the token of the session with the given token.
+func (s *Server) csrfToken(session string) string {
+	return s.keys.sign("csrf", session)
+}
+
+// fromWiki reports whether r, signed in with the session token session,
+// may go ahead.
+func (s *Server) fromWiki(r *http.Request, session string) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+		if u, err := url.Parse(r.Header.Get("Origin")); err == nil && u.Host != "" && u.Host == r.Host {
+			return true
+		}
+	}
+
+	token := r.Header.Get(csrfHeader)
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); token == "" && ct == "application/x-www-form-urlencoded" {
+		token = r.PostFormValue(csrfField)
+	}
+	ok, _ := s.keys.verify("csrf", session, token)
+	return ok
+}
+
+// refuseCrossSite answers a request fromWiki turned down.
+func (s *Server) refuseCrossSite(w http.ResponseWriter, r *http.Request) {
+	err := Forbidden("This request did not come from the wiki's own pages. Reload the page and try again.")
+	logf(r.Context(), "%s %s: refused, no CSRF token", r.Method, r.URL.Path)
+	if strings.HasPrefix(r.URL.Path, s.cfg.BasePath+apiPrefix+"/") {
+		writeJSON(w, err.Status, apiError{Error: err.Message})
+		return
+	}
+	s.renderError(w, r, err)
+}
@@ -0,0 +1,79 @@
+package wiki_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+func TestCrossSiteRequests(t *testing.T) {
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Accounts = wiki.AccountsConfig{Enabled: true, Signup: true}
+	})
+	w.CreatePage("Home", "Welcome")
+	c := signUp(t, w, "ann", "")
+	u, _ := url.Parse(c.URL("/"))
+	var token string
+	for _, cookie := range c.HTTP.Jar.Cookies(u) {
+		if cookie.Name == "wiki_csrf" {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		t.Fatal("no wiki_csrf cookie after signing up")
+	}
+
+	send := func(method, p, contentType, body string, header http.Header) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, c.URL(p), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = header
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wikitest.ReadBody(t, resp)
+		return resp
+	}
+	const form = "application/x-www-form-urlencoded"
+
+	for _, tc := range []struct {
+		name         string
+		method, path string
+		contentType  string
+		body         string
+		header       http.Header
+		want         int
+	}{
+		{"form without a token", "POST", "/save/Home", form, "body=pwned", http.Header{}, http.StatusForbidden},
+		{"form with a wrong token", "POST", "/save/Home", form, "body=pwned&csrf_token=" + url.QueryEscape(token+"x"), http.Header{}, http.StatusForbidden},
+		{"API without a token", "POST", "/api/v1/batch", "application/json", `{"operations":[]}`, http.Header{}, http.StatusForbidden},
+		{"reading", "GET", "/view/Home", "", "", http.Header{}, http.StatusOK},
+		{"form with the token", "POST", "/save/Home", form, "body=mine&csrf_token=" + url.QueryEscape(token), http.Header{}, http.StatusFound},
+		{"API with the token", "POST", "/api/v1/batch", "application/json", `{"operations":[]}`, http.Header{"X-Csrf-Token": {token}}, http.StatusOK},
+	} {
+		if resp := send(tc.method, tc.path, tc.contentType, tc.body, tc.header); resp.StatusCode != tc.want {
+			t.Errorf("%s: %s, want %d", tc.name, resp.Status, tc.want)
+		}
+	}
+	p, err := w.Server.GetPage(w.Context(nil), "Home")
+	if err != nil || string(p.Body) != "mine" {
+		t.Errorf("Home is %v, %v; want what the form with the token saved", p, err)
+	}
+
+	// without a session cookie there is nothing to forge
+	resp := w.Login("bob", wiki.RoleEditor).PostForm("/save/Other", url.Values{"body": {"x"}})
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusFound {
+		t.Errorf("saving as a user of the program: %s", resp.Status)
+	}
+}
@@ -0,0 +1,148 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Sizer is implemented by storage that can tell how much space it uses.
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+// DashboardData is the data handed to the admin/dashboard.html template.
+type DashboardData struct {
+	Common
+	Pages     int
+	Archived  int
+	Scheduled int
+	// StorageBytes is -1 when the storage can't tell.
+	StorageBytes int64
+	QueueDepth   int
+	PendingEdits int
+	BrokenLinks  int
+	ReadOnly     bool
+
+	// UserUsage and NamespaceUsage are the largest users and namespaces of
+	// storage, against their quotas.
+	UserUsage      []*UsageRow
+	NamespaceUsage []*UsageRow
+
+	// Accounts is set when the wiki manages its own users.
+	Accounts      bool
+	RecentSignups []*UserInfo
+	FailedLogins  int64
+
+	// Config is the wiki's configuration as JSON, without secrets.
+	Config string
+}
+
+// recentSignups is how many of the newest accounts the dashboard lists.
+const recentSignups = 5
+
+// redacted replaces secrets that are set with a placeholder, so the config
+// can be shown without revealing them.
+const redacted = "********"
+
+// dashboardHandler shows the state of the wiki and links to the other
+// admin pages.
+func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	all, err := s.allPages(ctx)
+	if err != nil {
+		return err
+	}
+	data := &DashboardData{Pages: len(all), StorageBytes: -1, QueueDepth: s.jobs.Len(), ReadOnly: s.ReadOnly()}
+	now := time.Now()
+	for _, p := range all {
+		if !p.Published(now) {
+			data.Scheduled++
+		} else if s.isArchived(p, now) {
+			data.Archived++
+		}
+	}
+
+	if sz, ok := s.store.(Sizer); ok {
+		if data.StorageBytes, err = sz.Size(ctx); err != nil {
+			return err
+		}
+	}
+	pending, err := s.moderation.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	data.PendingEdits = len(pending)
+	if data.UserUsage, data.NamespaceUsage, err = s.storageUsage(ctx); err != nil {
+		return err
+	}
+	if report, err := s.currentLinkReport(); err == nil {
+		data.BrokenLinks = len(report.Links)
+	}
+
+	if s.cfg.Accounts.Enabled {
+		accounts, err := s.accounts.Accounts(ctx)
+		if err != nil {
+			return err
+		}
+		sort.Slice(accounts, func(i, j int) bool { return accounts[i].CreatedAt.After(accounts[j].CreatedAt) })
+		for i := 0; i < len(accounts) && i < recentSignups; i++ {
+			data.RecentSignups = append(data.RecentSignups, userInfo(accounts[i]))
+		}
+		data.Accounts = true
+		data.FailedLogins = s.failedLogins.Load()
+	}
+
+	cfg := s.cfg
+	if cfg.Mail.Password != "" {
+		cfg.Mail.Password = redacted
+	}
+	if cfg.Spam.AkismetKey != "" {
+		cfg.Spam.AkismetKey = redacted
+	}
+	if cfg.Redis.Password != "" {
+		cfg.Redis.Password = redacted
+	}
+	if cfg.Anonymous.Salt != "" {
+		cfg.Anonymous.Salt = redacted
+	}
+	if len(cfg.Secrets) > 0 {
+		cfg.Secrets = []string{redacted}
+	}
+	if cfg.Shares.Secret != "" {
+		cfg.Shares.Secret = redacted
+	}
+	config, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	data.Config = string(config)
+
+	return s.renderTemplate(ctx, w, "admin/dashboard.html", data)
+}
+
+// Size adds up the files in the storage directory, including view counts,
+// held edits, notes and attachments.
+func (st *FileStorage) Size(ctx context.Context) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(st.dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return ctx.Err()
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
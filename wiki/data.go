@@ -0,0 +1,391 @@
+package wiki
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pages can hold structured data in fenced blocks, which are shown as
+// tables and can be gathered from many pages with a query directive.
+//
+// A data block holds one record, a "key: value" per line:
+//
+//	```data
+//	owner: alice
+//	service: billing
+//	```
+//
+// A csv block holds one record per row after the header row:
+//
+//	```csv
+//	service, owner
+//	billing, alice
+//	```
+//
+// A query directive, on a line of its own, shows the records of the pages
+// with the given tags as one table:
+//
+//	{{query: tag=runbook | fields=owner,service}}
+//
+// Its parts are separated by "|": tag (several, comma-separated), fields
+// (the columns, all of them by default), sort (a field, "-field" for
+// descending) and limit. Any other key=value keeps the records whose field
+// has that value. Like pages directives, queries run without knowing the
+// reader, so pages requiring sign-in are left out even for those signed
+// in.
+
+const (
+	fenceData = "data"
+	fenceCSV  = "csv"
+)
+
+const (
+	// maxQueryPages caps the pages a query reads records from.
+	maxQueryPages = 200
+	// maxQueryRows caps the rows a query shows.
+	maxQueryRows = 500
+)
+
+func isDataFence(fence string) bool {
+	return fence == fenceData || fence == fenceCSV
+}
+
+// dataRecord is a record of a data block. Values are keyed by the fields
+// lowercased, and Fields keeps them as written, in order.
+type dataRecord struct {
+	Fields []string
+	Values map[string]string
+}
+
+func (r *dataRecord) set(field, value string) {
+	key := strings.ToLower(field)
+	if _, ok := r.Values[key]; !ok {
+		r.Fields = append(r.Fields, field)
+	}
+	r.Values[key] = value
+}
+
+func (r *dataRecord) get(field string) string {
+	return r.Values[strings.ToLower(field)]
+}
+
+// parseDataBlock returns the records of the lines of a fenced block of
+// kind fence. Lines of data blocks without a colon, and malformed csv, are
+// skipped.
+func parseDataBlock(fence string, lines []string) []*dataRecord {
+	if fence == fenceData {
+		r := &dataRecord{Values: make(map[string]string)}
+		for _, line := range lines {
+			field, value, ok := strings.Cut(line, ":")
+			if field = strings.TrimSpace(field); ok && field != "" {
+				r.set(field, strings.TrimSpace(value))
+			}
+		}
+		if len(r.Fields) == 0 {
+			return nil
+		}
+		return []*dataRecord{r}
+	}
+
+	cr := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil || len(rows) < 2 {
+		return nil
+	}
+	header := rows[0]
+	var records []*dataRecord
+	for _, row := range rows[1:] {
+		r := &dataRecord{Values: make(map[string]string)}
+		for i, field := range header {
+			if field = strings.TrimSpace(field); field != "" && i < len(row) {
+				r.set(field, strings.TrimSpace(row[i]))
+			}
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// dataRecords returns the records of the data blocks of body, in order.
+func dataRecords(body string) []*dataRecord {
+	var records []*dataRecord
+	var fence string
+	var block []string
+	inCode := false
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			if inCode && isDataFence(fence) {
+				records = append(records, parseDataBlock(fence, block)...)
+				block = nil
+			} else if !inCode {
+				fence = strings.TrimSpace(trimmed[3:])
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode && isDataFence(fence) {
+			block = append(block, line)
+		}
+	}
+	if inCode && isDataFence(fence) {
+		records = append(records, parseDataBlock(fence, block)...)
+	}
+	return records
+}
+
+// dataTable renders a data block: a data block as a table of fields and
+// values, a csv block with the header row on top.
+func dataTable(fence string, lines []string) string {
+	records := parseDataBlock(fence, lines)
+	if len(records) == 0 {
+		return ""
+	}
+	var out strings.Builder
+	out.WriteString(`<table class="data">` + "\n")
+	if fence == fenceData {
+		r := records[0]
+		for _, field := range r.Fields {
+			out.WriteString("<tr><th>" + html.EscapeString(field) + "</th><td>" + inlineMarkdown(r.get(field), nil) + "</td></tr>\n")
+		}
+	} else {
+		writeTableRows(&out, csvColumns(lines), records, nil)
+	}
+	out.WriteString("</table>\n")
+	return out.String()
+}
+
+// csvColumns returns the header row of a csv block.
+func csvColumns(lines []string) []string {
+	cr := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil
+	}
+	return slices.DeleteFunc(header, func(f string) bool { return strings.TrimSpace(f) == "" })
+}
+
+// writeTableRows writes a header row of columns and a row per record. When
+// first is set, each row starts with what it returns for its record.
+func writeTableRows(out *strings.Builder, columns []string, records []*dataRecord, first func(i int) string) {
+	out.WriteString("<tr>")
+	if first != nil {
+		out.WriteString("<th>Page</th>")
+	}
+	for _, c := range columns {
+		out.WriteString("<th>" + html.EscapeString(c) + "</th>")
+	}
+	out.WriteString("</tr>\n")
+	for i, r := range records {
+		out.WriteString("<tr>")
+		if first != nil {
+			out.WriteString("<td>" + first(i) + "</td>")
+		}
+		for _, c := range columns {
+			out.WriteString("<td>" + inlineMarkdown(r.get(c), nil) + "</td>")
+		}
+		out.WriteString("</tr>\n")
+	}
+}
+
+// hasQuery reports whether src may hold a query directive.
+func hasQuery(src []byte) bool {
+	return bytes.Contains(src, []byte("{{query:"))
+}
+
+// queryDirective returns the spec of a line holding a query directive.
+func queryDirective(line string) (string, bool) {
+	spec, ok := strings.CutPrefix(line, "{{query:")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(spec, "}}")
+}
+
+// dataQuery is a parsed query directive.
+type dataQuery struct {
+	Tags    []string
+	Fields  []string
+	Filters map[string]string
+	Sort    string
+	Desc    bool
+	Limit   int
+}
+
+func parseDataQuery(spec string) (*dataQuery, error) {
+	q := &dataQuery{Filters: make(map[string]string), Limit: maxQueryRows}
+	for _, part := range strings.Split(spec, "|") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not of the form key=value", part)
+		}
+		switch key {
+		case "tag", "tags":
+			q.Tags = append(q.Tags, parseTags(value)...)
+		case "fields":
+			for _, f := range strings.Split(value, ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					q.Fields = append(q.Fields, f)
+				}
+			}
+		case "sort":
+			q.Sort, q.Desc = strings.CutPrefix(value, "-")
+			if q.Sort == "" {
+				return nil, errors.New("sort needs a field")
+			}
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxQueryRows {
+				return nil, fmt.Errorf("limit is a number from 1 to %d", maxQueryRows)
+			}
+			q.Limit = n
+		default:
+			q.Filters[key] = value
+		}
+	}
+	if len(q.Tags) == 0 {
+		return nil, errors.New("give the tag of the pages to query")
+	}
+	return q, nil
+}
+
+// runQuery renders a query directive as a table, or the reason it can't
+// be run.
+func (s *Server) runQuery(spec string) template.HTML {
+	// templates have no request to take a context from, so only pages
+	// anyone may read are queried
+	ctx := context.Background()
+	q, err := parseDataQuery(spec)
+	if err == nil {
+		var out string
+		if out, err = s.renderQuery(ctx, q); err == nil {
+			return template.HTML(out)
+		}
+		logf(ctx, "running query %q: %v", spec, err)
+		err = errors.New("the pages could not be read")
+	}
+	return template.HTML(`<p class="query-error">Query: ` + html.EscapeString(err.Error()) + "</p>\n")
+}
+
+func (s *Server) renderQuery(ctx context.Context, q *dataQuery) (string, error) {
+	results, err := s.search(ctx, SearchQuery{Tags: q.Tags, Sort: SortTitle})
+	if err != nil {
+		return "", err
+	}
+
+	var records []*dataRecord
+	var titles []string
+	now, read := time.Now(), 0
+	for _, res := range results {
+		if s.isArchived(res.Page, now) {
+			continue
+		}
+		if read++; read > maxQueryPages {
+			break
+		}
+		p, err := s.loadPage(ctx, res.Title)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, r := range dataRecords(string(p.Body)) {
+			if matchesFilters(r, q.Filters) {
+				records = append(records, r)
+				titles = append(titles, p.Title)
+			}
+		}
+	}
+
+	if q.Sort != "" {
+		order := make([]int, len(records))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int {
+			c := compareValues(records[a].get(q.Sort), records[b].get(q.Sort))
+			if q.Desc {
+				c = -c
+			}
+			return c
+		})
+		sorted, sortedTitles := make([]*dataRecord, len(order)), make([]string, len(order))
+		for i, j := range order {
+			sorted[i], sortedTitles[i] = records[j], titles[j]
+		}
+		records, titles = sorted, sortedTitles
+	}
+	if len(records) > q.Limit {
+		records, titles = records[:q.Limit], titles[:q.Limit]
+	}
+	if len(records) == 0 {
+		return `<p class="query-empty">No data matches.</p>` + "\n", nil
+	}
+
+	columns := q.Fields
+	if len(columns) == 0 {
+		seen := make(map[string]bool)
+		for _, r := range records {
+			for _, f := range r.Fields {
+				if key := strings.ToLower(f); !seen[key] {
+					seen[key] = true
+					columns = append(columns, f)
+				}
+			}
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString(`<table class="data query">` + "\n")
+	writeTableRows(&out, columns, records, func(i int) string {
+		return `<a href="` + html.EscapeString(s.pagePath("view", titles[i])) + `">` + html.EscapeString(titles[i]) + "</a>"
+	})
+	out.WriteString("</table>\n")
+	return out.String(), nil
+}
+
+func matchesFilters(r *dataRecord, filters map[string]string) bool {
+	for field, value := range filters {
+		if !strings.EqualFold(r.get(field), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues orders numbers by value and everything else as text, with
+// numbers first.
+func compareValues(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(x, y)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
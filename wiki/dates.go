@@ -0,0 +1,278 @@
+package wiki
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DateConfig is how the wiki shows times. Signed-in users can choose
+// their own time zone and formats on their account page.
+type DateConfig struct {
+	// TimeZone is an IANA name such as "Europe/Paris"; UTC by default.
+	TimeZone string `json:"time_zone"`
+	// DateFormat and TimeFormat are Go layouts, "2 Jan 2006" and
+	// "15:04 MST" by default. Times shown with their date use both.
+	DateFormat string `json:"date_format"`
+	TimeFormat string `json:"time_format"`
+	// Locale is how dates and numbers are written for readers whose
+	// browser asks for no locale the wiki knows, such as "en-GB"; its
+	// formats are used where DateFormat and TimeFormat are empty. Empty
+	// writes numbers as English does and dates in the default formats.
+	Locale string `json:"locale"`
+}
+
+const (
+	defaultDateFormat = "2 Jan 2006"
+	defaultTimeFormat = "15:04 MST"
+)
+
+// dateStyle is a DateConfig with the defaults filled in.
+type dateStyle struct {
+	Zone, Date, Time string
+	Locale           string
+}
+
+func (c DateConfig) style() dateStyle {
+	return c.styleIn(c.Locale)
+}
+
+// styleIn is style in the locale tag, whose formats fill in those c leaves
+// empty.
+func (c DateConfig) styleIn(tag string) dateStyle {
+	st := dateStyle{Zone: c.TimeZone, Date: c.DateFormat, Time: c.TimeFormat}
+	if l := findLocale(tag); l != nil {
+		st.Locale = l.Tag
+		if st.Date == "" {
+			st.Date = l.date
+		}
+		if st.Time == "" {
+			st.Time = l.time
+		}
+	}
+	if st.Zone == "" {
+		st.Zone = "UTC"
+	}
+	if st.Date == "" {
+		st.Date = defaultDateFormat
+	}
+	if st.Time == "" {
+		st.Time = defaultTimeFormat
+	}
+	return st
+}
+
+// DateFormat is a format users can pick, Example being today in it.
+type DateFormat struct {
+	Layout  string
+	Example string
+}
+
+// dateFormats and timeFormats are the choices offered to users.
+var (
+	dateFormats = []string{"2 Jan 2006", "Jan 2, 2006", "2006-01-02", "02/01/2006", "01/02/2006", "02.01.2006"}
+	timeFormats = []string{"15:04 MST", "3:04 PM MST", "15:04", "3:04 PM"}
+)
+
+func formatChoices(layouts []string, now time.Time) []DateFormat {
+	choices := make([]DateFormat, len(layouts))
+	for i, layout := range layouts {
+		choices[i] = DateFormat{Layout: layout, Example: now.Format(layout)}
+	}
+	return choices
+}
+
+var locations sync.Map // of *time.Location, by name
+
+// loadLocation is time.LoadLocation, minus reading the zone database every
+// time.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// location returns the zone of st, falling back to UTC for zones that
+// disappeared from the database since they were chosen.
+func (st dateStyle) location() *time.Location {
+	loc, err := loadLocation(st.Zone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// browserStyle returns the wiki's style in the locale the browser of ctx
+// asks for.
+func (s *Server) browserStyle(ctx context.Context) dateStyle {
+	if tag := browserLocale(ctx); tag != "" {
+		return s.cfg.Dates.styleIn(tag)
+	}
+	return s.cfg.Dates.style()
+}
+
+// dateStyle returns how the user of ctx sees times and numbers: the wiki's
+// style in their browser's locale, with their own choices on top.
+func (s *Server) dateStyle(ctx context.Context) dateStyle {
+	st := s.browserStyle(ctx)
+	user := UserFrom(ctx)
+	if user == "" {
+		return st
+	}
+	d, err := s.userData.UserData(ctx, user)
+	if err != nil {
+		logf(ctx, "loading the data of %s: %v", user, err)
+		return st
+	}
+	if d.Locale != "" {
+		st = s.cfg.Dates.styleIn(d.Locale)
+	}
+	if d.TimeZone != "" {
+		st.Zone = d.TimeZone
+	}
+	if d.DateFormat != "" {
+		st.Date = d.DateFormat
+	}
+	if d.TimeFormat != "" {
+		st.Time = d.TimeFormat
+	}
+	return st
+}
+
+// dateFuncs are the template functions showing times in st:
+//
+//	{{date .T}}             the date
+//	{{datetime .T}}         the date and time
+//	{{datefmt "15:04" .T}}  any layout, in the time zone of st
+//	{{timezone}}            the name of that zone
+//	{{number .Views}}       a number, with the separators of the locale
+//
+// The zero time renders as an empty string.
+func dateFuncs(st dateStyle) template.FuncMap {
+	loc := st.location()
+	format := func(layout string, t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.In(loc).Format(layout)
+	}
+	return template.FuncMap{
+		"date":     func(t time.Time) string { return format(st.Date, t) },
+		"datetime": func(t time.Time) string { return format(st.Date+" "+st.Time, t) },
+		"datefmt":  format,
+		"timezone": func() string { return st.Zone },
+		"number":   func(n interface{}) string { return formatNumber(st.Locale, n) },
+	}
+}
+
+// lookupTemplate returns the set for name as the user of ctx sees it: the
+// shared one, or one showing times the way they chose. Config.Funcs still
+// win over the date functions.
+func (s *Server) lookupTemplate(ctx context.Context, name string) (*template.Template, bool) {
+	st := s.dateStyle(ctx)
+	if st == s.cfg.Dates.style() {
+		return s.templates.Lookup(name)
+	}
+	funcs := dateFuncs(st)
+	for fn := range s.cfg.Funcs {
+		delete(funcs, fn)
+	}
+	return s.templates.LookupStyled(name, st, funcs)
+}
+
+// DatesData is the data handed to the dates.html template.
+type DatesData struct {
+	Common
+	Zone, Date, Time string
+	Locale           string
+	DateFormats      []DateFormat
+	TimeFormats      []DateFormat
+	Locales          []*Locale
+	// Default is the wiki's style in the browser's locale, used for what
+	// the user leaves empty.
+	Default dateStyle
+	Error   string
+}
+
+func (s *Server) datesData(ctx context.Context, user string) (*DatesData, error) {
+	d, err := s.userData.UserData(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().In(s.dateStyle(ctx).location())
+	return &DatesData{
+		Zone:        d.TimeZone,
+		Date:        d.DateFormat,
+		Time:        d.TimeFormat,
+		Locale:      d.Locale,
+		DateFormats: formatChoices(dateFormats, now),
+		TimeFormats: formatChoices(timeFormats, now),
+		Locales:     locales,
+		Default:     s.browserStyle(ctx),
+	}, nil
+}
+
+func (s *Server) datesFormHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("You need to sign in first.")
+	}
+	data, err := s.datesData(r.Context(), user)
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(r.Context(), w, "dates.html", data)
+}
+
+// datesHandler saves the user's time zone, formats and locale. Empty
+// fields go back to the wiki's.
+func (s *Server) datesHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("You need to sign in first.")
+	}
+	zone, date, clock := r.PostFormValue("time_zone"), r.PostFormValue("date_format"), r.PostFormValue("time_format")
+	tag := r.PostFormValue("locale")
+
+	var problem string
+	if zone != "" {
+		if _, err := loadLocation(zone); err != nil {
+			problem = "There is no time zone called " + zone + "."
+		}
+	}
+	if (date != "" && !slices.Contains(dateFormats, date)) || (clock != "" && !slices.Contains(timeFormats, clock)) {
+		problem = "Pick one of the formats offered."
+	}
+	if l := findLocale(tag); tag != "" && (l == nil || l.Tag != tag) {
+		problem = "Pick one of the languages offered."
+	}
+	if problem != "" {
+		data, err := s.datesData(r.Context(), user)
+		if err != nil {
+			return err
+		}
+		data.Zone, data.Error = zone, problem
+		return s.writeTemplate(r.Context(), w, http.StatusBadRequest, "dates.html", data)
+	}
+
+	err := s.updateUserData(r.Context(), user, func(d *UserData) error {
+		d.TimeZone, d.DateFormat, d.TimeFormat, d.Locale = zone, date, clock, tag
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.flash(w, r, FlashInfo, "Your date settings were saved.")
+	http.Redirect(w, r, s.pagePath("account", ""), http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,110 @@
+package wiki
+
+import "strings"
+
+// DiffLine is one line of a line-by-line comparison of two texts.
+type DiffLine struct {
+	// Kind is "same", "add", "del", or "skip" for a run of unchanged
+	// lines left out of a contextDiff.
+	Kind string
+	Text string
+}
+
+// maxDiffCells bounds the work of the longest common subsequence: past it,
+// the differing middle of the texts is shown as replaced wholesale.
+const maxDiffCells = 1 << 20
+
+// diffLines compares two texts line by line.
+func diffLines(a, b string) []DiffLine {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// most edits touch a few lines; skip what they share at both ends
+	pre := 0
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+
+	var out []DiffLine
+	for _, l := range x[:pre] {
+		out = append(out, DiffLine{"same", l})
+	}
+	out = append(out, diffMiddle(x[pre:len(x)-suf], y[pre:len(y)-suf])...)
+	for _, l := range x[len(x)-suf:] {
+		out = append(out, DiffLine{"same", l})
+	}
+	return out
+}
+
+func diffMiddle(x, y []string) []DiffLine {
+	var out []DiffLine
+	if len(x)*len(y) > maxDiffCells {
+		for _, l := range x {
+			out = append(out, DiffLine{"del", l})
+		}
+		for _, l := range y {
+			out = append(out, DiffLine{"add", l})
+		}
+		return out
+	}
+
+	// lcs[i][j] is the length of the common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			out = append(out, DiffLine{"same", x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, DiffLine{"del", x[i]})
+			i++
+		default:
+			out = append(out, DiffLine{"add", y[j]})
+			j++
+		}
+	}
+	return out
+}
+
+// contextDiff keeps the changed lines of a diff and n unchanged lines
+// around each, replacing longer unchanged runs with a "skip" line.
+func contextDiff(lines []DiffLine, n int) []DiffLine {
+	keep := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Kind == "same" {
+			continue
+		}
+		for j := max(0, i-n); j <= min(len(lines)-1, i+n); j++ {
+			keep[j] = true
+		}
+	}
+
+	var out []DiffLine
+	for i, l := range lines {
+		switch {
+		case keep[i]:
+			out = append(out, l)
+		case len(out) == 0 || out[len(out)-1].Kind != "skip":
+			out = append(out, DiffLine{Kind: "skip"})
+		}
+	}
+	return out
+}
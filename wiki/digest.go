@@ -0,0 +1,122 @@
+package wiki
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JobDigest sends the digest of the pages changed between Since and Until.
+// Its payload is a DigestWindow.
+const JobDigest = "digest.send"
+
+// DigestConfig schedules a mail summing up the pages changed recently.
+type DigestConfig struct {
+	// Recipients get the digest. None disables it.
+	Recipients []string `json:"recipients"`
+	// Interval is "daily" (the default) or "weekly".
+	Interval string `json:"interval"`
+}
+
+// DigestWindow is the payload of JobDigest.
+type DigestWindow struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// digestSummaryLength is about how much of each page the digest quotes.
+const digestSummaryLength = 200
+
+func (c DigestConfig) interval() (time.Duration, error) {
+	return parseInterval("digest", c.Interval)
+}
+
+// scheduleDigests enqueues a JobDigest every interval. The queue does the
+// sending, so a failing mail server gets retried.
+func (s *Server) scheduleDigests(interval time.Duration) {
+	s.schedule("digest", interval, func(last, now time.Time) error {
+		return s.jobs.Enqueue(JobDigest, DigestWindow{Since: last, Until: now})
+	})
+}
+
+func (s *Server) sendDigest(ctx context.Context, job *Job) error {
+	var window DigestWindow
+	if err := job.Decode(&window); err != nil {
+		return err
+	}
+
+	pages, err := s.listPages(ctx)
+	if err != nil {
+		return err
+	}
+	var changed []*Page
+	for _, p := range pages {
+		if !p.UpdatedAt.Before(window.Since) && p.UpdatedAt.Before(window.Until) {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].UpdatedAt.After(changed[j].UpdatedAt) })
+
+	var body strings.Builder
+	st := s.cfg.Dates.style()
+	fmt.Fprintf(&body, "Pages changed since %s:\n", window.Since.In(st.location()).Format(st.Date+" "+st.Time))
+	for _, p := range changed {
+		fmt.Fprintf(&body, "\n%s (revision %d", p.Title, p.Revision)
+		if p.Author != "" {
+			fmt.Fprintf(&body, " by %s", p.Author)
+		}
+		fmt.Fprintf(&body, ")\n%s\n", s.absoluteURL(s.pagePath("view", p.Title)))
+
+		if full, err := s.loadPage(ctx, p.Title); err == nil {
+			if summary := summarize(full.Body, digestSummaryLength); summary != "" {
+				fmt.Fprintf(&body, "%s\n", summary)
+			}
+		}
+	}
+
+	subject := fmt.Sprintf("%d pages changed on the wiki", len(changed))
+	if len(changed) == 1 {
+		subject = "1 page changed on the wiki"
+	}
+	return s.mailer.Send(ctx, s.cfg.Digest.Recipients, subject, body.String())
+}
+
+// absoluteURL turns a path from pagePath into a link usable outside the
+// wiki, using Config.PublicURL.
+func (s *Server) absoluteURL(p string) string {
+	return strings.TrimSuffix(s.cfg.PublicURL, "/") + p
+}
+
+// summarize returns the first paragraph of a page's text, without markdown
+// headings, cut to about max bytes on a word boundary.
+func summarize(body []byte, max int) string {
+	var para []string
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if len(para) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "```") {
+			continue
+		}
+		para = append(para, line)
+	}
+
+	summary := strings.Join(para, " ")
+	if len(summary) <= max {
+		return summary
+	}
+	cut := strings.LastIndex(summary[:max], " ")
+	if cut <= 0 {
+		cut = max
+	}
+	return strings.TrimSpace(summary[:cut]) + "…"
+}
@@ -0,0 +1,316 @@
+package wiki
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Books are exported from a namespace: the pages below a title, such as
+// team-x/Setup and team-x/Deploy below team-x. The page named after the
+// namespace, if there is one, is the index: it comes first, and the
+// chapters follow in the order it links to them, then the rest by title.
+// Sidebar pages are left out.
+
+const (
+	BookHTML = "html"
+	BookEPUB = "epub"
+)
+
+// maxBookPages caps the chapters of a book.
+const maxBookPages = 1000
+
+// book is a namespace gathered for export.
+type book struct {
+	Title    string
+	Chapters []*chapter
+	Made     time.Time
+}
+
+type chapter struct {
+	Page *Page
+	// ID names the chapter inside the book, and File is where it lives in
+	// an EPUB.
+	ID, File string
+	HTML     string
+}
+
+// bookHandler answers GET /book/{namespace...}?format=html|epub.
+func (s *Server) bookHandler(w http.ResponseWriter, r *http.Request, ns string) error {
+	format := r.FormValue("format")
+	if format == "" {
+		format = BookHTML
+	}
+	if format != BookHTML && format != BookEPUB {
+		return NewError(http.StatusBadRequest, "Books can be exported as html or epub.")
+	}
+
+	b, err := s.gatherBook(r.Context(), ns)
+	if err != nil {
+		return err
+	}
+
+	// rendered before the headers are set, so a chapter too complex to
+	// render makes an error page rather than a broken download
+	link := func(c *chapter) string { return "#" + c.ID }
+	if format == BookEPUB {
+		link = func(c *chapter) string { return c.File }
+	}
+	if err := s.renderChapters(b, link); err != nil {
+		return err
+	}
+
+	name := strings.ReplaceAll(ns, "/", "-") + "." + format
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name))
+	if format == BookEPUB {
+		w.Header().Set("Content-Type", "application/epub+zip")
+		return s.writeEPUB(w, b)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return s.writeBookHTML(w, b)
+}
+
+// gatherBook collects the pages of ns the user of ctx may read, in order.
+func (s *Server) gatherBook(ctx context.Context, ns string) (*book, error) {
+	pages, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages = s.readablePages(ctx, pages)
+
+	var index *Page
+	var below []*Page
+	for _, p := range pages {
+		switch {
+		case p.Title == ns:
+			index = p
+		case strings.HasPrefix(p.Title, ns+"/") && !slices.Contains(sidebarPages, path.Base(p.Title)):
+			below = append(below, p)
+		}
+	}
+	if index == nil && len(below) == 0 {
+		return nil, NotFound("There are no pages in " + ns + ".")
+	}
+	slices.SortFunc(below, func(a, b *Page) int { return strings.Compare(a.Title, b.Title) })
+
+	var ordered []*Page
+	if index != nil {
+		if index, err = s.loadPage(ctx, index.Title); err != nil {
+			return nil, err
+		}
+		ordered = append(ordered, index)
+		for _, title := range s.linkedTitles(index.Body) {
+			if i := slices.IndexFunc(below, func(p *Page) bool { return p.Title == title }); i >= 0 {
+				ordered = append(ordered, below[i])
+				below = slices.Delete(below, i, i+1)
+			}
+		}
+	}
+	ordered = append(ordered, below...)
+	if len(ordered) > maxBookPages {
+		return nil, NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("%s has more than %d pages, too many for one book.", ns, maxBookPages))
+	}
+
+	b := &book{Title: ns, Made: time.Now().UTC()}
+	for i, p := range ordered {
+		if i > 0 || index == nil {
+			p, err = s.loadPage(ctx, p.Title)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue // deleted meanwhile
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		id := fmt.Sprintf("chapter-%d", len(b.Chapters)+1)
+		b.Chapters = append(b.Chapters, &chapter{Page: p, ID: id, File: id + ".xhtml"})
+	}
+	return b, nil
+}
+
+// linkedTitles returns the pages body links to, in order, whether the
+// links are to a page's view path or relative titles.
+func (s *Server) linkedTitles(body []byte) []string {
+	var titles []string
+	for _, m := range mdLink.FindAllStringSubmatch(string(body), -1) {
+		href, _, _ := strings.Cut(m[2], "#")
+		if title, ok := strings.CutPrefix(href, s.pagePath("view", "")+"/"); ok {
+			href = title
+		} else if strings.HasPrefix(href, "/") || strings.Contains(href, ":") {
+			continue
+		}
+		if title, err := url.PathUnescape(href); err == nil && !slices.Contains(titles, title) {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// renderChapters renders the chapters of b, pointing links between them at
+// link(chapter) instead of the wiki.
+func (s *Server) renderChapters(b *book, link func(c *chapter) string) error {
+	for _, c := range b.Chapters {
+		out, err := s.markdown(c.Page.Body)
+		if err != nil {
+			return fmt.Errorf("rendering %s: %w", c.Page.Title, err)
+		}
+		c.HTML = string(out)
+	}
+	for _, c := range b.Chapters {
+		for _, other := range b.Chapters {
+			href := `href="` + html.EscapeString(s.pagePath("view", other.Page.Title)) + `"`
+			c.HTML = strings.ReplaceAll(c.HTML, href, `href="`+link(other)+`"`)
+		}
+	}
+	return nil
+}
+
+var bookHTML = template.Must(template.New("book").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<nav>
+<h2>Contents</h2>
+<ol>
+{{range .Chapters}}<li><a href="#{{.ID}}">{{.Page.Title}}</a></li>
+{{end}}</ol>
+</nav>
+{{range .Chapters}}
+<section id="{{.ID}}">
+<h1>{{.Page.Title}}</h1>
+{{.Body}}
+</section>
+{{end}}
+<footer><p>Exported on {{.Made.Format "2 Jan 2006 15:04 MST"}}.</p></footer>
+</body>
+</html>
+`))
+
+// writeBookHTML writes b, its chapters rendered, as one HTML document, the
+// table of contents on top.
+func (s *Server) writeBookHTML(w io.Writer, b *book) error {
+	type htmlChapter struct {
+		*chapter
+		Body template.HTML
+	}
+	data := struct {
+		*book
+		Chapters []htmlChapter
+	}{book: b}
+	for _, c := range b.Chapters {
+		data.Chapters = append(data.Chapters, htmlChapter{c, template.HTML(c.HTML)})
+	}
+	return bookHTML.Execute(w, data)
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+// writeEPUB writes b, its chapters rendered, as an EPUB 3 book, a chapter
+// per page.
+func (s *Server) writeEPUB(w io.Writer, b *book) error {
+
+	zw := zip.NewWriter(w)
+	// the mimetype comes first and uncompressed, so readers can sniff it
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store, Modified: b.Made})
+	if err != nil {
+		return err
+	}
+	io.WriteString(f, "application/epub+zip")
+
+	write := func(name, content string) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: b.Made})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+	if err := write("META-INF/container.xml", epubContainer); err != nil {
+		return err
+	}
+	if err := write("OEBPS/content.opf", epubPackage(b)); err != nil {
+		return err
+	}
+	if err := write("OEBPS/nav.xhtml", epubNav(b)); err != nil {
+		return err
+	}
+	for _, c := range b.Chapters {
+		if err := write(path.Join("OEBPS", c.File), xhtml(c.Page.Title, "<h1>"+xmlEscape(c.Page.Title)+"</h1>\n"+c.HTML)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func epubPackage(b *book) string {
+	var manifest, spine strings.Builder
+	for _, c := range b.Chapters {
+		fmt.Fprintf(&manifest, "    <item id=\"%s\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", c.ID, c.File)
+		fmt.Fprintf(&spine, "    <itemref idref=\"%s\"/>\n", c.ID)
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="id">urn:gowiki:` + xmlEscape(b.Title) + `:` + b.Made.Format("20060102150405") + `</dc:identifier>
+    <dc:title>` + xmlEscape(b.Title) + `</dc:title>
+    <dc:language>en</dc:language>
+    <meta property="dcterms:modified">` + b.Made.Format("2006-01-02T15:04:05Z") + `</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+` + manifest.String() + `  </manifest>
+  <spine>
+` + spine.String() + `  </spine>
+</package>
+`
+}
+
+func epubNav(b *book) string {
+	var items strings.Builder
+	for _, c := range b.Chapters {
+		fmt.Fprintf(&items, "<li><a href=\"%s\">%s</a></li>\n", c.File, xmlEscape(c.Page.Title))
+	}
+	return xhtml(b.Title, "<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n<ol>\n"+items.String()+"</ol>\n</nav>")
+}
+
+// xhtml wraps body in an XHTML document. The rendered markdown is already
+// well-formed XML.
+func xhtml(title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>` + xmlEscape(title) + `</title></head>
+<body>
+` + body + `
+</body>
+</html>
+`
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,147 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Error is an error that carries the HTTP status to answer with and a
+// message that is safe to show to the user. Handlers return it, and the
+// wrapper built by handle renders it.
+type Error struct {
+	Status  int
+	Message string
+	// Err is the underlying cause. It is logged, never shown.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an *Error with the given status and user-facing message.
+func NewError(status int, message string) *Error {
+	return &Error{Status: status, Message: message}
+}
+
+// NotFound is returned when a page or route doesn't exist.
+func NotFound(message string) *Error {
+	return NewError(http.StatusNotFound, message)
+}
+
+// Forbidden is returned when the user isn't allowed to do what they asked.
+func Forbidden(message string) *Error {
+	return NewError(http.StatusForbidden, message)
+}
+
+// appHandler is a handler that reports failures by returning them instead
+// of writing its own error responses.
+type appHandler func(http.ResponseWriter, *http.Request) error
+
+// pageHandler is an appHandler for routes that act on a page title.
+type pageHandler func(http.ResponseWriter, *http.Request, string) error
+
+// handle adapts fn to http.HandlerFunc, rendering any error it returns.
+func (s *Server) handle(fn appHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(w, r); err != nil {
+			s.renderError(w, r, err)
+		}
+	}
+}
+
+// renderError writes the themed error page for err. Errors that are not an
+// *Error become a 500 with a generic message, and their details only go to
+// the log. A headless wiki answers with the JSON of the API instead.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+
+	// nobody left to show the page to
+	if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+		logf(ctx, "%s %s: %v", r.Method, r.URL.Path, err)
+		return
+	}
+
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{
+			Status:  http.StatusInternalServerError,
+			Message: "Something went wrong while handling your request.",
+			Err:     err,
+		}
+	}
+	if e.Err != nil || e.Status >= http.StatusInternalServerError {
+		logf(ctx, "%s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	if s.cfg.Headless {
+		writeJSON(w, e.Status, apiError{Error: e.Message})
+		return
+	}
+
+	page := &ErrorPage{
+		Status:    e.Status,
+		Message:   e.Message,
+		RequestID: RequestIDFrom(ctx),
+	}
+	if err := s.writeTemplate(ctx, w, e.Status, "error.html", page); err != nil {
+		// the error page itself is broken, fall back to plain text
+		logf(ctx, "rendering error page: %v", err)
+		http.Error(w, e.Message, e.Status)
+	}
+}
+
+// notFoundRecorder swallows the mux's plain 404 response so a themed one
+// can be rendered instead.
+type notFoundRecorder struct {
+	http.ResponseWriter
+	notFound bool
+}
+
+func (rw *notFoundRecorder) WriteHeader(code int) {
+	if code == http.StatusNotFound {
+		rw.notFound = true
+		return
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *notFoundRecorder) Write(b []byte) (int, error) {
+	if rw.notFound {
+		return len(b), nil
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *notFoundRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// themedNotFound renders the error page for requests no route matches. The
+// mux's own answer is still used for everything else, such as 405 with its
+// Allow header.
+func (s *Server) themedNotFound(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &notFoundRecorder{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+		if rw.notFound {
+			w.Header().Del("Content-Type")
+			w.Header().Del("X-Content-Type-Options")
+			s.renderError(w, r, NotFound("There is nothing here."))
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package wiki
+
+import (
+	"context"
+	"time"
+)
+
+// The wiki publishes what happens to its pages and users as events, and
+// what follows from them, such as indexing, notifications, dropping view
+// counts or calling webhooks, subscribes to the events rather than being
+// done by the handlers. Events are delivered by the job queue, each kind
+// as jobs of that kind, so they are persisted and retried as jobs are,
+// each subscriber on its own.
+
+// The kinds of events the wiki publishes.
+const (
+	EventPageSaved   = JobPageSaved
+	EventPageDeleted = JobPageDeleted
+	// EventPageRenamed has the new title in Title and the old one in From.
+	EventPageRenamed = "page.renamed"
+	// EventUserCreated has the name of the account in User.
+	EventUserCreated = "user.created"
+)
+
+// eventKinds are the kinds a subscriber to all events gets.
+var eventKinds = []string{EventPageSaved, EventPageDeleted, EventPageRenamed, EventUserCreated}
+
+// Event is something that happened in the wiki, handed to its subscribers.
+// The events of pages decode into a PageEvent as well, for handlers of
+// JobPageSaved and JobPageDeleted written before events were.
+type Event struct {
+	Kind     string `json:"kind"`
+	Title    string `json:"title,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+	Author   string `json:"author,omitempty"`
+	// Mentions are the users newly @mentioned by a saved revision.
+	Mentions []string `json:"mentions,omitempty"`
+	// From is the title a renamed page had.
+	From string `json:"from,omitempty"`
+	// User is the account created.
+	User string    `json:"user,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// titles returns the pages the event is about: none for users, the old
+// and new title for renames.
+func (ev *Event) titles() []string {
+	switch {
+	case ev.Title == "":
+		return nil
+	case ev.From != "":
+		return []string{ev.From, ev.Title}
+	}
+	return []string{ev.Title}
+}
+
+// EventFunc handles an event. Returning an error retries it, as for a
+// JobFunc, without the event's other subscribers.
+type EventFunc func(ctx context.Context, ev *Event) error
+
+// Subscribe has fn called, in the background, for each event of kind.
+// Subscribers must be added before Handler is called, as job handlers.
+func (s *Server) Subscribe(kind string, fn EventFunc) {
+	s.jobs.Handle(kind, func(ctx context.Context, job *Job) error {
+		var ev Event
+		if err := job.Decode(&ev); err != nil {
+			return err
+		}
+		if ev.Kind == "" {
+			// enqueued as a PageEvent, by an older version
+			ev.Kind = job.Kind
+		}
+		return fn(ctx, &ev)
+	})
+}
+
+// publish hands ev to its subscribers and webhooks.
+func (s *Server) publish(ctx context.Context, ev *Event) {
+	ev.Time = time.Now().UTC()
+	s.enqueue(ctx, ev.Kind, ev)
+	s.queueWebhooks(ctx, ev)
+}
+
+// subscribe adds the wiki's own subscribers.
+func (s *Server) subscribe() error {
+	s.Subscribe(EventPageSaved, s.notifyPageSaved)
+	s.Subscribe(EventPageDeleted, s.notifyPageDeleted)
+	s.Subscribe(EventPageRenamed, s.notifyPageRenamed)
+	for _, kind := range []string{EventPageSaved, EventPageDeleted, EventPageRenamed} {
+		s.Subscribe(kind, s.reindexPage)
+		s.Subscribe(kind, s.recountPage)
+	}
+	s.Subscribe(EventPageDeleted, s.forgetPage)
+	s.Subscribe(EventPageRenamed, s.forgetPage)
+	return s.subscribeWebhooks()
+}
+
+// forgetPage drops what is kept of a page deleted or renamed away from:
+// its view count and its entries in the broken links report.
+func (s *Server) forgetPage(ctx context.Context, ev *Event) error {
+	gone, to := ev.Title, ""
+	if ev.Kind == EventPageRenamed {
+		gone, to = ev.From, ev.Title
+	}
+	if err := s.views.ForgetViews(ctx, gone); err != nil {
+		return err
+	}
+	return s.moveLinkReport(gone, to)
+}
@@ -0,0 +1,85 @@
+package wiki
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxFavorites is how many pages a user can star.
+const maxFavorites = 500
+
+// starHandler adds a page to the user's favorites, or removes it with
+// star=0.
+func (s *Server) starHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to star pages.")
+	}
+	star := r.FormValue("star") != "0"
+	err := s.updateUserData(r.Context(), user, func(d *UserData) error {
+		i := slices.Index(d.Favorites, title)
+		switch {
+		case star && i < 0:
+			if len(d.Favorites) >= maxFavorites {
+				return NewError(http.StatusBadRequest, "You have starred too many pages; unstar some first.")
+			}
+			d.Favorites = append(d.Favorites, title)
+		case !star && i >= 0:
+			d.Favorites = slices.Delete(d.Favorites, i, i+1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, s.pagePath("view", title), http.StatusSeeOther)
+	return nil
+}
+
+// markStarred fills in whether the page is one of the user's favorites. It
+// is best effort, like markWatching.
+func (s *Server) markStarred(r *http.Request, p *Page) {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return
+	}
+	d, err := s.userData.UserData(r.Context(), user)
+	if err != nil {
+		logf(r.Context(), "loading the data of %s: %v", user, err)
+		return
+	}
+	p.Starred = slices.Contains(d.Favorites, p.Title)
+}
+
+// favoritesHandler lists the user's favorites, most recently starred
+// first. Pages deleted or not published since are left out.
+func (s *Server) favoritesHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to see your favorites.")
+	}
+	d, err := s.userData.UserData(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	data := &ListData{}
+	for i := len(d.Favorites) - 1; i >= 0; i-- {
+		title := d.Favorites[i]
+		p, err := s.loadPage(r.Context(), title)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if p.Published(now) {
+			data.Pages = append(data.Pages, p)
+		}
+	}
+	return s.renderTemplate(r.Context(), w, "favorites.html", data)
+}
@@ -0,0 +1,104 @@
+package wiki
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// Flash messages tell the reader how what they did went, on the next page
+// the wiki shows them: "Page saved." after the redirect from /save/, or
+// why it wasn't. They travel in a signed cookie, from the response of the
+// action to the request showing them, and the layout renders them once.
+
+const flashCookie = "wiki_flash"
+
+// maxFlashes caps the messages waiting to be shown; older ones make way.
+const maxFlashes = 5
+
+// The kinds of flash messages, which the layout can style apart.
+const (
+	FlashInfo  = "info"
+	FlashError = "error"
+)
+
+// Flash is a message shown once to the reader.
+type Flash struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// flashState holds the messages of a request not shown yet: those of the
+// cookie it came with, and those added while handling it.
+type flashState struct {
+	pending []Flash
+}
+
+type flashKey struct{}
+
+// readFlash is the middleware taking the flash messages out of the
+// request's cookie, for the templates to show.
+func (s *Server) readFlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := &flashState{}
+		if c, err := r.Cookie(flashCookie); err == nil {
+			st.pending = s.decodeFlashes(c.Value)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flashKey{}, st)))
+	})
+}
+
+func (s *Server) decodeFlashes(value string) []Flash {
+	signed, _ := s.keys.openValue("flash", value)
+	if signed == "" {
+		return nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(signed)
+	if err != nil {
+		return nil
+	}
+	var flashes []Flash
+	if err := json.Unmarshal(data, &flashes); err != nil {
+		return nil
+	}
+	return flashes
+}
+
+// flash leaves message for the reader, shown by the next page rendered for
+// them: this response's, or that of the request it redirects to.
+func (s *Server) flash(w http.ResponseWriter, r *http.Request, kind, message string) {
+	st, _ := r.Context().Value(flashKey{}).(*flashState)
+	if st == nil {
+		return
+	}
+	st.pending = append(st.pending, Flash{Kind: kind, Message: message})
+	if n := len(st.pending); n > maxFlashes {
+		st.pending = st.pending[n-maxFlashes:]
+	}
+	data, err := json.Marshal(st.pending)
+	if err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    s.keys.signValue("flash", base64.RawURLEncoding.EncodeToString(data)),
+		Path:     s.cfg.BasePath + "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// takeFlashes returns the messages waiting for the reader of ctx, clearing
+// them from the cookie of w as they are about to be shown.
+func (s *Server) takeFlashes(ctx context.Context, w http.ResponseWriter) []Flash {
+	st, _ := ctx.Value(flashKey{}).(*flashState)
+	if st == nil || len(st.pending) == 0 {
+		return nil
+	}
+	flashes := st.pending
+	st.pending = nil
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: s.cfg.BasePath + "/", MaxAge: -1})
+	return flashes
+}
@@ -0,0 +1,118 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The link graph is drawn from the search index, which keeps the pages
+// each page links to. Only links between pages the reader can see are
+// edges; links to missing pages are left out.
+
+// maxGraphPages caps the pages of a graph, the most linked ones kept.
+const maxGraphPages = 2000
+
+// GraphNode is a page of the link graph.
+type GraphNode struct {
+	Title string   `json:"title"`
+	URL   string   `json:"url"`
+	Tags  []string `json:"tags,omitempty"`
+	// Links and Backlinks count the links from and to the page, including
+	// those of pages a truncated graph leaves out.
+	Links     int `json:"links"`
+	Backlinks int `json:"backlinks"`
+}
+
+// GraphEdge is a link from one page to another.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph is the answer of /api/v1/graph.
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []GraphEdge  `json:"edges"`
+	// Truncated is set when pages were left out past maxGraphPages.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// GraphData is the data handed to the graph.html template.
+type GraphData struct {
+	Common
+	Prefix string
+}
+
+// linkGraph returns the links between the published, unarchived pages the
+// user of ctx may read, limited to those below prefix when it is set.
+func (s *Server) linkGraph(ctx context.Context, prefix string) (*Graph, error) {
+	if err := s.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+
+	s.index.mu.RLock()
+	defer s.index.mu.RUnlock()
+
+	now := time.Now()
+	nodes := make(map[string]*GraphNode)
+	for title, doc := range s.index.docs {
+		if !strings.HasPrefix(title, prefix) || !doc.page.Published(now) || s.isArchived(doc.page, now) || !s.canRead(ctx, title) {
+			continue
+		}
+		nodes[title] = &GraphNode{Title: title, URL: s.pagePath("view", title), Tags: doc.page.Tags}
+	}
+	g := &Graph{Nodes: make([]*GraphNode, 0, len(nodes)), Edges: []GraphEdge{}}
+	for title, n := range nodes {
+		for _, to := range s.index.docs[title].links {
+			if target := nodes[to]; target != nil && to != title {
+				g.Edges = append(g.Edges, GraphEdge{From: title, To: to})
+				n.Links++
+				target.Backlinks++
+			}
+		}
+		g.Nodes = append(g.Nodes, n)
+	}
+
+	slices.SortFunc(g.Nodes, func(a, b *GraphNode) int {
+		if d := (b.Links + b.Backlinks) - (a.Links + a.Backlinks); d != 0 {
+			return d
+		}
+		return strings.Compare(a.Title, b.Title)
+	})
+	if len(g.Nodes) > maxGraphPages {
+		for _, n := range g.Nodes[maxGraphPages:] {
+			delete(nodes, n.Title)
+		}
+		g.Nodes, g.Truncated = g.Nodes[:maxGraphPages], true
+		g.Edges = slices.DeleteFunc(g.Edges, func(e GraphEdge) bool { return nodes[e.From] == nil || nodes[e.To] == nil })
+	}
+	slices.SortFunc(g.Edges, cmpEdges)
+	return g, nil
+}
+
+func cmpEdges(a, b GraphEdge) int {
+	if c := strings.Compare(a.From, b.From); c != 0 {
+		return c
+	}
+	return strings.Compare(a.To, b.To)
+}
+
+// apiGraphHandler answers the link graph as JSON. The prefix parameter
+// limits it to the pages below a title, such as a namespace.
+func (s *Server) apiGraphHandler(w http.ResponseWriter, r *http.Request) error {
+	g, err := s.linkGraph(r.Context(), r.FormValue("prefix"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, g)
+}
+
+func (s *Server) graphHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.renderTemplate(r.Context(), w, "graph.html", &GraphData{Prefix: r.FormValue("prefix")})
+}
@@ -0,0 +1,361 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (s *Server) getTitle(r *http.Request) (string, error) {
+	title := r.PathValue("title")
+
+	if err := s.titles.check(title); err != nil {
+		return "", &Error{Status: http.StatusNotFound, Message: "Invalid Page Title", Err: err}
+	}
+
+	return title, nil
+}
+
+// ListData is the data handed to templates listing pages.
+type ListData struct {
+	Common
+	Pages []*Page
+	// Pinned are the signed-in user's pinned searches, on the home page.
+	Pinned []*PinnedSearch
+}
+
+func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) error {
+	pages, err := s.listPages(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// archived pages stay reachable, but are no longer listed
+	now := time.Now()
+	listed := pages[:0]
+	for _, p := range pages {
+		if !s.isArchived(p, now) {
+			listed = append(listed, p)
+		}
+	}
+
+	data := &ListData{Pages: s.readablePages(r.Context(), listed)}
+	if user := UserFrom(r.Context()); user != "" {
+		if data.Pinned, err = s.pinnedSearches(r.Context(), user); err != nil {
+			return err
+		}
+	}
+	return s.renderTemplate(r.Context(), w, "index.html", data)
+}
+
+func (s *Server) viewHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	return s.showPage(w, r, title, http.StatusOK, Form{})
+}
+
+// pageFormFailed shows the page of title again after one of its forms
+// failed, err next to field in it, if the user can do something about
+// it. Other errors are returned as they are.
+func (s *Server) pageFormFailed(w http.ResponseWriter, r *http.Request, title, field string, err error) error {
+	var e *Error
+	if !errors.As(err, &e) || e.Status >= http.StatusInternalServerError {
+		return err
+	}
+	return s.showPage(w, r, title, e.Status, Form{Errors: FieldErrors{field: e.Message}, Values: r.PostForm})
+}
+
+// showPage renders the view of title with status, form holding what was
+// wrong with one of its forms, if anything.
+func (s *Server) showPage(w http.ResponseWriter, r *http.Request, title string, status int, form Form) error {
+	p, err := s.loadPageToShow(r.Context(), title)
+
+	// if this page does not exists, offer similar ones or go to the
+	// editor to create it
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.missingPage(w, r, title)
+	}
+	if err != nil {
+		return err
+	}
+
+	// scheduled pages don't exist for readers until they are published
+	if !p.Published(time.Now()) {
+		return NotFound("This page has not been published yet.")
+	}
+
+	s.countView(r, p)
+	s.annotate(r.Context(), p)
+	s.markPresent(r, p, false)
+	s.listAttachments(r.Context(), p)
+	s.markWatching(r, p)
+	s.markStarred(r, p)
+
+	if err := s.hooks.pageRendering(r.Context(), p); err != nil {
+		return err
+	}
+
+	return s.renderPage(r.Context(), w, status, "view.html", &ViewPageData{Page: p, Form: form})
+}
+
+func (s *Server) editHandler(w http.ResponseWriter, r *http.Request, title string) error {
+
+	p, err := s.loadPageToShow(r.Context(), title)
+	if errors.Is(err, fs.ErrNotExist) {
+		p = &Page{Title: title}
+	} else if err != nil {
+		return err
+	}
+	if p.TooLarge {
+		return NewError(http.StatusRequestEntityTooLarge, "This page is too large to edit in the browser.")
+	}
+	if field := r.FormValue("section"); field != "" && p.Revision > 0 {
+		if err := editSection(p, field); err != nil {
+			return err
+		}
+	}
+	s.markPresent(r, p, true)
+
+	return s.renderPage(r.Context(), w, http.StatusOK, "edit.html", &EditPageData{Page: p})
+}
+
+func (s *Server) saveHandler(w http.ResponseWriter, r *http.Request, title string) error {
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return NewError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("The page is too large to save; the limit is %d bytes.", tooLarge.Limit))
+		}
+		return &Error{Status: http.StatusBadRequest, Message: "The form could not be read.", Err: err}
+	}
+
+	body := r.FormValue("body")
+	var (
+		conflict *ConflictData
+		err      error
+	)
+	if field := r.FormValue("section"); field != "" {
+		body, conflict, err = s.spliceSection(r.Context(), title, field, r.FormValue("section_hash"), body)
+	} else {
+		conflict, err = s.checkConflict(r, title, body)
+	}
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		return s.writeTemplate(r.Context(), w, http.StatusConflict, "conflict.html", conflict)
+	}
+
+	p := &Page{
+		Title:    title,
+		Body:     []byte(body),
+		Author:   s.authorOf(r),
+		Archived: r.FormValue("archived") != "",
+		Tags:     parseTags(r.FormValue("tags")),
+	}
+	errs := FieldErrors{}
+	if p.PublishAt, err = parsePublishAt(r.FormValue("publish_at"), s.dateStyle(r.Context()).location()); err != nil {
+		errs.Add("publish_at", "This is not a valid date and time.")
+	}
+	errs.Check("tags", checkTags(r.FormValue("tags")))
+	errs.Check("body", s.checkLimits(p.Body))
+	if len(errs) > 0 {
+		return s.editAgain(w, r, p, errs)
+	}
+	verdict, err := s.checkSpam(r, p)
+	if err != nil {
+		return err
+	}
+	if verdict == SpamRejected {
+		return s.editAgain(w, r, p, Forbidden("The edit looks like spam and was not saved."))
+	}
+	if reason := s.holdReason(r, verdict); reason != "" {
+		return s.hold(w, r, p, reason)
+	}
+
+	if err := s.checkPageQuota(r.Context(), p.Title, p.Author, p.Body); err != nil {
+		return s.editAgain(w, r, p, err)
+	}
+	if err := s.hooks.pageSaving(r.Context(), p); err != nil {
+		return s.editAgain(w, r, p, err)
+	}
+	mentions := s.newMentions(r.Context(), p.Title, p.Body)
+	if err := s.savePage(r.Context(), p); err != nil {
+		return err
+	}
+	s.publish(r.Context(), &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision, Author: p.Author, Mentions: mentions})
+
+	s.flash(w, r, FlashInfo, "Page saved.")
+	http.Redirect(w, r, s.pagePath("view", title), http.StatusFound)
+	return nil
+}
+
+// editAgain shows the edit form again, with the text the user tried to
+// save and why it wasn't saved, rather than an error page losing the text:
+// FieldErrors next to their fields, other errors the user can do
+// something about as a flash message. Errors of the wiki rather than the
+// edit are returned as they are.
+func (s *Server) editAgain(w http.ResponseWriter, r *http.Request, p *Page, err error) error {
+	data := &EditPageData{Page: p}
+	data.Values = r.PostForm
+	status := http.StatusBadRequest
+	var (
+		fe FieldErrors
+		e  *Error
+	)
+	switch {
+	case errors.As(err, &fe):
+		data.Errors = fe
+	case errors.As(err, &e) && e.Status < http.StatusInternalServerError:
+		s.flash(w, r, FlashError, e.Message)
+		status = e.Status
+	default:
+		return err
+	}
+	// p holds the whole page by now, also when a section was edited, so
+	// the form saves it whole against the revision it started from
+	p.Revision, _ = strconv.Atoi(r.FormValue("base_revision"))
+	return s.writeTemplate(r.Context(), w, status, "edit.html", data)
+}
+
+// ConflictData is the data handed to the conflict.html template: the page
+// as it is stored now, and the text the user tried to save over it.
+type ConflictData struct {
+	Common
+	*Page
+	Yours string
+}
+
+// checkConflict reports whether the page changed since the revision the
+// editor started from, as sent in the base_revision field. Forms without
+// the field, from older templates or scripts, are not checked.
+func (s *Server) checkConflict(r *http.Request, title, body string) (*ConflictData, error) {
+	field := r.FormValue("base_revision")
+	if field == "" {
+		return nil, nil
+	}
+	base, err := strconv.Atoi(field)
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, "The base revision is not a number.")
+	}
+
+	current, err := s.loadPage(r.Context(), title)
+	if errors.Is(err, fs.ErrNotExist) {
+		// deleted or never created; saving recreates it
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.Revision == base {
+		return nil, nil
+	}
+	return &ConflictData{Page: current, Yours: body}, nil
+}
+
+// spliceSection returns the page with the section numbered field replaced
+// by text, the section as edited. Changes elsewhere in the page since the
+// editor loaded it are kept; a change to the section itself, which hash
+// identifies as it was, is a conflict over the section.
+func (s *Server) spliceSection(ctx context.Context, title, field, hash, text string) (string, *ConflictData, error) {
+	n, err := strconv.Atoi(field)
+	if err != nil {
+		return "", nil, NewError(http.StatusBadRequest, "The section is not a number.")
+	}
+	current, err := s.loadPage(ctx, title)
+	if errors.Is(err, fs.ErrNotExist) {
+		// deleted meanwhile; saving recreates it
+		return text, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	body := string(current.Body)
+	sec, ok := sectionAt(body, n)
+	if !ok || sectionHash(body[sec.Start:sec.End]) != hash {
+		// sections added or removed above move it
+		found := false
+		for _, other := range pageSections(body) {
+			if sectionHash(body[other.Start:other.End]) == hash {
+				sec, found = other, true
+				break
+			}
+		}
+		if !found {
+			now := *current
+			if ok {
+				now.Body = []byte(body[sec.Start:sec.End])
+				now.Section = &EditedSection{Index: n, Heading: sec.Heading, Hash: sectionHash(string(now.Body))}
+			}
+			return "", &ConflictData{Page: &now, Yours: text}, nil
+		}
+	}
+	if sec.End < len(body) {
+		text = withNewline(text)
+	}
+	return body[:sec.Start] + text + body[sec.End:], nil, nil
+}
+
+func (s *Server) deleteHandler(w http.ResponseWriter, r *http.Request, title string) error {
+
+	if err := s.hooks.pageDeleting(r.Context(), title); err != nil {
+		return err
+	}
+
+	err := s.deletePage(r.Context(), title)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		s.publish(r.Context(), &Event{Kind: EventPageDeleted, Title: title, Author: s.authorOf(r)})
+		s.flash(w, r, FlashInfo, "Deleted "+title+".")
+	}
+	http.Redirect(w, r, s.cfg.BasePath+"/", http.StatusFound)
+	return nil
+}
+
+func (s *Server) makeHandler(fn pageHandler) http.HandlerFunc {
+	return s.handle(s.withTitle(fn))
+}
+
+func (s *Server) withTitle(fn pageHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		// Here we will extract the page title from the Request,
+		// and call the provided handler 'fn'
+		title, err := s.getTitle(r)
+		if err != nil {
+			return err
+		}
+		if err := s.checkRead(r, title); err != nil {
+			return err
+		}
+		return fn(w, r, title)
+	}
+}
+
+// enqueue schedules follow-up work for a request. The request already
+// succeeded, so a failure here is logged rather than shown to the user.
+func (s *Server) enqueue(ctx context.Context, kind string, payload interface{}) {
+	if err := s.jobs.Enqueue(kind, payload); err != nil {
+		logf(ctx, "enqueueing %s: %v", kind, err)
+	}
+}
+
+// publishAtLayout is the format of <input type="datetime-local">, read in
+// the editor's time zone.
+const publishAtLayout = "2006-01-02T15:04"
+
+// parsePublishAt reads the optional publish_at form field.
+func parsePublishAt(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(publishAtLayout, value, loc)
+	return t.UTC(), err
+}
@@ -0,0 +1,242 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PageHead is what admins add to the <head> of a page, such as styling
+// for a landing page. Only they can set it, and saving the page keeps it.
+type PageHead struct {
+	CSS  string    `json:"css,omitempty"`
+	Meta []MetaTag `json:"meta,omitempty"`
+}
+
+// MetaTag is a <meta> element. Names with a colon, like og:title, are
+// written as the property attribute, as Open Graph wants.
+type MetaTag struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+func (h *PageHead) empty() bool {
+	return h == nil || (h.CSS == "" && len(h.Meta) == 0)
+}
+
+// HeadStore is implemented by storage that keeps the heads of pages in
+// their metadata. Storage.Save leaves the head as it is.
+type HeadStore interface {
+	SetPageHead(ctx context.Context, title string, head *PageHead) error
+}
+
+const (
+	maxHeadCSS     = 64 << 10
+	maxMetaTags    = 20
+	maxMetaContent = 1024
+)
+
+var metaName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9:._-]{0,63}$`)
+
+// unsafeCSS are the constructs refused in page CSS: a way out of the
+// <style> element, and ways to load or run code from a stylesheet.
+var unsafeCSS = []string{"<", "@import", "expression(", "javascript:", "behavior:", "-moz-binding", "vbscript:"}
+
+// cssComment matches the comments of CSS, and an unclosed one at the end.
+var cssComment = regexp.MustCompile(`(?s)/\*.*?(\*/|$)`)
+
+// plainCSS is css as unsafeCSS is matched against: lower case, without
+// comments and whitespace, and with its escapes decoded, so "@\69mport"
+// and "expr/**/ession(" are caught like the plain words.
+func plainCSS(css string) string {
+	css = cssComment.ReplaceAllString(css, "")
+	var out strings.Builder
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case c == '\\':
+			// up to six hex digits, then an optional space, or any
+			// other character as itself
+			j := i + 1
+			for j < len(css) && j < i+7 && isHexDigit(css[j]) {
+				j++
+			}
+			if j == i+1 {
+				if j < len(css) {
+					out.WriteByte(css[j])
+				}
+				i = j
+				continue
+			}
+			n, _ := strconv.ParseUint(css[i+1:j], 16, 32)
+			out.WriteRune(rune(n))
+			if j < len(css) && isCSSSpace(css[j]) {
+				j++
+			}
+			i = j - 1
+		case isCSSSpace(c):
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return strings.ToLower(out.String())
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isCSSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// checkHead returns a user-facing reason when h can't be used.
+func checkHead(h *PageHead) error {
+	if len(h.CSS) > maxHeadCSS {
+		return fmt.Errorf("the CSS is longer than %d bytes", maxHeadCSS)
+	}
+	plain := plainCSS(h.CSS)
+	for _, bad := range unsafeCSS {
+		if strings.Contains(plain, bad) {
+			return fmt.Errorf("the CSS can't contain %q", bad)
+		}
+	}
+	if len(h.Meta) > maxMetaTags {
+		return fmt.Errorf("a page can have up to %d meta tags", maxMetaTags)
+	}
+	for _, m := range h.Meta {
+		if !metaName.MatchString(m.Name) {
+			return fmt.Errorf("%q is not a meta tag name", m.Name)
+		}
+		if n := strings.ToLower(m.Name); n == "http-equiv" || n == "charset" || n == "viewport" {
+			return fmt.Errorf("the %s meta tag is set by the wiki", m.Name)
+		}
+		if len(m.Content) > maxMetaContent {
+			return fmt.Errorf("the content of %s is longer than %d bytes", m.Name, maxMetaContent)
+		}
+	}
+	return nil
+}
+
+// pageHead is the head template function: the CSS and meta tags of the
+// page being shown, if it has any.
+func (s *Server) pageHead(data interface{}) template.HTML {
+	p := pageOf(data)
+	if p == nil || p.Head.empty() || checkHead(p.Head) != nil {
+		return ""
+	}
+	var out strings.Builder
+	for _, m := range p.Head.Meta {
+		attr := "name"
+		if strings.Contains(m.Name, ":") {
+			attr = "property"
+		}
+		fmt.Fprintf(&out, "<meta %s=\"%s\" content=\"%s\">\n", attr, html.EscapeString(m.Name), html.EscapeString(m.Content))
+	}
+	if p.Head.CSS != "" {
+		out.WriteString("<style>\n" + p.Head.CSS + "\n</style>\n")
+	}
+	return template.HTML(out.String())
+}
+
+// parseMetaTags reads one "name: content" per line. The name ends at the
+// first colon followed by a space, so it can have colons itself.
+func parseMetaTags(text string) []MetaTag {
+	var tags []MetaTag
+	for _, line := range strings.Split(text, "\n") {
+		name, content, ok := strings.Cut(line, ": ")
+		if !ok {
+			name, content, ok = strings.Cut(line, ":")
+		}
+		if name = strings.TrimSpace(name); ok && name != "" {
+			tags = append(tags, MetaTag{Name: name, Content: strings.TrimSpace(content)})
+		}
+	}
+	return tags
+}
+
+// HeadData is the data handed to the admin/head.html template.
+type HeadData struct {
+	Common
+	Title string
+	Head  *PageHead
+	Error string
+}
+
+// MetaText is the meta tags as the form shows them.
+func (d *HeadData) MetaText() string {
+	var b strings.Builder
+	for _, m := range d.Head.Meta {
+		b.WriteString(m.Name + ": " + m.Content + "\n")
+	}
+	return b.String()
+}
+
+// headFormHandler shows the head of a page for an admin to change.
+func (s *Server) headFormHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	p, err := s.loadPage(r.Context(), title)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no page called " + title + ".")
+	}
+	if err != nil {
+		return err
+	}
+	data := &HeadData{Title: title, Head: p.Head}
+	if data.Head == nil {
+		data.Head = &PageHead{}
+	}
+	return s.renderTemplate(r.Context(), w, "admin/head.html", data)
+}
+
+// headHandler takes the form of admin/head.html: the CSS, and the meta
+// tags one "name: content" per line.
+func (s *Server) headHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	hs, ok := s.store.(HeadStore)
+	if !ok {
+		return NewError(http.StatusNotImplemented, "The storage of this wiki can't keep page heads.")
+	}
+	if _, err := s.loadPage(r.Context(), title); errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no page called " + title + ".")
+	} else if err != nil {
+		return err
+	}
+
+	head := &PageHead{
+		CSS:  strings.TrimSpace(strings.ReplaceAll(r.PostFormValue("css"), "\r\n", "\n")),
+		Meta: parseMetaTags(strings.ReplaceAll(r.PostFormValue("meta"), "\r\n", "\n")),
+	}
+	if err := checkHead(head); err != nil {
+		data := &HeadData{Title: title, Head: head, Error: "The head was not saved: " + err.Error() + "."}
+		return s.writeTemplate(r.Context(), w, http.StatusBadRequest, "admin/head.html", data)
+	}
+	if head.empty() {
+		head = nil
+	}
+	if err := hs.SetPageHead(r.Context(), title, head); err != nil {
+		return err
+	}
+	logf(r.Context(), "head of %s changed by %s", title, UserFrom(r.Context()))
+	s.flash(w, r, FlashInfo, "The head of the page was saved.")
+	http.Redirect(w, r, s.pagePath("view", title), http.StatusSeeOther)
+	return nil
+}
+
+// SetPageHead implements HeadStore, rewriting the metadata of the page.
+func (st *FileStorage) SetPageHead(ctx context.Context, title string, head *PageHead) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	meta, err := st.loadMeta(title)
+	if err != nil {
+		return err
+	}
+	meta.Head = head
+	return writeJSONFile(st.metaPath(title), meta)
+}
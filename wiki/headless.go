@@ -0,0 +1,154 @@
+package wiki
+
+import (
+	"net/http"
+	"time"
+)
+
+// A headless wiki (Config.Headless) loads no templates and serves only the
+// JSON API, for a front end of its own to show. These read its pages;
+// PATCH /api/v1/pages/{title} and /api/v1/batch change them. With
+// accounts, /api/v1/session signs in and out in place of the sign-in
+// pages, setting the same cookies, and /api/v1/read-only stands in for the
+// switch of the dashboard.
+
+// APIPage is a page as /api/v1/pages/{title} sends it, its text both as
+// written and rendered. URL is that of its view, which headless wikis
+// don't have.
+type APIPage struct {
+	Title     string    `json:"title"`
+	ID        string    `json:"id"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Author    string    `json:"author"`
+	Tags      []string  `json:"tags"`
+	Archived  bool      `json:"archived,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	Body      string    `json:"body,omitempty"`
+	HTML      string    `json:"html,omitempty"`
+}
+
+func (s *Server) apiPage(p *Page) *APIPage {
+	tags := p.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	page := &APIPage{
+		Title:     p.Title,
+		ID:        p.ID,
+		Revision:  p.Revision,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+		Author:    p.Author,
+		Tags:      tags,
+		Archived:  p.Archived,
+	}
+	if !s.cfg.Headless {
+		page.URL = s.absoluteURL(s.pagePath("view", p.Title))
+	}
+	return page
+}
+
+// apiPagesHandler answers GET /api/v1/pages with the pages the reader may
+// see, without their text.
+func (s *Server) apiPagesHandler(w http.ResponseWriter, r *http.Request) error {
+	pages, err := s.ListPages(r.Context())
+	if err != nil {
+		return err
+	}
+	list := make([]*APIPage, 0, len(pages))
+	for _, p := range pages {
+		list = append(list, s.apiPage(p))
+	}
+	return writeJSON(w, http.StatusOK, struct {
+		Pages []*APIPage `json:"pages"`
+	}{list})
+}
+
+// apiPageHandler answers GET /api/v1/pages/{title} with the page and its
+// text, counting the view as the page itself would.
+func (s *Server) apiPageHandler(w http.ResponseWriter, r *http.Request) error {
+	p, err := s.GetPage(r.Context(), r.PathValue("title"))
+	if err != nil {
+		return err
+	}
+	html, err := s.markdown(p.Body)
+	if err != nil {
+		return err
+	}
+	s.countView(r, p)
+	page := s.apiPage(p)
+	page.Body, page.HTML = string(p.Body), string(html)
+	return writeJSON(w, http.StatusOK, page)
+}
+
+// APISession is the user /api/v1/session signed in.
+type APISession struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// sessionHandler answers GET /api/v1/session with the user signed in.
+func (s *Server) sessionHandler(w http.ResponseWriter, r *http.Request) error {
+	u := CurrentUser(r.Context())
+	if u == nil {
+		return NewError(http.StatusUnauthorized, "Nobody is signed in.")
+	}
+	return writeJSON(w, http.StatusOK, &APISession{Name: u.Name, Role: u.Role})
+}
+
+// logInHandler signs in the account of the JSON name and password posted
+// to /api/v1/session.
+func (s *Server) logInHandler(w http.ResponseWriter, r *http.Request) error {
+	var login struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := readJSON(w, r, &login); err != nil {
+		return err
+	}
+	if err := s.logIn(w, r, login.Name, login.Password); err != nil {
+		return err
+	}
+	a, err := s.accounts.Account(r.Context(), login.Name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, &APISession{Name: a.Name, Role: a.Role})
+}
+
+// logOutHandler ends the session on DELETE /api/v1/session.
+func (s *Server) logOutHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.logOut(w, r); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// readOnlyState is the body of /api/v1/read-only.
+type readOnlyState struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// apiReadOnlyHandler answers GET /api/v1/read-only with the mode.
+func (s *Server) apiReadOnlyHandler(w http.ResponseWriter, r *http.Request) error {
+	return writeJSON(w, http.StatusOK, readOnlyState{s.ReadOnly()})
+}
+
+// setReadOnlyHandler switches the mode to that of the JSON put to
+// /api/v1/read-only.
+func (s *Server) setReadOnlyHandler(w http.ResponseWriter, r *http.Request) error {
+	var st readOnlyState
+	if err := readJSON(w, r, &st); err != nil {
+		return err
+	}
+	s.SetReadOnly(st.ReadOnly)
+	if st.ReadOnly {
+		logf(r.Context(), "read-only mode turned on by %s", UserFrom(r.Context()))
+	} else {
+		logf(r.Context(), "read-only mode turned off by %s", UserFrom(r.Context()))
+	}
+	return writeJSON(w, http.StatusOK, st)
+}
@@ -0,0 +1,102 @@
+package wiki
+
+import "context"
+
+// PageHook is called with a page that is about to be saved or rendered. It
+// may change the page; returning an error stops the operation, and an
+// *Error is shown to the user as is.
+type PageHook func(ctx context.Context, p *Page) error
+
+// DeleteHook is called with the title of a page that is about to be
+// deleted. Returning an error keeps the page.
+type DeleteHook func(ctx context.Context, title string) error
+
+// LoginHook is called with the name of a user who signed in with their
+// password, once their session is established.
+type LoginHook func(ctx context.Context, user string)
+
+// Hooks is a registry of extension points around page operations, so spam
+// filters, notifiers or custom renderers can be plugged in without touching
+// the handlers. Hooks run in registration order and must all be registered
+// before the server handles requests.
+type Hooks struct {
+	pageSave   []PageHook
+	pageRender []PageHook
+	pageDelete []DeleteHook
+	spamCheck  []SpamCheck
+	upload     []UploadHook
+	userLogin  []LoginHook
+}
+
+// DefaultHooks is used by servers whose Config has no Hooks. Extension
+// packages register into it from their init functions, so importing them
+// is enough to enable them:
+//
+//	import _ "example.com/wikispam"
+var DefaultHooks = &Hooks{}
+
+// OnPageSave registers fn to run before a page is written to storage.
+func (h *Hooks) OnPageSave(fn PageHook) {
+	h.pageSave = append(h.pageSave, fn)
+}
+
+// OnPageRender registers fn to run before a page is rendered for viewing.
+// It receives a copy, so changes only affect what is displayed.
+func (h *Hooks) OnPageRender(fn PageHook) {
+	h.pageRender = append(h.pageRender, fn)
+}
+
+// OnPageDelete registers fn to run before a page is deleted.
+func (h *Hooks) OnPageDelete(fn DeleteHook) {
+	h.pageDelete = append(h.pageDelete, fn)
+}
+
+// OnSpamCheck registers fn to judge anonymous edits before they are saved.
+// The strongest verdict of all checks wins.
+func (h *Hooks) OnSpamCheck(fn SpamCheck) {
+	h.spamCheck = append(h.spamCheck, fn)
+}
+
+// OnUpload registers fn to check files before they are attached to a page,
+// e.g. with a virus scanner.
+func (h *Hooks) OnUpload(fn UploadHook) {
+	h.upload = append(h.upload, fn)
+}
+
+// OnUserLogin registers fn to run after a user signed in, e.g. to audit
+// sign-ins or warm caches for them.
+func (h *Hooks) OnUserLogin(fn LoginHook) {
+	h.userLogin = append(h.userLogin, fn)
+}
+
+func runPageHooks(ctx context.Context, hooks []PageHook, p *Page) error {
+	for _, fn := range hooks {
+		if err := fn(ctx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) pageSaving(ctx context.Context, p *Page) error {
+	return runPageHooks(ctx, h.pageSave, p)
+}
+
+func (h *Hooks) pageRendering(ctx context.Context, p *Page) error {
+	return runPageHooks(ctx, h.pageRender, p)
+}
+
+func (h *Hooks) pageDeleting(ctx context.Context, title string) error {
+	for _, fn := range h.pageDelete {
+		if err := fn(ctx, title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) userLoggedIn(ctx context.Context, user string) {
+	for _, fn := range h.userLogin {
+		fn(ctx, user)
+	}
+}
@@ -0,0 +1,177 @@
+package wiki
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapClient speaks the little of IMAP4rev1 (RFC 3501) the inbox needs,
+// over TLS: logging in, selecting a mailbox, finding the unseen messages,
+// fetching them and marking them seen.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is an untagged response line, with the literals it
+// carried in order.
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+const (
+	imapTimeout = 30 * time.Second
+	// maxIMAPLiteral bounds what a server may send in one literal.
+	maxIMAPLiteral = maxInboundMailBytes + 64<<10
+)
+
+// imapLiteral ends a line announcing a literal of that many bytes.
+var imapLiteral = regexp.MustCompile(`\{(\d+)\}$`)
+
+func dialIMAP(ctx context.Context, addr string) (*imapClient, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &tls.Dialer{NetDialer: &net.Dialer{Timeout: imapTimeout}, Config: &tls.Config{ServerName: host}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := c.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.text, "* OK") {
+		conn.Close()
+		return nil, fmt.Errorf("imap: unexpected greeting %q", greeting.text)
+	}
+	return c, nil
+}
+
+// readLine reads a response line, and the literals within it.
+func (c *imapClient) readLine() (*imapResponse, error) {
+	resp := &imapResponse{}
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		resp.text += line
+		m := imapLiteral.FindStringSubmatch(line)
+		if m == nil {
+			return resp, nil
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > maxIMAPLiteral {
+			return nil, fmt.Errorf("imap: literal of %s bytes", m[1])
+		}
+		lit := make([]byte, n)
+		if _, err := io.ReadFull(c.r, lit); err != nil {
+			return nil, err
+		}
+		resp.literals = append(resp.literals, lit)
+	}
+}
+
+// cmd sends a command and returns its untagged responses, or the error the
+// server answered with.
+func (c *imapClient) cmd(format string, args ...interface{}) ([]*imapResponse, error) {
+	c.tag++
+	tag := "g" + strconv.Itoa(c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := fmt.Fprintf(c.conn, tag+" "+format+"\r\n", args...); err != nil {
+		return nil, err
+	}
+	var untagged []*imapResponse
+	for {
+		resp, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		status, ok := strings.CutPrefix(resp.text, tag+" ")
+		if !ok {
+			untagged = append(untagged, resp)
+			continue
+		}
+		if !strings.HasPrefix(status, "OK") {
+			return nil, fmt.Errorf("imap: %s", status)
+		}
+		return untagged, nil
+	}
+}
+
+// imapQuote writes s as an IMAP quoted string.
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) login(user, password string) error {
+	_, err := c.cmd("LOGIN %s %s", imapQuote(user), imapQuote(password))
+	return err
+}
+
+func (c *imapClient) selectMailbox(name string) error {
+	_, err := c.cmd("SELECT %s", imapQuote(name))
+	return err
+}
+
+// unseen returns the UIDs of the messages not seen yet.
+func (c *imapClient) unseen() ([]int, error) {
+	resps, err := c.cmd("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []int
+	for _, resp := range resps {
+		rest, ok := strings.CutPrefix(resp.text, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, f := range strings.Fields(rest) {
+			if uid, err := strconv.Atoi(f); err == nil {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the whole message of uid, leaving it unseen.
+func (c *imapClient) fetch(uid int) ([]byte, error) {
+	resps, err := c.cmd("UID FETCH %d BODY.PEEK[]", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, resp := range resps {
+		if strings.Contains(resp.text, "FETCH") && len(resp.literals) > 0 {
+			return resp.literals[0], nil
+		}
+	}
+	return nil, errors.New("imap: the message wasn't sent")
+}
+
+func (c *imapClient) markSeen(uid int) error {
+	_, err := c.cmd(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid)
+	return err
+}
+
+func (c *imapClient) Close() error {
+	c.cmd("LOGOUT")
+	return c.conn.Close()
+}
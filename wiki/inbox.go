@@ -0,0 +1,380 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// JobInboxPoll fetches the unseen messages of the IMAP mailbox and files
+// them. It has no payload.
+const JobInboxPoll = "inbox.poll"
+
+// InboxConfig files mail as pages, so meeting notes and reports can be
+// sent to the wiki. Messages come from an IMAP mailbox, polled over TLS,
+// or are posted to /api/v1/inbox by a mail provider's inbound webhook, as
+// the raw message with the token in an "Authorization: Bearer" header.
+//
+// Each message is appended to Page, or to the page of its subject below
+// Namespace, which it creates if need be. Only mail from Senders, or from
+// the address of an account that may edit, is filed. From: can be forged,
+// so it is always filed by Author as an editor, never as the account.
+type InboxConfig struct {
+	// IMAP is the host:port of the IMAPS server, e.g. "imap.example.com:993".
+	IMAP     string `json:"imap"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Mailbox is the folder polled, INBOX by default.
+	Mailbox string `json:"mailbox"`
+	// PollMinutes is how often the mailbox is polled, every 5 minutes by
+	// default.
+	PollMinutes int `json:"poll_minutes"`
+
+	// Token enables the webhook.
+	Token string `json:"token"`
+
+	Page      string `json:"page"`
+	Namespace string `json:"namespace"`
+
+	// Senders are addresses, or domains as "@example.com", mailing
+	// without an account.
+	Senders []string `json:"senders"`
+	// Author is who mail is filed by, "mail" by default.
+	Author string `json:"author"`
+}
+
+const (
+	defaultInboxPoll   = 5 * time.Minute
+	defaultInboxAuthor = "mail"
+	// maxInboundMailBytes is the largest message filed.
+	maxInboundMailBytes = 10 << 20
+)
+
+func (c *InboxConfig) enabled() bool {
+	return c.IMAP != "" || c.Token != ""
+}
+
+func (c *InboxConfig) interval() time.Duration {
+	if c.PollMinutes > 0 {
+		return time.Duration(c.PollMinutes) * time.Minute
+	}
+	return defaultInboxPoll
+}
+
+func (c *InboxConfig) mailbox() string {
+	if c.Mailbox != "" {
+		return c.Mailbox
+	}
+	return "INBOX"
+}
+
+func (c *InboxConfig) author() string {
+	if c.Author != "" {
+		return c.Author
+	}
+	return defaultInboxAuthor
+}
+
+func (s *Server) checkInbox() error {
+	c := &s.cfg.Inbox
+	if !c.enabled() {
+		return nil
+	}
+	if (c.Page == "") == (c.Namespace == "") {
+		return errors.New("inbox: give one of page and namespace")
+	}
+	for _, title := range []string{c.Page, c.Namespace} {
+		if title == "" {
+			continue
+		}
+		if err := s.titles.check(title); err != nil {
+			return fmt.Errorf("inbox: %v", err)
+		}
+	}
+	if c.IMAP != "" && c.Username == "" {
+		return errors.New("inbox: the IMAP server needs a username")
+	}
+	return nil
+}
+
+// pollInbox enqueues a JobInboxPoll now and then every interval, until
+// the server is closed.
+func (s *Server) pollInbox() {
+	ticker := time.NewTicker(s.cfg.Inbox.interval())
+	defer ticker.Stop()
+	for {
+		if err := s.jobs.Enqueue(JobInboxPoll, struct{}{}); err != nil {
+			log.Printf("inbox: %v", err)
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchInbox handles inbox.poll. A message is marked seen once filed, or
+// refused: refusals are logged, and the message isn't fetched again. The
+// mail waits in the mailbox while the wiki is read-only.
+func (s *Server) fetchInbox(ctx context.Context, job *Job) error {
+	if s.ReadOnly() {
+		return nil
+	}
+	c := &s.cfg.Inbox
+	client, err := dialIMAP(ctx, c.IMAP)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.login(c.Username, c.Password); err != nil {
+		return err
+	}
+	if err := client.selectMailbox(c.mailbox()); err != nil {
+		return err
+	}
+	uids, err := client.unseen()
+	if err != nil {
+		return err
+	}
+	for _, uid := range uids {
+		raw, err := client.fetch(uid)
+		if err != nil {
+			return err
+		}
+		title, err := s.fileMail(ctx, raw)
+		var e *Error
+		switch {
+		case errors.As(err, &e) && e.Status == http.StatusConflict:
+			// the page was edited meanwhile; the next poll tries again
+			continue
+		case errors.As(err, &e):
+			log.Printf("inbox: message %d: %v", uid, err)
+		case err != nil:
+			return err
+		default:
+			log.Printf("inbox: message %d filed in %s", uid, title)
+		}
+		if err := client.markSeen(uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inboxHandler files the message in the body, for inbound mail webhooks.
+func (s *Server) inboxHandler(w http.ResponseWriter, r *http.Request) error {
+	token := s.cfg.Inbox.Token
+	if token == "" {
+		return NotFound("The inbox takes no mail over HTTP.")
+	}
+	given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return NewError(http.StatusUnauthorized, "The inbox token is missing or wrong.")
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxInboundMailBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("The message is too large; the limit is %d bytes.", tooLarge.Limit))
+		}
+		return err
+	}
+	title, err := s.fileMail(r.Context(), raw)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}{title, s.absoluteURL(s.pagePath("view", title))})
+}
+
+// fileMail appends a message to its page, returning the page's title.
+// Messages that can't be filed are refused with an *Error.
+func (s *Server) fileMail(ctx context.Context, raw []byte) (string, error) {
+	if len(raw) > maxInboundMailBytes {
+		return "", NewError(http.StatusRequestEntityTooLarge, "The message is too large.")
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return "", NewError(http.StatusBadRequest, "The message can't be read: "+err.Error()+".")
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return "", NewError(http.StatusBadRequest, "The message has no sender.")
+	}
+	user, err := s.mailSender(ctx, from.Address)
+	if err != nil {
+		return "", err
+	}
+	ctx = WithUser(ctx, user)
+
+	subject := decodeMailHeader(msg.Header.Get("Subject"))
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	text, err := mailText(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return "", NewError(http.StatusBadRequest, "The message has no text: "+err.Error()+".")
+	}
+	date, err := msg.Header.Date()
+	if err != nil {
+		date = time.Now()
+	}
+	sender := from.Name
+	if sender == "" {
+		sender = from.Address
+	}
+	entry := fmt.Sprintf("## %s\n\n*%s, %s*\n\n%s\n", subject, sender, date.Format("2 Jan 2006 15:04 MST"), strings.TrimSpace(text))
+
+	title := s.cfg.Inbox.Page
+	if title == "" {
+		title = s.cfg.Inbox.Namespace + "/" + mailTopic(subject)
+		if err := s.titles.check(title); err != nil {
+			title = s.cfg.Inbox.Namespace + "/" + squashTitle(mailTopic(subject))
+		}
+	}
+	op := &BatchOperation{Op: OpCreate, Title: title, Body: entry}
+	current, err := s.loadPage(ctx, title)
+	switch {
+	case err == nil:
+		op.Op, op.BaseRevision = OpUpdate, current.Revision
+		op.Body = strings.TrimRight(string(current.Body), "\n") + "\n\n" + entry
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", err
+	}
+	if _, err := s.applyOperation(ctx, op); err != nil {
+		return "", err
+	}
+	logf(ctx, "mail from %s filed in %s", from.Address, title)
+	return title, nil
+}
+
+// mailSender returns who mail from address is filed by: Author, as an
+// editor, if the address is among Senders or that of an account that may
+// edit. Nothing vouches for the address, so mail is never filed as the
+// account, whose role it would get.
+func (s *Server) mailSender(ctx context.Context, address string) (*User, error) {
+	author := &User{Name: s.cfg.Inbox.author(), Role: RoleEditor}
+	lower := strings.ToLower(address)
+	for _, sender := range s.cfg.Inbox.Senders {
+		sender = strings.ToLower(sender)
+		if sender == lower || strings.HasPrefix(sender, "@") && strings.HasSuffix(lower, sender) {
+			return author, nil
+		}
+	}
+	accounts, err := s.accounts.Accounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		if a.Email != "" && strings.EqualFold(a.Email, address) && !a.Disabled && !a.Unverified && a.Role != RoleReader {
+			return author, nil
+		}
+	}
+	return nil, Forbidden(address + " may not file mail in the wiki.")
+}
+
+// replyPrefix matches the Re: and Fwd: in front of subjects.
+var replyPrefix = regexp.MustCompile(`(?i)^((re|fwd?|aw|wg|sv|vs)\s*:\s*)+`)
+
+// mailTopic is the subject without its reply and forward prefixes, so a
+// thread is filed on one page.
+func mailTopic(subject string) string {
+	return strings.TrimSpace(replyPrefix.ReplaceAllString(subject, ""))
+}
+
+// decodeMailHeader decodes the RFC 2047 words of a header, keeping those
+// in charsets it doesn't know as they are.
+func decodeMailHeader(v string) string {
+	dec := &mime.WordDecoder{CharsetReader: charsetReader}
+	if d, err := dec.DecodeHeader(v); err == nil {
+		return strings.TrimSpace(d)
+	}
+	return strings.TrimSpace(v)
+}
+
+// charsetReader reads Latin-1 as UTF-8; UTF-8 and ASCII need no reader.
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "windows-1252":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		for _, c := range data {
+			b.WriteRune(rune(c))
+		}
+		return strings.NewReader(b.String()), nil
+	}
+	return nil, fmt.Errorf("unknown charset %q", charset)
+}
+
+// mailText returns the plain text of a message body or part, the first
+// text/plain part of multipart ones.
+func mailText(contentType, encoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", errors.New("no part is plain text")
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := mailText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err == nil {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("%s is not plain text", mediaType)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxInboundMailBytes))
+	if err != nil {
+		return "", err
+	}
+	if charset := strings.ToLower(params["charset"]); charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		r, err := charsetReader(charset, bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		if data, err = io.ReadAll(r); err != nil {
+			return "", err
+		}
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("the text is not UTF-8")
+	}
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
@@ -0,0 +1,115 @@
+package wiki_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+const weeklySync = "From: Ann <ann@example.com>\r\n" +
+	"Subject: =?utf-8?q?Weekly_sync_=E2=9C=93?=\r\n" +
+	"Date: Mon, 12 Oct 2026 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\nContent-Type: text/html\r\n\r\n<p>hi</p>\r\n" +
+	"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nNotes: caf=C3=A9\r\n- one\r\n" +
+	"--b--\r\n"
+
+// mailInbox posts the message to the inbox webhook with the token and
+// returns the status.
+func mailInbox(t *testing.T, w *wikitest.Wiki, token, message string) int {
+	t.Helper()
+	c := w.Client()
+	req, err := http.NewRequest("POST", c.URL("/api/v1/inbox"), strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wikitest.ReadBody(t, resp)
+	return resp.StatusCode
+}
+
+func TestInboxNamespace(t *testing.T) {
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Titles.Pattern = `^[a-zA-Z0-9/]+$`
+		cfg.Inbox = wiki.InboxConfig{Token: "tok", Namespace: "Mail", Senders: []string{"@example.com"}}
+	})
+	reply := strings.NewReplacer("Subject: ", "Subject: Re: ", "- one", "- two").Replace(weeklySync)
+	for _, tc := range []struct {
+		name    string
+		token   string
+		message string
+		want    int
+	}{
+		{"a wrong token", "bad", weeklySync, http.StatusUnauthorized},
+		{"a message", "tok", weeklySync, http.StatusCreated},
+		{"the reply", "tok", reply, http.StatusCreated},
+		{"another domain", "tok", strings.Replace(weeklySync, "example.com", "evil.com", 1), http.StatusForbidden},
+		{"not a message", "tok", "garbage", http.StatusBadRequest},
+	} {
+		if got := mailInbox(t, w, tc.token, tc.message); got != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	p, err := w.Server.GetPage(w.Context(nil), "Mail/WeeklySync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Author != "mail" {
+		t.Errorf("Mail/WeeklySync is by %q, want mail", p.Author)
+	}
+	body := string(p.Body)
+	for _, want := range []string{"## Weekly sync ✓", "*Ann, 12 Oct 2026 10:00 UTC*", "Notes: café\n- one", "## Re: Weekly sync ✓", "- two"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Mail/WeeklySync has no %q: %s", want, body)
+		}
+	}
+	if strings.Contains(body, "<p>") {
+		t.Errorf("Mail/WeeklySync has the HTML part: %s", body)
+	}
+}
+
+func TestInboxAccounts(t *testing.T) {
+	st := wiki.NewFileStorage(t.TempDir())
+	ctx := context.Background()
+	for _, a := range []*wiki.Account{
+		{Name: "root", Role: wiki.RoleAdmin, Email: "root@corp.com"},
+		{Name: "rd", Role: wiki.RoleReader, Email: "rd@corp.com"},
+	} {
+		if err := st.CreateAccount(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Storage = st
+		cfg.Inbox = wiki.InboxConfig{Token: "tok", Page: "Inbox"}
+	})
+	message := func(from string) string {
+		return "From: " + from + "\r\nSubject: x\r\nContent-Type: text/plain\r\n\r\nhello\r\n"
+	}
+
+	if got := mailInbox(t, w, "tok", message("root@corp.com")); got != http.StatusCreated {
+		t.Errorf("mail from an admin: %d, want 201", got)
+	}
+	if got := mailInbox(t, w, "tok", message("rd@corp.com")); got != http.StatusForbidden {
+		t.Errorf("mail from a reader: %d, want 403", got)
+	}
+	if got := mailInbox(t, w, "tok", message("stranger@corp.com")); got != http.StatusForbidden {
+		t.Errorf("mail from a stranger: %d, want 403", got)
+	}
+	// anyone can write a From line, so the page is by the inbox, not root
+	p, err := w.Server.GetPage(w.Context(nil), "Inbox")
+	if err != nil || p.Author != "mail" {
+		t.Errorf("Inbox is %v, %v; want it by mail", p, err)
+	}
+}
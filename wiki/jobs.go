@@ -0,0 +1,335 @@
+package wiki
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job kinds enqueued by the wiki itself, as EventPageSaved and
+// EventPageDeleted. Their payload is an Event.
+const (
+	JobPageSaved   = "page.saved"
+	JobPageDeleted = "page.deleted"
+)
+
+// PageEvent is what the payload of JobPageSaved and JobPageDeleted holds
+// about the page; see Event for the rest.
+type PageEvent struct {
+	Title    string `json:"title"`
+	Revision int    `json:"revision,omitempty"`
+	Author   string `json:"author,omitempty"`
+	// Mentions are the users newly @mentioned by a saved revision.
+	Mentions []string `json:"mentions,omitempty"`
+}
+
+// Job is a unit of background work.
+type Job struct {
+	ID      string          `json:"id"`
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+	// Handler is the one of the kind's handlers the job is for, counting
+	// from 0 in the order they were registered.
+	Handler   int       `json:"handler"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Decode unmarshals the job's payload into v.
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// JobFunc runs a job. Returning an error retries it later, up to
+// maxJobAttempts times, alone: the kind's other handlers have jobs of
+// their own. Handlers should still be safe to run twice, as a job cut
+// short by a restart runs again.
+type JobFunc func(ctx context.Context, job *Job) error
+
+const (
+	maxJobAttempts = 5
+	jobQueueSize   = 1024
+)
+
+var errQueueFull = errors.New("job queue is full")
+
+// Queue runs work triggered by requests, such as indexing or notifications,
+// on a few background workers so the request doesn't wait for it. When it
+// has a directory, pending jobs are kept there as JSON files and picked up
+// again after a restart.
+type Queue struct {
+	dir     string
+	workers int
+
+	mu       sync.RWMutex
+	handlers map[string][]JobFunc
+
+	jobs chan *Job
+	// retrying counts the failed jobs waiting to be queued again
+	retrying atomic.Int64
+	// unfinished counts the jobs queued and not done yet, see Wait
+	unfinished atomic.Int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewQueue returns a stopped queue; dir may be empty for an in-memory one.
+func NewQueue(workers int, dir string) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Queue{
+		dir:      dir,
+		workers:  workers,
+		handlers: make(map[string][]JobFunc),
+		jobs:     make(chan *Job, jobQueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Handle registers fn for jobs of the given kind. Several functions may
+// handle one kind; each gets a job of its own for everything enqueued.
+func (q *Queue) Handle(kind string, fn JobFunc) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.handlers[kind] = append(q.handlers[kind], fn)
+}
+
+// Enqueue schedules a job for each handler of kind, with payload
+// marshalled as JSON. Kinds nobody handles are dropped without error.
+func (q *Queue) Enqueue(kind string, payload interface{}) error {
+	q.mu.RLock()
+	handlers := len(q.handlers[kind])
+	q.mu.RUnlock()
+	if handlers == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i := 0; i < handlers; i++ {
+		job := &Job{ID: newID(), Kind: kind, Payload: data, Handler: i, CreatedAt: now}
+		if err := q.persist(job); err != nil {
+			return err
+		}
+
+		q.unfinished.Add(1)
+		select {
+		case q.jobs <- job:
+		default:
+			// it stays on disk, if we have a disk, for the next start
+			q.unfinished.Add(-1)
+			return errQueueFull
+		}
+	}
+	return nil
+}
+
+// Start launches the workers and requeues jobs left over from a previous run.
+func (q *Queue) Start() error {
+	pending, err := q.pending()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	for _, job := range pending {
+		q.unfinished.Add(1)
+		select {
+		case q.jobs <- job:
+		default:
+			q.unfinished.Add(-1)
+			log.Printf("jobs: queue full, %d persisted jobs wait for the next start", len(pending))
+			return nil
+		}
+	}
+	return nil
+}
+
+// Close stops the workers, letting running jobs finish. Queued jobs that
+// did not run are lost unless the queue is persistent.
+func (q *Queue) Close() {
+	q.cancel()
+	q.wg.Wait()
+}
+
+// Len returns the number of jobs waiting to run, including failed ones
+// waiting for their retry.
+func (q *Queue) Len() int {
+	return len(q.jobs) + int(q.retrying.Load())
+}
+
+// Wait returns once every job enqueued so far ran, retries included, or
+// with the error of ctx. Tests use it to see what the jobs of a request
+// did, such as indexing a saved page.
+func (q *Queue) Wait(ctx context.Context) error {
+	tick := time.NewTicker(5 * time.Millisecond)
+	defer tick.Stop()
+	for q.unfinished.Load() > 0 {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case job := <-q.jobs:
+			q.run(job)
+		}
+	}
+}
+
+func (q *Queue) run(job *Job) {
+	q.mu.RLock()
+	handlers := q.handlers[job.Kind]
+	q.mu.RUnlock()
+	if job.Handler >= 0 {
+		if job.Handler >= len(handlers) {
+			log.Printf("jobs: dropping %s %s, its handler is gone", job.Kind, job.ID)
+			q.forget(job)
+			q.unfinished.Add(-1)
+			return
+		}
+		handlers = handlers[job.Handler : job.Handler+1]
+	}
+
+	job.Attempts++
+	var err error
+	for _, fn := range handlers {
+		if err = q.call(fn, job); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		q.forget(job)
+		q.unfinished.Add(-1)
+		return
+	}
+
+	if job.Attempts >= maxJobAttempts {
+		log.Printf("jobs: giving up on %s %s after %d attempts: %v", job.Kind, job.ID, job.Attempts, err)
+		q.forget(job)
+		q.unfinished.Add(-1)
+		return
+	}
+
+	log.Printf("jobs: %s %s failed, retrying: %v", job.Kind, job.ID, err)
+	if err := q.persist(job); err != nil {
+		log.Printf("jobs: %v", err)
+	}
+
+	// back off without holding up the worker
+	delay := time.Duration(1<<job.Attempts) * time.Second
+	q.retrying.Add(1)
+	time.AfterFunc(delay, func() {
+		defer q.retrying.Add(-1)
+		select {
+		case q.jobs <- job:
+		case <-q.ctx.Done():
+		}
+	})
+}
+
+// call runs fn, turning a panic into an error so one bad job can't take
+// down a worker.
+func (q *Queue) call(fn JobFunc, job *Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn(q.ctx, job)
+}
+
+func (q *Queue) jobPath(job *Job) string {
+	return filepath.Join(q.dir, job.ID+".json")
+}
+
+func (q *Queue) persist(job *Job) error {
+	if q.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(q.jobPath(job), data, 0600)
+}
+
+func (q *Queue) forget(job *Job) {
+	if q.dir == "" {
+		return
+	}
+	if err := os.Remove(q.jobPath(job)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("jobs: %v", err)
+	}
+}
+
+// pending reads back the jobs persisted by a previous run.
+func (q *Queue) pending() ([]*Job, error) {
+	if q.dir == "" {
+		return nil, nil
+	}
+	files, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*Job
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// jobs persisted before they had a handler each are for all of
+		// them
+		job := Job{Handler: -1}
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Printf("jobs: skipping %s: %v", file, err)
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// newID returns a random ID that sorts by creation time.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
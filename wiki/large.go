@@ -0,0 +1,147 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+)
+
+// streamPageBytes is the body size past which a page is rendered straight
+// to the response rather than into a pooled buffer, which would keep that
+// much memory around after the request.
+const streamPageBytes = 256 << 10
+
+// PageStreamer is implemented by storage that can hand out a page's body
+// without reading it whole. Without it, a page is loaded to learn its size.
+type PageStreamer interface {
+	// Stat returns a page without its body, and the size of the body.
+	Stat(ctx context.Context, title string) (*Page, int64, error)
+	// OpenBody returns a reader of the page's body. Readers that are also
+	// io.Seekers can serve range requests.
+	OpenBody(ctx context.Context, title string) (io.ReadCloser, error)
+}
+
+// tooLarge reports whether a body of size bytes is past Config.MaxPageBytes.
+func (s *Server) tooLarge(size int64) bool {
+	return s.cfg.MaxPageBytes > 0 && size > s.cfg.MaxPageBytes
+}
+
+// loadPageToShow is loadPage for displaying a page. Pages past
+// Config.MaxPageBytes come without their body, marked TooLarge, and if the
+// storage is a PageStreamer their body isn't even read.
+func (s *Server) loadPageToShow(ctx context.Context, title string) (*Page, error) {
+	if ps, ok := s.store.(PageStreamer); ok && s.cfg.MaxPageBytes > 0 && s.mountOf(title) == nil {
+		p, size, err := ps.Stat(ctx, title)
+		if err != nil {
+			return nil, err
+		}
+		if s.tooLarge(size) {
+			p.TooLarge = true
+			return p, nil
+		}
+	}
+
+	p, err := s.loadPage(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	if s.tooLarge(int64(len(p.Body))) {
+		p.Body, p.TooLarge = nil, true
+	}
+	return p, nil
+}
+
+// renderPage renders a page template, straight to w for large pages.
+func (s *Server) renderPage(ctx context.Context, w http.ResponseWriter, status int, name string, data templateData) error {
+	p := pageOf(data)
+	if len(p.Body) <= streamPageBytes {
+		return s.writeTemplate(ctx, w, status, name, data)
+	}
+
+	ctx, end := s.startSpan(ctx, "render "+name)
+	defer end()
+
+	tmpl, ok := s.lookupTemplate(ctx, name)
+	if !ok {
+		return fmt.Errorf("the template %s does not exist", name)
+	}
+	// checked before anything is out, while an error page can still be
+	// shown; a page taking too long to render is cut short instead
+	if err := s.checkRenderable(p.Body); err != nil {
+		return err
+	}
+	*data.common() = s.commonData(ctx, w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		// part of the page is out already, too late for an error page
+		logf(ctx, "rendering %s for %s: %v", name, p.Title, err)
+	}
+	return nil
+}
+
+// rawHandler serves the text of a page as is, streamed from storage when
+// it can be, so it works for pages too large to show.
+func (s *Server) rawHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	ctx := r.Context()
+	var (
+		p    *Page
+		body io.Reader
+	)
+	if ps, ok := s.store.(PageStreamer); ok && s.mountOf(title) == nil {
+		var err error
+		if p, _, err = ps.Stat(ctx, title); err == nil {
+			var rc io.ReadCloser
+			if rc, err = ps.OpenBody(ctx, title); err == nil {
+				defer rc.Close()
+				body = rc
+			}
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	} else {
+		loaded, err := s.loadPage(ctx, title)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			p, body = loaded, bytes.NewReader(loaded.Body)
+		}
+	}
+	if p == nil || !p.Published(time.Now()) {
+		return NotFound("There is no such page.")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", p.UpdatedAt, rs)
+		return nil
+	}
+	_, err := io.Copy(w, body)
+	return err
+}
+
+func (st *FileStorage) Stat(ctx context.Context, title string) (*Page, int64, error) {
+	info, err := os.Stat(st.generateArticlePath(title))
+	if err != nil {
+		return nil, 0, err
+	}
+	meta, err := st.loadMeta(title)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &Page{Title: title}
+	meta.apply(p)
+	return p, info.Size(), nil
+}
+
+func (st *FileStorage) OpenBody(ctx context.Context, title string) (io.ReadCloser, error) {
+	return os.Open(st.generateArticlePath(title))
+}
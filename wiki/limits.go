@@ -0,0 +1,93 @@
+package wiki
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LimitsConfig caps how complex a page may be, so that one pathological
+// page can't tie the wiki up rendering it. Pages past a limit are refused
+// when saved, and those saved before it are shown as an error page rather
+// than rendered. Zero leaves a limit off.
+type LimitsConfig struct {
+	// MaxLinks is the most links, images included, a page may hold.
+	MaxLinks int `json:"max_links"`
+	// MaxDirectives is the most query and pages directives a page may
+	// hold. Directives are how a page includes others, and what they list
+	// is not rendered again, so inclusion is one level deep and its cost
+	// is the number of directives.
+	MaxDirectives int `json:"max_directives"`
+	// RenderMillis is how long rendering a page may take before the wiki
+	// gives up on it.
+	RenderMillis int `json:"render_millis"`
+}
+
+func (c LimitsConfig) renderTimeout() time.Duration {
+	return time.Duration(c.RenderMillis) * time.Millisecond
+}
+
+// complexity counts the links and directives of a page's Markdown, leaving
+// out fenced blocks, where neither is rendered.
+func complexity(src []byte) (links, directives int) {
+	inCode := false
+	for _, line := range strings.Split(string(src), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		if _, ok := queryDirective(trimmed); ok {
+			directives++
+			continue
+		}
+		if _, ok := listingDirective(trimmed); ok {
+			directives++
+			continue
+		}
+		links += len(mdLink.FindAllStringIndex(line, -1))
+	}
+	return links, directives
+}
+
+// checkLimits refuses to save body when it is past the size or complexity
+// limits.
+func (s *Server) checkLimits(body []byte) error {
+	if max := s.cfg.MaxBodyBytes; max > 0 && int64(len(body)) > max {
+		return NewError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("The page is too large to save; the limit is %d bytes.", max))
+	}
+	return s.complexityError(body, http.StatusUnprocessableEntity, "The page can't be saved")
+}
+
+// checkRenderable refuses to render src when it is past the complexity
+// limits.
+func (s *Server) checkRenderable(src []byte) error {
+	return s.complexityError(src, http.StatusUnprocessableEntity, "The page is too complex to show")
+}
+
+// complexityError returns an error with status if src has more links or
+// directives than allowed, its message starting with what failed.
+func (s *Server) complexityError(src []byte, status int, what string) error {
+	limits := s.cfg.Limits
+	if limits.MaxLinks <= 0 && limits.MaxDirectives <= 0 {
+		return nil
+	}
+	links, directives := complexity(src)
+	if max := limits.MaxLinks; max > 0 && links > max {
+		return NewError(status, fmt.Sprintf("%s: it has %d links, and the limit is %d.", what, links, max))
+	}
+	if max := limits.MaxDirectives; max > 0 && directives > max {
+		return NewError(status, fmt.Sprintf("%s: it has %d query and pages directives, and the limit is %d.", what, directives, max))
+	}
+	return nil
+}
+
+// errRenderTimeout is the error of pages that took longer to render than
+// LimitsConfig.RenderMillis allows.
+var errRenderTimeout = NewError(http.StatusServiceUnavailable,
+	"The page took too long to show. It may hold too much or too many directives; edit it to split it up.")
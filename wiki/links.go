@@ -0,0 +1,329 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// JobLinkCheck checks the external links of every published page and
+// replaces the broken links report. It has no payload.
+const JobLinkCheck = "links.check"
+
+// LinkCheckConfig schedules the external link checker.
+type LinkCheckConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interval is "daily" (the default) or "weekly".
+	Interval string `json:"interval"`
+}
+
+func (c LinkCheckConfig) interval() (time.Duration, error) {
+	return parseInterval("link check", c.Interval)
+}
+
+// BrokenLinksReport is the data handed to the special/broken-links.html
+// template.
+type BrokenLinksReport struct {
+	CheckedAt time.Time
+	Checked   int
+	Links     []*BrokenLink
+}
+
+// BrokenLinksData is the data handed to the special/broken-links.html
+// template: the last report.
+type BrokenLinksData struct {
+	Common
+	*BrokenLinksReport
+}
+
+// BrokenLink is an external URL that didn't answer, or answered with an
+// error, and the pages linking to it.
+type BrokenLink struct {
+	URL    string
+	Status int    `json:",omitempty"`
+	Error  string `json:",omitempty"`
+	Pages  []string
+}
+
+const (
+	linkCheckTimeout = 10 * time.Second
+	linkCheckWorkers = 4
+	maxCheckedLinks  = 5000
+)
+
+// externalLink finds URLs in page text, whether bare or in markdown links.
+var externalLink = regexp.MustCompile("https?://[^\\s<>()\\[\\]\"'`]+")
+
+func extractLinks(body []byte) []string {
+	var links []string
+	for _, l := range externalLink.FindAllString(string(body), -1) {
+		links = append(links, strings.TrimRight(l, ".,;:!?"))
+	}
+	return links
+}
+
+// linkClient refuses to connect to loopback and private addresses, so
+// links written on the wiki can't be used to probe the internal network.
+var linkClient = &http.Client{
+	Timeout: linkCheckTimeout,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout: linkCheckTimeout,
+			Control: func(network, address string, c syscall.RawConn) error {
+				ap, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := ap.Addr().Unmap(); !ip.IsGlobalUnicast() || ip.IsPrivate() {
+					return fmt.Errorf("%s is not a public address", ip)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: linkCheckTimeout,
+	},
+}
+
+// checkLink returns the status of a URL, trying GET when a server doesn't
+// like HEAD. A zero status comes with the error that prevented an answer.
+func checkLink(ctx context.Context, url string) (int, error) {
+	status, err := requestLink(ctx, http.MethodHead, url)
+	if err == nil && status >= 400 {
+		status, err = requestLink(ctx, http.MethodGet, url)
+	}
+	return status, err
+}
+
+func requestLink(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "gowiki-link-checker")
+	resp, err := linkClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// isBroken decides which answers count as rot. Errors like 401 or 429
+// mean the link works but we may not look.
+func isBroken(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusGone || status >= 500
+}
+
+func (s *Server) checkLinks(ctx context.Context, job *Job) error {
+	pages, err := s.listPages(ctx)
+	if err != nil {
+		return err
+	}
+
+	linkedFrom := make(map[string][]string)
+	for _, meta := range pages {
+		p, err := s.loadPage(ctx, meta.Title)
+		if err != nil {
+			return err
+		}
+		for _, l := range extractLinks(p.Body) {
+			if n := len(linkedFrom[l]); n == 0 || linkedFrom[l][n-1] != p.Title {
+				linkedFrom[l] = append(linkedFrom[l], p.Title)
+			}
+		}
+	}
+
+	urls := make([]string, 0, len(linkedFrom))
+	for u := range linkedFrom {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	if len(urls) > maxCheckedLinks {
+		log.Printf("links: checking the first %d of %d links", maxCheckedLinks, len(urls))
+		urls = urls[:maxCheckedLinks]
+	}
+
+	report := &BrokenLinksReport{Checked: len(urls)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	todo := make(chan string)
+	for i := 0; i < linkCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range todo {
+				status, err := checkLink(ctx, u)
+				if err == nil && !isBroken(status) {
+					continue
+				}
+				b := &BrokenLink{URL: u, Status: status, Pages: linkedFrom[u]}
+				if err != nil {
+					b.Error = err.Error()
+				}
+				mu.Lock()
+				report.Links = append(report.Links, b)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, u := range urls {
+		select {
+		case todo <- u:
+		case <-ctx.Done():
+		}
+	}
+	close(todo)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(report.Links, func(i, j int) bool { return report.Links[i].URL < report.Links[j].URL })
+	report.CheckedAt = time.Now().UTC()
+	log.Printf("links: %d of %d links are broken", len(report.Links), report.Checked)
+	return s.setLinkReport(report)
+}
+
+// linkReportPath keeps the last report across restarts; see lastRunPath.
+func (s *Server) linkReportPath() string {
+	if s.cfg.JobDir == "" {
+		return ""
+	}
+	return filepath.Join(s.cfg.JobDir, "broken-links.report")
+}
+
+func (s *Server) setLinkReport(report *BrokenLinksReport) error {
+	s.linksMu.Lock()
+	s.linkReport = report
+	s.linksMu.Unlock()
+
+	path := s.linkReportPath()
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (s *Server) currentLinkReport() (*BrokenLinksReport, error) {
+	s.linksMu.Lock()
+	defer s.linksMu.Unlock()
+
+	if s.linkReport != nil {
+		return s.linkReport, nil
+	}
+	path := s.linkReportPath()
+	if path == "" {
+		return &BrokenLinksReport{}, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &BrokenLinksReport{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report BrokenLinksReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	s.linkReport = &report
+	return s.linkReport, nil
+}
+
+// moveLinkReport keeps the broken links report current until the next
+// check: the links of title are listed under to once it is renamed, and
+// dropped when to is empty, as the page is deleted.
+func (s *Server) moveLinkReport(title, to string) error {
+	report, err := s.currentLinkReport()
+	if err != nil || len(report.Links) == 0 {
+		return err
+	}
+	moved := &BrokenLinksReport{CheckedAt: report.CheckedAt, Checked: report.Checked}
+	changed := false
+	for _, l := range report.Links {
+		i := slices.Index(l.Pages, title)
+		if i < 0 {
+			moved.Links = append(moved.Links, l)
+			continue
+		}
+		changed = true
+		c := *l
+		c.Pages = slices.Delete(slices.Clone(l.Pages), i, i+1)
+		if to != "" && !slices.Contains(c.Pages, to) {
+			c.Pages = append(c.Pages, to)
+			sort.Strings(c.Pages)
+		}
+		if len(c.Pages) > 0 {
+			moved.Links = append(moved.Links, &c)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.setLinkReport(moved)
+}
+
+// brokenLinksHandler shows the last report of the link checker, as far as
+// the reader may see the pages linking.
+func (s *Server) brokenLinksHandler(w http.ResponseWriter, r *http.Request) error {
+	report, err := s.currentLinkReport()
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(r.Context(), w, "special/broken-links.html", &BrokenLinksData{BrokenLinksReport: s.readableLinks(r.Context(), report)})
+}
+
+// readableLinks is report without the pages the user of ctx may not read,
+// and the links only they have.
+func (s *Server) readableLinks(ctx context.Context, report *BrokenLinksReport) *BrokenLinksReport {
+	if len(s.cfg.Namespaces) == 0 {
+		return report
+	}
+	out := &BrokenLinksReport{CheckedAt: report.CheckedAt, Checked: report.Checked}
+	for _, l := range report.Links {
+		var pages []string
+		for _, title := range l.Pages {
+			if s.canRead(ctx, title) {
+				pages = append(pages, title)
+			}
+		}
+		if len(pages) > 0 {
+			out.Links = append(out.Links, &BrokenLink{URL: l.URL, Status: l.Status, Error: l.Error, Pages: pages})
+		}
+	}
+	return out
+}
+
+// checkLinksHandler lets an admin run the checker now rather than wait.
+func (s *Server) checkLinksHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.jobs.Enqueue(JobLinkCheck, struct{}{}); err != nil {
+		return err
+	}
+	s.flash(w, r, FlashInfo, "The links are being checked; reload the page in a while for the results.")
+	http.Redirect(w, r, s.pagePath("special", "broken-links"), http.StatusFound)
+	return nil
+}
@@ -0,0 +1,182 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A pages directive, on a line of its own, lists the pages matching it as
+// they are when the page is shown, so index pages keep themselves current:
+//
+//	{{pages: tag=runbook | prefix=team-x | days=30 | sort=-updated}}
+//
+// Its parts are separated by "|": tag (several, comma-separated; pages
+// need all of them), prefix (pages below a title, such as a namespace),
+// days (pages changed in the last so many days), sort (title, the
+// default, created or updated, "-" in front for newest first) and limit.
+// Archived pages are left out, and so are those requiring sign-in, even
+// for readers who are signed in: directives run inside the markdown
+// template function, which has no request to know the reader from, so they
+// list what someone signed out may see.
+
+const (
+	defaultListingPages = 50
+	maxListingPages     = 500
+)
+
+// pageListing is a parsed pages directive.
+type pageListing struct {
+	Tags   []string
+	Prefix string
+	Days   int
+	Sort   string
+	Desc   bool
+	Limit  int
+}
+
+// hasListing reports whether src may hold a pages directive.
+func hasListing(src []byte) bool {
+	return bytes.Contains(src, []byte("{{pages:"))
+}
+
+// listingDirective returns the spec of a line holding a pages directive.
+func listingDirective(line string) (string, bool) {
+	spec, ok := strings.CutPrefix(line, "{{pages:")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(spec, "}}")
+}
+
+func parseListing(spec string) (*pageListing, error) {
+	l := &pageListing{Sort: "title", Limit: defaultListingPages}
+	for _, part := range strings.Split(spec, "|") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%q is not of the form key=value", part)
+		}
+		switch key {
+		case "tag", "tags":
+			l.Tags = append(l.Tags, parseTags(value)...)
+		case "prefix", "namespace":
+			l.Prefix = strings.Trim(value, "/")
+		case "days":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, errors.New("days is a number of days")
+			}
+			l.Days = n
+		case "sort":
+			l.Sort, l.Desc = strings.CutPrefix(value, "-")
+			if !slices.Contains([]string{"title", "created", "updated"}, l.Sort) {
+				return nil, errors.New("sort is title, created or updated")
+			}
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxListingPages {
+				return nil, fmt.Errorf("limit is a number from 1 to %d", maxListingPages)
+			}
+			l.Limit = n
+		default:
+			return nil, fmt.Errorf("unknown part %q; use tag, prefix, days, sort and limit", key)
+		}
+	}
+	return l, nil
+}
+
+// runListing renders a pages directive as a list, or the reason it can't
+// be.
+func (s *Server) runListing(spec string) template.HTML {
+	// templates have no request to take a context from, so only pages
+	// anyone may read are listed
+	ctx := context.Background()
+	l, err := parseListing(spec)
+	if err == nil {
+		var pages []*Page
+		if pages, err = s.listingPages(ctx, l); err == nil {
+			return s.renderListing(l, pages, s.dateStyle(ctx).location())
+		}
+		logf(ctx, "listing pages %q: %v", spec, err)
+		err = errors.New("the pages could not be read")
+	}
+	return template.HTML(`<p class="listing-error">Pages: ` + html.EscapeString(err.Error()) + "</p>\n")
+}
+
+// listingPages returns the pages of l from the search index, sorted and
+// limited as it asks.
+func (s *Server) listingPages(ctx context.Context, l *pageListing) ([]*Page, error) {
+	if err := s.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var pages []*Page
+
+	s.index.mu.RLock()
+	for title, doc := range s.index.docs {
+		p := doc.page
+		switch {
+		case l.Prefix != "" && !strings.HasPrefix(title, l.Prefix+"/"),
+			l.Days > 0 && now.Sub(p.UpdatedAt) > days(l.Days),
+			!hasTags(p.Tags, l.Tags),
+			!p.Published(now) || s.isArchived(p, now) || !s.canRead(ctx, title):
+			continue
+		}
+		pages = append(pages, p)
+	}
+	s.index.mu.RUnlock()
+
+	slices.SortFunc(pages, func(a, b *Page) int {
+		c := 0
+		switch l.Sort {
+		case "created":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case "updated":
+			c = a.UpdatedAt.Compare(b.UpdatedAt)
+		}
+		if l.Desc {
+			c = -c
+		}
+		if c == 0 {
+			c = strings.Compare(a.Title, b.Title)
+		}
+		return c
+	})
+	if len(pages) > l.Limit {
+		pages = pages[:l.Limit]
+	}
+	return pages, nil
+}
+
+func (s *Server) renderListing(l *pageListing, pages []*Page, loc *time.Location) template.HTML {
+	if len(pages) == 0 {
+		return template.HTML(`<p class="listing-empty">No pages match.</p>` + "\n")
+	}
+	var out strings.Builder
+	out.WriteString(`<ul class="listing">` + "\n")
+	for _, p := range pages {
+		name := p.Title
+		if l.Prefix != "" {
+			name = strings.TrimPrefix(name, l.Prefix+"/")
+		}
+		out.WriteString(`<li><a href="` + html.EscapeString(s.pagePath("view", p.Title)) + `">` + html.EscapeString(name) + "</a>")
+		if l.Sort == "updated" || l.Days > 0 {
+			out.WriteString(` <small>` + p.UpdatedAt.In(loc).Format(time.DateOnly) + `</small>`)
+		}
+		out.WriteString("</li>\n")
+	}
+	out.WriteString("</ul>\n")
+	return template.HTML(out.String())
+}
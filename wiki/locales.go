@@ -0,0 +1,167 @@
+package wiki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The wiki shows dates and numbers the way the reader's language writes
+// them, "17 May 2024" or "May 17, 2024", "1,234.5" or "1.234,5": in the
+// locale they picked on their account page, else the first of their
+// browser's Accept-Language the wiki knows, else DateConfig.Locale.
+// Formats set in DateConfig, or picked by the user, win over the locale's.
+// Go only names months in English, so the locales of other languages
+// write dates with numbers.
+
+// Locale is a way of writing dates and numbers.
+type Locale struct {
+	// Tag is the BCP 47 language tag, such as "en-GB".
+	Tag string
+	// Name is what users choose it by.
+	Name string
+
+	date, time     string
+	group, decimal string
+}
+
+// locales are those the wiki knows. A tag with a region falls back to its
+// language: "de-AT" is written as "de".
+var locales = []*Locale{
+	{Tag: "en", Name: "English (US)", date: "Jan 2, 2006", time: "3:04 PM MST", group: ",", decimal: "."},
+	{Tag: "en-GB", Name: "English (UK)", date: "2 Jan 2006", time: "15:04 MST", group: ",", decimal: "."},
+	{Tag: "en-AU", Name: "English (Australia)", date: "2 Jan 2006", time: "3:04 PM MST", group: ",", decimal: "."},
+	{Tag: "en-IE", Name: "English (Ireland)", date: "2 Jan 2006", time: "15:04 MST", group: ",", decimal: "."},
+	{Tag: "en-IN", Name: "English (India)", date: "2 Jan 2006", time: "3:04 PM MST", group: ",", decimal: "."},
+	{Tag: "de", Name: "Deutsch", date: "02.01.2006", time: "15:04 MST", group: ".", decimal: ","},
+	{Tag: "de-CH", Name: "Deutsch (Schweiz)", date: "02.01.2006", time: "15:04 MST", group: "\u2019", decimal: "."},
+	{Tag: "es", Name: "Español", date: "02/01/2006", time: "15:04 MST", group: ".", decimal: ","},
+	{Tag: "fr", Name: "Français", date: "02/01/2006", time: "15:04 MST", group: "\u202f", decimal: ","},
+	{Tag: "it", Name: "Italiano", date: "02/01/2006", time: "15:04 MST", group: ".", decimal: ","},
+	{Tag: "nl", Name: "Nederlands", date: "02-01-2006", time: "15:04 MST", group: ".", decimal: ","},
+	{Tag: "pl", Name: "Polski", date: "02.01.2006", time: "15:04 MST", group: "\u00a0", decimal: ","},
+	{Tag: "pt", Name: "Português", date: "02/01/2006", time: "15:04 MST", group: ".", decimal: ","},
+	{Tag: "sv", Name: "Svenska", date: "2006-01-02", time: "15:04 MST", group: "\u00a0", decimal: ","},
+	{Tag: "ja", Name: "日本語", date: "2006/01/02", time: "15:04 MST", group: ",", decimal: "."},
+	{Tag: "zh", Name: "中文", date: "2006-01-02", time: "15:04 MST", group: ",", decimal: "."},
+}
+
+// findLocale returns the locale of tag, or of its language, nil if the
+// wiki knows neither.
+func findLocale(tag string) *Locale {
+	for _, t := range []string{tag, strings.Split(tag, "-")[0]} {
+		for _, l := range locales {
+			if strings.EqualFold(l.Tag, t) {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// acceptedLocale returns the tag of the locale preferred by an
+// Accept-Language header, empty if it names none the wiki knows.
+func acceptedLocale(header string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if tag != "" && tag != "*" && q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	slices.SortStableFunc(choices, func(a, b choice) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	for _, c := range choices {
+		if l := findLocale(c.tag); l != nil {
+			return l.Tag
+		}
+	}
+	return ""
+}
+
+type localeKey struct{}
+
+// negotiateLocale is the middleware noting the locale the browser asks
+// for, which dateStyle writes dates and numbers in.
+func negotiateLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		if tag := acceptedLocale(r.Header.Get("Accept-Language")); tag != "" {
+			r = r.WithContext(context.WithValue(r.Context(), localeKey{}, tag))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// browserLocale returns the tag negotiateLocale found for ctx.
+func browserLocale(ctx context.Context) string {
+	tag, _ := ctx.Value(localeKey{}).(string)
+	return tag
+}
+
+// formatNumber writes n with the separators of the locale tag, English's
+// when the wiki doesn't know it: {{number .Views}}. Numbers that aren't
+// integers or floats are written as they are.
+func formatNumber(tag string, n interface{}) string {
+	var s string
+	switch v := n.(type) {
+	case int:
+		s = strconv.FormatInt(int64(v), 10)
+	case int32:
+		s = strconv.FormatInt(int64(v), 10)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case uint64:
+		s = strconv.FormatUint(v, 10)
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(n)
+	}
+	group, decimal := ",", "."
+	if l := findLocale(tag); l != nil {
+		group, decimal = l.group, l.decimal
+	}
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(group)
+		}
+		b.WriteRune(d)
+	}
+	if hasFrac {
+		b.WriteString(decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
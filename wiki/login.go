@@ -0,0 +1,436 @@
+package wiki
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionCookie = "wiki_session"
+	sessionTTL    = 30 * 24 * time.Hour
+)
+
+// failedLogins counts rejected sign-ins across all servers; it is published
+// through expvar.
+var failedLogins = expvar.NewInt("failed_logins")
+
+// loginSessions maps the tokens in session cookies to account names. They
+// are kept in a Cache, so replicas sharing one share sign-ins, and a
+// restart signs everyone out of an in-memory one.
+//
+// Signing a user out everywhere bumps their generation, which the sessions
+// started before no longer match.
+type loginSessions struct {
+	cache Cache
+}
+
+func sessionKey(token string) string   { return "session:" + token }
+func generationKey(name string) string { return "session-gen:" + name }
+
+func (ls *loginSessions) generation(ctx context.Context, name string) (string, error) {
+	gen, ok, err := ls.cache.Get(ctx, generationKey(name))
+	if err != nil || !ok {
+		return "0", err
+	}
+	return string(gen), nil
+}
+
+func (ls *loginSessions) start(ctx context.Context, name string) (string, error) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	gen, err := ls.generation(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if gen != "0" {
+		// keep the generation around as long as the new session
+		if err := ls.cache.Set(ctx, generationKey(name), []byte(gen), sessionTTL); err != nil {
+			return "", err
+		}
+	}
+	if err := ls.cache.Set(ctx, sessionKey(token), []byte(name+"\n"+gen), sessionTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// lookup returns the name signed in with token, or "".
+func (ls *loginSessions) lookup(ctx context.Context, token string) (string, error) {
+	value, ok, err := ls.cache.Get(ctx, sessionKey(token))
+	if err != nil || !ok {
+		return "", err
+	}
+	name, gen, _ := strings.Cut(string(value), "\n")
+	current, err := ls.generation(ctx, name)
+	if err != nil || gen != current {
+		return "", err
+	}
+	return name, nil
+}
+
+func (ls *loginSessions) end(ctx context.Context, token string) error {
+	return ls.cache.Delete(ctx, sessionKey(token))
+}
+
+// endAll signs a user out everywhere, e.g. once their account is disabled.
+func (ls *loginSessions) endAll(ctx context.Context, name string) error {
+	_, err := ls.cache.Incr(ctx, generationKey(name), sessionTTL)
+	return err
+}
+
+// sessionToken returns the token of the request's session cookie, or ""
+// without one signed by the secrets. old reports one signed by an old
+// secret, to be signed again.
+func (s *Server) sessionToken(r *http.Request) (token string, old bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	return s.keys.openValue("session", c.Value)
+}
+
+// sessionAccount returns the account signed in with the session token, or
+// nil.
+func (s *Server) sessionAccount(r *http.Request, token string) *Account {
+	if token == "" {
+		return nil
+	}
+	name, err := s.logins.lookup(r.Context(), token)
+	if err != nil {
+		logf(r.Context(), "looking up a session: %v", err)
+		return nil
+	}
+	if name == "" {
+		return nil
+	}
+	a, err := s.accounts.Account(r.Context(), name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logf(r.Context(), "loading account %s: %v", name, err)
+		}
+		return nil
+	}
+	if a.Disabled {
+		return nil
+	}
+	return a
+}
+
+// authenticate identifies users by their session cookie, unless the
+// program's own middleware already did, and refuses their requests from
+// other sites. Until a user with MustReset picks
+// a new password, that's the only page they can use; until one with
+// Unverified confirms their address, they are a reader.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CurrentUser(r.Context()) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, old := s.sessionToken(r)
+		a := s.sessionAccount(r, token)
+		if a == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.fromWiki(r, token) {
+			s.refuseCrossSite(w, r)
+			return
+		}
+		if old {
+			s.setSessionCookies(w, r, token)
+		}
+
+		if a.MustReset {
+			allowed := s.pagePath("account", "password")
+			if r.URL.Path != allowed && r.URL.Path != s.pagePath("logout", "") {
+				http.Redirect(w, r, allowed, http.StatusSeeOther)
+				return
+			}
+		}
+		role := a.Role
+		if a.Unverified {
+			if !s.unverifiedAllowed(r) {
+				s.refuseUnverified(w, r)
+				return
+			}
+			role = RoleReader
+		}
+		next.ServeHTTP(w, r.WithContext(WithUser(r.Context(), &User{Name: a.Name, Role: role})))
+	})
+}
+
+// LoginData is the data handed to the login.html, signup.html and
+// password.html templates.
+type LoginData struct {
+	Common
+	Name string
+	// Next is where to go after signing in.
+	Next   string
+	Error  string
+	Signup bool
+	// VerifyEmail makes the email field of signup.html required.
+	VerifyEmail bool
+	// MustReset hides the current password field of password.html.
+	MustReset bool
+}
+
+// localPath keeps redirects after sign-in on this wiki.
+func (s *Server) localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return s.cfg.BasePath + "/"
+	}
+	return next
+}
+
+func (s *Server) loginFormHandler(w http.ResponseWriter, r *http.Request) error {
+	data := &LoginData{Next: r.FormValue("next"), Signup: s.cfg.Accounts.Signup}
+	return s.renderTemplate(r.Context(), w, "login.html", data)
+}
+
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) error {
+	name := r.PostFormValue("name")
+	if err := s.logIn(w, r, name, r.PostFormValue("password")); err != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			return err
+		}
+		data := &LoginData{Name: name, Next: r.PostFormValue("next"), Error: e.Message, Signup: s.cfg.Accounts.Signup}
+		return s.writeTemplate(r.Context(), w, e.Status, "login.html", data)
+	}
+	http.Redirect(w, r, s.localPath(r.PostFormValue("next")), http.StatusSeeOther)
+	return nil
+}
+
+// logIn signs the account name in with password, or returns the *Error
+// telling why not: a wrong password, or too many of them from the
+// address of r.
+func (s *Server) logIn(w http.ResponseWriter, r *http.Request, name, password string) error {
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	limited, err := s.overLimit(r.Context(), loginLimit, ip)
+	if err != nil {
+		return err
+	}
+	if limited {
+		return NewError(http.StatusTooManyRequests, "Too many failed sign-ins; try again later.")
+	}
+
+	a, err := s.accounts.Account(r.Context(), name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if a == nil || a.Disabled || !checkPasswordHash(a.Password, password) {
+		if a == nil {
+			checkPasswordHash(dummyHash(), password)
+		}
+		failedLogins.Add(1)
+		s.failedLogins.Add(1)
+		if err := s.countAttempt(r.Context(), loginLimit, ip); err != nil {
+			return err
+		}
+		return NewError(http.StatusUnauthorized, "Wrong user name or password.")
+	}
+
+	a.LastLogin = optionalTime(time.Now().UTC())
+	if err := s.accounts.UpdateAccount(r.Context(), a); err != nil {
+		return err
+	}
+	if err := s.signIn(w, r, a.Name); err != nil {
+		return err
+	}
+	s.hooks.userLoggedIn(r.Context(), a.Name)
+	return nil
+}
+
+func (s *Server) signIn(w http.ResponseWriter, r *http.Request, name string) error {
+	token, err := s.logins.start(r.Context(), name)
+	if err != nil {
+		return err
+	}
+	s.setSessionCookies(w, r, token)
+	return nil
+}
+
+// setSessionCookies sets the session cookie, signed, and the CSRF token
+// of the session, which scripts have to read.
+func (s *Server) setSessionCookies(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.keys.signValue("session", token),
+		Path:     s.cfg.BasePath + "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    s.csrfToken(token),
+		Path:     s.cfg.BasePath + "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *Server) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: s.cfg.BasePath + "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Path: s.cfg.BasePath + "/", MaxAge: -1})
+}
+
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.logOut(w, r); err != nil {
+		return err
+	}
+	http.Redirect(w, r, s.cfg.BasePath+"/", http.StatusSeeOther)
+	return nil
+}
+
+// logOut ends the session of r, if any.
+func (s *Server) logOut(w http.ResponseWriter, r *http.Request) error {
+	if token, _ := s.sessionToken(r); token != "" {
+		if err := s.logins.end(r.Context(), token); err != nil {
+			return err
+		}
+	}
+	s.clearSessionCookies(w)
+	return nil
+}
+
+func (s *Server) signupFormHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.renderTemplate(r.Context(), w, "signup.html", &LoginData{VerifyEmail: s.cfg.Accounts.VerifyEmail})
+}
+
+// signupHandler creates an editor account and signs it in. With
+// AccountsConfig.VerifyEmail the account is unverified until the link
+// mailed to it is opened.
+func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) error {
+	name, password := r.PostFormValue("name"), r.PostFormValue("password")
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	verify := s.cfg.Accounts.VerifyEmail
+	var err error
+	if verify || email != "" {
+		err = checkEmail(email)
+	}
+	var a *Account
+	if err == nil {
+		a, err = s.newAccount(name, email, password, RoleEditor)
+	}
+	if err == nil {
+		a.Unverified = verify
+		err = s.accounts.CreateAccount(r.Context(), a)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		err = NewError(http.StatusConflict, "This user name is taken.")
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		data := &LoginData{Name: name, Error: e.Message, VerifyEmail: verify}
+		return s.writeTemplate(r.Context(), w, e.Status, "signup.html", data)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.publish(r.Context(), &Event{Kind: EventUserCreated, User: a.Name})
+	if err := s.signIn(w, r, a.Name); err != nil {
+		return err
+	}
+	if verify {
+		s.enqueue(r.Context(), JobVerifyEmail, VerifyEvent{Name: a.Name})
+		http.Redirect(w, r, s.pagePath("account", "verify"), http.StatusSeeOther)
+		return nil
+	}
+	http.Redirect(w, r, s.cfg.BasePath+"/", http.StatusSeeOther)
+	return nil
+}
+
+func (s *Server) newAccount(name, email, password string, role Role) (*Account, error) {
+	if err := checkUserName(name); err != nil {
+		return nil, err
+	}
+	if err := checkPassword(password); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Account{Name: name, Email: email, Role: role, Password: hash, CreatedAt: now, LastLogin: &now}, nil
+}
+
+// currentAccount returns the account of a user signed in with a session
+// cookie. Users authenticated by the program have none.
+func (s *Server) currentAccount(ctx context.Context) (*Account, error) {
+	name := UserFrom(ctx)
+	if name == "" {
+		return nil, Forbidden("You need to sign in first.")
+	}
+	a, err := s.accounts.Account(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, NotFound("Your account is not managed by this wiki.")
+	}
+	return a, err
+}
+
+func (s *Server) passwordFormHandler(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.currentAccount(r.Context())
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(r.Context(), w, "password.html", &LoginData{Name: a.Name, MustReset: a.MustReset})
+}
+
+// passwordHandler changes the signed-in user's password. It asks for the
+// current one, unless an admin forced the change.
+func (s *Server) passwordHandler(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.currentAccount(r.Context())
+	if err != nil {
+		return err
+	}
+
+	fail := func(status int, message string) error {
+		data := &LoginData{Name: a.Name, MustReset: a.MustReset, Error: message}
+		return s.writeTemplate(r.Context(), w, status, "password.html", data)
+	}
+	if !a.MustReset && !checkPasswordHash(a.Password, r.PostFormValue("current")) {
+		return fail(http.StatusForbidden, "The current password is wrong.")
+	}
+	password := r.PostFormValue("password")
+	if password != r.PostFormValue("confirm") {
+		return fail(http.StatusBadRequest, "The new passwords don't match.")
+	}
+	if err := checkPassword(password); err != nil {
+		return fail(http.StatusBadRequest, err.(*Error).Message)
+	}
+
+	if a.Password, err = hashPassword(password); err != nil {
+		return err
+	}
+	a.MustReset = false
+	if err := s.accounts.UpdateAccount(r.Context(), a); err != nil {
+		return err
+	}
+
+	// other devices may have been signed in by whoever knew the old one
+	if err := s.logins.endAll(r.Context(), a.Name); err != nil {
+		return err
+	}
+	if err := s.signIn(w, r, a.Name); err != nil {
+		return err
+	}
+	s.flash(w, r, FlashInfo, "Your password was changed, and your other sessions signed out.")
+	http.Redirect(w, r, s.cfg.BasePath+"/", http.StatusSeeOther)
+	return nil
+}
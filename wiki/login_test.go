@@ -0,0 +1,130 @@
+package wiki_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+// mailbox is a wiki.Mailer keeping what it is sent.
+type mailbox struct {
+	mu    sync.Mutex
+	mails []string
+}
+
+func (m *mailbox) Send(ctx context.Context, to []string, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, strings.Join(to, ",")+"\n"+subject+"\n"+body)
+	return nil
+}
+
+func (m *mailbox) last() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.mails) == 0 {
+		return ""
+	}
+	return m.mails[len(m.mails)-1]
+}
+
+// signUp makes an account with the signup form and returns the client
+// signed in to it.
+func signUp(t *testing.T, w *wikitest.Wiki, name, email string) *wikitest.Client {
+	t.Helper()
+	c := w.Client()
+	resp := c.PostForm("/signup", url.Values{"name": {name}, "email": {email}, "password": {"correct horse"}})
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("signing up %s: %s: %s", name, resp.Status, body)
+	}
+	return c
+}
+
+func TestLogin(t *testing.T) {
+	var logins []string
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Accounts = wiki.AccountsConfig{Enabled: true, Signup: true}
+		cfg.Hooks = &wiki.Hooks{}
+		cfg.Hooks.OnUserLogin(func(ctx context.Context, user string) { logins = append(logins, user) })
+	})
+	signUp(t, w, "ann", "")
+
+	c := w.Client()
+	resp := c.PostForm("/login", url.Values{"name": {"ann"}, "password": {"wrong horse"}})
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("signing in with a wrong password: %s, want 401", resp.Status)
+	}
+	resp = c.PostForm("/login", url.Values{"name": {"nobody"}, "password": {"correct horse"}})
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("signing in as nobody: %s, want 401", resp.Status)
+	}
+	if len(logins) != 0 {
+		t.Errorf("OnUserLogin ran for failed sign-ins: %v", logins)
+	}
+
+	resp = c.PostForm("/login", url.Values{"name": {"ann"}, "password": {"correct horse"}, "next": {"/view/Home"}})
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/view/Home" {
+		t.Fatalf("signing in: %s to %q: %s", resp.Status, resp.Header.Get("Location"), body)
+	}
+	if len(logins) != 1 || logins[0] != "ann" {
+		t.Errorf("OnUserLogin ran for %v, want [ann]", logins)
+	}
+	resp = c.Get("/api/v1/session")
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusOK || !strings.Contains(body, `"name":"ann"`) {
+		t.Errorf("session after signing in: %s: %s", resp.Status, body)
+	}
+
+	resp = c.PostForm("/logout", nil)
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("signing out: %s", resp.Status)
+	}
+	resp = c.Get("/api/v1/session")
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("session after signing out: %s, want 401", resp.Status)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mail := &mailbox{}
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Accounts = wiki.AccountsConfig{Enabled: true, Signup: true, VerifyEmail: true}
+		cfg.Mailer = mail
+	})
+	w.CreatePage("Home", "Welcome")
+	c := signUp(t, w, "ann", "ann@example.com")
+	w.WaitJobs()
+
+	resp := c.PostForm("/save/Home", url.Values{"body": {"Hello"}})
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("saving before verifying: %s, want 403", resp.Status)
+	}
+	if html := c.GetRendered("Home"); !strings.Contains(html, "Welcome") {
+		t.Errorf("unverified accounts can't read: %s", html)
+	}
+
+	link := regexp.MustCompile(`/account/verify\?token=\S+`).FindString(mail.last())
+	if !strings.HasPrefix(mail.last(), "ann@example.com\n") || link == "" {
+		t.Fatalf("no link mailed to ann@example.com: %q", mail.last())
+	}
+	resp = c.Get(link[:len(link)-4] + "AAAA")
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("opening a forged link: %s, want 404", resp.Status)
+	}
+	// opened in a mail program, without the session
+	resp = w.Client().Get(link)
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusOK {
+		t.Fatalf("opening the link: %s: %s", resp.Status, body)
+	}
+
+	resp = c.PostForm("/save/Home", url.Values{"body": {"Hello"}})
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusFound {
+		t.Errorf("saving once verified: %s: %s", resp.Status, body)
+	}
+}
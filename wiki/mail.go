@@ -0,0 +1,73 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// MailConfig is the SMTP server the wiki sends mail through.
+type MailConfig struct {
+	// Addr is the server's host:port. Empty disables mail.
+	Addr string `json:"addr"`
+	From string `json:"from"`
+
+	// Username and Password authenticate with PLAIN auth, which net/smtp
+	// only allows over TLS or to localhost.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Mailer sends plain text mail. Config.Mailer may replace the SMTP one,
+// e.g. to use a provider's API.
+type Mailer interface {
+	Send(ctx context.Context, to []string, subject, body string) error
+}
+
+var errMailDisabled = errors.New("mail is not configured")
+
+// smtpMailer sends mail with net/smtp.
+type smtpMailer struct {
+	cfg MailConfig
+}
+
+func (m *smtpMailer) Send(ctx context.Context, to []string, subject, body string) error {
+	if m.cfg.Addr == "" {
+		return errMailDisabled
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(m.cfg.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, host)
+	}
+
+	return smtp.SendMail(m.cfg.Addr, auth, m.cfg.From, to, m.message(subject, body))
+}
+
+// message builds the mail. Recipients only appear in the envelope, so
+// they don't see each other's addresses.
+func (m *smtpMailer) message(subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&buf, "To: undisclosed-recipients:;\r\n")
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return buf.Bytes()
+}
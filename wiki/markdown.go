@@ -0,0 +1,255 @@
+package wiki
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// minCachedMarkdown is the size from which rendering costs more than
+	// a trip to the cache.
+	minCachedMarkdown = 4 << 10
+	markdownCacheTTL  = 24 * time.Hour
+)
+
+// Markdown renders a small, safe subset of Markdown: ATX headings,
+// paragraphs, "-" and "*" bullet lists, fenced code blocks, and the inline
+// forms **strong**, *em*, `code` and [text](url). Any HTML in the source is
+// escaped, and links are limited to http(s), mailto and relative URLs.
+//
+// Fenced blocks marked data or csv are shown as tables; see dataRecords.
+func Markdown(src []byte) template.HTML {
+	return renderMarkdown(src, nil, nil)
+}
+
+// markdown is the markdown template function: Markdown, with @mentions
+// linked to the users' profiles and query and pages directives run. Long
+// pages are cached by their content, so there is nothing to invalidate,
+// except for those with directives, whose output depends on other pages.
+// Pages past the LimitsConfig are not rendered: the error makes the
+// template fail, and the view show it.
+func (s *Server) markdown(src []byte) (template.HTML, error) {
+	if err := s.checkRenderable(src); err != nil {
+		return "", err
+	}
+	var deadline time.Time
+	if timeout := s.cfg.Limits.renderTimeout(); timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if len(src) < minCachedMarkdown || hasQuery(src) || hasListing(src) {
+		return renderMarkdownUntil(src, s.userPath, s.runDirective, deadline)
+	}
+
+	// templates have no request to take a context from
+	ctx := context.Background()
+	sum := sha256.Sum256(src)
+	key := "markdown:" + hex.EncodeToString(sum[:16])
+	if html, ok, err := s.cache.Get(ctx, key); err != nil {
+		logf(ctx, "reading cached markdown: %v", err)
+	} else if ok {
+		return template.HTML(html), nil
+	}
+
+	out, err := renderMarkdownUntil(src, s.userPath, s.runDirective, deadline)
+	if err != nil {
+		return "", err
+	}
+	if err := s.cache.Set(ctx, key, []byte(out), markdownCacheTTL); err != nil {
+		logf(ctx, "caching markdown: %v", err)
+	}
+	return out, nil
+}
+
+// mentionsHTML escapes plain text, like a note's comment, linking the
+// @mentions in it.
+func (s *Server) mentionsHTML(text string) template.HTML {
+	return template.HTML(linkMentions(html.EscapeString(text), s.userPath))
+}
+
+// runDirective renders a line holding a query or pages directive.
+func (s *Server) runDirective(line string) (template.HTML, bool) {
+	if spec, ok := queryDirective(line); ok {
+		return s.runQuery(spec), true
+	}
+	if spec, ok := listingDirective(line); ok {
+		return s.runListing(spec), true
+	}
+	return "", false
+}
+
+// renderMarkdown is Markdown, linking mentions with mentionLink unless it
+// is nil. Lines holding a directive are replaced with what directive
+// returns for them, or left as text when it is nil or doesn't know them.
+func renderMarkdown(src []byte, mentionLink func(string) string, directive func(line string) (template.HTML, bool)) template.HTML {
+	out, _ := renderMarkdownUntil(src, mentionLink, directive, time.Time{})
+	return out
+}
+
+// renderMarkdownUntil is renderMarkdown giving up with errRenderTimeout
+// once deadline passes, unless it is zero.
+func renderMarkdownUntil(src []byte, mentionLink func(string) string, directive func(line string) (template.HTML, bool), deadline time.Time) (template.HTML, error) {
+	var out strings.Builder
+	var para []string
+	inList, inCode := false, false
+	// fence is the kind of the fenced block we're in, and block its lines
+	// when it's a data block
+	var fence string
+	var block []string
+
+	flushPara := func() {
+		if len(para) > 0 {
+			out.WriteString("<p>" + inlineMarkdown(strings.Join(para, " "), mentionLink) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if inList {
+			out.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	for _, line := range strings.Split(text, "\n") {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return "", errRenderTimeout
+		}
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			switch {
+			case inCode && isDataFence(fence):
+				out.WriteString(dataTable(fence, block))
+				block = nil
+			case inCode:
+				out.WriteString("</code></pre>\n")
+			default:
+				flushPara()
+				closeList()
+				fence = strings.TrimSpace(trimmed[3:])
+				if !isDataFence(fence) {
+					out.WriteString("<pre><code>")
+				}
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			if isDataFence(fence) {
+				block = append(block, line)
+			} else {
+				out.WriteString(html.EscapeString(line) + "\n")
+			}
+			continue
+		}
+		if directive != nil {
+			if html, ok := directive(trimmed); ok {
+				flushPara()
+				closeList()
+				out.WriteString(string(html))
+				continue
+			}
+		}
+
+		switch {
+		case trimmed == "":
+			flushPara()
+			closeList()
+		case headingLevel(trimmed) > 0:
+			flushPara()
+			closeList()
+			n := headingLevel(trimmed)
+			tag := string(rune('0' + n))
+			out.WriteString("<h" + tag + ">" + inlineMarkdown(strings.TrimSpace(trimmed[n:]), mentionLink) + "</h" + tag + ">\n")
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			flushPara()
+			if !inList {
+				out.WriteString("<ul>\n")
+				inList = true
+			}
+			out.WriteString("<li>" + inlineMarkdown(trimmed[2:], mentionLink) + "</li>\n")
+		default:
+			closeList()
+			para = append(para, trimmed)
+		}
+	}
+	flushPara()
+	closeList()
+	if inCode {
+		if isDataFence(fence) {
+			out.WriteString(dataTable(fence, block))
+		} else {
+			out.WriteString("</code></pre>\n")
+		}
+	}
+
+	return template.HTML(out.String()), nil
+}
+
+// headingLevel returns n for a line starting with n '#' and a space (n <= 6).
+func headingLevel(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || n == len(line) || line[n] != ' ' {
+		return 0
+	}
+	return n
+}
+
+var (
+	mdCode   = regexp.MustCompile("`([^`]+)`")
+	mdLink   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdEm     = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+// inlineMarkdown escapes s and then applies the inline rules, linking
+// mentions when mentionLink is set. Code spans are cut out first so their
+// content is left alone.
+func inlineMarkdown(s string, mentionLink func(string) string) string {
+	var codes []string
+	s = mdCode.ReplaceAllStringFunc(s, func(m string) string {
+		codes = append(codes, "<code>"+html.EscapeString(m[1:len(m)-1])+"</code>")
+		return "\x00"
+	})
+
+	s = html.EscapeString(s)
+	s = mdLink.ReplaceAllStringFunc(s, func(m string) string {
+		parts := mdLink.FindStringSubmatch(m)
+		href := html.UnescapeString(parts[2])
+		if !safeURL(href) {
+			return parts[1]
+		}
+		return `<a href="` + html.EscapeString(href) + `">` + parts[1] + `</a>`
+	})
+	s = mdStrong.ReplaceAllString(s, "<strong>$1</strong>")
+	s = mdEm.ReplaceAllString(s, "<em>$1</em>")
+	if mentionLink != nil {
+		s = linkMentions(s, mentionLink)
+	}
+
+	for _, c := range codes {
+		s = strings.Replace(s, "\x00", c, 1)
+	}
+	return s
+}
+
+// safeURL accepts relative links and a few harmless schemes, rejecting
+// things like javascript: URLs.
+func safeURL(u string) bool {
+	lower := strings.ToLower(u)
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return !strings.Contains(lower, ":") || strings.Index(lower, ":") > strings.IndexAny(lower+"/", "/?#")
+}
@@ -0,0 +1,163 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// mention matches @name, as long as the @ doesn't follow a word, so
+// addresses like someone@example.com are left alone. The name follows the
+// rules of checkUserName, not ending in a dot so sentences can end on one.
+var mention = regexp.MustCompile(`(^|[^\w@/.])@([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9_])?)`)
+
+// extractMentions returns the names mentioned in text, once each, leaving
+// out code.
+func extractMentions(text string) []string {
+	var names []string
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		line = mdCode.ReplaceAllString(line, "")
+		for _, m := range mention.FindAllStringSubmatch(line, -1) {
+			if !slices.Contains(names, m[2]) {
+				names = append(names, m[2])
+			}
+		}
+	}
+	return names
+}
+
+// linkMentions turns the mentions in rendered inline markdown into links,
+// outside of the links already there.
+func linkMentions(s string, link func(name string) string) string {
+	replace := func(s string) string {
+		return mention.ReplaceAllStringFunc(s, func(m string) string {
+			parts := mention.FindStringSubmatch(m)
+			return parts[1] + `<a class="mention" href="` + link(parts[2]) + `">@` + parts[2] + `</a>`
+		})
+	}
+
+	var out strings.Builder
+	for s != "" {
+		start := strings.Index(s, "<a ")
+		if start < 0 {
+			out.WriteString(replace(s))
+			break
+		}
+		end := strings.Index(s[start:], "</a>")
+		if end < 0 {
+			out.WriteString(replace(s[:start]) + s[start:])
+			break
+		}
+		end += start + len("</a>")
+		out.WriteString(replace(s[:start]) + s[start:end])
+		s = s[end:]
+	}
+	return out.String()
+}
+
+// userPath is the profile of a user, which mentions link to.
+func (s *Server) userPath(name string) string {
+	return s.pagePath("user", url.PathEscape(name))
+}
+
+// newMentions returns who body mentions that the stored version of the
+// page didn't, so re-saving a page doesn't notify everyone again. With
+// accounts enabled, names that aren't accounts are dropped.
+func (s *Server) newMentions(ctx context.Context, title string, body []byte) []string {
+	names := extractMentions(string(body))
+	if len(names) == 0 {
+		return nil
+	}
+	if old, err := s.loadPage(ctx, title); err == nil {
+		before := extractMentions(string(old.Body))
+		names = slices.DeleteFunc(names, func(name string) bool { return slices.Contains(before, name) })
+	}
+	return s.knownUsers(ctx, names)
+}
+
+func (s *Server) knownUsers(ctx context.Context, names []string) []string {
+	if !s.cfg.Accounts.Enabled {
+		return names
+	}
+	return slices.DeleteFunc(names, func(name string) bool {
+		_, err := s.accounts.Account(ctx, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logf(ctx, "loading account %s: %v", name, err)
+		}
+		return err != nil
+	})
+}
+
+// notifyMentioned tells the users in names that author mentioned them,
+// except the author. It returns who was told, so they don't get a second
+// notification for the same change.
+func (s *Server) notifyMentioned(ctx context.Context, names []string, author string, n *Notification) ([]string, error) {
+	var told []string
+	for _, name := range names {
+		if name == author {
+			continue
+		}
+		c := *n
+		c.Kind = NotifyMention
+		c.Actor = author
+		c.Message = fmt.Sprintf("%s mentioned you on %s.", actorName(author), n.Title)
+		if err := s.notify(ctx, name, &c); err != nil {
+			return told, err
+		}
+		told = append(told, name)
+	}
+	return told, nil
+}
+
+// ProfileData is the data handed to the user.html template.
+type ProfileData struct {
+	Common
+	Name string
+	// Account is nil for users the wiki doesn't manage.
+	Account *UserInfo
+	// Pages are the pages the user edited last, newest first.
+	Pages []*Page
+}
+
+func (s *Server) userHandler(w http.ResponseWriter, r *http.Request) error {
+	name := r.PathValue("name")
+	data := &ProfileData{Name: name}
+
+	if s.cfg.Accounts.Enabled {
+		a, err := s.accounts.Account(r.Context(), name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return NotFound("There is no such user.")
+		}
+		if err != nil {
+			return err
+		}
+		data.Account = userInfo(a)
+	}
+
+	pages, err := s.listPages(r.Context())
+	if err != nil {
+		return err
+	}
+	for _, p := range s.readablePages(r.Context(), pages) {
+		if p.Author == name {
+			data.Pages = append(data.Pages, p)
+		}
+	}
+	slices.SortFunc(data.Pages, func(a, b *Page) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+
+	return s.renderTemplate(r.Context(), w, "user.html", data)
+}
@@ -0,0 +1,174 @@
+package wiki
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a handler to add behaviour around it, such as logging,
+// authentication or compression.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in the given middleware. The first one is the outermost, so
+// Chain(h, a, b) serves a request through a, then b, then h.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// panicsRecovered counts handler panics caught by recoverPanics across all
+// servers; it is published through expvar.
+var panicsRecovered = expvar.NewInt("panics_recovered")
+
+// responseRecorder remembers whether a handler already started its response,
+// so recovery knows if it is still allowed to send an error page.
+type responseRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+}
+
+func (rw *responseRecorder) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+	}
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.status = http.StatusOK
+	}
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to hijack
+// it for a WebSocket.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+type requestIDKey struct{}
+
+// maxRequestIDLen bounds the X-Request-ID values we accept from clients and
+// proxies, so they can't stuff arbitrary data into our logs.
+const maxRequestIDLen = 128
+
+// RequestID tags every request with an ID, reusing the one sent by an
+// upstream proxy in X-Request-ID when it looks sane, and echoes it back in
+// the response. Requests that already carry an ID in their context, because
+// an outer RequestID ran first, are passed through untouched.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestIDFrom(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestIDFrom returns the ID assigned by RequestID, or "" outside a request.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs a line prefixed with the request ID carried by ctx, if any.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if id := RequestIDFrom(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// LogRequests writes one access log line per request.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r)
+
+		logf(r.Context(), "%s %s %d %s", r.Method, r.URL.Path, rw.status, time.Since(start))
+	})
+}
+
+// traceRequests wraps each request in a tracing span.
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, end := s.startSpan(r.Context(), "http "+r.Method)
+		defer end()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recoverPanics turns a panic in any handler into a logged stack trace and a
+// friendly 500 page instead of a dropped connection.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseRecorder{ResponseWriter: w}
+
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// net/http uses this sentinel to abort a response on purpose
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			panicsRecovered.Add(1)
+			logf(r.Context(), "panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+
+			// too late for an error page if the response already started
+			if rw.wroteHeader {
+				return
+			}
+			s.renderError(w, r, fmt.Errorf("panic: %v", err))
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,288 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PendingEdit is an edit held for review instead of being saved.
+type PendingEdit struct {
+	ID          string
+	Page        *Page
+	SubmittedAt time.Time
+	RemoteAddr  string
+	// Reason tells the moderator why the edit was held.
+	Reason string
+}
+
+// ModerationQueue is implemented by storages that keep held edits.
+// Servers whose storage doesn't implement it hold them in memory.
+type ModerationQueue interface {
+	// Hold stores an edit until a moderator decides on it.
+	Hold(ctx context.Context, e *PendingEdit) error
+	// Pending returns the held edits, oldest first.
+	Pending(ctx context.Context) ([]*PendingEdit, error)
+	// Take removes a held edit and returns it, or an error matching
+	// fs.ErrNotExist when there is none with that ID.
+	Take(ctx context.Context, id string) (*PendingEdit, error)
+}
+
+// ModerationConfig decides which edits are held for review. Edits flagged
+// by a spam check always are.
+type ModerationConfig struct {
+	// Anonymous holds every edit made without signing in.
+	Anonymous bool `json:"anonymous"`
+}
+
+// holdReason returns why an edit made from r should be held, or "" when
+// it can be saved right away.
+func (s *Server) holdReason(r *http.Request, verdict SpamVerdict) string {
+	switch {
+	case verdict == SpamFlagged:
+		return "flagged as spam"
+	case s.cfg.Moderation.Anonymous && CurrentUser(r.Context()) == nil:
+		return "anonymous edit"
+	}
+	return ""
+}
+
+// hold puts an edit in the moderation queue and tells the author.
+func (s *Server) hold(w http.ResponseWriter, r *http.Request, p *Page, reason string) error {
+	e := &PendingEdit{
+		ID:          newID(),
+		Page:        p,
+		SubmittedAt: time.Now().UTC(),
+		Reason:      reason,
+	}
+	e.RemoteAddr, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	if err := s.moderation.Hold(r.Context(), e); err != nil {
+		return err
+	}
+	logf(r.Context(), "edit of %s held for review: %s", p.Title, reason)
+
+	return s.writeTemplate(r.Context(), w, http.StatusAccepted, "held.html", &EditPageData{Page: p})
+}
+
+// ModerationData is the data handed to the admin/moderation.html template.
+type ModerationData struct {
+	Common
+	Edits []*PendingEdit
+}
+
+func (s *Server) moderationHandler(w http.ResponseWriter, r *http.Request) error {
+	edits, err := s.moderation.Pending(r.Context())
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(r.Context(), w, "admin/moderation.html", &ModerationData{Edits: edits})
+}
+
+// moderateHandler approves or rejects the held edit named in the path.
+// Approving saves it as a new revision of the page, on top of whatever was
+// saved in the meantime.
+func (s *Server) moderateHandler(w http.ResponseWriter, r *http.Request) error {
+	decision := r.FormValue("decision")
+	if decision != "approve" && decision != "reject" {
+		return NewError(http.StatusBadRequest, "The decision must be approve or reject.")
+	}
+
+	e, err := s.moderation.Take(r.Context(), r.PathValue("id"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("This edit is no longer waiting for review.")
+	}
+	if err != nil {
+		return err
+	}
+
+	if decision == "approve" {
+		if err := s.approve(r.Context(), e); err != nil {
+			// put it back, so the decision can be made again
+			if herr := s.moderation.Hold(r.Context(), e); herr != nil {
+				logf(r.Context(), "edit %s lost: %v", e.ID, herr)
+			}
+			return err
+		}
+	}
+	logf(r.Context(), "edit %s of %s %sd by %s", e.ID, e.Page.Title, decision, UserFrom(r.Context()))
+	s.flash(w, r, FlashInfo, "The edit of "+e.Page.Title+" was "+decision+"d.")
+
+	http.Redirect(w, r, s.pagePath("admin", "moderation"), http.StatusFound)
+	return nil
+}
+
+func (s *Server) approve(ctx context.Context, e *PendingEdit) error {
+	p := e.Page
+	if err := s.hooks.pageSaving(ctx, p); err != nil {
+		return err
+	}
+	mentions := s.newMentions(ctx, p.Title, p.Body)
+	if err := s.savePage(ctx, p); err != nil {
+		return err
+	}
+	s.publish(ctx, &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision, Author: p.Author, Mentions: mentions})
+	return nil
+}
+
+// pendingEdits is an in-memory ModerationQueue.
+type pendingEdits struct {
+	mu    sync.Mutex
+	edits map[string]*PendingEdit
+}
+
+func (pe *pendingEdits) Hold(ctx context.Context, e *PendingEdit) error {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if pe.edits == nil {
+		pe.edits = make(map[string]*PendingEdit)
+	}
+	pe.edits[e.ID] = e
+	return nil
+}
+
+func (pe *pendingEdits) Pending(ctx context.Context) ([]*PendingEdit, error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	edits := make([]*PendingEdit, 0, len(pe.edits))
+	for _, e := range pe.edits {
+		edits = append(edits, e)
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].ID < edits[j].ID })
+	return edits, nil
+}
+
+func (pe *pendingEdits) Take(ctx context.Context, id string) (*PendingEdit, error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	e, ok := pe.edits[id]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	delete(pe.edits, id)
+	return e, nil
+}
+
+// pendingFile is how FileStorage writes a held edit.
+type pendingFile struct {
+	ID          string     `json:"id"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	Author      string     `json:"author,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	Archived    bool       `json:"archived,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	RemoteAddr  string     `json:"remote_addr,omitempty"`
+	Reason      string     `json:"reason"`
+}
+
+func (st *FileStorage) pendingDir() string {
+	return filepath.Join(st.dir, ".pending")
+}
+
+// pendingPath returns the file of a held edit. IDs come from URLs, so
+// anything that isn't a plain file name is refused.
+func (st *FileStorage) pendingPath(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || id[0] == '.' {
+		return "", fmt.Errorf("pending edit %q: %w", id, fs.ErrNotExist)
+	}
+	return filepath.Join(st.pendingDir(), id+".json"), nil
+}
+
+func (st *FileStorage) Hold(ctx context.Context, e *PendingEdit) error {
+	path, err := st.pendingPath(e.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(&pendingFile{
+		ID:          e.ID,
+		Title:       e.Page.Title,
+		Body:        string(e.Page.Body),
+		Author:      e.Page.Author,
+		PublishAt:   optionalTime(e.Page.PublishAt),
+		Archived:    e.Page.Archived,
+		SubmittedAt: e.SubmittedAt,
+		RemoteAddr:  e.RemoteAddr,
+		Reason:      e.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(st.pendingDir(), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (st *FileStorage) Pending(ctx context.Context) ([]*PendingEdit, error) {
+	files, err := filepath.Glob(filepath.Join(st.pendingDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	edits := make([]*PendingEdit, 0, len(files))
+	for _, file := range files {
+		e, err := readPending(file)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, e)
+	}
+	return edits, nil
+}
+
+func (st *FileStorage) Take(ctx context.Context, id string) (*PendingEdit, error) {
+	path, err := st.pendingPath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	e, err := readPending(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func readPending(path string) (*PendingEdit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f pendingFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &PendingEdit{
+		ID: f.ID,
+		Page: &Page{
+			Title:     f.Title,
+			Body:      []byte(f.Body),
+			Author:    f.Author,
+			PublishAt: timeOf(f.PublishAt),
+			Archived:  f.Archived,
+		},
+		SubmittedAt: f.SubmittedAt,
+		RemoteAddr:  f.RemoteAddr,
+		Reason:      f.Reason,
+	}, nil
+}
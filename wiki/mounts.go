@@ -0,0 +1,471 @@
+package wiki
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mounts show the markdown files of an external source as read-only pages
+// below a prefix, so generated docs can sit next to the wiki's own pages:
+// with the prefix docs/api, the file auth/tokens.md is the page
+// docs/api/auth/tokens. Pages below a mount's prefix only come from the
+// mount. Sources are read again periodically by the mount.refresh job,
+// which enqueues page.saved and page.deleted for what changed. The pages
+// last read are kept in .mounts in the data directory, so a restart
+// serves them right away, at the revisions they had.
+
+// JobMountRefresh reads the source of a mount again.
+const JobMountRefresh = "mount.refresh"
+
+const (
+	// maxMountPages caps the pages read from a source.
+	maxMountPages = 10000
+	// maxMountPageBytes caps the size of each.
+	maxMountPageBytes = 8 << 20
+
+	defaultMountRefresh = 15 * time.Minute
+	mountFetchTimeout   = 30 * time.Second
+	// mountLoadTimeout bounds how long New waits for a source it has no
+	// pages of yet.
+	mountLoadTimeout = 2 * time.Minute
+)
+
+// MountConfig is a source of read-only pages. Exactly one of Dir, Git and
+// Index is set.
+type MountConfig struct {
+	// Prefix is where the pages appear, e.g. "docs/api".
+	Prefix string `json:"prefix"`
+
+	// Dir is a local directory of .md files.
+	Dir string `json:"dir"`
+	// Git is the URL of a repository of .md files, cloned into
+	// .mounts in the data directory, on Branch or its default branch.
+	Git    string `json:"git"`
+	Branch string `json:"branch"`
+	// Index is the URL of a text file listing the .md files to show, a
+	// path relative to it per line.
+	Index string `json:"index"`
+
+	// RefreshMinutes is how often the source is read again, every 15
+	// minutes by default.
+	RefreshMinutes int `json:"refresh_minutes"`
+}
+
+func (c *MountConfig) check() error {
+	sources := 0
+	for _, src := range []string{c.Dir, c.Git, c.Index} {
+		if src != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("mount %s: give one of dir, git and index", c.Prefix)
+	}
+	if c.Prefix == "" || strings.HasPrefix(c.Prefix, "/") || strings.HasSuffix(c.Prefix, "/") {
+		return fmt.Errorf("mount %q: the prefix is a title, without leading or trailing slashes", c.Prefix)
+	}
+	if c.Index != "" {
+		if u, err := url.Parse(c.Index); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("mount %s: the index must be an http or https URL", c.Prefix)
+		}
+	}
+	return nil
+}
+
+func (c *MountConfig) interval() time.Duration {
+	if c.RefreshMinutes > 0 {
+		return time.Duration(c.RefreshMinutes) * time.Minute
+	}
+	return defaultMountRefresh
+}
+
+// source describes the mount for readers.
+func (c *MountConfig) source() string {
+	return cmp.Or(c.Dir, c.Git, c.Index)
+}
+
+// mount holds the pages last read from a source.
+type mount struct {
+	cfg MountConfig
+
+	mu    sync.RWMutex
+	pages map[string]*Page
+}
+
+func (m *mount) contains(title string) bool {
+	return strings.HasPrefix(title, m.cfg.Prefix+"/")
+}
+
+func (m *mount) load(title string) (*Page, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	p, ok := m.pages[title]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	cp := *p
+	return &cp, nil
+}
+
+// list returns the pages without their bodies, as Storage.List does.
+func (m *mount) list() []*Page {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pages := make([]*Page, 0, len(m.pages))
+	for _, p := range m.pages {
+		meta := *p
+		meta.Body = nil
+		pages = append(pages, &meta)
+	}
+	return pages
+}
+
+// mountedPage is how a page of a mount is kept in the data directory.
+type mountedPage struct {
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Revision  int       `json:"revision"`
+}
+
+func (s *Server) mountStatePath(m *mount) string {
+	return filepath.Join(s.cfg.DataDir, ".mounts", filepath.FromSlash(m.cfg.Prefix)+".json")
+}
+
+// loadMounts fills the mounts before the wiki serves anything, so their
+// pages are there from the start: with those kept at the last refresh, or
+// the first time, read from the source. Events aren't published for
+// these, the search index being built from the pages as they are.
+func (s *Server) loadMounts() {
+	for _, m := range s.mounts {
+		if s.cfg.DataDir != "" {
+			var kept []*mountedPage
+			if err := readJSONFile(s.mountStatePath(m), &kept); err != nil {
+				log.Printf("mount %s: %v", m.cfg.Prefix, err)
+			} else if kept != nil {
+				m.pages = make(map[string]*Page, len(kept))
+				for _, mp := range kept {
+					m.pages[mp.Title] = &Page{Title: mp.Title, Body: []byte(mp.Body), CreatedAt: mp.CreatedAt, UpdatedAt: mp.UpdatedAt, Revision: mp.Revision, MountedFrom: m.cfg.source()}
+				}
+				continue
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), mountLoadTimeout)
+		if _, _, err := s.updateMount(ctx, m); err != nil {
+			log.Printf("mount %s: %v", m.cfg.Prefix, err)
+		}
+		cancel()
+	}
+}
+
+// mountOf returns the mount title is below, or nil.
+func (s *Server) mountOf(title string) *mount {
+	for _, m := range s.mounts {
+		if m.contains(title) {
+			return m
+		}
+	}
+	return nil
+}
+
+// mounted refuses changes to mounted pages.
+func (s *Server) mounted(title string) error {
+	if m := s.mountOf(title); m != nil {
+		return Forbidden(title + " is mounted from " + m.cfg.source() + " and can't be changed here.")
+	}
+	return nil
+}
+
+// allPages is Storage.List with the mounted pages in place of any stored
+// below their prefixes.
+func (s *Server) allPages(ctx context.Context) ([]*Page, error) {
+	all, err := s.store.List(ctx)
+	if err != nil || len(s.mounts) == 0 {
+		return all, err
+	}
+	all = slices.DeleteFunc(all, func(p *Page) bool { return s.mountOf(p.Title) != nil })
+	for _, m := range s.mounts {
+		all = append(all, m.list()...)
+	}
+	return all, nil
+}
+
+// refreshMounts enqueues a refresh of every mount now and then every
+// interval of its own, until the server is closed.
+func (s *Server) refreshMounts() {
+	for _, m := range s.mounts {
+		go func() {
+			ticker := time.NewTicker(m.cfg.interval())
+			defer ticker.Stop()
+			for {
+				if err := s.jobs.Enqueue(JobMountRefresh, m.cfg.Prefix); err != nil {
+					log.Printf("mount %s: %v", m.cfg.Prefix, err)
+				}
+				select {
+				case <-s.stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+}
+
+// refreshMount handles mount.refresh, reading the source of a mount and
+// swapping in its pages.
+func (s *Server) refreshMount(ctx context.Context, job *Job) error {
+	var prefix string
+	if err := job.Decode(&prefix); err != nil {
+		return err
+	}
+	i := slices.IndexFunc(s.mounts, func(m *mount) bool { return m.cfg.Prefix == prefix })
+	if i < 0 {
+		return nil // removed from the configuration since
+	}
+	m := s.mounts[i]
+
+	changed, gone, err := s.updateMount(ctx, m)
+	if err != nil {
+		return fmt.Errorf("mount %s: %w", prefix, err)
+	}
+	for _, p := range changed {
+		s.publish(ctx, &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision})
+	}
+	for _, title := range gone {
+		s.publish(ctx, &Event{Kind: EventPageDeleted, Title: title})
+	}
+	return nil
+}
+
+// updateMount reads the source of m and swaps in its pages, returning
+// those that changed and the titles of those gone. What changed is kept
+// in the data directory, if there is one.
+func (s *Server) updateMount(ctx context.Context, m *mount) (changed []*Page, gone []string, err error) {
+	prefix := m.cfg.Prefix
+	files, err := s.readMount(ctx, &m.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	m.mu.RLock()
+	old := m.pages
+	m.mu.RUnlock()
+	pages := make(map[string]*Page, len(files))
+	for name, body := range files {
+		title := prefix + "/" + strings.TrimSuffix(name, ".md")
+		if err := s.titles.check(title); err != nil {
+			logf(ctx, "mount %s: skipping %s: %v", prefix, name, err)
+			continue
+		}
+		p := old[title]
+		if p == nil || string(p.Body) != string(body) {
+			next := &Page{Title: title, Body: body, CreatedAt: now, UpdatedAt: now, Revision: 1, MountedFrom: m.cfg.source()}
+			if p != nil {
+				next.CreatedAt, next.Revision = p.CreatedAt, p.Revision+1
+			}
+			p = next
+			changed = append(changed, p)
+		}
+		pages[title] = p
+	}
+
+	for title := range old {
+		if _, ok := pages[title]; !ok {
+			gone = append(gone, title)
+		}
+	}
+
+	m.mu.Lock()
+	m.pages = pages
+	m.mu.Unlock()
+
+	if s.cfg.DataDir != "" && (old == nil || len(changed) > 0 || len(gone) > 0) {
+		kept := make([]*mountedPage, 0, len(pages))
+		for _, p := range pages {
+			kept = append(kept, &mountedPage{Title: p.Title, Body: string(p.Body), CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt, Revision: p.Revision})
+		}
+		// an older copy only has the refresh after a restart publish more
+		if err := writeJSONFile(s.mountStatePath(m), kept); err != nil {
+			logf(ctx, "mount %s: keeping its pages: %v", prefix, err)
+		}
+	}
+	return changed, gone, nil
+}
+
+// readMount returns the .md files of a source by their slash-separated
+// paths.
+func (s *Server) readMount(ctx context.Context, c *MountConfig) (map[string][]byte, error) {
+	switch {
+	case c.Git != "":
+		dir, err := s.syncGit(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return readMarkdownDir(ctx, dir)
+	case c.Index != "":
+		return fetchIndex(ctx, c.Index)
+	}
+	return readMarkdownDir(ctx, c.Dir)
+}
+
+func readMarkdownDir(ctx context.Context, dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if file != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(file, ".md") {
+			return nil
+		}
+		if len(files) >= maxMountPages {
+			return fmt.Errorf("more than %d pages", maxMountPages)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > maxMountPageBytes {
+			logf(ctx, "mount: skipping %s, larger than %d bytes", file, maxMountPageBytes)
+			return nil
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = body
+		return nil
+	})
+	return files, err
+}
+
+// syncGit clones the repository of a mount, or brings the clone up to
+// date, and returns its directory.
+func (s *Server) syncGit(ctx context.Context, c *MountConfig) (string, error) {
+	if s.cfg.DataDir == "" {
+		return "", errors.New("git mounts need a data directory to clone into")
+	}
+	dir := filepath.Join(s.cfg.DataDir, ".mounts", filepath.FromSlash(c.Prefix))
+	git := func(args ...string) error {
+		out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+			return "", err
+		}
+		args := []string{"clone", "--depth", "1"}
+		if c.Branch != "" {
+			args = append(args, "--branch", c.Branch)
+		}
+		return dir, git(append(args, "--", c.Git, dir)...)
+	} else if err != nil {
+		return "", err
+	}
+
+	ref := c.Branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := git("-C", dir, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return "", err
+	}
+	return dir, git("-C", dir, "reset", "--hard", "FETCH_HEAD")
+}
+
+// fetchIndex reads the files an index lists. Lines that are empty or
+// start with # are skipped.
+func fetchIndex(ctx context.Context, index string) (map[string][]byte, error) {
+	base, err := url.Parse(index)
+	if err != nil {
+		return nil, err
+	}
+	list, err := fetchMountFile(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	sc := bufio.NewScanner(strings.NewReader(string(list)))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(line, "./"))
+		if !strings.HasSuffix(name, ".md") || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			logf(ctx, "mount %s: skipping %q, not a relative path to a .md file", index, line)
+			continue
+		}
+		if len(files) >= maxMountPages {
+			return nil, fmt.Errorf("%s lists more than %d pages", index, maxMountPages)
+		}
+		ref, err := base.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+		body, err := fetchMountFile(ctx, ref.String())
+		if err != nil {
+			return nil, err
+		}
+		files[name] = body
+	}
+	return files, nil
+}
+
+var mountClient = &http.Client{Timeout: mountFetchTimeout}
+
+func fetchMountFile(ctx context.Context, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := mountClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", link, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMountPageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMountPageBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", link, maxMountPageBytes)
+	}
+	return body, nil
+}
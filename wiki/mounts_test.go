@@ -0,0 +1,67 @@
+package wiki_test
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+func TestMounts(t *testing.T) {
+	data, src := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "Guide.md"), []byte("# Guide\nOne"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configure := func(cfg *wiki.Config) {
+		cfg.DataDir = data
+		cfg.Titles.Pattern = `^[a-zA-Z0-9/]+$`
+		cfg.Mounts = []wiki.MountConfig{{Prefix: "docs", Dir: src}}
+	}
+	page := func(w *wikitest.Wiki) *wiki.Page {
+		t.Helper()
+		p, err := w.Server.GetPage(w.Context(nil), "docs/Guide")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	w := wikitest.New(t, configure)
+	if p := page(w); p.Revision != 1 || !strings.Contains(string(p.Body), "One") {
+		t.Fatalf("docs/Guide is revision %d: %s", p.Revision, p.Body)
+	}
+	if html := w.Login("rita", wiki.RoleReader).GetRendered("docs/Guide"); !strings.Contains(html, "<h1") {
+		t.Errorf("docs/Guide isn't shown: %s", html)
+	}
+	ed := w.Login("ed", wiki.RoleEditor)
+	resp := ed.PostForm("/save/docs/Guide", url.Values{"body": {"Changed here"}})
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("saving a mounted page: %s, want 403", resp.Status)
+	}
+	if _, err := w.Server.ChangePage(w.Context(&wiki.User{Name: "ed", Role: wiki.RoleEditor}),
+		&wiki.BatchOperation{Op: "create", Title: "docs/New", Body: "x"}); err == nil {
+		t.Error("ChangePage created a page below the mount")
+	}
+	w.WaitJobs()
+
+	// the same files are the same revision after a restart
+	w = wikitest.New(t, configure)
+	w.WaitJobs()
+	if p := page(w); p.Revision != 1 {
+		t.Errorf("docs/Guide is revision %d after a restart, want 1", p.Revision)
+	}
+
+	if err := os.WriteFile(filepath.Join(src, "Guide.md"), []byte("# Guide\nTwo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w.Server.Jobs().Enqueue(wiki.JobMountRefresh, "docs")
+	w.WaitJobs()
+	if p := page(w); p.Revision != 2 || !strings.Contains(string(p.Body), "Two") {
+		t.Errorf("docs/Guide is revision %d after a refresh: %s", p.Revision, p.Body)
+	}
+}
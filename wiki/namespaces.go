@@ -0,0 +1,127 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// NamespaceConfig overrides settings for some of the pages, so one wiki can
+// hold sections governed differently.
+type NamespaceConfig struct {
+	// Match picks the pages: "team-x/*" is every page below team-x, at any
+	// depth, and anything else is a pattern for path.Match, e.g. "Draft*".
+	Match string `json:"match"`
+	// RequireLogin hides the pages from anonymous readers, in lists and
+	// searches too.
+	RequireLogin bool `json:"require_login"`
+	// LoginToEdit only lets signed-in users change the pages.
+	LoginToEdit bool `json:"login_to_edit"`
+	// Theme is a stylesheet in StaticDir the pages are shown with.
+	Theme string `json:"theme"`
+	// DisableNotes turns off notes on the pages.
+	DisableNotes bool `json:"disable_notes"`
+	// QuotaBytes caps the pages and attachments of the namespace together,
+	// instead of QuotaConfig.NamespaceBytes.
+	QuotaBytes int64 `json:"quota_bytes"`
+}
+
+func (ns *NamespaceConfig) matches(title string) bool {
+	if prefix, ok := strings.CutSuffix(ns.Match, "/*"); ok {
+		return strings.HasPrefix(title, prefix+"/")
+	}
+	ok, _ := path.Match(ns.Match, title)
+	return ok
+}
+
+// noNamespace applies to the pages no namespace matches.
+var noNamespace = &NamespaceConfig{}
+
+// namespace returns the settings of the first namespace matching title.
+func (s *Server) namespace(title string) *NamespaceConfig {
+	for i := range s.cfg.Namespaces {
+		if ns := &s.cfg.Namespaces[i]; ns.matches(title) {
+			return ns
+		}
+	}
+	return noNamespace
+}
+
+// canRead reports whether the user of ctx may see the page title.
+func (s *Server) canRead(ctx context.Context, title string) bool {
+	return !s.namespace(title).RequireLogin || CurrentUser(ctx) != nil
+}
+
+// readablePages drops the pages the user of ctx may not see.
+func (s *Server) readablePages(ctx context.Context, pages []*Page) []*Page {
+	if len(s.cfg.Namespaces) == 0 {
+		return pages
+	}
+	readable := pages[:0:0]
+	for _, p := range pages {
+		if s.canRead(ctx, p.Title) {
+			readable = append(readable, p)
+		}
+	}
+	return readable
+}
+
+// checkRead refuses the pages of namespaces that require signing in to
+// anonymous readers. withTitle runs it for every page route.
+func (s *Server) checkRead(r *http.Request, title string) error {
+	if !s.canRead(r.Context(), title) {
+		return Forbidden("Sign in to see this page.")
+	}
+	return nil
+}
+
+// canEdit reports whether the user of ctx may change pages: anyone but
+// readers, anonymous users as far as the namespace lets them.
+func canEdit(ctx context.Context) bool {
+	u := CurrentUser(ctx)
+	return u == nil || u.Role != RoleReader
+}
+
+// errReader refuses changes to readers.
+var errReader = Forbidden("Readers can't change pages.")
+
+// editing wraps the handlers that change a page, so they respect
+// NamespaceConfig.LoginToEdit, refuse readers and leave mounted pages
+// alone.
+func (s *Server) editing(fn pageHandler) pageHandler {
+	return func(w http.ResponseWriter, r *http.Request, title string) error {
+		if !canEdit(r.Context()) {
+			return errReader
+		}
+		if s.namespace(title).LoginToEdit && CurrentUser(r.Context()) == nil {
+			return Forbidden("Sign in to change this page.")
+		}
+		if err := s.mounted(title); err != nil {
+			return err
+		}
+		return fn(w, r, title)
+	}
+}
+
+// noting wraps the handlers of notes, so they respect
+// NamespaceConfig.DisableNotes.
+func (s *Server) noting(fn pageHandler) pageHandler {
+	return func(w http.ResponseWriter, r *http.Request, title string) error {
+		if s.namespace(title).DisableNotes {
+			return Forbidden("Notes are turned off for this page.")
+		}
+		return fn(w, r, title)
+	}
+}
+
+// pageTheme is the theme template function: the stylesheet of the
+// namespace of the page being shown, if there is one.
+func (s *Server) pageTheme(data interface{}) string {
+	if p := pageOf(data); p != nil {
+		if theme := s.namespace(p.Title).Theme; theme != "" {
+			return s.assetPath(theme)
+		}
+	}
+	return ""
+}
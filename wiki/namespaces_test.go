@@ -0,0 +1,52 @@
+package wiki_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+func TestRequireLoginHidesPages(t *testing.T) {
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Titles.Pattern = `^[a-zA-Z0-9/]+$`
+		cfg.Namespaces = []wiki.NamespaceConfig{{Match: "team/*", RequireLogin: true}}
+	})
+	w.CreatePage("team/Plan", "The secret plan")
+	w.CreatePage("Public", "The public plan")
+
+	anon := w.Client()
+	for _, p := range []string{"/view/team/Plan", "/edit/team/Plan", "/api/v1/pages/team/Plan"} {
+		resp := anon.Get(p)
+		if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s: %s, want 403: %s", p, resp.Status, body)
+		}
+	}
+	for _, p := range []string{"/", "/api/v1/pages", "/search?q=plan", "/api/v1/search?q=plan"} {
+		resp := anon.Get(p)
+		body := wikitest.ReadBody(t, resp)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s: %s", p, resp.Status, body)
+		}
+		if strings.Contains(body, "team/Plan") {
+			t.Errorf("GET %s shows team/Plan to anonymous readers: %s", p, body)
+		}
+		if !strings.Contains(body, "Public") {
+			t.Errorf("GET %s doesn't show Public: %s", p, body)
+		}
+	}
+	if _, err := w.Server.GetPage(w.Context(nil), "team/Plan"); err == nil {
+		t.Error("GetPage gave anonymous readers team/Plan")
+	}
+
+	reader := w.Login("rita", wiki.RoleReader)
+	if html := reader.GetRendered("team/Plan"); !strings.Contains(html, "The secret plan") {
+		t.Errorf("signed-in readers don't see team/Plan: %s", html)
+	}
+	resp := reader.Get("/api/v1/search?q=plan")
+	if body := wikitest.ReadBody(t, resp); !strings.Contains(body, "team/Plan") {
+		t.Errorf("signed-in readers don't find team/Plan: %s", body)
+	}
+}
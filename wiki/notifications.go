@@ -0,0 +1,541 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification kinds.
+const (
+	NotifyMention     = "mention"
+	NotifyPageChanged = "page_changed"
+	NotifyReply       = "reply"
+	NotifyNote        = "note"
+)
+
+// JobNoteAdded follows a new note on a page. Its payload is a NoteEvent.
+const JobNoteAdded = "note.added"
+
+// NoteEvent is the payload of JobNoteAdded.
+type NoteEvent struct {
+	Title  string `json:"title"`
+	ID     string `json:"id"`
+	Author string `json:"author,omitempty"`
+	// ReplyTo is the author of the note this one answers.
+	ReplyTo  string   `json:"reply_to,omitempty"`
+	Mentions []string `json:"mentions,omitempty"`
+}
+
+// Notification tells a user about something that happened on the wiki.
+type Notification struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Title   string `json:"title"`
+	Actor   string `json:"actor,omitempty"`
+	Message string `json:"message"`
+	// Anchor is the fragment of the page to link to, e.g. a note.
+	Anchor    string    `json:"anchor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Read      bool      `json:"read,omitempty"`
+}
+
+// NotificationStore is implemented by storage that keeps users'
+// notifications and the pages they watch. Wikis whose storage doesn't
+// implement it keep them in memory.
+type NotificationStore interface {
+	// Notify adds a notification for user, dropping their oldest ones
+	// beyond maxNotifications.
+	Notify(ctx context.Context, user string, n *Notification) error
+	// Notifications returns a user's notifications, newest first.
+	Notifications(ctx context.Context, user string) ([]*Notification, error)
+	// MarkRead marks the given notifications of user as read, or all of
+	// them when ids is empty.
+	MarkRead(ctx context.Context, user string, ids []string) error
+
+	Watch(ctx context.Context, user, title string, watch bool) error
+	// Watchers returns the users watching a page.
+	Watchers(ctx context.Context, title string) ([]string, error)
+	// Watched returns the pages a user watches, sorted.
+	Watched(ctx context.Context, user string) ([]string, error)
+	// ForgetUser drops a user's notifications and watches.
+	ForgetUser(ctx context.Context, user string) error
+}
+
+// maxNotifications is how many notifications a user keeps.
+const maxNotifications = 200
+
+// notifyWatchers tells the users watching a page that it changed, leaving
+// out whoever changed it and those in skip.
+func (s *Server) notifyWatchers(ctx context.Context, title string, n *Notification, skip ...string) error {
+	watchers, err := s.notifications.Watchers(ctx, title)
+	if err != nil {
+		return err
+	}
+	for _, user := range watchers {
+		if user == n.Actor || slices.Contains(skip, user) {
+			continue
+		}
+		if err := s.notify(ctx, user, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) notify(ctx context.Context, user string, n *Notification) error {
+	c := *n
+	c.ID = newID()
+	c.CreatedAt = time.Now().UTC()
+	return s.notifications.Notify(ctx, user, &c)
+}
+
+func (s *Server) notifyPageSaved(ctx context.Context, ev *Event) error {
+	told, err := s.notifyMentioned(ctx, ev.Mentions, ev.Author, &Notification{Title: ev.Title})
+	if err != nil {
+		return err
+	}
+	return s.notifyWatchers(ctx, ev.Title, &Notification{
+		Kind:    NotifyPageChanged,
+		Title:   ev.Title,
+		Actor:   ev.Author,
+		Message: fmt.Sprintf("%s was changed by %s (revision %d).", ev.Title, actorName(ev.Author), ev.Revision),
+	}, told...)
+}
+
+func (s *Server) notifyPageDeleted(ctx context.Context, ev *Event) error {
+	return s.notifyWatchers(ctx, ev.Title, &Notification{
+		Kind:    NotifyPageChanged,
+		Title:   ev.Title,
+		Actor:   ev.Author,
+		Message: fmt.Sprintf("%s was deleted by %s.", ev.Title, actorName(ev.Author)),
+	})
+}
+
+// notifyPageRenamed tells the watchers of the old title where the page
+// went.
+func (s *Server) notifyPageRenamed(ctx context.Context, ev *Event) error {
+	return s.notifyWatchers(ctx, ev.From, &Notification{
+		Kind:    NotifyPageChanged,
+		Title:   ev.Title,
+		Actor:   ev.Author,
+		Message: fmt.Sprintf("%s was renamed to %s by %s.", ev.From, ev.Title, actorName(ev.Author)),
+	})
+}
+
+// notifyNoteAdded tells the users mentioned in a note, the author of the
+// note being answered, then the page's watchers. Nobody hears about the
+// same note twice.
+func (s *Server) notifyNoteAdded(ctx context.Context, job *Job) error {
+	var ev NoteEvent
+	if err := job.Decode(&ev); err != nil {
+		return err
+	}
+	anchor := "note-" + ev.ID
+
+	told, err := s.notifyMentioned(ctx, ev.Mentions, ev.Author, &Notification{Title: ev.Title, Anchor: anchor})
+	if err != nil {
+		return err
+	}
+
+	if ev.ReplyTo != "" && ev.ReplyTo != ev.Author && !slices.Contains(told, ev.ReplyTo) {
+		err := s.notify(ctx, ev.ReplyTo, &Notification{
+			Kind:    NotifyReply,
+			Title:   ev.Title,
+			Actor:   ev.Author,
+			Anchor:  anchor,
+			Message: fmt.Sprintf("%s replied to your note on %s.", actorName(ev.Author), ev.Title),
+		})
+		if err != nil {
+			return err
+		}
+		told = append(told, ev.ReplyTo)
+	}
+
+	watchers, err := s.notifications.Watchers(ctx, ev.Title)
+	if err != nil {
+		return err
+	}
+	for _, user := range watchers {
+		if user == ev.Author || slices.Contains(told, user) {
+			continue
+		}
+		err := s.notify(ctx, user, &Notification{
+			Kind:    NotifyNote,
+			Title:   ev.Title,
+			Actor:   ev.Author,
+			Anchor:  anchor,
+			Message: fmt.Sprintf("%s added a note to %s.", actorName(ev.Author), ev.Title),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func actorName(name string) string {
+	if name == "" {
+		return "an anonymous user"
+	}
+	if isAnonymousAuthor(name) {
+		return "an anonymous user (" + strings.TrimPrefix(name, anonPrefix) + ")"
+	}
+	return name
+}
+
+// NotificationsData is the data handed to the notifications.html template.
+type NotificationsData struct {
+	Common
+	Notifications []*Notification
+	Unread        int
+}
+
+func unread(list []*Notification) int {
+	n := 0
+	for _, x := range list {
+		if !x.Read {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) notificationsHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to see your notifications.")
+	}
+	list, err := s.notifications.Notifications(r.Context(), user)
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(r.Context(), w, "notifications.html", &NotificationsData{Notifications: list, Unread: unread(list)})
+}
+
+// unreadHandler answers the layout's badge with the number of unread
+// notifications, zero for anonymous users.
+func (s *Server) unreadHandler(w http.ResponseWriter, r *http.Request) error {
+	resp := struct {
+		SignedIn bool `json:"signed_in"`
+		Unread   int  `json:"unread"`
+	}{}
+	if user := UserFrom(r.Context()); user != "" {
+		list, err := s.notifications.Notifications(r.Context(), user)
+		if err != nil {
+			return err
+		}
+		resp.SignedIn, resp.Unread = true, unread(list)
+	}
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+// markReadHandler marks the notifications named by the id fields as read,
+// or all of them without any. Notifications are opened through it too,
+// with the link to go to in the open field.
+func (s *Server) markReadHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to see your notifications.")
+	}
+	if err := r.ParseForm(); err != nil {
+		return NewError(http.StatusBadRequest, "The form could not be read.")
+	}
+	if err := s.notifications.MarkRead(r.Context(), user, r.PostForm["id"]); err != nil {
+		return err
+	}
+
+	next := s.pagePath("notifications", "")
+	if open := r.PostFormValue("open"); open != "" {
+		next = s.localPath(open)
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+	return nil
+}
+
+// notificationLink is where a notification leads.
+func (s *Server) notificationLink(n *Notification) string {
+	link := s.pagePath("view", n.Title)
+	if n.Anchor != "" {
+		link += "#" + url.PathEscape(n.Anchor)
+	}
+	return link
+}
+
+// watchHandler starts watching a page, or stops with watch=0.
+func (s *Server) watchHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to watch pages.")
+	}
+	if err := s.notifications.Watch(r.Context(), user, title, r.FormValue("watch") != "0"); err != nil {
+		return err
+	}
+	http.Redirect(w, r, s.pagePath("view", title), http.StatusSeeOther)
+	return nil
+}
+
+// markWatching fills in whether the user showing a page watches it. It is
+// best effort, like view counting.
+func (s *Server) markWatching(r *http.Request, p *Page) {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return
+	}
+	watchers, err := s.notifications.Watchers(r.Context(), p.Title)
+	if err != nil {
+		logf(r.Context(), "loading watchers of %s: %v", p.Title, err)
+		return
+	}
+	p.Watching = slices.Contains(watchers, user)
+}
+
+// userNotifications keeps notifications and watches in memory.
+type userNotifications struct {
+	mu       sync.Mutex
+	byUser   map[string][]*Notification
+	watchers map[string][]string
+}
+
+func (un *userNotifications) Notify(ctx context.Context, user string, n *Notification) error {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	if un.byUser == nil {
+		un.byUser = make(map[string][]*Notification)
+	}
+	un.byUser[user] = addNotification(un.byUser[user], n)
+	return nil
+}
+
+// addNotification puts n first in list, which is newest first, and drops
+// the oldest beyond maxNotifications.
+func addNotification(list []*Notification, n *Notification) []*Notification {
+	list = append([]*Notification{n}, list...)
+	if len(list) > maxNotifications {
+		list = list[:maxNotifications]
+	}
+	return list
+}
+
+func (un *userNotifications) Notifications(ctx context.Context, user string) ([]*Notification, error) {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	list := make([]*Notification, len(un.byUser[user]))
+	for i, n := range un.byUser[user] {
+		c := *n
+		list[i] = &c
+	}
+	return list, nil
+}
+
+func (un *userNotifications) MarkRead(ctx context.Context, user string, ids []string) error {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	markRead(un.byUser[user], ids)
+	return nil
+}
+
+func markRead(list []*Notification, ids []string) {
+	for _, n := range list {
+		if len(ids) == 0 || slices.Contains(ids, n.ID) {
+			n.Read = true
+		}
+	}
+}
+
+func (un *userNotifications) Watch(ctx context.Context, user, title string, watch bool) error {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	if un.watchers == nil {
+		un.watchers = make(map[string][]string)
+	}
+	un.watchers[title] = setWatch(un.watchers[title], user, watch)
+	if len(un.watchers[title]) == 0 {
+		delete(un.watchers, title)
+	}
+	return nil
+}
+
+func setWatch(users []string, user string, watch bool) []string {
+	i := slices.Index(users, user)
+	switch {
+	case watch && i < 0:
+		users = append(users, user)
+		sort.Strings(users)
+	case !watch && i >= 0:
+		users = slices.Delete(users, i, i+1)
+	}
+	return users
+}
+
+func (un *userNotifications) Watchers(ctx context.Context, title string) ([]string, error) {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	return slices.Clone(un.watchers[title]), nil
+}
+
+func (un *userNotifications) Watched(ctx context.Context, user string) ([]string, error) {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	return watchedBy(un.watchers, user), nil
+}
+
+// watchedBy returns the titles user watches in a map of watchers by title.
+func watchedBy(watchers map[string][]string, user string) []string {
+	var titles []string
+	for title, users := range watchers {
+		if slices.Contains(users, user) {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func (un *userNotifications) ForgetUser(ctx context.Context, user string) error {
+	un.mu.Lock()
+	defer un.mu.Unlock()
+
+	delete(un.byUser, user)
+	for title, users := range un.watchers {
+		if users = setWatch(users, user, false); len(users) == 0 {
+			delete(un.watchers, title)
+		} else {
+			un.watchers[title] = users
+		}
+	}
+	return nil
+}
+
+// notificationsPath is the file of a user's notifications. Names set by
+// the program's middleware can be anything, hence the escaping.
+func (st *FileStorage) notificationsPath(user string) string {
+	return filepath.Join(st.dir, ".notifications", url.PathEscape(user)+".json")
+}
+
+func (st *FileStorage) watchersPath() string {
+	return filepath.Join(st.dir, ".watchers.json")
+}
+
+// readJSONFile decodes a file into v, leaving v alone when the file
+// doesn't exist.
+func readJSONFile(name string, v interface{}) error {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSONFile(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	// write then rename, so a crash never leaves a truncated file
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (st *FileStorage) Notify(ctx context.Context, user string, n *Notification) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var list []*Notification
+	if err := readJSONFile(st.notificationsPath(user), &list); err != nil {
+		return err
+	}
+	return writeJSONFile(st.notificationsPath(user), addNotification(list, n))
+}
+
+func (st *FileStorage) Notifications(ctx context.Context, user string) ([]*Notification, error) {
+	var list []*Notification
+	err := readJSONFile(st.notificationsPath(user), &list)
+	return list, err
+}
+
+func (st *FileStorage) MarkRead(ctx context.Context, user string, ids []string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var list []*Notification
+	if err := readJSONFile(st.notificationsPath(user), &list); err != nil || len(list) == 0 {
+		return err
+	}
+	markRead(list, ids)
+	return writeJSONFile(st.notificationsPath(user), list)
+}
+
+func (st *FileStorage) Watch(ctx context.Context, user, title string, watch bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	watchers := make(map[string][]string)
+	if err := readJSONFile(st.watchersPath(), &watchers); err != nil {
+		return err
+	}
+	watchers[title] = setWatch(watchers[title], user, watch)
+	if len(watchers[title]) == 0 {
+		delete(watchers, title)
+	}
+	return writeJSONFile(st.watchersPath(), watchers)
+}
+
+func (st *FileStorage) Watchers(ctx context.Context, title string) ([]string, error) {
+	var watchers map[string][]string
+	err := readJSONFile(st.watchersPath(), &watchers)
+	return watchers[title], err
+}
+
+func (st *FileStorage) Watched(ctx context.Context, user string) ([]string, error) {
+	var watchers map[string][]string
+	err := readJSONFile(st.watchersPath(), &watchers)
+	return watchedBy(watchers, user), err
+}
+
+func (st *FileStorage) ForgetUser(ctx context.Context, user string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	err := os.Remove(st.notificationsPath(user))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	watchers := make(map[string][]string)
+	if err := readJSONFile(st.watchersPath(), &watchers); err != nil {
+		return err
+	}
+	for title, users := range watchers {
+		if users = setWatch(users, user, false); len(users) == 0 {
+			delete(watchers, title)
+		} else {
+			watchers[title] = users
+		}
+	}
+	return writeJSONFile(st.watchersPath(), watchers)
+}
@@ -0,0 +1,134 @@
+package wiki
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NamespaceExport is index.json of the zip /export/namespace/<name>.zip
+// sends: the pages of a namespace the reader may see, to hand a project's
+// pages over as files. Their text is in pages/<title>.md and their
+// attachments in attachments/<title>/.
+type NamespaceExport struct {
+	Namespace  string          `json:"namespace"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Pages      []*ExportedPage `json:"pages"`
+}
+
+// namespaceExportHandler sends the zip of the pages below a namespace,
+// and of the namespace's own page, that the reader may see. The exports
+// of pages titled namespace/... share its path, and end in .json.
+func (s *Server) namespaceExportHandler(w http.ResponseWriter, r *http.Request) error {
+	if strings.HasSuffix(r.PathValue("name"), ".json") {
+		r.SetPathValue("title", "namespace/"+r.PathValue("name"))
+		return s.pageExportHandler(w, r)
+	}
+	name, ok := strings.CutSuffix(r.PathValue("name"), ".zip")
+	if !ok {
+		return NotFound("Namespaces are exported as <name>.zip.")
+	}
+	name = strings.TrimSuffix(name, "/")
+	if err := s.titles.check(name); err != nil {
+		return NotFound("Invalid namespace")
+	}
+	ctx := r.Context()
+	all, err := s.ListPages(ctx)
+	if err != nil {
+		return err
+	}
+	ex := &NamespaceExport{Namespace: name, ExportedAt: time.Now().UTC()}
+	for _, p := range all {
+		if p.Title == name || strings.HasPrefix(p.Title, name+"/") {
+			ex.Pages = append(ex.Pages, &ExportedPage{
+				Title:     p.Title,
+				Revision:  p.Revision,
+				UpdatedAt: p.UpdatedAt,
+				Tags:      p.Tags,
+				File:      "pages/" + p.Title + ".md",
+			})
+		}
+	}
+	if len(ex.Pages) == 0 {
+		return NotFound("There are no pages in " + name + ".")
+	}
+	as, _ := s.store.(AttachmentStore)
+	for _, ep := range ex.Pages {
+		if as == nil || s.mountOf(ep.Title) != nil {
+			continue
+		}
+		files, err := as.Attachments(ctx, ep.Title)
+		if err != nil {
+			return err
+		}
+		for _, a := range files {
+			ep.Attachments = append(ep.Attachments, a.Name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(pathBase(name)+".zip"))
+	w.Header().Set("Cache-Control", "no-store")
+
+	zw := zip.NewWriter(w)
+	create := func(name string, modified time.Time) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	}
+	f, err := create("index.json", ex.ExportedAt)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ex); err != nil {
+		return err
+	}
+	for _, ep := range ex.Pages {
+		p, err := s.loadPage(ctx, ep.Title)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		f, err := create(ep.File, p.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(p.Body); err != nil {
+			return err
+		}
+		for _, file := range ep.Attachments {
+			if err := exportAttachment(ctx, as, create, ep.Title, file); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// exportAttachment copies an attachment of title into the zip, unless it
+// was deleted meanwhile.
+func exportAttachment(ctx context.Context, as AttachmentStore, create func(string, time.Time) (io.Writer, error), title, name string) error {
+	rc, a, err := as.OpenAttachment(ctx, title, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := create("attachments/"+title+"/"+name, a.ModTime)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	return err
+}
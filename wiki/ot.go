@@ -0,0 +1,241 @@
+package wiki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// textOp is an operational transformation on text, in the format of the
+// ot.js library: a sequence of components that together walk over the
+// whole document, retaining, inserting or deleting characters. In JSON a
+// positive number retains that many characters, a negative one deletes
+// them, and a string is inserted.
+//
+// Lengths count UTF-16 code units, like JavaScript strings do, so that
+// offsets mean the same on both ends.
+type textOp []opComponent
+
+type opComponent struct {
+	retain int
+	delete int
+	insert []uint16
+}
+
+var errOpMismatch = errors.New("operation does not fit the document")
+
+func (op *textOp) Retain(n int) {
+	if n == 0 {
+		return
+	}
+	if last := len(*op) - 1; last >= 0 && (*op)[last].retain > 0 {
+		(*op)[last].retain += n
+		return
+	}
+	*op = append(*op, opComponent{retain: n})
+}
+
+func (op *textOp) Delete(n int) {
+	if n == 0 {
+		return
+	}
+	if last := len(*op) - 1; last >= 0 && (*op)[last].delete > 0 {
+		(*op)[last].delete += n
+		return
+	}
+	*op = append(*op, opComponent{delete: n})
+}
+
+// Insert adds text. Inserts are kept before deletes at the same position,
+// so equal operations always have the same components.
+func (op *textOp) Insert(s []uint16) {
+	if len(s) == 0 {
+		return
+	}
+	o := *op
+	last := len(o) - 1
+	switch {
+	case last >= 0 && o[last].insert != nil:
+		o[last].insert = append(o[last].insert, s...)
+	case last >= 0 && o[last].delete > 0:
+		if last > 0 && o[last-1].insert != nil {
+			o[last-1].insert = append(o[last-1].insert, s...)
+		} else {
+			o = append(o, o[last])
+			o[last] = opComponent{insert: s}
+		}
+	default:
+		o = append(o, opComponent{insert: s})
+	}
+	*op = o
+}
+
+// baseLength is the length of the documents op applies to.
+func (op textOp) baseLength() int {
+	n := 0
+	for _, c := range op {
+		n += c.retain + c.delete
+	}
+	return n
+}
+
+// Apply returns doc changed by op.
+func (op textOp) Apply(doc []uint16) ([]uint16, error) {
+	if op.baseLength() != len(doc) {
+		return nil, errOpMismatch
+	}
+	out := make([]uint16, 0, len(doc))
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.retain > 0:
+			out = append(out, doc[pos:pos+c.retain]...)
+			pos += c.retain
+		case c.delete > 0:
+			pos += c.delete
+		default:
+			out = append(out, c.insert...)
+		}
+	}
+	return out, nil
+}
+
+// transformOps returns a' and b' such that applying a then b' gives the
+// same document as b then a'. When both insert at the same place, a's text
+// comes first.
+func transformOps(a, b textOp) (textOp, textOp, error) {
+	if a.baseLength() != b.baseLength() {
+		return nil, nil, errOpMismatch
+	}
+
+	var a1, b1 textOp
+	i, j := 0, 0
+	var ca, cb opComponent
+	next := func(op textOp, k *int) opComponent {
+		if *k >= len(op) {
+			return opComponent{}
+		}
+		*k++
+		return op[*k-1]
+	}
+	ca, cb = next(a, &i), next(b, &j)
+
+	for {
+		switch {
+		case ca.insert != nil:
+			a1.Insert(ca.insert)
+			b1.Retain(len(ca.insert))
+			ca = next(a, &i)
+			continue
+		case cb.insert != nil:
+			a1.Retain(len(cb.insert))
+			b1.Insert(cb.insert)
+			cb = next(b, &j)
+			continue
+		}
+
+		aDone := ca.retain == 0 && ca.delete == 0
+		bDone := cb.retain == 0 && cb.delete == 0
+		if aDone && bDone {
+			return a1, b1, nil
+		}
+		if aDone || bDone {
+			return nil, nil, errOpMismatch
+		}
+
+		n := min(ca.retain+ca.delete, cb.retain+cb.delete)
+		switch {
+		case ca.retain > 0 && cb.retain > 0:
+			a1.Retain(n)
+			b1.Retain(n)
+		case ca.delete > 0 && cb.retain > 0:
+			a1.Delete(n)
+		case ca.retain > 0 && cb.delete > 0:
+			b1.Delete(n)
+		}
+		// both deleting the same text leaves nothing to do
+
+		ca = shorten(ca, n)
+		if ca.retain == 0 && ca.delete == 0 {
+			ca = next(a, &i)
+		}
+		cb = shorten(cb, n)
+		if cb.retain == 0 && cb.delete == 0 {
+			cb = next(b, &j)
+		}
+	}
+}
+
+func shorten(c opComponent, n int) opComponent {
+	if c.retain > 0 {
+		c.retain -= n
+	} else {
+		c.delete -= n
+	}
+	return c
+}
+
+// transformIndex moves a cursor position in a document over op.
+func (op textOp) transformIndex(pos int) int {
+	newPos, index := pos, 0
+	for _, c := range op {
+		if index > pos {
+			break
+		}
+		switch {
+		case c.retain > 0:
+			index += c.retain
+		case c.delete > 0:
+			newPos -= min(c.delete, pos-index)
+			index += c.delete
+		default:
+			newPos += len(c.insert)
+		}
+	}
+	return newPos
+}
+
+func (op textOp) MarshalJSON() ([]byte, error) {
+	parts := make([]interface{}, len(op))
+	for i, c := range op {
+		switch {
+		case c.retain > 0:
+			parts[i] = c.retain
+		case c.delete > 0:
+			parts[i] = -c.delete
+		default:
+			parts[i] = string(utf16.Decode(c.insert))
+		}
+	}
+	return json.Marshal(parts)
+}
+
+func (op *textOp) UnmarshalJSON(data []byte) error {
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+
+	*op = nil
+	for _, part := range parts {
+		var n int
+		if err := json.Unmarshal(part, &n); err == nil {
+			switch {
+			case n > 0:
+				op.Retain(n)
+			case n < 0:
+				op.Delete(-n)
+			default:
+				return errors.New("operation component of length zero")
+			}
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(part, &s); err != nil {
+			return fmt.Errorf("operation component %s: %v", part, err)
+		}
+		op.Insert(utf16.Encode([]rune(s)))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type Page struct {
+	Title string
+	Body  []byte
+
+	// Metadata maintained by the storage layer. ID never changes, even
+	// when the page is renamed, and is what permalinks point to.
+	ID        string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Author    string
+	Revision  int
+
+	// PublishAt keeps the page hidden from readers until that time. The
+	// zero time means it is published.
+	PublishAt time.Time
+
+	// MountedFrom is the source of a page of a mount, which can't be
+	// changed in the wiki; see MountConfig.
+	MountedFrom string
+
+	// Archived pages are kept out of listings and shown with a banner.
+	// Config.ArchiveAfterDays can archive pages without setting it.
+	Archived bool
+
+	// Tags group the page with others; see parseTags for their form.
+	Tags []string
+
+	// Head is what admins added to the page's <head>, nil for none. It is
+	// set through HeadStore; Storage.Save keeps it.
+	Head *PageHead
+
+	// Views is how many times the page was viewed. It is filled in when
+	// the page is shown, not by Storage.Load.
+	Views int64
+	// Annotations are the readers' notes on the page, also filled in when
+	// it is shown.
+	Annotations []*Annotation
+	// Present lists who is viewing or editing the page right now.
+	Present []Presence
+	// Attachments are the files uploaded to the page.
+	Attachments []*Attachment
+	// Watching is set when the user viewing the page watches it.
+	Watching bool
+	// Starred is set when it is one of their favorites.
+	Starred bool
+	// TooLarge is set when the page is past Config.MaxPageBytes, and shown
+	// without its Body.
+	TooLarge bool
+	// Section is set when one section of the page is edited, Body holding
+	// just that section.
+	Section *EditedSection
+}
+
+// Published reports whether readers may see the page at time now.
+func (p *Page) Published(now time.Time) bool {
+	return p.PublishAt.IsZero() || !now.Before(p.PublishAt)
+}
+
+// listPages returns the pages readers may see right now.
+func (s *Server) listPages(ctx context.Context) ([]*Page, error) {
+	ctx, end := s.startSpan(ctx, "storage.list")
+	defer end()
+
+	all, err := s.allPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	pages := all[:0]
+	for _, p := range all {
+		if p.Published(now) {
+			pages = append(pages, p)
+		}
+	}
+	return pages, nil
+}
+
+func (s *Server) savePage(ctx context.Context, p *Page) error {
+	ctx, end := s.startSpan(ctx, "storage.save")
+	defer end()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := s.titles.check(p.Title); err != nil {
+		return &Error{Status: http.StatusBadRequest, Message: "The page can't be saved: " + err.Error() + "."}
+	}
+	if err := s.mounted(p.Title); err != nil {
+		return err
+	}
+
+	return s.store.Save(ctx, p)
+
+}
+
+func (s *Server) loadPage(ctx context.Context, title string) (*Page, error) {
+	ctx, end := s.startSpan(ctx, "storage.load")
+	defer end()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if m := s.mountOf(title); m != nil {
+		return m.load(title)
+	}
+	return s.store.Load(ctx, title)
+
+}
+
+func (s *Server) deletePage(ctx context.Context, title string) error {
+	ctx, end := s.startSpan(ctx, "storage.delete")
+	defer end()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := s.mounted(title); err != nil {
+		return err
+	}
+	return s.store.Delete(ctx, title)
+}
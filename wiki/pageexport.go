@@ -0,0 +1,271 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A page is exported as JSON from /export/<title>.json, and an admin of
+// another wiki can import the file through /api/v1/import, to move one
+// article without copying the data directory. With a RevisionStore, an
+// export holds the last maxExportRevisions revisions of the page, else only
+// the current one.
+
+// pageExportFormat marks the files, so other JSON isn't imported by mistake.
+const pageExportFormat = "gowiki-page/1"
+
+const (
+	// maxExportAttachmentBytes caps the attachments put in an export, the
+	// rest listed in LeftOut.
+	maxExportAttachmentBytes = 16 << 20
+	// maxImportBytes is the largest export accepted, allowing for the
+	// base64 encoding of the attachments.
+	maxImportBytes = maxExportAttachmentBytes/3*4 + 8<<20
+	// maxExportRevisions caps the revisions put in an export.
+	maxExportRevisions = 100
+)
+
+// PageExport is the file of an exported page.
+type PageExport struct {
+	Format     string    `json:"format"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	Title     string     `json:"title"`
+	ID        string     `json:"id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	Archived  bool       `json:"archived,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Head      *PageHead  `json:"head,omitempty"`
+
+	// Revisions are oldest first.
+	Revisions   []ExportedRevision   `json:"revisions"`
+	Attachments []ExportedAttachment `json:"attachments,omitempty"`
+	// LeftOut names the attachments past maxExportAttachmentBytes.
+	LeftOut []string `json:"left_out,omitempty"`
+}
+
+// ExportedRevision is a revision of an exported page.
+type ExportedRevision struct {
+	Revision  int       `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Author    string    `json:"author,omitempty"`
+	Body      string    `json:"body"`
+}
+
+// ExportedAttachment is a file of an exported page, its content base64 in
+// the JSON.
+type ExportedAttachment struct {
+	Name    string    `json:"name"`
+	ModTime time.Time `json:"mod_time"`
+	Content []byte    `json:"content"`
+}
+
+// pageExportHandler sends a page the reader may see as a PageExport.
+func (s *Server) pageExportHandler(w http.ResponseWriter, r *http.Request) error {
+	title, ok := strings.CutSuffix(r.PathValue("title"), ".json")
+	if !ok {
+		return NotFound("Pages are exported as <title>.json.")
+	}
+	p, err := s.GetPage(r.Context(), title)
+	if err != nil {
+		return err
+	}
+
+	ex := &PageExport{
+		Format:     pageExportFormat,
+		ExportedAt: time.Now().UTC(),
+		Title:      p.Title,
+		ID:         p.ID,
+		CreatedAt:  p.CreatedAt,
+		PublishAt:  optionalTime(p.PublishAt),
+		Archived:   p.Archived,
+		Tags:       p.Tags,
+		Head:       p.Head,
+	}
+	if ex.Revisions, err = s.exportRevisions(r.Context(), p); err != nil {
+		return err
+	}
+	if as, ok := s.store.(AttachmentStore); ok && s.mountOf(title) == nil {
+		files, err := as.Attachments(r.Context(), title)
+		if err != nil {
+			return err
+		}
+		var total int64
+		for _, a := range files {
+			if total+a.Size > maxExportAttachmentBytes {
+				ex.LeftOut = append(ex.LeftOut, a.Name)
+				continue
+			}
+			content, err := readAttachment(r.Context(), as, title, a.Name)
+			if err != nil {
+				return err
+			}
+			total += int64(len(content))
+			ex.Attachments = append(ex.Attachments, ExportedAttachment{Name: a.Name, ModTime: a.ModTime, Content: content})
+		}
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(pathBase(title)+".json"))
+	return writeJSON(w, http.StatusOK, ex)
+}
+
+// exportRevisions returns the kept revisions of p, ending with p itself.
+func (s *Server) exportRevisions(ctx context.Context, p *Page) ([]ExportedRevision, error) {
+	current := ExportedRevision{Revision: p.Revision, UpdatedAt: p.UpdatedAt, Author: p.Author, Body: string(p.Body)}
+	rs, ok := s.store.(RevisionStore)
+	if !ok || s.mountOf(p.Title) != nil {
+		return []ExportedRevision{current}, nil
+	}
+	kept, err := rs.Revisions(ctx, p.Title)
+	if err != nil {
+		return nil, err
+	}
+	if len(kept) > maxExportRevisions {
+		kept = kept[len(kept)-maxExportRevisions:]
+	}
+	var revs []ExportedRevision
+	for _, meta := range kept {
+		if meta.Revision >= p.Revision {
+			break
+		}
+		old, err := rs.LoadRevision(ctx, p.Title, meta.Revision)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, ExportedRevision{Revision: old.Revision, UpdatedAt: old.UpdatedAt, Author: old.Author, Body: string(old.Body)})
+	}
+	return append(revs, current), nil
+}
+
+func readAttachment(ctx context.Context, as AttachmentStore, title, name string) ([]byte, error) {
+	f, _, err := as.OpenAttachment(ctx, title, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(f)
+	return buf.Bytes(), err
+}
+
+// pathBase is the last segment of a title.
+func pathBase(title string) string {
+	return title[strings.LastIndex(title, "/")+1:]
+}
+
+// importPageHandler saves the page of a PageExport in the body, under the
+// title parameter or the exported title. It refuses to replace a page
+// unless replace=1. The latest revision becomes a new revision here, by
+// its author, with the tags, schedule, head and attachments of the export;
+// with a RevisionStore, the earlier ones are kept before it.
+func (s *Server) importPageHandler(w http.ResponseWriter, r *http.Request) error {
+	var ex PageExport
+	if err := readJSONLimit(w, r, &ex, maxImportBytes); err != nil {
+		return err
+	}
+	if ex.Format != pageExportFormat {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("This is not a page export; the format should be %q.", pageExportFormat))
+	}
+	if len(ex.Revisions) == 0 {
+		return NewError(http.StatusBadRequest, "The export has no revision to import.")
+	}
+	title := r.FormValue("title")
+	if title == "" {
+		title = ex.Title
+	}
+	if err := s.titles.check(title); err != nil {
+		return NewError(http.StatusBadRequest, "Invalid page title: "+err.Error()+".")
+	}
+	for _, a := range ex.Attachments {
+		if _, err := cleanAttachmentName(a.Name); err != nil {
+			return NewError(http.StatusBadRequest, "The file "+a.Name+" can't be attached: "+err.Error()+".")
+		}
+	}
+	if ex.Head != nil {
+		if err := checkHead(ex.Head); err != nil {
+			return NewError(http.StatusBadRequest, "The head can't be imported: "+err.Error()+".")
+		}
+	}
+
+	ctx := r.Context()
+	if _, err := s.loadPage(ctx, title); err == nil && r.FormValue("replace") != "1" {
+		return NewError(http.StatusConflict, "The page already exists; add replace=1 to replace it.")
+	}
+	latest := ex.Revisions[len(ex.Revisions)-1]
+	p := &Page{
+		Title:     title,
+		Body:      []byte(latest.Body),
+		Author:    latest.Author,
+		PublishAt: timeOf(ex.PublishAt),
+		Archived:  ex.Archived,
+		Tags:      parseTags(strings.Join(ex.Tags, ",")),
+	}
+	if err := s.hooks.pageSaving(ctx, p); err != nil {
+		return err
+	}
+	if err := s.saveImported(ctx, p, ex.Revisions[:len(ex.Revisions)-1]); err != nil {
+		return err
+	}
+	s.publish(ctx, &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision, Author: UserFrom(ctx)})
+	logf(ctx, "%s imported by %q", title, UserFrom(ctx))
+
+	if hs, ok := s.store.(HeadStore); ok && ex.Head != nil {
+		if err := hs.SetPageHead(ctx, title, ex.Head); err != nil {
+			return err
+		}
+	}
+	if len(ex.Attachments) > 0 {
+		as, err := s.attachmentStore()
+		if err != nil {
+			return err
+		}
+		for _, a := range ex.Attachments {
+			u := &Upload{Title: title, Name: a.Name, Size: int64(len(a.Content)), Content: bytes.NewReader(a.Content)}
+			if err := s.uploading(ctx, u); err != nil {
+				return err
+			}
+			if err := as.Attach(ctx, title, a.Name, bytes.NewReader(a.Content)); err != nil {
+				return err
+			}
+		}
+		if err := s.recountAttachments(ctx, title); err != nil {
+			logf(ctx, "counting attachments of %s: %v", title, err)
+		}
+	}
+
+	return writeJSON(w, http.StatusCreated, struct {
+		Title    string `json:"title"`
+		URL      string `json:"url"`
+		Revision int    `json:"revision"`
+	}{title, s.absoluteURL(s.pagePath("view", title)), p.Revision})
+}
+
+// saveImported saves p after the earlier revisions of its export, which
+// only storage keeping revisions can take.
+func (s *Server) saveImported(ctx context.Context, p *Page, earlier []ExportedRevision) error {
+	rs, ok := s.store.(RevisionStore)
+	if !ok || len(earlier) == 0 {
+		return s.savePage(ctx, p)
+	}
+	if err := s.mounted(p.Title); err != nil {
+		return err
+	}
+	revs := make([]*Page, len(earlier))
+	for i, e := range earlier {
+		revs[i] = &Page{Title: p.Title, Body: []byte(e.Body), Author: e.Author, UpdatedAt: e.UpdatedAt}
+	}
+	ctx, end := s.startSpan(ctx, "storage.save")
+	defer end()
+	return rs.ImportRevisions(ctx, p, revs)
+}
@@ -0,0 +1,257 @@
+package wiki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// PATCH /api/v1/pages/<title> changes part of a page, so a bot updating
+// one section doesn't need the whole body and doesn't undo what others
+// changed elsewhere meanwhile. The change is either a unified diff, whose
+// hunks are found by their content wherever they moved to, or edits of
+// sections named by their headings.
+
+// Section edits.
+const (
+	EditAppend  = "append"
+	EditPrepend = "prepend"
+	EditReplace = "replace"
+)
+
+// maxPatchBytes caps the request of a patch.
+const maxPatchBytes = 1 << 20
+
+// PagePatch is the body of a PATCH request: Patch or Edits.
+type PagePatch struct {
+	// Patch is a unified diff against the page, as diff -u writes it.
+	Patch string `json:"patch,omitempty"`
+	// Edits are applied in order.
+	Edits []SectionEdit `json:"edits,omitempty"`
+	// BaseRevision, when set, makes the patch fail if the page moved past
+	// it. Patches usually leave it out, as they apply to later revisions
+	// too.
+	BaseRevision int `json:"base_revision,omitempty"`
+}
+
+// SectionEdit changes one section of a page.
+type SectionEdit struct {
+	// Op is append, adding Text at the end of the section, prepend, adding
+	// it right below the heading, or replace, putting it in place of
+	// everything below the heading.
+	Op string `json:"op"`
+	// Section is the heading of the section, compared regardless of case;
+	// the first of several with the same heading is edited. Empty means
+	// the whole page.
+	Section string `json:"section,omitempty"`
+	Text    string `json:"text"`
+	// Create adds a missing section at the end of the page instead of
+	// failing, with a level 2 heading.
+	Create bool `json:"create,omitempty"`
+}
+
+// patchHandler applies a PagePatch to a page and answers with the revision
+// saved. Patches are applied one at a time, so two of them never work from
+// the same revision.
+func (s *Server) patchHandler(w http.ResponseWriter, r *http.Request) error {
+	if CurrentUser(r.Context()) == nil {
+		return Forbidden("Sign in to change pages.")
+	}
+	if !canEdit(r.Context()) {
+		return errReader
+	}
+	title := r.PathValue("title")
+	if err := s.titles.check(title); err != nil {
+		return NotFound("Invalid Page Title")
+	}
+	var patch PagePatch
+	if err := readJSONLimit(w, r, &patch, maxPatchBytes); err != nil {
+		return err
+	}
+	if (patch.Patch == "") == (len(patch.Edits) == 0) {
+		return NewError(http.StatusBadRequest, "Give either a patch or edits.")
+	}
+
+	s.patchMu.Lock()
+	defer s.patchMu.Unlock()
+
+	current, err := s.loadPage(r.Context(), title)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no such page.")
+	}
+	if err != nil {
+		return err
+	}
+	if patch.BaseRevision != 0 && patch.BaseRevision != current.Revision {
+		return NewError(http.StatusConflict, "The page changed since the base revision.")
+	}
+
+	body := string(current.Body)
+	if patch.Patch != "" {
+		body, err = applyUnifiedDiff(body, patch.Patch)
+	} else {
+		for i := 0; i < len(patch.Edits) && err == nil; i++ {
+			body, err = applySectionEdit(body, &patch.Edits[i])
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	rev, err := s.applyOperation(r.Context(), &BatchOperation{Op: OpUpdate, Title: title, Body: body, BaseRevision: current.Revision})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, struct {
+		Title    string `json:"title"`
+		Revision int    `json:"revision"`
+	}{title, rev})
+}
+
+// applySectionEdit returns body with e applied.
+func applySectionEdit(body string, e *SectionEdit) (string, error) {
+	text := e.Text
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+
+	sec := section{Content: 0, End: len(body)}
+	if e.Section != "" {
+		var ok bool
+		if sec, ok = findSection(body, e.Section); !ok {
+			if !e.Create {
+				return "", NewError(http.StatusUnprocessableEntity, fmt.Sprintf("There is no section called %q.", e.Section))
+			}
+			body = withNewline(body)
+			if body != "" {
+				body += "\n"
+			}
+			body += "## " + strings.TrimSpace(e.Section) + "\n"
+			sec = section{Content: len(body), End: len(body)}
+		}
+	}
+
+	switch e.Op {
+	case EditAppend:
+		// before the blank lines between the section and the next one
+		end := sec.Content + len(strings.TrimRightFunc(body[sec.Content:sec.End], unicode.IsSpace))
+		if end > sec.Content {
+			if i := strings.IndexByte(body[end:], '\n'); i >= 0 {
+				end += i + 1
+			} else {
+				end = len(body)
+			}
+		}
+		return withNewline(body[:end]) + text + body[end:], nil
+	case EditPrepend:
+		return withNewline(body[:sec.Content]) + text + body[sec.Content:], nil
+	case EditReplace:
+		return withNewline(body[:sec.Content]) + text + body[sec.End:], nil
+	}
+	return "", NewError(http.StatusBadRequest, fmt.Sprintf("Unknown edit %q; use append, prepend or replace.", e.Op))
+}
+
+// withNewline ends s with a newline unless it is empty.
+func withNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
+
+// diffHunk is a hunk of a unified diff: the lines it expects, where, and
+// what it puts in their place.
+type diffHunk struct {
+	at       int // 0-based line of the old text
+	old, new []string
+}
+
+// applyUnifiedDiff applies the hunks of patch to body. A hunk whose lines
+// moved is applied where they are now, the nearest place if they occur
+// more than once; a hunk whose lines are gone fails the whole patch.
+func applyUnifiedDiff(body, patch string) (string, error) {
+	hunks, err := parseUnifiedDiff(patch)
+	if err != nil {
+		return "", NewError(http.StatusBadRequest, "The patch can't be read: "+err.Error()+".")
+	}
+	lines := strings.Split(body, "\n")
+	// shift is how far the lines moved since the patch was made
+	from, shift := 0, 0
+	for i, h := range hunks {
+		at := findLines(lines, h.old, from, h.at+shift)
+		if at < 0 {
+			return "", NewError(http.StatusConflict, fmt.Sprintf("Hunk %d of the patch does not apply; the page changed there.", i+1))
+		}
+		lines = append(lines[:at], append(append([]string(nil), h.new...), lines[at+len(h.old):]...)...)
+		from = at + len(h.new)
+		shift = at - h.at + len(h.new) - len(h.old)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// findLines returns where want occurs in lines at or after from, nearest
+// to near, or -1.
+func findLines(lines, want []string, from, near int) int {
+	matches := func(at int) bool {
+		if at < from || at+len(want) > len(lines) {
+			return false
+		}
+		for i, l := range want {
+			if lines[at+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; near-d >= from || near+d <= len(lines); d++ {
+		if matches(near - d) {
+			return near - d
+		}
+		if matches(near + d) {
+			return near + d
+		}
+	}
+	return -1
+}
+
+func parseUnifiedDiff(patch string) ([]diffHunk, error) {
+	var hunks []diffHunk
+	var h *diffHunk
+	for _, line := range strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") {
+				return nil, fmt.Errorf("bad hunk header %q", line)
+			}
+			start, _, _ := strings.Cut(fields[1][1:], ",")
+			n, err := strconv.Atoi(start)
+			if err != nil {
+				return nil, fmt.Errorf("bad hunk header %q", line)
+			}
+			hunks = append(hunks, diffHunk{at: max(n-1, 0)})
+			h = &hunks[len(hunks)-1]
+		case h == nil:
+			// the --- and +++ lines, or anything else before the first hunk
+		case strings.HasPrefix(line, " "):
+			h.old = append(h.old, line[1:])
+			h.new = append(h.new, line[1:])
+		case strings.HasPrefix(line, "-"):
+			h.old = append(h.old, line[1:])
+		case strings.HasPrefix(line, "+"):
+			h.new = append(h.new, line[1:])
+		case line == "" || strings.HasPrefix(line, `\`):
+			// a trailing newline, or "\ No newline at end of file"
+		default:
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, errors.New("it has no hunks")
+	}
+	return hunks, nil
+}
@@ -0,0 +1,152 @@
+package wiki
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Renamer is implemented by storage that can move a page to a new title
+// together with its ID, metadata, notes and attachments. Rename reports a
+// missing page with fs.ErrNotExist and an existing target with fs.ErrExist.
+//
+// For storage without it the wiki copies the page and deletes the old one,
+// which keeps the ID but loses what Storage doesn't know about.
+type Renamer interface {
+	Rename(ctx context.Context, from, to string) error
+}
+
+// newPageID returns the ID of a new page. It is random rather than derived
+// from the title, so it stays the same when the page is renamed.
+func newPageID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// permalink is the path of the link to a page that survives renames, or
+// the absolute URL when Config.PublicURL is set.
+func (s *Server) permalink(id string) string {
+	return s.absoluteURL(s.pagePath("p", id))
+}
+
+// permalinkHandler sends /p/<id> to wherever the page is now.
+func (s *Server) permalinkHandler(w http.ResponseWriter, r *http.Request) error {
+	id := r.PathValue("id")
+
+	pages, err := s.listPages(r.Context())
+	if err != nil {
+		return err
+	}
+	for _, p := range pages {
+		if p.ID == id {
+			http.Redirect(w, r, s.pagePath("view", p.Title), http.StatusFound)
+			return nil
+		}
+	}
+	return NotFound("No page has this permalink.")
+}
+
+// renameHandler moves a page to the title in the "to" field. Links to the
+// old title break, permalinks don't.
+func (s *Server) renameHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	to := r.FormValue("to")
+	if err := s.titles.check(to); err != nil {
+		return s.pageFormFailed(w, r, title, "to", NewError(http.StatusBadRequest, "The page can't be renamed: "+err.Error()+"."))
+	}
+	if to == title {
+		http.Redirect(w, r, s.pagePath("view", title), http.StatusFound)
+		return nil
+	}
+
+	err := s.renamePage(r.Context(), title, to)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no page to rename.")
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return s.pageFormFailed(w, r, title, "to", NewError(http.StatusConflict, fmt.Sprintf("There already is a page called %s.", to)))
+	}
+	if err != nil {
+		return err
+	}
+
+	s.publish(r.Context(), &Event{Kind: EventPageRenamed, Title: to, From: title, Author: UserFrom(r.Context())})
+	s.flash(w, r, FlashInfo, "Renamed "+title+" to "+to+".")
+
+	http.Redirect(w, r, s.pagePath("view", to), http.StatusFound)
+	return nil
+}
+
+func (s *Server) renamePage(ctx context.Context, from, to string) error {
+	ctx, end := s.startSpan(ctx, "storage.rename")
+	defer end()
+
+	if err := s.mounted(from); err != nil {
+		return err
+	}
+	if err := s.mounted(to); err != nil {
+		return err
+	}
+
+	if rn, ok := s.store.(Renamer); ok {
+		return rn.Rename(ctx, from, to)
+	}
+
+	p, err := s.loadPage(ctx, from)
+	if err != nil {
+		return err
+	}
+	if _, err := s.loadPage(ctx, to); err == nil {
+		return fs.ErrExist
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	p.Title = to
+	if err := s.store.Save(ctx, p); err != nil {
+		return err
+	}
+	return s.deletePage(ctx, from)
+}
+
+func (st *FileStorage) Rename(ctx context.Context, from, to string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, err := os.Stat(st.generateArticlePath(to)); err == nil {
+		return fs.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := os.Stat(st.generateArticlePath(from)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.generateArticlePath(to)), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(st.generateArticlePath(from), st.generateArticlePath(to)); err != nil {
+		return err
+	}
+
+	// the rest is optional, and only there for some pages
+	moves := [][2]string{
+		{st.metaPath(from), st.metaPath(to)},
+		{st.notesPath(from), st.notesPath(to)},
+		{st.attachmentDir(from), st.attachmentDir(to)},
+		{st.revisionDir(from), st.revisionDir(to)},
+	}
+	for _, m := range moves {
+		if err := os.MkdirAll(filepath.Dir(m[1]), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(m[0], m[1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
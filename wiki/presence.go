@@ -0,0 +1,110 @@
+package wiki
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// presenceTTL is how long a heartbeat keeps someone listed on a page. The
+// pages send one every presenceTTL/3.
+const presenceTTL = 45 * time.Second
+
+// Presence is someone currently on a page.
+type Presence struct {
+	User    string `json:"user"`
+	Editing bool   `json:"editing"`
+}
+
+// presenceTracker keeps short-lived records of who is on which page. It is
+// only in memory: after a restart the next heartbeats fill it again.
+type presenceTracker struct {
+	mu    sync.Mutex
+	pages map[string]map[string]presenceRecord
+}
+
+type presenceRecord struct {
+	editing bool
+	expires time.Time
+}
+
+func (pt *presenceTracker) touch(title, user string, editing bool, now time.Time) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	if pt.pages == nil {
+		pt.pages = make(map[string]map[string]presenceRecord)
+	}
+	users := pt.pages[title]
+	if users == nil {
+		users = make(map[string]presenceRecord)
+		pt.pages[title] = users
+	}
+	users[user] = presenceRecord{editing: editing, expires: now.Add(presenceTTL)}
+}
+
+// present returns who is on the page, dropping expired records on the way.
+func (pt *presenceTracker) present(title string, now time.Time) map[string]bool {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	present := make(map[string]bool)
+	for user, rec := range pt.pages[title] {
+		if now.After(rec.expires) {
+			delete(pt.pages[title], user)
+			continue
+		}
+		present[user] = rec.editing
+	}
+	if len(pt.pages[title]) == 0 {
+		delete(pt.pages, title)
+	}
+	return present
+}
+
+// presence returns who is viewing or editing a page, by name. Editors
+// connected to a collaborative session count even between heartbeats.
+func (s *Server) presence(title string) []Presence {
+	present := s.presenceTracker.present(title, time.Now())
+
+	s.collabMu.Lock()
+	if session, ok := s.sessions[title]; ok {
+		session.mu.Lock()
+		for c := range session.clients {
+			present[c.user] = true
+		}
+		session.mu.Unlock()
+	}
+	s.collabMu.Unlock()
+
+	list := make([]Presence, 0, len(present))
+	for user, editing := range present {
+		list = append(list, Presence{User: user, Editing: editing})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].User < list[j].User })
+	return list
+}
+
+// presenceHandler records a heartbeat from a signed-in user, sent with
+// mode=editing from the editor, and answers with everyone on the page.
+// Anonymous readers get the list without being added to it.
+func (s *Server) presenceHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	if u := CurrentUser(r.Context()); u != nil {
+		s.presenceTracker.touch(title, u.Name, r.FormValue("mode") == "editing", time.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(s.presence(title))
+}
+
+// markPresent records the user showing a page and fills in who else is on
+// it.
+func (s *Server) markPresent(r *http.Request, p *Page, editing bool) {
+	if u := CurrentUser(r.Context()); u != nil {
+		s.presenceTracker.touch(p.Title, u.Name, editing, time.Now())
+	}
+	p.Present = s.presence(p.Title)
+}
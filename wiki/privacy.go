@@ -0,0 +1,408 @@
+package wiki
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// formerUser is who the pages and notes of deleted users are credited to.
+// It isn't a valid user name, so no one can sign up as it.
+const formerUser = "former user"
+
+// Reattributor is implemented by storage that can credit a user's pages
+// and notes to someone else without making new revisions, the kept ones
+// included, as FileStorage does. With storage that can't, deleting a user
+// saves the pages they last edited again as formerUser, and the earlier
+// revisions they wrote keep their name.
+type Reattributor interface {
+	Reattribute(ctx context.Context, from, to string) error
+}
+
+// UserExport is everything the wiki keeps about a user, as exported to
+// them. The text of their pages is alongside, in pages/, and that of the
+// revisions they wrote in revisions/.
+type UserExport struct {
+	User          string          `json:"user"`
+	ExportedAt    time.Time       `json:"exported_at"`
+	Account       *UserInfo       `json:"account,omitempty"`
+	Data          *UserData       `json:"data"`
+	Watching      []string        `json:"watching"`
+	Notifications []*Notification `json:"notifications"`
+	// Pages are those the user last edited, and Revisions the kept
+	// revisions of any page they wrote, their last ones included.
+	Pages     []*ExportedPage `json:"pages"`
+	Revisions []*ExportedPage `json:"revisions"`
+	Notes     []*ExportedNote `json:"notes"`
+}
+
+type ExportedPage struct {
+	Title     string    `json:"title"`
+	Revision  int       `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Tags      []string  `json:"tags,omitempty"`
+	// File is where the text is in the export.
+	File string `json:"file"`
+	// Attachments are the names of the page's files, in exports that
+	// carry them.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+type ExportedNote struct {
+	Title string `json:"title"`
+	*Annotation
+}
+
+// exportUser collects the data of user. Unpublished pages are included:
+// they are the user's own.
+func (s *Server) exportUser(ctx context.Context, user string) (*UserExport, error) {
+	ex := &UserExport{User: user, ExportedAt: time.Now().UTC()}
+	if s.cfg.Accounts.Enabled {
+		a, err := s.accounts.Account(ctx, user)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			ex.Account = userInfo(a)
+		}
+	}
+
+	var err error
+	if ex.Data, err = s.userData.UserData(ctx, user); err != nil {
+		return nil, err
+	}
+	if ex.Watching, err = s.notifications.Watched(ctx, user); err != nil {
+		return nil, err
+	}
+	if ex.Notifications, err = s.notifications.Notifications(ctx, user); err != nil {
+		return nil, err
+	}
+
+	all, err := s.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rs, _ := s.store.(RevisionStore)
+	for _, p := range all {
+		if p.Author == user {
+			ex.Pages = append(ex.Pages, &ExportedPage{
+				Title:     p.Title,
+				Revision:  p.Revision,
+				UpdatedAt: p.UpdatedAt,
+				Tags:      p.Tags,
+				File:      "pages/" + p.Title + ".txt",
+			})
+		}
+		if rs != nil {
+			kept, err := rs.Revisions(ctx, p.Title)
+			if err != nil {
+				return nil, err
+			}
+			for _, rev := range kept {
+				if rev.Author == user {
+					ex.Revisions = append(ex.Revisions, &ExportedPage{
+						Title:     p.Title,
+						Revision:  rev.Revision,
+						UpdatedAt: rev.UpdatedAt,
+						Tags:      rev.Tags,
+						File:      fmt.Sprintf("revisions/%s/%d.txt", p.Title, rev.Revision),
+					})
+				}
+			}
+		}
+		notes, err := s.annotations.Annotations(ctx, p.Title)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range notes {
+			if a.Author == user {
+				ex.Notes = append(ex.Notes, &ExportedNote{Title: p.Title, Annotation: a})
+			}
+		}
+	}
+	return ex, nil
+}
+
+// exportHandler sends the signed-in user a zip of their data: data.json,
+// a UserExport, and the text of their pages and revisions.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to export your data.")
+	}
+	ex, err := s.exportUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("wiki-%s-%s.zip", user, ex.ExportedAt.Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(name))
+	w.Header().Set("Cache-Control", "no-store")
+
+	zw := zip.NewWriter(w)
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: ex.ExportedAt})
+	}
+	f, err := create("data.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ex); err != nil {
+		return err
+	}
+	for _, ep := range ex.Pages {
+		p, err := s.loadPage(r.Context(), ep.Title)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		f, err := create(ep.File)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(p.Body); err != nil {
+			return err
+		}
+	}
+	rs, _ := s.store.(RevisionStore)
+	for _, er := range ex.Revisions {
+		rev, err := rs.LoadRevision(r.Context(), er.Title, er.Revision)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // deleted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		f, err := create(er.File)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(rev.Body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// deleteUser removes what the wiki keeps about user and credits their
+// pages and notes to formerUser. What others wrote about them, mentions
+// included, is left alone.
+func (s *Server) deleteUser(ctx context.Context, user string) error {
+	if err := s.reattribute(ctx, user); err != nil {
+		return err
+	}
+	if err := s.userData.DeleteUserData(ctx, user); err != nil {
+		return err
+	}
+	if err := s.notifications.ForgetUser(ctx, user); err != nil {
+		return err
+	}
+	if s.cfg.Accounts.Enabled {
+		err := s.accounts.DeleteAccount(ctx, user)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := s.logins.endAll(ctx, user); err != nil {
+			return err
+		}
+	}
+	logf(ctx, "deleted the data of user %s", user)
+	return nil
+}
+
+func (s *Server) reattribute(ctx context.Context, user string) error {
+	if ra, ok := s.store.(Reattributor); ok {
+		if err := ra.Reattribute(ctx, user, formerUser); err != nil {
+			return err
+		}
+	} else {
+		all, err := s.store.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, meta := range all {
+			if meta.Author != user {
+				continue
+			}
+			p, err := s.loadPage(ctx, meta.Title)
+			if err != nil {
+				return err
+			}
+			p.Author = formerUser
+			if err := s.savePage(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+	// notes kept apart from the pages
+	if ra, ok := s.annotations.(Reattributor); ok && any(s.annotations) != any(s.store) {
+		return ra.Reattribute(ctx, user, formerUser)
+	}
+	return nil
+}
+
+// AccountData is the data handed to the account.html template.
+type AccountData struct {
+	Common
+	Name string
+	// Managed is set for accounts of the wiki, which have a password.
+	Managed bool
+	// Unverified is set until the account's email address is confirmed.
+	Unverified bool
+	Error      string
+}
+
+func (s *Server) accountHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("You need to sign in first.")
+	}
+	return s.renderTemplate(r.Context(), w, "account.html", s.accountData(r.Context(), user))
+}
+
+func (s *Server) accountData(ctx context.Context, user string) *AccountData {
+	data := &AccountData{Name: user}
+	if s.cfg.Accounts.Enabled {
+		a, err := s.accounts.Account(ctx, user)
+		data.Managed = err == nil
+		data.Unverified = err == nil && a.Unverified
+	}
+	return data
+}
+
+// deleteAccountHandler deletes the signed-in user, who confirms by typing
+// their name and, for accounts of the wiki, their password. The last admin
+// can't go, so the wiki stays manageable.
+func (s *Server) deleteAccountHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	user := UserFrom(ctx)
+	if user == "" {
+		return Forbidden("You need to sign in first.")
+	}
+	data := s.accountData(ctx, user)
+	fail := func(status int, message string) error {
+		data.Error = message
+		return s.writeTemplate(ctx, w, status, "account.html", data)
+	}
+
+	if r.PostFormValue("confirm") != user {
+		return fail(http.StatusBadRequest, "Type your user name to confirm.")
+	}
+	if data.Managed {
+		a, err := s.accounts.Account(ctx, user)
+		if err != nil {
+			return err
+		}
+		if !checkPasswordHash(a.Password, r.PostFormValue("password")) {
+			return fail(http.StatusForbidden, "The password is wrong.")
+		}
+		if a.Role == RoleAdmin {
+			last, err := s.lastAdmin(ctx)
+			if err != nil {
+				return err
+			}
+			if last {
+				return fail(http.StatusForbidden, "You are the only administrator; make someone else one first.")
+			}
+		}
+	}
+
+	if err := s.deleteUser(ctx, user); err != nil {
+		return err
+	}
+	s.clearSessionCookies(w)
+	s.flash(w, r, FlashInfo, "Your account was deleted.")
+	http.Redirect(w, r, s.cfg.BasePath+"/", http.StatusSeeOther)
+	return nil
+}
+
+// lastAdmin reports whether there is at most one enabled admin account.
+func (s *Server) lastAdmin(ctx context.Context) (bool, error) {
+	accounts, err := s.accounts.Accounts(ctx)
+	if err != nil {
+		return false, err
+	}
+	admins := 0
+	for _, a := range accounts {
+		if a.Role == RoleAdmin && !a.Disabled {
+			admins++
+		}
+	}
+	return admins <= 1, nil
+}
+
+// Reattribute rewrites the author of from's pages, revisions and notes in
+// place.
+func (st *FileStorage) Reattribute(ctx context.Context, from, to string) error {
+	pages, err := st.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, p := range pages {
+		if p.Author == from {
+			meta, err := st.loadMeta(p.Title)
+			if err != nil {
+				return err
+			}
+			meta.Author = to
+			data, err := json.Marshal(meta)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(st.metaPath(p.Title), data, 0600); err != nil {
+				return err
+			}
+		}
+
+		notes, err := st.loadNotes(p.Title)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, a := range notes {
+			if a.Author == from {
+				a.Author, changed = to, true
+			}
+		}
+		if changed {
+			if err := st.saveNotes(p.Title, notes); err != nil {
+				return err
+			}
+		}
+		if err := st.reattributeRevisions(p.Title, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pn *pageNotes) Reattribute(ctx context.Context, from, to string) error {
+	pn.mu.Lock()
+	defer pn.mu.Unlock()
+
+	for _, notes := range pn.notes {
+		for _, a := range notes {
+			if a.Author == from {
+				a.Author = to
+			}
+		}
+	}
+	return nil
+}
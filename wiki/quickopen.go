@@ -0,0 +1,179 @@
+package wiki
+
+import (
+	"fmt"
+	"math/bits"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Quick open is the palette Ctrl+K opens in the layout: it jumps to a page
+// by a few letters of its title or tags. /api/v1/quickopen ranks the pages
+// for it without reading their text, so it can answer every keystroke.
+
+const (
+	defaultQuickOpenResults = 10
+	maxQuickOpenResults     = 50
+	// quickOpenRecent is how long a change counts as recent.
+	quickOpenRecent = 7 * 24 * time.Hour
+)
+
+// QuickOpenResult is a page offered by /api/v1/quickopen. Match is how the
+// page was found: "title", "tag", or "recent" when nothing was typed.
+type QuickOpenResult struct {
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Score     int       `json:"score"`
+	Match     string    `json:"match"`
+	Tags      []string  `json:"tags,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Favorite  bool      `json:"favorite,omitempty"`
+}
+
+// titleScore rates how well q, lower-cased, matches a title: best the
+// whole title, then its beginning, that of its last segment or of a word,
+// anywhere in it, and last its letters in order. Zero is no match.
+func titleScore(q, title string) int {
+	t := strings.ToLower(title)
+	base := strings.ToLower(pathBase(title))
+	switch {
+	case t == q || base == q:
+		return 100
+	case strings.HasPrefix(t, q):
+		return 80
+	case strings.HasPrefix(base, q):
+		return 70
+	case strings.Contains(t, " "+q) || strings.Contains(t, "/"+q) || strings.Contains(t, "_"+q) || strings.Contains(t, "-"+q):
+		return 60
+	case strings.Contains(t, q):
+		return 40
+	}
+	// the letters of q in order, fewer gaps scoring higher
+	gaps, i := 0, 0
+	for _, r := range t {
+		if i == len(q) {
+			break
+		}
+		c, size := utf8.DecodeRuneInString(q[i:])
+		if r == c {
+			i += size
+		} else if i > 0 {
+			gaps++
+		}
+	}
+	if i < len(q) {
+		return 0
+	}
+	return max(1, 30-gaps)
+}
+
+// tagScore rates how well q matches one of the tags.
+func tagScore(q string, tags []string) int {
+	score := 0
+	for _, tag := range tags {
+		switch {
+		case tag == q:
+			score = max(score, 50)
+		case strings.HasPrefix(tag, q):
+			score = max(score, 30)
+		}
+	}
+	return score
+}
+
+// quickOpenHandler answers GET /api/v1/quickopen?q=..., the pages the
+// reader may see best matching q, favorites, popular and recently changed
+// pages first among equals. Without q, it offers the reader's favorites
+// and then the recently changed pages.
+func (s *Server) quickOpenHandler(w http.ResponseWriter, r *http.Request) error {
+	limit := defaultQuickOpenResults
+	if v := r.FormValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxQuickOpenResults {
+			return NewError(http.StatusBadRequest, fmt.Sprintf("The limit is a number from 1 to %d.", maxQuickOpenResults))
+		}
+	}
+	q := strings.ToLower(strings.TrimSpace(r.FormValue("q")))
+
+	ctx := r.Context()
+	pages, err := s.ListPages(ctx)
+	if err != nil {
+		return err
+	}
+	counts, err := s.views.ViewCounts(ctx)
+	if err != nil {
+		return err
+	}
+	var favorites []string
+	if user := UserFrom(ctx); user != "" {
+		d, err := s.userData.UserData(ctx, user)
+		if err != nil {
+			return err
+		}
+		favorites = d.Favorites
+	}
+
+	now := time.Now()
+	var results []*QuickOpenResult
+	for _, p := range pages {
+		res := &QuickOpenResult{
+			Title:     p.Title,
+			Tags:      p.Tags,
+			UpdatedAt: p.UpdatedAt,
+			Favorite:  slices.Contains(favorites, p.Title),
+		}
+		if q == "" {
+			res.Match = "recent"
+		} else {
+			title, tag := titleScore(q, p.Title), tagScore(q, p.Tags)
+			if title == 0 && tag == 0 {
+				continue
+			}
+			res.Score, res.Match = title, "title"
+			if tag > title {
+				res.Score, res.Match = tag, "tag"
+			}
+		}
+		if res.Favorite {
+			res.Score += 15
+		}
+		if now.Sub(p.UpdatedAt) < quickOpenRecent {
+			res.Score += 10
+		}
+		if n := counts[p.Title]; n > 0 {
+			// a point per doubling of the views, at most 10
+			res.Score += min(10, bits.Len64(uint64(n)))
+		}
+		results = append(results, res)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if q == "" && a.Favorite != b.Favorite {
+			return a.Favorite
+		}
+		if q == "" {
+			return a.UpdatedAt.After(b.UpdatedAt)
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Title < b.Title
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for _, res := range results {
+		res.URL = s.absoluteURL(s.pagePath("view", res.Title))
+	}
+	if results == nil {
+		results = []*QuickOpenResult{}
+	}
+	return writeJSON(w, http.StatusOK, struct {
+		Results []*QuickOpenResult `json:"results"`
+	}{results})
+}
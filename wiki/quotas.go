@@ -0,0 +1,310 @@
+package wiki
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Quotas cap the bytes of pages and attachments a user, or a namespace,
+// takes up. A page counts towards the user who saved it last, and an
+// attachment towards the user who uploaded it, or the page's author when
+// the storage doesn't know. Namespaces are those of Config.Namespaces, and
+// for pages no namespace matches, the first element of the title: team-x
+// for team-x/Setup. Edits that don't make usage grow are always let
+// through, so users over their quota can still clean up, and admins have
+// no quota.
+
+// QuotaConfig sets the quotas. Zero means no limit.
+type QuotaConfig struct {
+	// UserBytes caps what each user takes up.
+	UserBytes int64 `json:"user_bytes"`
+	// NamespaceBytes caps each namespace, unless its NamespaceConfig sets
+	// QuotaBytes.
+	NamespaceBytes int64 `json:"namespace_bytes"`
+}
+
+// usageItem is a page, or when File is set an attachment of it.
+type usageItem struct {
+	Title, File string
+	Owner       string
+	Bytes       int64
+}
+
+// attachmentIndex holds the attachments of every page, so usage can be
+// added up without reading all the directories. It is built when first
+// needed and kept current by uploads and the page.saved and page.deleted
+// jobs.
+type attachmentIndex struct {
+	mu    sync.Mutex
+	built bool
+	files map[string][]*Attachment
+}
+
+// attachmentsByPage returns the attachments of every page, by title.
+func (s *Server) attachmentsByPage(ctx context.Context) (map[string][]*Attachment, error) {
+	as, ok := s.store.(AttachmentStore)
+	if !ok {
+		return nil, nil
+	}
+	s.attached.mu.Lock()
+	defer s.attached.mu.Unlock()
+	if !s.attached.built {
+		all, err := s.store.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		files := make(map[string][]*Attachment)
+		for _, p := range all {
+			list, err := as.Attachments(ctx, p.Title)
+			if err != nil {
+				return nil, err
+			}
+			if len(list) > 0 {
+				files[p.Title] = list
+			}
+		}
+		s.attached.files, s.attached.built = files, true
+	}
+	return s.attached.files, nil
+}
+
+// recountAttachments reads the attachments of a page into the index again.
+func (s *Server) recountAttachments(ctx context.Context, title string) error {
+	as, ok := s.store.(AttachmentStore)
+	if !ok {
+		return nil
+	}
+	s.attached.mu.Lock()
+	defer s.attached.mu.Unlock()
+	if !s.attached.built {
+		return nil
+	}
+	list, err := as.Attachments(ctx, title)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		delete(s.attached.files, title)
+	} else {
+		s.attached.files[title] = list
+	}
+	return nil
+}
+
+// recountPage keeps the attachment index current as pages are saved,
+// deleted and renamed, renames moving their attachments along.
+func (s *Server) recountPage(ctx context.Context, ev *Event) error {
+	for _, title := range ev.titles() {
+		if err := s.recountAttachments(ctx, title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usageItems returns the pages and attachments of the wiki with their
+// sizes, pages taken from the search index.
+func (s *Server) usageItems(ctx context.Context) ([]usageItem, error) {
+	if err := s.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+	files, err := s.attachmentsByPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []usageItem
+	s.index.mu.RLock()
+	for title, doc := range s.index.docs {
+		if s.mountOf(title) != nil {
+			continue
+		}
+		items = append(items, usageItem{Title: title, Owner: doc.page.Author, Bytes: doc.size})
+	}
+	s.index.mu.RUnlock()
+	authors := make(map[string]string, len(items))
+	for _, it := range items {
+		authors[it.Title] = it.Owner
+	}
+	for title, list := range files {
+		for _, a := range list {
+			owner := a.Uploader
+			if owner == "" {
+				owner = authors[title]
+			}
+			items = append(items, usageItem{Title: title, File: a.Name, Owner: owner, Bytes: a.Size})
+		}
+	}
+	return items, nil
+}
+
+// quotaNamespace returns the namespace a page counts towards and its
+// quota. Top-level pages no namespace matches are in none.
+func (s *Server) quotaNamespace(title string) (string, int64) {
+	if ns := s.namespace(title); ns != noNamespace {
+		return ns.Match, cmp.Or(ns.QuotaBytes, s.cfg.Quotas.NamespaceBytes)
+	}
+	first, _, ok := strings.Cut(title, "/")
+	if !ok {
+		return "", 0
+	}
+	return first, s.cfg.Quotas.NamespaceBytes
+}
+
+func (s *Server) quotasEnabled() bool {
+	if s.cfg.Quotas.UserBytes > 0 || s.cfg.Quotas.NamespaceBytes > 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.cfg.Namespaces, func(ns NamespaceConfig) bool { return ns.QuotaBytes > 0 })
+}
+
+// checkQuota refuses a change storing item, replacing the page or file it
+// names, if it makes a user or namespace grow past its quota.
+func (s *Server) checkQuota(ctx context.Context, item usageItem) error {
+	if !s.quotasEnabled() {
+		return nil
+	}
+	if u := CurrentUser(ctx); u != nil && u.Role == RoleAdmin {
+		return nil
+	}
+	items, err := s.usageItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	ns, nsQuota := s.quotaNamespace(item.Title)
+	var userBefore, userAfter, nsBefore, nsAfter int64
+	count := func(it usageItem, user, space *int64) {
+		if it.Owner == item.Owner {
+			*user += it.Bytes
+		}
+		if ns != "" {
+			if key, _ := s.quotaNamespace(it.Title); key == ns {
+				*space += it.Bytes
+			}
+		}
+	}
+	for _, it := range items {
+		count(it, &userBefore, &nsBefore)
+		if it.Title != item.Title || it.File != item.File {
+			count(it, &userAfter, &nsAfter)
+		}
+	}
+	count(item, &userAfter, &nsAfter)
+
+	if quota := s.cfg.Quotas.UserBytes; quota > 0 && item.Owner != "" && userAfter > quota && userAfter > userBefore {
+		return NewError(http.StatusInsufficientStorage,
+			fmt.Sprintf("This would take you past your storage quota of %d bytes; you use %d.", quota, userBefore))
+	}
+	if nsQuota > 0 && ns != "" && nsAfter > nsQuota && nsAfter > nsBefore {
+		return NewError(http.StatusInsufficientStorage,
+			fmt.Sprintf("This would take %s past its storage quota of %d bytes; it uses %d.", ns, nsQuota, nsBefore))
+	}
+	return nil
+}
+
+// checkPageQuota checks saving body as title by author.
+func (s *Server) checkPageQuota(ctx context.Context, title, author string, body []byte) error {
+	return s.checkQuota(ctx, usageItem{Title: title, Owner: author, Bytes: int64(len(body))})
+}
+
+// UsageRow is the usage of a user or namespace. Quota is zero when there
+// is none.
+type UsageRow struct {
+	Name  string
+	Bytes int64
+	Quota int64
+}
+
+// Over reports whether the row is past its quota.
+func (r *UsageRow) Over() bool {
+	return r.Quota > 0 && r.Bytes > r.Quota
+}
+
+// maxUsageRows caps the users and namespaces the dashboard lists.
+const maxUsageRows = 20
+
+// storageUsage adds up the usage by user and by namespace, largest first.
+func (s *Server) storageUsage(ctx context.Context) (users, namespaces []*UsageRow, err error) {
+	items, err := s.usageItems(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	byUser, byNS := make(map[string]*UsageRow), make(map[string]*UsageRow)
+	add := func(rows map[string]*UsageRow, name string, quota, bytes int64) {
+		row := rows[name]
+		if row == nil {
+			row = &UsageRow{Name: name, Quota: quota}
+			rows[name] = row
+		}
+		row.Bytes += bytes
+	}
+	for _, it := range items {
+		quota := s.cfg.Quotas.UserBytes
+		if it.Owner == "" {
+			quota = 0
+		}
+		add(byUser, it.Owner, quota, it.Bytes)
+		name, nsQuota := s.quotaNamespace(it.Title)
+		add(byNS, name, nsQuota, it.Bytes)
+	}
+	sorted := func(rows map[string]*UsageRow) []*UsageRow {
+		list := make([]*UsageRow, 0, len(rows))
+		for _, row := range rows {
+			list = append(list, row)
+		}
+		slices.SortFunc(list, func(a, b *UsageRow) int {
+			return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), strings.Compare(a.Name, b.Name))
+		})
+		if len(list) > maxUsageRows {
+			list = list[:maxUsageRows]
+		}
+		return list
+	}
+	return sorted(byUser), sorted(byNS), nil
+}
+
+// uploadersPath keeps who uploaded the files of a page.
+func (st *FileStorage) uploadersPath(title string) string {
+	return filepath.Join(st.attachmentDir(title), ".uploaders.json")
+}
+
+// setUploader records the uploader of a file, or forgets it when user is
+// empty.
+func (st *FileStorage) setUploader(title, name, user string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	who := make(map[string]string)
+	if err := readJSONFile(st.uploadersPath(title), &who); err != nil {
+		return err
+	}
+	if user == "" {
+		if _, ok := who[name]; !ok {
+			return nil
+		}
+		delete(who, name)
+	} else {
+		who[name] = user
+	}
+	return writeJSONFile(st.uploadersPath(title), who)
+}
+
+// fillUploaders sets the Uploader of files from the record of a page.
+func (st *FileStorage) fillUploaders(title string, files []*Attachment) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var who map[string]string
+	if err := readJSONFile(st.uploadersPath(title), &who); err != nil {
+		return err
+	}
+	for _, a := range files {
+		a.Uploader = who[a.Name]
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package wiki
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// rateLimit allows Max attempts at something per Window, counted in the
+// shared Cache so replicas enforce it together.
+type rateLimit struct {
+	Name   string
+	Max    int64
+	Window time.Duration
+}
+
+// loginLimit is the failed sign-ins allowed from one address.
+var loginLimit = rateLimit{Name: "login", Max: 10, Window: 15 * time.Minute}
+
+func (l rateLimit) key(who string) string {
+	return "limit:" + l.Name + ":" + who
+}
+
+// overLimit reports whether who used up their attempts.
+func (s *Server) overLimit(ctx context.Context, l rateLimit, who string) (bool, error) {
+	n, ok, err := s.cache.Get(ctx, l.key(who))
+	if err != nil || !ok {
+		return false, err
+	}
+	count, _ := strconv.ParseInt(string(n), 10, 64)
+	return count >= l.Max, nil
+}
+
+// countAttempt counts one attempt by who. The window starts with the
+// first one.
+func (s *Server) countAttempt(ctx context.Context, l rateLimit, who string) error {
+	_, err := s.cache.Incr(ctx, l.key(who), l.Window)
+	return err
+}
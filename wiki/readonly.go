@@ -0,0 +1,73 @@
+package wiki
+
+import (
+	"net/http"
+	"strings"
+)
+
+// readOnlyMessage is shown for the requests refused in read-only mode.
+const readOnlyMessage = "The wiki is read-only for maintenance at the moment. Reading works as usual; please try changing it again later."
+
+// ReadOnly reports whether the wiki is in read-only mode.
+func (s *Server) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// SetReadOnly switches read-only mode, in which pages can be read and
+// searched but nothing can be changed, e.g. while backing up the data
+// directory.
+func (s *Server) SetReadOnly(on bool) {
+	s.readOnly.Store(on)
+}
+
+// guardReadOnly answers requests that would change the wiki with a 503
+// while it is read-only. Signing in and out, presence and turning the
+// mode off again still work.
+func (s *Server) guardReadOnly(next http.Handler) http.Handler {
+	base := s.cfg.BasePath
+	allowed := []string{base + "/login", base + "/logout", base + "/admin/read-only", base + apiPrefix + "/session", base + apiPrefix + "/read-only"}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.ReadOnly() || !s.changesWiki(r, allowed) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", "300")
+		if strings.HasPrefix(r.URL.Path, base+apiPrefix+"/") {
+			writeJSON(w, http.StatusServiceUnavailable, apiError{Error: readOnlyMessage})
+			return
+		}
+		s.renderError(w, r, NewError(http.StatusServiceUnavailable, readOnlyMessage))
+	})
+}
+
+// changesWiki reports whether r is refused in read-only mode: anything but
+// reading, and the editors, except for the allowed paths.
+func (s *Server) changesWiki(r *http.Request, allowed []string) bool {
+	base := s.cfg.BasePath
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return strings.HasPrefix(r.URL.Path, base+"/edit/") || strings.HasPrefix(r.URL.Path, base+"/collab/")
+	}
+	for _, path := range allowed {
+		if r.URL.Path == path {
+			return false
+		}
+	}
+	return !strings.HasPrefix(r.URL.Path, base+"/presence/")
+}
+
+// readOnlyHandler takes the form of admin/dashboard.html switching
+// read-only mode on (on=1) or off.
+func (s *Server) readOnlyHandler(w http.ResponseWriter, r *http.Request) error {
+	on := r.PostFormValue("on") == "1"
+	s.SetReadOnly(on)
+	if on {
+		logf(r.Context(), "read-only mode turned on by %s", UserFrom(r.Context()))
+		s.flash(w, r, FlashInfo, "The wiki is read-only now.")
+	} else {
+		logf(r.Context(), "read-only mode turned off by %s", UserFrom(r.Context()))
+		s.flash(w, r, FlashInfo, "The wiki can be edited again.")
+	}
+	http.Redirect(w, r, s.pagePath("admin", ""), http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,232 @@
+package wiki
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisConfig points the wiki's Cache at a Redis server, which replicas of
+// the wiki then share.
+type RedisConfig struct {
+	// Addr is host:port. Empty keeps the cache in memory.
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	// Prefix starts every key, so wikis can share a server; "gowiki:" by
+	// default.
+	Prefix string `json:"prefix"`
+}
+
+const (
+	redisTimeout  = 5 * time.Second
+	maxRedisIdle  = 16
+	defaultPrefix = "gowiki:"
+)
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisClient speaks just enough RESP for redisCache, over a small pool
+// of connections.
+type redisClient struct {
+	cfg RedisConfig
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (rc *redisClient) conn(ctx context.Context) (*redisConn, error) {
+	rc.mu.Lock()
+	if n := len(rc.idle); n > 0 {
+		c := rc.idle[n-1]
+		rc.idle = rc.idle[:n-1]
+		rc.mu.Unlock()
+		return c, nil
+	}
+	rc.mu.Unlock()
+
+	d := net.Dialer{Timeout: redisTimeout}
+	nc, err := d.DialContext(ctx, "tcp", rc.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if rc.cfg.Password != "" {
+		if _, err := c.do(ctx, "AUTH", rc.cfg.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if rc.cfg.DB != 0 {
+		if _, err := c.do(ctx, "SELECT", strconv.Itoa(rc.cfg.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do sends a command and returns its reply: a string, an int64, nil or a
+// []interface{} of those.
+func (rc *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	c, err := rc.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, args...)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		// the connection may be out of step with the server
+		c.Close()
+		return nil, err
+	}
+
+	rc.mu.Lock()
+	if len(rc.idle) < maxRedisIdle {
+		rc.idle = append(rc.idle, c)
+		c = nil
+	}
+	rc.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+	return reply, err
+}
+
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > redisTimeout {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	c.SetDeadline(deadline)
+
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
+
+// redisCache is a Cache in Redis.
+type redisCache struct {
+	client *redisClient
+	prefix string
+}
+
+func newRedisCache(cfg RedisConfig) *redisCache {
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return &redisCache{client: &redisClient{cfg: cfg}, prefix: prefix}
+}
+
+func (rc *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := rc.client.do(ctx, "GET", rc.prefix+key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: GET replied %T", reply)
+	}
+	return []byte(s), true, nil
+}
+
+func (rc *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", rc.prefix + key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := rc.client.do(ctx, args...)
+	return err
+}
+
+func (rc *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := []string{"DEL"}
+	for _, k := range keys {
+		args = append(args, rc.prefix+k)
+	}
+	_, err := rc.client.do(ctx, args...)
+	return err
+}
+
+func (rc *redisCache) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	reply, err := rc.client.do(ctx, "INCR", rc.prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: INCR replied %T", reply)
+	}
+	if n == 1 && ttl > 0 {
+		if _, err := rc.client.do(ctx, "PEXPIRE", rc.prefix+key, strconv.FormatInt(ttl.Milliseconds(), 10)); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,94 @@
+package wiki
+
+import (
+	"html/template"
+	"sync"
+)
+
+// templateRegistry holds the parsed templates. Requests read it
+// concurrently while a reload may swap in a whole new set; readers always
+// see either the old set or the new one, never a mix.
+type templateRegistry struct {
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+
+	// sources are the templates as parsed, never executed: html/template
+	// only clones those. The styled sets are cloned from them.
+	sources map[string]*template.Template
+	// styled are the sets of users who chose how dates are shown, by
+	// style, each filled in as its templates are used.
+	styled map[dateStyle]map[string]*template.Template
+}
+
+// maxDateStyles caps the styled sets kept. Past it they are dropped and
+// built again as needed.
+const maxDateStyles = 32
+
+// Lookup returns the template set for a page template name.
+func (reg *templateRegistry) Lookup(name string) (*template.Template, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	tmpl, ok := reg.templates[name]
+	return tmpl, ok
+}
+
+// LookupStyled is Lookup for a date style other than the wiki's, whose
+// template functions are overridden by funcs.
+func (reg *templateRegistry) LookupStyled(name string, style dateStyle, funcs template.FuncMap) (*template.Template, bool) {
+	reg.mu.RLock()
+	tmpl, ok := reg.styled[style][name]
+	reg.mu.RUnlock()
+	if ok {
+		return tmpl, true
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if tmpl, ok := reg.styled[style][name]; ok {
+		return tmpl, true
+	}
+	source, ok := reg.sources[name]
+	if !ok {
+		return nil, false
+	}
+	tmpl, err := source.Clone()
+	if err != nil {
+		// sources are never executed, so this can't happen
+		panic(err)
+	}
+	tmpl.Funcs(funcs)
+
+	if reg.styled[style] == nil {
+		if len(reg.styled) >= maxDateStyles {
+			reg.styled = nil
+		}
+		if reg.styled == nil {
+			reg.styled = make(map[dateStyle]map[string]*template.Template)
+		}
+		reg.styled[style] = make(map[string]*template.Template)
+	}
+	reg.styled[style][name] = tmpl
+	return tmpl, true
+}
+
+// swap replaces all templates at once. The sets given must not have been
+// executed.
+func (reg *templateRegistry) swap(sources map[string]*template.Template) error {
+	templates := make(map[string]*template.Template, len(sources))
+	for name, t := range sources {
+		var err error
+		if templates[name], err = t.Clone(); err != nil {
+			return err
+		}
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.templates = templates
+	reg.sources = sources
+	reg.styled = nil
+	return nil
+}
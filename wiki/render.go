@@ -0,0 +1,238 @@
+package wiki
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var mainTempl = `{{define "main" }} {{ template "base" . }} {{ end }}`
+
+// CleanBasePath normalizes a configured prefix to "/name" form, with no
+// trailing slash, so it can be prepended to any absolute route.
+func CleanBasePath(base string) string {
+	base = strings.Trim(base, "/")
+	if base == "" {
+		return ""
+	}
+	return "/" + base
+}
+
+// pagePath builds the link for an action on a page, e.g. pagePath("edit", "Home")
+// gives "/wiki/edit/Home" when mounted under /wiki.
+func (s *Server) pagePath(action, title string) string {
+	return path.Join("/", s.cfg.BasePath, action, title)
+}
+
+// pageLink is the link template function. Unlike pagePath it refuses
+// titles the wiki would not route, so templates can't produce dead links.
+func (s *Server) pageLink(action, title string) (string, error) {
+	if title != "" {
+		if err := s.titles.check(title); err != nil {
+			return "", err
+		}
+	}
+	return s.pagePath(action, title), nil
+}
+
+// templateFuncs are available to every template of this wiki: the default
+// set, overlaid with Config.Funcs.
+func (s *Server) templateFuncs() template.FuncMap {
+	funcs := template.FuncMap{
+		"link":     s.pageLink,
+		"asset":    s.assetPath,
+		"markdown": s.markdown,
+		"archived": func(p *Page) bool { return s.isArchived(p, time.Now()) },
+
+		"collaborative": func() bool { return s.cfg.Collaboration },
+		"readonly":      s.ReadOnly,
+		"permalink":     s.permalink,
+		"attachment":    s.attachmentPath,
+		"notification":  s.notificationLink,
+		"mentions":      s.mentionsHTML,
+		"user":          s.userPath,
+		"search":        s.searchPath,
+		"tag":           s.tagPath,
+		"theme":         s.pageTheme,
+		"sidebar":       s.pageSidebar,
+		"head":          s.pageHead,
+		"social":        s.socialMeta,
+		"restricted":    func(title string) bool { return s.namespace(title).RequireLogin },
+		"notes":         func(title string) bool { return !s.namespace(title).DisableNotes },
+	}
+	for name, fn := range dateFuncs(s.cfg.Dates.style()) {
+		funcs[name] = fn
+	}
+	for name, fn := range s.cfg.Funcs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// assetPath links to a file in the static directory.
+func (s *Server) assetPath(name string) string {
+	return path.Join("/", s.cfg.BasePath, "static", name)
+}
+
+// loadTemplates builds one template set per page template. Page templates
+// are every *.html below TemplateIncludePath, subdirectories included, and
+// are keyed by their slash-separated path relative to it ("view.html",
+// "admin/users.html").
+//
+// Each set also holds a layout: the *.html files directly in
+// TemplateLayoutPath, followed by those of a named layout in a
+// subdirectory of it when a page template lives in a directory of the same
+// name. So "admin/users.html" is laid out with layouts/*.html plus
+// layouts/admin/*.html, and the latter can redefine any block.
+//
+// The new sets only replace the current ones once all of them parsed, so a
+// broken template leaves the running wiki as it was.
+func (s *Server) loadTemplates() error {
+	templates := make(map[string]*template.Template)
+
+	layoutFiles, err := filepath.Glob(filepath.Join(s.cfg.TemplateLayoutPath, "*.html"))
+	if err != nil {
+		return err
+	}
+
+	includeFiles, err := s.includeFiles()
+	if err != nil {
+		return err
+	}
+
+	mainTemplate := template.New("main").Funcs(s.templateFuncs())
+
+	mainTemplate, err = mainTemplate.Parse(mainTempl)
+
+	if err != nil {
+		return err
+	}
+
+	for name, file := range includeFiles {
+		layout, err := s.namedLayoutFiles(name)
+		if err != nil {
+			return err
+		}
+
+		files := make([]string, 0, len(layoutFiles)+len(layout)+1)
+		files = append(files, layoutFiles...)
+		files = append(files, layout...)
+		files = append(files, file)
+
+		templates[name], err = mainTemplate.Clone()
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := templates[name].ParseFiles(files...); err != nil {
+			return err
+		}
+	}
+	if err := s.templates.swap(templates); err != nil {
+		return err
+	}
+	log.Println("Templates loades successfully")
+
+	return nil
+}
+
+// includeFiles maps template names to the page template files found below
+// TemplateIncludePath, leaving out the layout directory.
+func (s *Server) includeFiles() (map[string]string, error) {
+	root := filepath.Clean(s.cfg.TemplateIncludePath)
+	layouts := filepath.Clean(s.cfg.TemplateLayoutPath)
+	files := make(map[string]string)
+
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if file != root && (file == layouts || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(file) != ".html" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = file
+		return nil
+	})
+
+	return files, err
+}
+
+// namedLayoutFiles returns the files of the layout matching the directory
+// of the page template name, looking from the innermost directory out.
+func (s *Server) namedLayoutFiles(name string) ([]string, error) {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		files, err := filepath.Glob(filepath.Join(s.cfg.TemplateLayoutPath, filepath.FromSlash(dir), "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			return files, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) renderTemplate(ctx context.Context, w http.ResponseWriter, name string, data templateData) error {
+	return s.writeTemplate(ctx, w, http.StatusOK, name, data)
+}
+
+// ErrorPage is the data handed to the error.html template.
+type ErrorPage struct {
+	Common
+	Status    int
+	Message   string
+	RequestID string
+}
+
+// writeTemplate renders name into a pooled buffer and only writes to w once
+// rendering succeeded, so a failure leaves the response untouched for the
+// caller to report. The Common fields of data are filled in first.
+func (s *Server) writeTemplate(ctx context.Context, w http.ResponseWriter, status int, name string, data templateData) error {
+	ctx, end := s.startSpan(ctx, "render "+name)
+	defer end()
+
+	tmpl, ok := s.lookupTemplate(ctx, name)
+
+	if !ok {
+		return fmt.Errorf("the template %s does not exist", name)
+	}
+
+	*data.common() = s.commonData(ctx, w)
+	buf := s.bufpool.Get()
+	defer s.bufpool.Put(buf)
+
+	err := tmpl.Execute(buf, data)
+
+	if err != nil {
+		return err
+	}
+
+	// the client may have gone away while we were rendering
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
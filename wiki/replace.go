@@ -0,0 +1,140 @@
+package wiki
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ReplaceData is the data handed to the admin/replace.html template.
+type ReplaceData struct {
+	Common
+	Find    string
+	Replace string
+	Regex   bool
+
+	// Matches are the pages the replacement would change, with a preview.
+	Matches []*ReplaceMatch
+	// Applied are the pages saved with the replacement.
+	Applied []string
+}
+
+// ReplaceMatch is a page affected by a find-and-replace.
+type ReplaceMatch struct {
+	Title string
+	Count int
+	Diff  []DiffLine
+}
+
+const (
+	maxFindLength      = 1000
+	replaceDiffContext = 2
+)
+
+// replacer parses the find-and-replace form into a function returning the
+// new text and the number of replacements.
+func replacer(r *http.Request) (*ReplaceData, func(string) (string, int), error) {
+	data := &ReplaceData{
+		Find:    r.FormValue("find"),
+		Replace: r.FormValue("replace"),
+		Regex:   r.FormValue("regex") != "",
+	}
+	if data.Find == "" {
+		return data, nil, nil
+	}
+	if len(data.Find) > maxFindLength {
+		return nil, nil, NewError(http.StatusBadRequest, "The search text is too long.")
+	}
+
+	if !data.Regex {
+		return data, func(text string) (string, int) {
+			n := strings.Count(text, data.Find)
+			return strings.ReplaceAll(text, data.Find, data.Replace), n
+		}, nil
+	}
+
+	re, err := regexp.Compile(data.Find)
+	if err != nil {
+		return nil, nil, NewError(http.StatusBadRequest, "The regular expression is not valid: "+err.Error()+".")
+	}
+	return data, func(text string) (string, int) {
+		n := len(re.FindAllStringIndex(text, -1))
+		return re.ReplaceAllString(text, data.Replace), n
+	}, nil
+}
+
+// replaceHandler previews a find-and-replace across every page, including
+// unpublished ones, showing what would change.
+func (s *Server) replaceHandler(w http.ResponseWriter, r *http.Request) error {
+	data, replace, err := replacer(r)
+	if err != nil {
+		return err
+	}
+	if replace == nil {
+		return s.renderTemplate(r.Context(), w, "admin/replace.html", data)
+	}
+
+	pages, err := s.store.List(r.Context())
+	if err != nil {
+		return err
+	}
+	for _, meta := range pages {
+		p, err := s.loadPage(r.Context(), meta.Title)
+		if err != nil {
+			return err
+		}
+		text := string(p.Body)
+		changed, n := replace(text)
+		if n == 0 || changed == text {
+			continue
+		}
+		data.Matches = append(data.Matches, &ReplaceMatch{
+			Title: p.Title,
+			Count: n,
+			Diff:  contextDiff(diffLines(text, changed), replaceDiffContext),
+		})
+	}
+
+	return s.renderTemplate(r.Context(), w, "admin/replace.html", data)
+}
+
+// applyReplaceHandler saves the replacement on the pages ticked in the
+// preview, each as a new revision by the admin. The text is replaced again
+// in the current revision, so edits made since the preview are kept.
+func (s *Server) applyReplaceHandler(w http.ResponseWriter, r *http.Request) error {
+	data, replace, err := replacer(r)
+	if err != nil {
+		return err
+	}
+	if replace == nil {
+		return NewError(http.StatusBadRequest, "Nothing to search for.")
+	}
+
+	for _, title := range r.Form["title"] {
+		if err := s.titles.check(title); err != nil {
+			return NewError(http.StatusBadRequest, "Invalid Page Title")
+		}
+		p, err := s.loadPage(r.Context(), title)
+		if err != nil {
+			return err
+		}
+		changed, n := replace(string(p.Body))
+		if n == 0 {
+			continue
+		}
+
+		p.Body = []byte(changed)
+		p.Author = UserFrom(r.Context())
+		if err := s.hooks.pageSaving(r.Context(), p); err != nil {
+			return err
+		}
+		if err := s.savePage(r.Context(), p); err != nil {
+			return err
+		}
+		s.publish(r.Context(), &Event{Kind: EventPageSaved, Title: p.Title, Revision: p.Revision, Author: p.Author})
+		data.Applied = append(data.Applied, title)
+	}
+	logf(r.Context(), "replaced %q in %d pages", data.Find, len(data.Applied))
+
+	return s.renderTemplate(r.Context(), w, "admin/replace.html", data)
+}
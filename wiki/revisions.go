@@ -0,0 +1,236 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RevisionStore is implemented by storage that keeps the earlier revisions
+// of pages, which are then part of their exports.
+type RevisionStore interface {
+	// Revisions lists the kept revisions of a page, oldest first, without
+	// their bodies.
+	Revisions(ctx context.Context, title string) ([]*Page, error)
+	// LoadRevision returns a kept revision of a page, or an error matching
+	// fs.ErrNotExist.
+	LoadRevision(ctx context.Context, title string, revision int) (*Page, error)
+	// ImportRevisions saves p as Save does, after keeping earlier, oldest
+	// first, as the revisions before it, with their authors and times. It
+	// is how imports bring the history of a page along.
+	ImportRevisions(ctx context.Context, p *Page, earlier []*Page) error
+}
+
+// FileStorage keeps the revisions of a page in .revisions/<title>.revs/,
+// as the lines that changed from the revision before. Every
+// revisionSnapshotEvery revisions start a new file with the whole text, so
+// loading one applies at most revisionSnapshotEvery-1 deltas to it,
+// however long the history. Pages saved before revisions were kept start
+// theirs with the next save.
+
+// revisionSnapshotEvery is how many revisions share a file.
+const revisionSnapshotEvery = 16
+
+// storedRevision is a revision in its file: the first of a file has the
+// whole Body, the others the Delta from the one before.
+type storedRevision struct {
+	pageMeta
+	Body  *string     `json:"body,omitempty"`
+	Delta []deltaStep `json:"delta,omitempty"`
+}
+
+// deltaStep is a step of a delta from the lines of one text to those of
+// the next: Keep lines are copied, Drop lines skipped, and the Add lines
+// written.
+type deltaStep struct {
+	Keep int      `json:"keep,omitempty"`
+	Drop int      `json:"drop,omitempty"`
+	Add  []string `json:"add,omitempty"`
+}
+
+// makeDelta returns the steps from a to b, which it splits in lines as
+// diffLines does, so that joining them again is exact.
+func makeDelta(a, b string) []deltaStep {
+	var steps []deltaStep
+	for _, l := range diffLines(a, b) {
+		var last *deltaStep
+		if n := len(steps); n > 0 {
+			last = &steps[n-1]
+		}
+		switch l.Kind {
+		case "same":
+			if last != nil && last.Keep > 0 {
+				last.Keep++
+			} else {
+				steps = append(steps, deltaStep{Keep: 1})
+			}
+		case "del":
+			if last != nil && last.Drop > 0 {
+				last.Drop++
+			} else {
+				steps = append(steps, deltaStep{Drop: 1})
+			}
+		case "add":
+			if last != nil && last.Add != nil {
+				last.Add = append(last.Add, l.Text)
+			} else {
+				steps = append(steps, deltaStep{Add: []string{l.Text}})
+			}
+		}
+	}
+	return steps
+}
+
+// applyDelta writes the text the steps make of a.
+func applyDelta(a string, steps []deltaStep) (string, error) {
+	lines := strings.Split(a, "\n")
+	var out []string
+	i := 0
+	for _, st := range steps {
+		if i+st.Keep+st.Drop > len(lines) {
+			return "", errors.New("the delta doesn't match the revision before")
+		}
+		out = append(out, lines[i:i+st.Keep]...)
+		i += st.Keep + st.Drop
+		out = append(out, st.Add...)
+	}
+	if i != len(lines) {
+		return "", errors.New("the delta doesn't match the revision before")
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func (st *FileStorage) revisionDir(title string) string {
+	return filepath.Join(st.dir, ".revisions", filepath.FromSlash(title)+".revs")
+}
+
+// revisionFile is the file holding a revision, named after the first
+// revision it may hold.
+func (st *FileStorage) revisionFile(title string, revision int) string {
+	first := revision - (revision-1)%revisionSnapshotEvery
+	return filepath.Join(st.revisionDir(title), strconv.Itoa(first)+".json")
+}
+
+func (st *FileStorage) loadRevisionFile(name string) ([]*storedRevision, error) {
+	var revs []*storedRevision
+	return revs, readJSONFile(name, &revs)
+}
+
+// keepRevision adds the revision meta of a page, with body, to its
+// history; before is the body of the revision it replaces. Save calls it
+// with st.mu held.
+func (st *FileStorage) keepRevision(title string, meta *pageMeta, before, body []byte) error {
+	if meta.Revision < 1 {
+		return nil
+	}
+	name := st.revisionFile(title, meta.Revision)
+	revs, err := st.loadRevisionFile(name)
+	if err != nil {
+		return err
+	}
+	rev := &storedRevision{pageMeta: *meta}
+	rev.Head = nil
+	if n := len(revs); n > 0 && revs[n-1].Revision == meta.Revision-1 {
+		rev.Delta = makeDelta(string(before), string(body))
+	} else {
+		// a new file, or a gap left by a save that failed halfway
+		text := string(body)
+		revs, rev.Body = nil, &text
+	}
+	return writeJSONFile(name, append(revs, rev))
+}
+
+// Revisions implements RevisionStore.
+func (st *FileStorage) Revisions(ctx context.Context, title string) ([]*Page, error) {
+	files, err := os.ReadDir(st.revisionDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pages []*Page
+	for _, f := range files {
+		if _, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".json")); err != nil {
+			continue
+		}
+		revs, err := st.loadRevisionFile(filepath.Join(st.revisionDir(title), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, rev := range revs {
+			p := &Page{Title: title}
+			rev.apply(p)
+			pages = append(pages, p)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Revision < pages[j].Revision })
+	return pages, nil
+}
+
+// LoadRevision implements RevisionStore.
+func (st *FileStorage) LoadRevision(ctx context.Context, title string, revision int) (*Page, error) {
+	if revision < 1 {
+		return nil, fs.ErrNotExist
+	}
+	name := st.revisionFile(title, revision)
+	revs, err := st.loadRevisionFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var body string
+	for i, rev := range revs {
+		switch {
+		case rev.Body != nil:
+			body = *rev.Body
+		case i == 0:
+			return nil, fmt.Errorf("%s: no revision to apply the deltas to", name)
+		default:
+			if body, err = applyDelta(body, rev.Delta); err != nil {
+				return nil, fmt.Errorf("%s: revision %d: %w", name, rev.Revision, err)
+			}
+		}
+		if rev.Revision == revision {
+			p := &Page{Title: title, Body: []byte(body)}
+			rev.apply(p)
+			return p, nil
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// reattributeRevisions credits the revisions of a page by from to to.
+func (st *FileStorage) reattributeRevisions(title, from, to string) error {
+	files, err := os.ReadDir(st.revisionDir(title))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := filepath.Join(st.revisionDir(title), f.Name())
+		revs, err := st.loadRevisionFile(name)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, rev := range revs {
+			if rev.Author == from {
+				rev.Author, changed = to, true
+			}
+		}
+		if changed {
+			if err := writeJSONFile(name, revs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package wiki_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+// revisionBody is the text of revision n of the page the revision tests
+// save: growing, shrinking and rewriting lines, with and without a final
+// newline.
+func revisionBody(n int) string {
+	var lines []string
+	for i := 0; i < n%7+1; i++ {
+		lines = append(lines, fmt.Sprintf("line %d of revision %d", i, n/3))
+	}
+	switch n % 5 {
+	case 0:
+		return strings.Join(lines, "\n") + "\n"
+	case 1:
+		return strings.Join(lines, "\n")
+	case 2:
+		return strings.Join(lines, "\r\n") + "\r\n\r\n"
+	case 3:
+		return "# Heading\n\n" + strings.Join(lines, "\n\n") + "\nünïcödé ✓"
+	default:
+		return strings.Repeat("x", n*50)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	dir := t.TempDir()
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Storage = wiki.NewFileStorage(dir)
+	})
+	ctx := w.Context(&wiki.User{Name: "ed", Role: wiki.RoleEditor})
+	const last = 40
+	for n := 1; n <= last; n++ {
+		op := &wiki.BatchOperation{Op: "update", Title: "Notes", Body: revisionBody(n)}
+		if n == 1 {
+			op.Op = "create"
+		}
+		rev, err := w.Server.ChangePage(ctx, op)
+		if err != nil || rev != n {
+			t.Fatalf("saving revision %d: %d, %v", n, rev, err)
+		}
+	}
+
+	check := func(s *wiki.Server) {
+		t.Helper()
+		revs, err := s.PageRevisions(ctx, "Notes")
+		if err != nil || len(revs) != last {
+			t.Fatalf("PageRevisions: %d revisions, %v; want %d", len(revs), err, last)
+		}
+		for n := 1; n <= last; n++ {
+			p, err := s.PageRevision(ctx, "Notes", n)
+			if err != nil {
+				t.Fatalf("revision %d: %v", n, err)
+			}
+			if p.Revision != n || string(p.Body) != revisionBody(n) {
+				t.Errorf("revision %d is %d: %q, want %q", n, p.Revision, p.Body, revisionBody(n))
+			}
+		}
+		for _, n := range []int{0, last + 1} {
+			if _, err := s.PageRevision(ctx, "Notes", n); err == nil {
+				t.Errorf("revision %d was found", n)
+			}
+		}
+	}
+	check(w.Server)
+
+	// a file every 16 revisions, starting with it in full
+	files, _ := filepath.Glob(filepath.Join(dir, ".revisions", "Notes.revs", "*.json"))
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	if strings.Join(files, " ") != "1.json 17.json 33.json" {
+		t.Errorf("revision files are %v, want 1, 17 and 33", files)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".revisions", "Notes.revs", "17.json"))
+	if err != nil || !strings.Contains(string(data), "line 0 of revision 5") {
+		t.Errorf("17.json doesn't hold revision 17 whole: %s, %v", data, err)
+	}
+
+	// read back from the files alone
+	restarted := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Storage = wiki.NewFileStorage(dir)
+	})
+	check(restarted.Server)
+}
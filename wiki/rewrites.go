@@ -0,0 +1,116 @@
+package wiki
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Rewrite rules keep the links into a wiki migrated from another system
+// working: a request whose address matches a rule is sent to the page the
+// rule names, before it is routed. For a MediaWiki, with its spaces as
+// underscores:
+//
+//	{"from": "^/index\\.php\\?title=([^&]+)", "to": "$1", "replace": {"_": " "}}
+//	{"from": "^/wiki/(.+)", "to": "$1", "replace": {"_": " "}}
+
+// RewriteConfig is a rewrite rule.
+type RewriteConfig struct {
+	// From is a regular expression matched against the path of the
+	// request, below BasePath, followed by "?" and the query if there is
+	// one.
+	From string `json:"from"`
+	// To is the title, with $1 or ${name} for what From captured. The
+	// title is unescaped, so captures from the query can be used as is.
+	To string `json:"to"`
+	// Replace replaces strings in the title, e.g. ":" by "/" for the
+	// namespaces of a DokuWiki.
+	Replace map[string]string `json:"replace"`
+	// Status is that of the redirect, 301 by default. Rewrite instead
+	// shows the page at the old address.
+	Status  int  `json:"status"`
+	Rewrite bool `json:"rewrite"`
+}
+
+type rewriteRule struct {
+	cfg      RewriteConfig
+	from     *regexp.Regexp
+	replacer *strings.Replacer
+}
+
+func newRewriteRule(c RewriteConfig) (*rewriteRule, error) {
+	from, err := regexp.Compile(c.From)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite %q: %v", c.From, err)
+	}
+	switch c.Status {
+	case 0:
+		c.Status = http.StatusMovedPermanently
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("rewrite %q: %d is not a redirect status", c.From, c.Status)
+	}
+	rule := &rewriteRule{cfg: c, from: from}
+	if len(c.Replace) > 0 {
+		var pairs []string
+		for old, repl := range c.Replace {
+			pairs = append(pairs, old, repl)
+		}
+		rule.replacer = strings.NewReplacer(pairs...)
+	}
+	return rule, nil
+}
+
+// title returns the title address maps to, or "" if the rule doesn't
+// match it.
+func (rule *rewriteRule) title(address string) string {
+	m := rule.from.FindStringSubmatchIndex(address)
+	if m == nil {
+		return ""
+	}
+	title := string(rule.from.ExpandString(nil, rule.cfg.To, address, m))
+	if t, err := url.QueryUnescape(title); err == nil {
+		title = t
+	}
+	if rule.replacer != nil {
+		title = rule.replacer.Replace(title)
+	}
+	return strings.TrimSpace(title)
+}
+
+// rewriteLegacy applies the first rewrite rule matching a request to it,
+// unless the title it gives is not one the wiki would have. Rules apply
+// before routing, so they can also catch addresses the wiki has itself.
+func (s *Server) rewriteLegacy(next http.Handler) http.Handler {
+	if len(s.rewrites) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		address := strings.TrimPrefix(r.URL.Path, s.cfg.BasePath)
+		if r.URL.RawQuery != "" {
+			address += "?" + r.URL.RawQuery
+		}
+		for _, rule := range s.rewrites {
+			title := rule.title(address)
+			if title == "" || s.titles.check(title) != nil {
+				continue
+			}
+			target := &url.URL{Path: s.pagePath("view", title)}
+			if target.Path == r.URL.Path {
+				// already there, e.g. after a rule turning /view/A_B into /view/A B
+				break
+			}
+			if !rule.cfg.Rewrite {
+				http.Redirect(w, r, target.EscapedPath(), rule.cfg.Status)
+				return
+			}
+			r2 := r.Clone(r.Context())
+			r2.URL.Path, r2.URL.RawPath, r2.URL.RawQuery = target.Path, "", ""
+			next.ServeHTTP(w, r2)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
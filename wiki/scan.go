@@ -0,0 +1,137 @@
+package wiki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Upload is a file sent to a page, as seen by upload hooks.
+type Upload struct {
+	Title string
+	Name  string
+	Size  int64
+	// Content is rewound for each hook.
+	Content io.ReadSeeker
+}
+
+// UploadHook checks a file before it is stored. Returning an error rejects
+// the upload; an *Error is shown to the user as is, anything else as a
+// failure to scan, so a scanner that is down doesn't let files through.
+type UploadHook func(ctx context.Context, u *Upload) error
+
+// errMalware rejects files a scanner found something in.
+func errMalware(what string) error {
+	return NewError(http.StatusUnprocessableEntity, "The file was rejected by the virus scanner: "+what+".")
+}
+
+// uploading runs the configured scanners and the registered upload hooks.
+func (s *Server) uploading(ctx context.Context, u *Upload) error {
+	for _, fn := range append(append([]UploadHook(nil), s.scanners...), s.hooks.upload...) {
+		if _, err := u.Content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		err := fn(ctx, u)
+		if err == nil {
+			continue
+		}
+		var e *Error
+		if errors.As(err, &e) {
+			logf(ctx, "upload of %s to %s rejected: %s", u.Name, u.Title, e.Message)
+			return err
+		}
+		return &Error{Status: http.StatusServiceUnavailable, Message: "The file could not be checked; try again later.", Err: err}
+	}
+	return nil
+}
+
+const (
+	clamTimeout   = 30 * time.Second
+	clamChunkSize = 32 << 10
+)
+
+// ClamAV scans uploads with a clamd daemon, using its INSTREAM command.
+// network is "unix" or "tcp".
+func ClamAV(network, addr string) UploadHook {
+	return func(ctx context.Context, u *Upload) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return fmt.Errorf("clamav: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(clamTimeout))
+
+		w := bufio.NewWriter(conn)
+		w.WriteString("zINSTREAM\x00")
+		buf := make([]byte, clamChunkSize)
+		for {
+			n, err := u.Content.Read(buf)
+			if n > 0 {
+				binary.Write(w, binary.BigEndian, uint32(n))
+				w.Write(buf[:n])
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		binary.Write(w, binary.BigEndian, uint32(0))
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("clamav: %v", err)
+		}
+
+		reply, err := bufio.NewReader(conn).ReadString(0)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("clamav: %v", err)
+		}
+		// "stream: OK" or "stream: Eicar-Signature FOUND"
+		reply = strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), "\x00")
+		switch {
+		case reply == "OK":
+			return nil
+		case strings.HasSuffix(reply, " FOUND"):
+			return errMalware(strings.TrimSuffix(reply, " FOUND"))
+		}
+		return fmt.Errorf("clamav: %s", reply)
+	}
+}
+
+// ScanCommand runs a command with each upload on stdin. Exit status 1
+// rejects the file, with the first line of its output as the reason; any
+// other failure counts as not being able to scan.
+func ScanCommand(name string, args ...string) UploadHook {
+	return func(ctx context.Context, u *Upload) error {
+		ctx, cancel := context.WithTimeout(ctx, clamTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdin = u.Content
+		cmd.Env = append(cmd.Environ(), "WIKI_PAGE="+u.Title, "WIKI_FILE="+u.Name)
+		out, err := cmd.Output()
+
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 1 {
+			reason, _, _ := strings.Cut(string(bytes.TrimSpace(out)), "\n")
+			if reason == "" {
+				reason = "rejected by " + name
+			}
+			return errMalware(reason)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package wiki
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// scheduleCheckInterval is how often schedules look whether they are due.
+const scheduleCheckInterval = time.Hour
+
+// parseInterval reads the "daily" (the default) or "weekly" settings of
+// periodic work.
+func parseInterval(what, value string) (time.Duration, error) {
+	switch value {
+	case "", "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("%s interval %q: want daily or weekly", what, value)
+}
+
+// lastRunPath is where a schedule keeps the time it last ran, so restarts
+// neither skip nor repeat a run. It lives next to the jobs, with an
+// extension the queue doesn't pick up.
+func (s *Server) lastRunPath(name string) string {
+	if s.cfg.JobDir == "" {
+		return ""
+	}
+	return filepath.Join(s.cfg.JobDir, name+".last")
+}
+
+// lastRun returns when the named schedule last ran, or now if it never did.
+func (s *Server) lastRun(name string) time.Time {
+	path := s.lastRunPath(name)
+	if path == "" {
+		return time.Now()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("%s: %v", name, err)
+		}
+		return time.Now()
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Printf("%s: %s: %v", name, path, err)
+		return time.Now()
+	}
+	return t
+}
+
+func (s *Server) setLastRun(name string, t time.Time) error {
+	path := s.lastRunPath(name)
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(t.UTC().Format(time.RFC3339)+"\n"), 0600)
+}
+
+// schedule calls enqueue every interval until the server is closed, with
+// the time of the previous run. enqueue should only hand work to the job
+// queue, which takes care of retrying it.
+func (s *Server) schedule(name string, interval time.Duration, enqueue func(last, now time.Time) error) {
+	last := s.lastRun(name)
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if now := time.Now(); now.Sub(last) >= interval {
+			if err := enqueue(last, now); err != nil {
+				log.Printf("%s: %v", name, err)
+			} else {
+				last = now
+				if err := s.setLastRun(name, last); err != nil {
+					log.Printf("%s: %v", name, err)
+				}
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
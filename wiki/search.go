@@ -0,0 +1,487 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SearchQuery is what to look for: pages containing every word of Text
+// and carrying every one of Tags, in the order of Sort.
+type SearchQuery struct {
+	Text string   `json:"text,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+	// Sort is SortRelevance (the default), SortRecent or SortTitle.
+	Sort string `json:"sort,omitempty"`
+}
+
+const (
+	SortRelevance = "relevance"
+	SortRecent    = "recent"
+	SortTitle     = "title"
+)
+
+// SearchConfig weighs where the words of a search are found. A word in the
+// body counts once per occurrence.
+type SearchConfig struct {
+	// TitleBoost is added for a word in the title, 10 by default.
+	TitleBoost int `json:"title_boost"`
+	// TagBoost is added for a word that is one of the page's tags, 5 by
+	// default.
+	TagBoost int `json:"tag_boost"`
+}
+
+func (c SearchConfig) boosts() (title, tag int) {
+	title, tag = c.TitleBoost, c.TagBoost
+	if title == 0 {
+		title = 10
+	}
+	if tag == 0 {
+		tag = 5
+	}
+	return title, tag
+}
+
+func (q SearchQuery) empty() bool {
+	return strings.TrimSpace(q.Text) == "" && len(q.Tags) == 0
+}
+
+// searchQuery reads the q, tag and sort parameters of a request. Tags may
+// be given as several tag parameters or comma-separated.
+func searchQuery(r *http.Request) (SearchQuery, error) {
+	text := r.FormValue("q") // parses the form for r.Form too
+	q := SearchQuery{Text: text, Tags: parseTags(strings.Join(r.Form["tag"], ",")), Sort: r.FormValue("sort")}
+	switch q.Sort {
+	case "", SortRelevance, SortRecent, SortTitle:
+	default:
+		return q, NewError(http.StatusBadRequest, "Results can be sorted by relevance, recent or title.")
+	}
+	if q.Sort == SortRelevance {
+		q.Sort = ""
+	}
+	return q, nil
+}
+
+// values encodes the query the way searchQuery reads it.
+func (q SearchQuery) values() url.Values {
+	v := url.Values{}
+	if q.Text != "" {
+		v.Set("q", q.Text)
+	}
+	for _, t := range q.Tags {
+		v.Add("tag", t)
+	}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	return v
+}
+
+// SearchResult is a page matching a query.
+type SearchResult struct {
+	*Page
+	Score int
+	// Snippet is the passage around the first word found, the words
+	// marked. It is only filled in for the results shown.
+	Snippet template.HTML
+}
+
+// searchIndex holds the words of every page, so searches don't read them
+// all from storage. It is built on the first search and kept current by
+// the page.saved and page.deleted jobs.
+type searchIndex struct {
+	mu    sync.RWMutex
+	built bool
+	docs  map[string]*indexedPage
+	// stale are the pages changed while the index was being built, which
+	// it may have read before the change
+	stale map[string]bool
+}
+
+type indexedPage struct {
+	page  *Page // without its body
+	size  int64 // of the body
+	terms map[string]int
+	links []string // the titles of the pages it links to
+}
+
+// terms splits text into lower-case words.
+func terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func (s *Server) indexPage(p *Page) *indexedPage {
+	doc := &indexedPage{size: int64(len(p.Body)), terms: make(map[string]int), links: s.linkedTitles(p.Body)}
+	for _, t := range terms(string(p.Body)) {
+		doc.terms[t]++
+	}
+	meta := *p
+	meta.Body = nil
+	doc.page = &meta
+	return doc
+}
+
+// ensureIndex builds the index unless it already is.
+func (s *Server) ensureIndex(ctx context.Context) error {
+	s.index.mu.RLock()
+	built := s.index.built
+	s.index.mu.RUnlock()
+	if built {
+		return nil
+	}
+
+	all, err := s.allPages(ctx)
+	if err != nil {
+		return err
+	}
+	docs := make(map[string]*indexedPage, len(all))
+	for _, meta := range all {
+		p, err := s.loadPage(ctx, meta.Title)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		docs[p.Title] = s.indexPage(p)
+	}
+
+	s.index.mu.Lock()
+	if s.index.built {
+		s.index.mu.Unlock()
+		return nil
+	}
+	s.index.docs = docs
+	s.index.built = true
+	stale := s.index.stale
+	s.index.stale = nil
+	s.index.mu.Unlock()
+
+	for title := range stale {
+		if err := s.refreshIndex(ctx, title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markStale reports whether the index is built, remembering title for the
+// build in progress otherwise.
+func (s *Server) markStale(title string) bool {
+	s.index.mu.Lock()
+	defer s.index.mu.Unlock()
+
+	if s.index.built {
+		return true
+	}
+	if s.index.stale == nil {
+		s.index.stale = make(map[string]bool)
+	}
+	s.index.stale[title] = true
+	return false
+}
+
+// refreshIndex reads a page into the index again, or drops it when it no
+// longer exists.
+func (s *Server) refreshIndex(ctx context.Context, title string) error {
+	p, err := s.loadPage(ctx, title)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.index.mu.Lock()
+		delete(s.index.docs, title)
+		s.index.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	doc := s.indexPage(p)
+
+	s.index.mu.Lock()
+	s.index.docs[title] = doc
+	s.index.mu.Unlock()
+	return nil
+}
+
+// reindexPage keeps the index current as pages are saved, deleted and
+// renamed. An index not built yet reads the pages when it is.
+func (s *Server) reindexPage(ctx context.Context, ev *Event) error {
+	for _, title := range ev.titles() {
+		if !s.markStale(title) {
+			continue
+		}
+		if err := s.refreshIndex(ctx, title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// search returns the published pages matching q, sorted as it asks. A
+// page scores the number of times the words occur in it, boosted by
+// SearchConfig for those in its title or tags.
+func (s *Server) search(ctx context.Context, q SearchQuery) ([]*SearchResult, error) {
+	if err := s.ensureIndex(ctx); err != nil {
+		return nil, err
+	}
+	words := terms(q.Text)
+	titleBoost, tagBoost := s.cfg.Search.boosts()
+
+	s.index.mu.RLock()
+	defer s.index.mu.RUnlock()
+
+	now := time.Now()
+	var results []*SearchResult
+	for _, doc := range s.index.docs {
+		if !doc.page.Published(now) || !hasTags(doc.page.Tags, q.Tags) || !s.canRead(ctx, doc.page.Title) {
+			continue
+		}
+		score, title := 0, terms(doc.page.Title)
+		for _, w := range words {
+			n := doc.terms[w]
+			if slices.Contains(title, w) {
+				n += titleBoost
+			}
+			if slices.Contains(doc.page.Tags, w) {
+				n += tagBoost
+			}
+			if n == 0 {
+				score = -1
+				break
+			}
+			score += n
+		}
+		if score < 0 {
+			continue
+		}
+		results = append(results, &SearchResult{Page: doc.page, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch {
+		case q.Sort == SortRecent && !a.UpdatedAt.Equal(b.UpdatedAt):
+			return a.UpdatedAt.After(b.UpdatedAt)
+		case q.Sort == "" && a.Score != b.Score:
+			return a.Score > b.Score
+		}
+		return a.Title < b.Title
+	})
+	return results, nil
+}
+
+// snippetLength is about how much text a snippet shows.
+const snippetLength = 200
+
+// addSnippets fills in the snippets of results for the words of text.
+// Pages that can't be read keep none.
+func (s *Server) addSnippets(ctx context.Context, results []*SearchResult, text string) {
+	words := terms(text)
+	for _, res := range results {
+		p, err := s.loadPage(ctx, res.Title)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				logf(ctx, "loading %s for a snippet: %v", res.Title, err)
+			}
+			continue
+		}
+		res.Snippet = snippet(string(p.Body), words, snippetLength)
+	}
+}
+
+// snippet cuts about length bytes of body around the first of words found,
+// or from the start when none is, and marks the words in it.
+func snippet(body string, words []string, length int) template.HTML {
+	lower := strings.ToLower(body)
+	start := 0
+	for _, w := range words {
+		if i := wordIndex(lower, w); i >= 0 && (start == 0 || i < start) {
+			start = i
+		}
+	}
+	start = max(0, start-length/4)
+	end := min(len(body), start+length)
+	// don't cut words, or runes
+	for start > 0 && !unicode.IsSpace(rune(body[start-1])) {
+		start--
+	}
+	for end < len(body) && !unicode.IsSpace(rune(body[end])) {
+		end++
+	}
+	text := strings.Join(strings.Fields(body[start:end]), " ")
+
+	var out strings.Builder
+	if start > 0 {
+		out.WriteString("… ")
+	}
+	for i := 0; i < len(text); {
+		j := strings.IndexFunc(text[i:], func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) })
+		if j < 0 {
+			out.WriteString(html.EscapeString(text[i:]))
+			break
+		}
+		out.WriteString(html.EscapeString(text[i : i+j]))
+		i += j
+		k := strings.IndexFunc(text[i:], func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+		if k < 0 {
+			k = len(text) - i
+		}
+		word := text[i : i+k]
+		if slices.Contains(words, strings.ToLower(word)) {
+			out.WriteString("<mark>" + html.EscapeString(word) + "</mark>")
+		} else {
+			out.WriteString(html.EscapeString(word))
+		}
+		i += k
+	}
+	if end < len(body) {
+		out.WriteString(" …")
+	}
+	return template.HTML(out.String())
+}
+
+// wordIndex finds w in s as a whole word.
+func wordIndex(s, w string) int {
+	for from := 0; ; {
+		i := strings.Index(s[from:], w)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[i+len(w):])
+		if !isWordRune(before) && !isWordRune(after) {
+			return i
+		}
+		from = i + len(w)
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsNumber(r))
+}
+
+func hasTags(tags, want []string) bool {
+	for _, t := range want {
+		if !slices.Contains(tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// maxSearchResults is how many results are shown, with snippets.
+const maxSearchResults = 50
+
+// SearchData is the data handed to the search.html template.
+type SearchData struct {
+	Common
+	Query   SearchQuery
+	Results []*SearchResult
+	// Total counts the results, of which only maxSearchResults are shown.
+	Total int
+	// SignedIn offers to save the search.
+	SignedIn bool
+}
+
+func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) error {
+	q, err := searchQuery(r)
+	if err != nil {
+		return err
+	}
+	data := &SearchData{Query: q, SignedIn: UserFrom(r.Context()) != ""}
+	if !q.empty() {
+		if data.Results, err = s.search(r.Context(), q); err != nil {
+			return err
+		}
+		data.Total = len(data.Results)
+		if len(data.Results) > maxSearchResults {
+			data.Results = data.Results[:maxSearchResults]
+		}
+		s.addSnippets(r.Context(), data.Results, q.Text)
+	}
+	return s.renderTemplate(r.Context(), w, "search.html", data)
+}
+
+// apiSearchResult is a search result in the JSON API.
+type apiSearchResult struct {
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	Score     int       `json:"score"`
+	Tags      []string  `json:"tags,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Snippet is HTML, the words in <mark> elements.
+	Snippet string `json:"snippet"`
+}
+
+// apiSearchHandler answers GET /api/v1/search, which takes the parameters
+// of /search plus limit, up to maxSearchResults.
+func (s *Server) apiSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	q, err := searchQuery(r)
+	if err != nil {
+		return err
+	}
+	if q.empty() {
+		return NewError(http.StatusBadRequest, "Give words to search for, tags or both.")
+	}
+	limit := maxSearchResults
+	if v := r.FormValue("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxSearchResults {
+			return NewError(http.StatusBadRequest, fmt.Sprintf("The limit is a number from 1 to %d.", maxSearchResults))
+		}
+	}
+
+	results, err := s.search(r.Context(), q)
+	if err != nil {
+		return err
+	}
+	total := len(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	s.addSnippets(r.Context(), results, q.Text)
+
+	resp := struct {
+		Total   int                `json:"total"`
+		Results []*apiSearchResult `json:"results"`
+	}{Total: total, Results: make([]*apiSearchResult, len(results))}
+	for i, res := range results {
+		resp.Results[i] = &apiSearchResult{
+			Title:     res.Title,
+			URL:       s.absoluteURL(s.pagePath("view", res.Title)),
+			Score:     res.Score,
+			Tags:      res.Tags,
+			UpdatedAt: res.UpdatedAt,
+			Snippet:   string(res.Snippet),
+		}
+	}
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+// searchPath links to the results of q.
+func (s *Server) searchPath(q SearchQuery) string {
+	link := s.pagePath("search", "")
+	if v := q.values(); len(v) > 0 {
+		link += "?" + v.Encode()
+	}
+	return link
+}
+
+// tagPath links to the pages carrying a tag.
+func (s *Server) tagPath(tag string) string {
+	return s.searchPath(SearchQuery{Tags: []string{tag}})
+}
@@ -0,0 +1,157 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SavedSearch is a query a user kept under a name. Pinned searches are
+// listed on the user's home page, run again on every view.
+type SavedSearch struct {
+	Name   string      `json:"name"`
+	Query  SearchQuery `json:"query"`
+	Pinned bool        `json:"pinned,omitempty"`
+}
+
+const (
+	maxSavedSearches = 50
+	// maxPinnedResults is how many pages a pinned search lists on the home
+	// page; the rest are a click away.
+	maxPinnedResults = 10
+)
+
+// PinnedSearch is a pinned search with its current results.
+type PinnedSearch struct {
+	*SavedSearch
+	Results []*SearchResult
+	// More is set when there are results beyond those listed.
+	More bool
+}
+
+// pinnedSearches runs the pinned searches of the user, leaving out archived
+// pages as the home page does.
+func (s *Server) pinnedSearches(ctx context.Context, user string) ([]*PinnedSearch, error) {
+	d, err := s.userData.UserData(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var pinned []*PinnedSearch
+	for _, saved := range d.SavedSearches {
+		if !saved.Pinned {
+			continue
+		}
+		results, err := s.search(ctx, saved.Query)
+		if err != nil {
+			return nil, err
+		}
+		results = slices.DeleteFunc(results, func(r *SearchResult) bool { return s.isArchived(r.Page, now) })
+		ps := &PinnedSearch{SavedSearch: saved, Results: results}
+		if len(results) > maxPinnedResults {
+			ps.Results, ps.More = results[:maxPinnedResults], true
+		}
+		pinned = append(pinned, ps)
+	}
+	return pinned, nil
+}
+
+// SavedSearchesData is the data handed to the searches.html template.
+type SavedSearchesData struct {
+	Common
+	Searches []*SavedSearch
+}
+
+func (s *Server) savedSearchesHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to save searches.")
+	}
+	d, err := s.userData.UserData(r.Context(), user)
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(r.Context(), w, "searches.html", &SavedSearchesData{Searches: d.SavedSearches})
+}
+
+// saveSearchHandler keeps the query of the form under its name, replacing
+// a saved search of the same name.
+func (s *Server) saveSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to save searches.")
+	}
+	q, err := searchQuery(r)
+	if err != nil {
+		return err
+	}
+	saved := &SavedSearch{
+		Name:   strings.TrimSpace(r.PostFormValue("name")),
+		Query:  q,
+		Pinned: r.PostFormValue("pin") != "",
+	}
+	if saved.Name == "" {
+		return NewError(http.StatusBadRequest, "Give the search a name.")
+	}
+	if saved.Query.empty() {
+		return NewError(http.StatusBadRequest, "There is nothing to search for.")
+	}
+
+	err = s.updateUserData(r.Context(), user, func(d *UserData) error {
+		i := slices.IndexFunc(d.SavedSearches, func(ss *SavedSearch) bool { return ss.Name == saved.Name })
+		if i >= 0 {
+			d.SavedSearches[i] = saved
+			return nil
+		}
+		if len(d.SavedSearches) >= maxSavedSearches {
+			return NewError(http.StatusBadRequest, "You have too many saved searches; delete some first.")
+		}
+		d.SavedSearches = append(d.SavedSearches, saved)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.flash(w, r, FlashInfo, "The search was saved.")
+	http.Redirect(w, r, s.pagePath("searches", ""), http.StatusSeeOther)
+	return nil
+}
+
+// pinSearchHandler pins a saved search to the home page, or unpins it with
+// pin=0.
+func (s *Server) pinSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.changeSavedSearch(w, r, func(d *UserData, i int) {
+		d.SavedSearches[i].Pinned = r.PostFormValue("pin") != "0"
+	})
+}
+
+func (s *Server) deleteSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	return s.changeSavedSearch(w, r, func(d *UserData, i int) {
+		d.SavedSearches = slices.Delete(d.SavedSearches, i, i+1)
+	})
+}
+
+// changeSavedSearch applies fn to the saved search named by the form and
+// goes back to the list.
+func (s *Server) changeSavedSearch(w http.ResponseWriter, r *http.Request, fn func(d *UserData, i int)) error {
+	user := UserFrom(r.Context())
+	if user == "" {
+		return Forbidden("Sign in to save searches.")
+	}
+	name := r.PostFormValue("name")
+	err := s.updateUserData(r.Context(), user, func(d *UserData) error {
+		i := slices.IndexFunc(d.SavedSearches, func(ss *SavedSearch) bool { return ss.Name == name })
+		if i < 0 {
+			return NotFound("There is no saved search of that name.")
+		}
+		fn(d, i)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	http.Redirect(w, r, s.pagePath("searches", ""), http.StatusSeeOther)
+	return nil
+}
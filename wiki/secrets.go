@@ -0,0 +1,129 @@
+package wiki
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The server's secrets sign session cookies, CSRF tokens and share links.
+// The first secret signs and all of them verify, so a secret is rotated by
+// putting a new one first and dropping the old one once what it signed has
+// expired, a month for sessions. Each use signs with a key derived from the
+// secrets for it, so a signature made for one can't pass for another.
+
+// secretFile is where a generated secret is kept in the data directory,
+// one secret per line like Config.Secrets.
+const secretFile = ".secret"
+
+// minSecretLength is the shortest secret accepted.
+const minSecretLength = 16
+
+// keyring holds the secrets, the one signing first.
+type keyring struct {
+	secrets [][]byte
+}
+
+// newKeyring returns the configured secrets, or those of .secret in
+// dataDir, written with a random one on the first run. Without either
+// the secret is random and lasts as long as the process.
+func newKeyring(secrets []string, dataDir string) (*keyring, error) {
+	if len(secrets) == 0 && dataDir != "" {
+		var err error
+		if secrets, err = loadSecretFile(filepath.Join(dataDir, secretFile)); err != nil {
+			return nil, fmt.Errorf("secrets: %w", err)
+		}
+	}
+	k := &keyring{}
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("secrets must be at least %d characters long", minSecretLength)
+		}
+		k.secrets = append(k.secrets, []byte(secret))
+	}
+	if len(k.secrets) == 0 {
+		k.secrets = [][]byte{[]byte(randomSecret())}
+	}
+	return k, nil
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// loadSecretFile reads the secrets of name, creating it with a random
+// secret if it doesn't exist.
+func loadSecretFile(name string) ([]string, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		secret := randomSecret()
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			return nil, err
+		}
+		return []string{secret}, os.WriteFile(name, []byte(secret+"\n"), 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var secrets []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			secrets = append(secrets, line)
+		}
+	}
+	return secrets, nil
+}
+
+// mac signs msg for purpose with the i-th secret.
+func (k *keyring) mac(i int, purpose, msg string) string {
+	key := hmac.New(sha256.New, k.secrets[i])
+	key.Write([]byte(purpose))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sign returns the signature of msg for purpose.
+func (k *keyring) sign(purpose, msg string) string {
+	return k.mac(0, purpose, msg)
+}
+
+// verify reports whether sig is msg signed for purpose by any of the
+// secrets, and whether by an old one, so it should be signed again.
+func (k *keyring) verify(purpose, msg, sig string) (ok, old bool) {
+	for i := range k.secrets {
+		if hmac.Equal([]byte(sig), []byte(k.mac(i, purpose, msg))) {
+			return true, i > 0
+		}
+	}
+	return false, false
+}
+
+// signValue returns value with its signature appended, as in cookies.
+func (k *keyring) signValue(purpose, value string) string {
+	return value + "." + k.sign(purpose, value)
+}
+
+// openValue returns the value of what signValue returned, or "" if the
+// signature doesn't verify; old is set as verify sets it.
+func (k *keyring) openValue(purpose, signed string) (value string, old bool) {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return "", false
+	}
+	value = signed[:i]
+	ok, old := k.verify(purpose, value, signed[i+1:])
+	if !ok {
+		return "", false
+	}
+	return value, old
+}
@@ -0,0 +1,112 @@
+package wiki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// section is a part of a page: a heading and what follows it, up to the
+// next heading of the same or a higher level, so a section holds its
+// subsections. Offsets are in bytes of the body.
+type section struct {
+	Heading string // without the #s
+	Level   int
+	Start   int // of the heading line
+	Content int // just past the heading line
+	End     int
+}
+
+// pageSections returns the sections of body, in order. Headings in fenced
+// code blocks don't count, as Markdown doesn't treat them as headings.
+func pageSections(body string) []section {
+	var secs []section
+	inCode := false
+	for pos := 0; pos < len(body); {
+		end := strings.IndexByte(body[pos:], '\n')
+		next := pos + end + 1
+		if end < 0 {
+			next = len(body)
+		}
+		trimmed := strings.TrimSpace(body[pos:next])
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			inCode = !inCode
+		case !inCode && headingLevel(trimmed) > 0:
+			n := headingLevel(trimmed)
+			secs = append(secs, section{Heading: strings.TrimSpace(trimmed[n:]), Level: n, Start: pos, Content: next})
+		}
+		pos = next
+	}
+
+	for i := range secs {
+		secs[i].End = len(body)
+		for _, later := range secs[i+1:] {
+			if later.Level <= secs[i].Level {
+				secs[i].End = later.Start
+				break
+			}
+		}
+	}
+	return secs
+}
+
+// findSection returns the first section of body with the heading, compared
+// regardless of case.
+func findSection(body, heading string) (section, bool) {
+	heading = strings.TrimSpace(heading)
+	for _, sec := range pageSections(body) {
+		if strings.EqualFold(sec.Heading, heading) {
+			return sec, true
+		}
+	}
+	return section{}, false
+}
+
+// EditedSection is the section of a page in the editor, numbered from 1
+// in the order of the headings; section 0 is the text before the first.
+// Hash identifies the text it was loaded with, so saving can tell whether
+// someone changed the section meanwhile, as opposed to the rest of the
+// page.
+type EditedSection struct {
+	Index   int
+	Heading string
+	Hash    string
+}
+
+// sectionAt returns the n-th section of body, as EditedSection numbers
+// them.
+func sectionAt(body string, n int) (section, bool) {
+	secs := pageSections(body)
+	switch {
+	case n == 0:
+		lead := section{End: len(body)}
+		if len(secs) > 0 {
+			lead.End = secs[0].Start
+		}
+		return lead, true
+	case n < 0 || n > len(secs):
+		return section{}, false
+	}
+	return secs[n-1], true
+}
+
+func sectionHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:16])
+}
+
+// editSection cuts the body of p down to the section numbered field, for
+// the editor.
+func editSection(p *Page, field string) error {
+	n, err := strconv.Atoi(field)
+	sec, ok := sectionAt(string(p.Body), n)
+	if err != nil || !ok {
+		return NotFound("The page has no such section.")
+	}
+	text := string(p.Body[sec.Start:sec.End])
+	p.Body = []byte(text)
+	p.Section = &EditedSection{Index: n, Heading: sec.Heading, Hash: sectionHash(text)}
+	return nil
+}
@@ -0,0 +1,135 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// These methods are the wiki's page operations for front ends other than
+// its HTTP handlers, such as the gRPC server of the gowiki command. They
+// act as the user of ctx, set with WithUser, and check what the handlers
+// check. Failures are *Error values, their Status as the handlers would
+// answer.
+//
+// Page.Revision numbers the current revision of a page, and ChangePage
+// can refuse to save over a newer one. Earlier revisions are kept by
+// storage that is a RevisionStore.
+
+// GetPage returns a published page the user of ctx may read.
+func (s *Server) GetPage(ctx context.Context, title string) (*Page, error) {
+	if err := s.titles.check(title); err != nil {
+		return nil, NotFound("Invalid Page Title")
+	}
+	if !s.canRead(ctx, title) {
+		return nil, Forbidden("Sign in to see this page.")
+	}
+	p, err := s.loadPage(ctx, title)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !p.Published(time.Now())) {
+		return nil, NotFound("There is no page called " + title + ".")
+	}
+	return p, err
+}
+
+// PageRevisions lists the kept revisions of a page the user of ctx may
+// read, oldest first and without their bodies. Without a RevisionStore,
+// that is only the current one.
+func (s *Server) PageRevisions(ctx context.Context, title string) ([]*Page, error) {
+	p, err := s.GetPage(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	current := *p
+	current.Body = nil
+	rs, ok := s.store.(RevisionStore)
+	if !ok || s.mountOf(title) != nil {
+		return []*Page{&current}, nil
+	}
+	kept, err := rs.Revisions(ctx, title)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(kept); n == 0 || kept[n-1].Revision != p.Revision {
+		// saved before revisions were kept
+		kept = append(kept, &current)
+	}
+	return kept, nil
+}
+
+// PageRevision returns a revision of a page the user of ctx may read.
+func (s *Server) PageRevision(ctx context.Context, title string, revision int) (*Page, error) {
+	p, err := s.GetPage(ctx, title)
+	if err != nil || p.Revision == revision {
+		return p, err
+	}
+	missing := NotFound(fmt.Sprintf("%s has no revision %d.", title, revision))
+	rs, ok := s.store.(RevisionStore)
+	if !ok || s.mountOf(title) != nil || revision < 1 || revision > p.Revision {
+		return nil, missing
+	}
+	old, err := rs.LoadRevision(ctx, title, revision)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, missing
+	}
+	return old, err
+}
+
+// ListPages returns the published pages the user of ctx may read, without
+// their bodies, as the index lists them.
+func (s *Server) ListPages(ctx context.Context) ([]*Page, error) {
+	pages, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.readablePages(ctx, pages), nil
+}
+
+// ChangePage creates, updates or deletes a page as one operation of a
+// batch would, and returns the revision saved.
+func (s *Server) ChangePage(ctx context.Context, op *BatchOperation) (int, error) {
+	if s.ReadOnly() {
+		return 0, NewError(http.StatusServiceUnavailable, "The wiki is read-only for maintenance; try again later.")
+	}
+	if CurrentUser(ctx) == nil {
+		return 0, Forbidden("Sign in to change pages.")
+	}
+	if !canEdit(ctx) {
+		return 0, errReader
+	}
+	return s.applyOperation(ctx, op)
+}
+
+// Search returns up to limit of the pages matching q, with snippets, and
+// how many matched in all. Zero is the most the search API returns.
+func (s *Server) Search(ctx context.Context, q SearchQuery, limit int) ([]*SearchResult, int, error) {
+	if q.empty() {
+		return nil, 0, NewError(http.StatusBadRequest, "Give words to search for, tags or both.")
+	}
+	if limit == 0 {
+		limit = maxSearchResults
+	}
+	if limit < 1 || limit > maxSearchResults {
+		return nil, 0, NewError(http.StatusBadRequest, fmt.Sprintf("The limit is a number from 1 to %d.", maxSearchResults))
+	}
+	switch q.Sort {
+	case SortRelevance:
+		q.Sort = ""
+	case "", SortRecent, SortTitle:
+	default:
+		return nil, 0, NewError(http.StatusBadRequest, "Results can be sorted by relevance, recent or title.")
+	}
+
+	results, err := s.search(ctx, q)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(results)
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	s.addSnippets(ctx, results, q.Text)
+	return results, total, nil
+}
@@ -0,0 +1,335 @@
+package wiki
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Share links let someone without an account read one page of a namespace
+// that requires signing in, until the link expires or is revoked. The
+// token in the link is the share's ID and a signature over it, its page
+// and expiry, so links can't be made up or pointed at other pages.
+
+// ShareConfig sets up share links.
+type ShareConfig struct {
+	// Secret is what signed the links before Config.Secrets did. Links it
+	// signed keep working until they expire.
+	Secret string `json:"secret"`
+	// MaxHours is the longest a link can last, a week by default.
+	MaxHours int `json:"max_hours"`
+}
+
+func (c ShareConfig) maxAge() time.Duration {
+	if c.MaxHours <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(c.MaxHours) * time.Hour
+}
+
+// Share is a link to read one page.
+type Share struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ShareStore keeps the share links. Share reports unknown and revoked
+// links with fs.ErrNotExist. Stores may forget links once they expired.
+type ShareStore interface {
+	Shares(ctx context.Context, title string) ([]*Share, error)
+	Share(ctx context.Context, id string) (*Share, error)
+	AddShare(ctx context.Context, sh *Share) error
+	RevokeShare(ctx context.Context, id string) error
+}
+
+// shareList is the in-memory ShareStore used when the storage has none.
+type shareList struct {
+	mu     sync.Mutex
+	shares []*Share
+}
+
+func (sl *shareList) Shares(ctx context.Context, title string) ([]*Share, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sharesOf(sl.shares, title), nil
+}
+
+func (sl *shareList) Share(ctx context.Context, id string) (*Share, error) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return findShare(sl.shares, id)
+}
+
+func (sl *shareList) AddShare(ctx context.Context, sh *Share) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.shares = append(pruneShares(sl.shares), sh)
+	return nil
+}
+
+func (sl *shareList) RevokeShare(ctx context.Context, id string) error {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.shares = slices.DeleteFunc(sl.shares, func(sh *Share) bool { return sh.ID == id })
+	return nil
+}
+
+func sharesOf(shares []*Share, title string) []*Share {
+	var out []*Share
+	for _, sh := range shares {
+		if sh.Title == title {
+			out = append(out, sh)
+		}
+	}
+	return out
+}
+
+func findShare(shares []*Share, id string) (*Share, error) {
+	for _, sh := range shares {
+		if sh.ID == id {
+			return sh, nil
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// pruneShares drops the expired links.
+func pruneShares(shares []*Share) []*Share {
+	now := time.Now()
+	return slices.DeleteFunc(shares, func(sh *Share) bool { return now.After(sh.ExpiresAt) })
+}
+
+func (st *FileStorage) sharesPath() string {
+	return filepath.Join(st.dir, ".shares.json")
+}
+
+func (st *FileStorage) loadShares() ([]*Share, error) {
+	var shares []*Share
+	return shares, readJSONFile(st.sharesPath(), &shares)
+}
+
+func (st *FileStorage) Shares(ctx context.Context, title string) ([]*Share, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	shares, err := st.loadShares()
+	return sharesOf(shares, title), err
+}
+
+func (st *FileStorage) Share(ctx context.Context, id string) (*Share, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	shares, err := st.loadShares()
+	if err != nil {
+		return nil, err
+	}
+	return findShare(shares, id)
+}
+
+func (st *FileStorage) AddShare(ctx context.Context, sh *Share) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	shares, err := st.loadShares()
+	if err != nil {
+		return err
+	}
+	return writeJSONFile(st.sharesPath(), append(pruneShares(shares), sh))
+}
+
+func (st *FileStorage) RevokeShare(ctx context.Context, id string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	shares, err := st.loadShares()
+	if err != nil {
+		return err
+	}
+	shares = slices.DeleteFunc(pruneShares(shares), func(sh *Share) bool { return sh.ID == id })
+	return writeJSONFile(st.sharesPath(), shares)
+}
+
+// shareToken is the part of a share link after /shared/.
+func (s *Server) shareToken(sh *Share) string {
+	return sh.ID + "." + s.keys.sign("share", shareMessage(sh))
+}
+
+func shareMessage(sh *Share) string {
+	return sh.ID + "\n" + sh.Title + "\n" + strconv.FormatInt(sh.ExpiresAt.Unix(), 10)
+}
+
+// validShareToken checks token against the secrets, and the share
+// secret of links made before them.
+func (s *Server) validShareToken(sh *Share, token string) bool {
+	sig, ok := strings.CutPrefix(token, sh.ID+".")
+	if !ok {
+		return false
+	}
+	if ok, _ := s.keys.verify("share", shareMessage(sh), sig); ok {
+		return true
+	}
+	if s.cfg.Shares.Secret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(s.cfg.Shares.Secret))
+	mac.Write([]byte(shareMessage(sh)))
+	return hmac.Equal([]byte(sig), []byte(hex.EncodeToString(mac.Sum(nil)[:16])))
+}
+
+// sharePath links to a share.
+func (s *Server) sharePath(sh *Share) string {
+	return s.pagePath("shared", s.shareToken(sh))
+}
+
+// canShare reports whether the user of ctx may make share links: editors
+// and admins.
+func canShare(ctx context.Context) bool {
+	u := CurrentUser(ctx)
+	return u != nil && u.Role != RoleReader
+}
+
+// checkShare refuses share links to users who can't make them and to pages
+// anyone can read.
+func (s *Server) checkShare(r *http.Request, title string) error {
+	if !canShare(r.Context()) {
+		return Forbidden("Only editors can share pages.")
+	}
+	if !s.namespace(title).RequireLogin {
+		return NewError(http.StatusBadRequest, "Anyone can read this page already; share its address instead.")
+	}
+	return nil
+}
+
+// ShareLink is a share as share.html shows it.
+type ShareLink struct {
+	*Share
+	URL     string
+	Expired bool
+}
+
+// ShareData is the data handed to the share.html template.
+type ShareData struct {
+	Common
+	Title    string
+	Links    []*ShareLink
+	MaxHours int
+	Form
+}
+
+func (s *Server) shareFormHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	if err := s.checkShare(r, title); err != nil {
+		return err
+	}
+	return s.showShares(w, r, title, http.StatusOK, Form{})
+}
+
+// showShares renders the share links of title with status, form holding
+// what was wrong with the one asked for, if anything.
+func (s *Server) showShares(w http.ResponseWriter, r *http.Request, title string, status int, form Form) error {
+	shares, err := s.shares.Shares(r.Context(), title)
+	if err != nil {
+		return err
+	}
+	data := &ShareData{Title: title, MaxHours: int(s.cfg.Shares.maxAge() / time.Hour), Form: form}
+	now := time.Now()
+	for i := len(shares) - 1; i >= 0; i-- {
+		sh := shares[i]
+		data.Links = append(data.Links, &ShareLink{Share: sh, URL: s.absoluteURL(s.sharePath(sh)), Expired: now.After(sh.ExpiresAt)})
+	}
+	return s.writeTemplate(r.Context(), w, status, "share.html", data)
+}
+
+// shareHandler makes a share link lasting the hours asked for, up to
+// ShareConfig.MaxHours.
+func (s *Server) shareHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	if err := s.checkShare(r, title); err != nil {
+		return err
+	}
+	if _, err := s.loadPage(r.Context(), title); errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no page called " + title + ".")
+	} else if err != nil {
+		return err
+	}
+
+	hours, err := strconv.Atoi(r.PostFormValue("hours"))
+	age := time.Duration(hours) * time.Hour
+	if err != nil || hours < 1 || age > s.cfg.Shares.maxAge() {
+		errs := FieldErrors{"hours": "Links last from 1 hour to " + strconv.Itoa(int(s.cfg.Shares.maxAge()/time.Hour)) + " hours."}
+		return s.showShares(w, r, title, http.StatusBadRequest, Form{Errors: errs, Values: r.PostForm})
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	sh := &Share{ID: newPageID(), Title: title, CreatedBy: UserFrom(r.Context()), CreatedAt: now, ExpiresAt: now.Add(age)}
+	if err := s.shares.AddShare(r.Context(), sh); err != nil {
+		return err
+	}
+	logf(r.Context(), "share %s of %s made by %s, expires %s", sh.ID, title, sh.CreatedBy, sh.ExpiresAt.Format(time.RFC3339))
+	s.flash(w, r, FlashInfo, "The link was made.")
+	http.Redirect(w, r, s.pagePath("share", title), http.StatusSeeOther)
+	return nil
+}
+
+func (s *Server) unshareHandler(w http.ResponseWriter, r *http.Request, title string) error {
+	if err := s.checkShare(r, title); err != nil {
+		return err
+	}
+	id := r.PostFormValue("id")
+	if sh, err := s.shares.Share(r.Context(), id); err != nil || sh.Title != title {
+		return NotFound("There is no such share link.")
+	}
+	if err := s.shares.RevokeShare(r.Context(), id); err != nil {
+		return err
+	}
+	logf(r.Context(), "share %s of %s revoked by %s", id, title, UserFrom(r.Context()))
+	s.flash(w, r, FlashInfo, "The link was revoked; it no longer works.")
+	http.Redirect(w, r, s.pagePath("share", title), http.StatusSeeOther)
+	return nil
+}
+
+// SharedData is the data handed to the shared.html template.
+type SharedData struct {
+	Common
+	*Page
+	ExpiresAt time.Time
+}
+
+// sharedHandler shows the page of a share link, to anyone holding it.
+func (s *Server) sharedHandler(w http.ResponseWriter, r *http.Request) error {
+	gone := NotFound("This link is not valid, or no longer.")
+	token := r.PathValue("token")
+	id, _, _ := strings.Cut(token, ".")
+	sh, err := s.shares.Share(r.Context(), id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return gone
+	}
+	if err != nil {
+		return err
+	}
+	if !s.validShareToken(sh, token) || time.Now().After(sh.ExpiresAt) {
+		return gone
+	}
+
+	p, err := s.loadPageToShow(r.Context(), sh.Title)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !p.Published(time.Now())) {
+		return gone
+	}
+	if err != nil {
+		return err
+	}
+	logf(r.Context(), "share %s of %s used", sh.ID, sh.Title)
+
+	// the token is in the address; keep it out of referrers and caches
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	return s.renderTemplate(r.Context(), w, "shared.html", &SharedData{Page: p, ExpiresAt: sh.ExpiresAt})
+}
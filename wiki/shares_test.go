@@ -0,0 +1,81 @@
+package wiki_test
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+var (
+	shareURL = regexp.MustCompile(`http://wiki\.test(/shared/[^"]+)"`)
+	shareID  = regexp.MustCompile(`name="id" value="([^"]+)"`)
+)
+
+func TestShares(t *testing.T) {
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Titles.Pattern = `^[a-zA-Z0-9/]+$`
+		cfg.Namespaces = []wiki.NamespaceConfig{{Match: "team/*", RequireLogin: true}}
+	})
+	w.CreatePage("team/Plan", "The secret plan")
+	w.CreatePage("Public", "The public plan")
+	ed := w.Login("ed", wiki.RoleEditor)
+
+	for _, tc := range []struct {
+		name  string
+		c     *wikitest.Client
+		title string
+		want  int
+	}{
+		{"a reader", w.Login("rita", wiki.RoleReader), "team/Plan", http.StatusForbidden},
+		{"a public page", ed, "Public", http.StatusBadRequest},
+		{"a missing page", ed, "team/Missing", http.StatusNotFound},
+	} {
+		resp := tc.c.PostForm("/share/"+tc.title, url.Values{"hours": {"1"}})
+		if wikitest.ReadBody(t, resp); resp.StatusCode != tc.want {
+			t.Errorf("sharing %s: %s, want %d", tc.name, resp.Status, tc.want)
+		}
+	}
+
+	resp := ed.PostForm("/share/team/Plan", url.Values{"hours": {"1"}})
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("sharing team/Plan: %s: %s", resp.Status, body)
+	}
+	resp = ed.Get("/share/team/Plan")
+	list := wikitest.ReadBody(t, resp)
+	link, id := shareURL.FindStringSubmatch(list), shareID.FindStringSubmatch(list)
+	if link == nil || id == nil {
+		t.Fatalf("no share link listed: %s", list)
+	}
+
+	anon := w.Client()
+	resp = anon.Get(link[1])
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusOK || !strings.Contains(body, "The secret plan") {
+		t.Errorf("GET the share link: %s: %s", resp.Status, body)
+	} else if resp.Header.Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("the share link leaks in referrers: %q", resp.Header.Get("Referrer-Policy"))
+	}
+	for _, p := range []string{link[1] + "x", "/shared/" + id[1] + ".forged", "/shared/nothing"} {
+		resp = anon.Get(p)
+		if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: %s, want 404", p, resp.Status)
+		}
+	}
+	resp = anon.Get("/view/team/Plan")
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("the share link opened the page itself: %s", resp.Status)
+	}
+
+	resp = ed.PostForm("/unshare/team/Plan", url.Values{"id": {id[1]}})
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("revoking: %s", resp.Status)
+	}
+	resp = anon.Get(link[1])
+	if wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET the revoked link: %s, want 404", resp.Status)
+	}
+}
@@ -0,0 +1,75 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"html"
+	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// sidebarPages are the titles, within a namespace, of the page whose links
+// become the navigation of every page in it. Title rules have to allow
+// underscores for them to be created.
+var sidebarPages = []string{"_sidebar", "_toc"}
+
+// findSidebar loads the sidebar page closest to title: the one of its own
+// namespace, else of the namespace above, up to the top level.
+func (s *Server) findSidebar(ctx context.Context, title string) (*Page, error) {
+	dir := path.Dir(title)
+	for {
+		for _, name := range sidebarPages {
+			candidate := name
+			if dir != "." {
+				candidate = dir + "/" + name
+			}
+			if s.titles.check(candidate) != nil {
+				continue
+			}
+			p, err := s.loadPage(ctx, candidate)
+			if err == nil && p.Published(time.Now()) {
+				return p, nil
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if dir == "." {
+			return nil, nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// pageSidebar is the sidebar template function: the navigation of the
+// namespace of the page being shown, with the link to the page itself
+// marked as current, or nothing.
+func (s *Server) pageSidebar(data interface{}) template.HTML {
+	p := pageOf(data)
+	if p == nil || p.Title == "" {
+		return ""
+	}
+	// templates have no request to take a context from
+	ctx := context.Background()
+	sb, err := s.findSidebar(ctx, p.Title)
+	if err != nil {
+		logf(ctx, "loading the sidebar of %s: %v", p.Title, err)
+		return ""
+	}
+	if sb == nil {
+		return ""
+	}
+
+	out, err := s.markdown(sb.Body)
+	if err != nil {
+		logf(ctx, "rendering the sidebar of %s: %v", p.Title, err)
+		return ""
+	}
+	nav := string(out)
+	current := `href="` + html.EscapeString(s.pagePath("view", p.Title)) + `"`
+	nav = strings.ReplaceAll(nav, current, current+` class="current" aria-current="page"`)
+	return template.HTML(`<nav class="sidebar">` + "\n" + nav + "</nav>\n")
+}
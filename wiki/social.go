@@ -0,0 +1,188 @@
+package wiki
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Shared links to a page unfurl in chat tools and search results from the
+// Open Graph and Twitter card meta tags and the JSON-LD the view carries:
+// the title, an excerpt of the text, when it changed and its first image.
+// Meta tags an admin set in the page's head take precedence.
+
+// excerptLength is about how much text the description holds.
+const excerptLength = 200
+
+var (
+	markdownImage = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)\s>]+)`)
+	markdownLink  = regexp.MustCompile(`(!?)\[([^\]]*)\]\([^)]*\)`)
+)
+
+// imageExts are the attachments that can stand for a page with no image in
+// its text.
+var imageExts = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// socialMeta is the social template function: the metadata of the page
+// being viewed.
+func (s *Server) socialMeta(data interface{}) template.HTML {
+	p := pageOf(data)
+	if p == nil || p.Revision == 0 {
+		return ""
+	}
+	pageURL := s.absoluteURL(s.pagePath("view", p.Title))
+	description := excerpt(string(p.Body), excerptLength)
+	image := s.pageImage(p, pageURL)
+
+	set := make(map[string]bool)
+	if p.Head != nil {
+		for _, m := range p.Head.Meta {
+			set[strings.ToLower(m.Name)] = true
+		}
+	}
+	var out strings.Builder
+	meta := func(name, content string) {
+		if content == "" || set[name] {
+			return
+		}
+		attr := "name"
+		if strings.Contains(name, ":") && !strings.HasPrefix(name, "twitter:") {
+			attr = "property"
+		}
+		fmt.Fprintf(&out, "<meta %s=\"%s\" content=\"%s\">\n", attr, name, html.EscapeString(content))
+	}
+
+	meta("description", description)
+	meta("og:type", "article")
+	meta("og:title", p.Title)
+	meta("og:description", description)
+	if s.cfg.PublicURL != "" {
+		meta("og:url", pageURL)
+	}
+	meta("og:image", image)
+	meta("article:published_time", p.CreatedAt.UTC().Format(time.RFC3339))
+	meta("article:modified_time", p.UpdatedAt.UTC().Format(time.RFC3339))
+	for _, tag := range p.Tags {
+		fmt.Fprintf(&out, "<meta property=\"article:tag\" content=\"%s\">\n", html.EscapeString(tag))
+	}
+	card := "summary"
+	if image != "" {
+		card = "summary_large_image"
+	}
+	meta("twitter:card", card)
+	meta("twitter:title", p.Title)
+	meta("twitter:description", description)
+	meta("twitter:image", image)
+
+	ld := map[string]any{
+		"@context":     "https://schema.org",
+		"@type":        "Article",
+		"headline":     p.Title,
+		"dateModified": p.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+	if !p.CreatedAt.IsZero() {
+		ld["datePublished"] = p.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	if description != "" {
+		ld["description"] = description
+	}
+	if s.cfg.PublicURL != "" {
+		ld["url"] = pageURL
+	}
+	if image != "" {
+		ld["image"] = image
+	}
+	if len(p.Tags) > 0 {
+		ld["keywords"] = strings.Join(p.Tags, ", ")
+	}
+	// Marshal escapes <, > and &, so the JSON can't end the script element
+	js, err := json.Marshal(ld)
+	if err != nil {
+		return template.HTML(out.String())
+	}
+	out.WriteString(`<script type="application/ld+json">` + string(js) + "</script>\n")
+	return template.HTML(out.String())
+}
+
+// pageImage returns the absolute address of the first image in the text
+// of p, or else of its first attachment that is an image. Relative ones
+// are only absolute with Config.PublicURL, so without it they are left
+// out.
+func (s *Server) pageImage(p *Page, pageURL string) string {
+	var src string
+	if m := markdownImage.FindStringSubmatch(string(p.Body)); m != nil {
+		src = m[1]
+	} else {
+		for _, a := range p.Attachments {
+			if ext := strings.ToLower(path.Ext(a.Name)); slices.Contains(imageExts, ext) {
+				src = s.attachmentPath(p.Title, a.Name)
+				break
+			}
+		}
+	}
+	if src == "" {
+		return ""
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	if !u.IsAbs() {
+		if s.cfg.PublicURL == "" {
+			return ""
+		}
+		base, err := url.Parse(pageURL)
+		if err != nil {
+			return ""
+		}
+		u = base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+// excerpt returns about length bytes of the first paragraphs of a page's
+// Markdown, as plain text.
+func excerpt(body string, length int) string {
+	var words []string
+	n, inCode := 0, false
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			inCode = !inCode
+			continue
+		case inCode, trimmed == "", headingLevel(trimmed) > 0, strings.HasPrefix(trimmed, "{{"), strings.HasPrefix(trimmed, "|"):
+			continue
+		}
+		// links keep their text, images lose theirs
+		trimmed = markdownLink.ReplaceAllStringFunc(trimmed, func(link string) string {
+			m := markdownLink.FindStringSubmatch(link)
+			if m[1] != "" {
+				return ""
+			}
+			return m[2]
+		})
+		trimmed = strings.TrimLeft(trimmed, ">-*+ ")
+		trimmed = strings.Map(func(r rune) rune {
+			if r == '*' || r == '_' || r == '`' {
+				return -1
+			}
+			return r
+		}, trimmed)
+		for _, w := range strings.Fields(trimmed) {
+			if n+len(w) > length {
+				return strings.ToValidUTF8(strings.Join(words, " "), "") + " …"
+			}
+			words = append(words, w)
+			n += len(w) + 1
+		}
+	}
+	return strings.ToValidUTF8(strings.Join(words, " "), "")
+}
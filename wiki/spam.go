@@ -0,0 +1,221 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SpamVerdict is the outcome of a spam check.
+type SpamVerdict int
+
+const (
+	// SpamClean lets the edit through.
+	SpamClean SpamVerdict = iota
+	// SpamFlagged holds the edit for a moderator to review.
+	SpamFlagged
+	// SpamRejected refuses the edit.
+	SpamRejected
+)
+
+func (v SpamVerdict) String() string {
+	switch v {
+	case SpamClean:
+		return "clean"
+	case SpamFlagged:
+		return "flagged"
+	case SpamRejected:
+		return "rejected"
+	}
+	return fmt.Sprintf("SpamVerdict(%d)", int(v))
+}
+
+// Edit is a submitted change to a page, as seen by spam checks.
+type Edit struct {
+	Page *Page
+	// Previous is the stored page, or nil when the edit creates it.
+	Previous *Page
+
+	// What the client told us about itself; external services like
+	// Akismet want them.
+	RemoteAddr string
+	UserAgent  string
+	Referer    string
+}
+
+// SpamCheck judges an edit. A check that fails with an error is skipped, so
+// an unreachable service doesn't stop people from editing.
+type SpamCheck func(ctx context.Context, e *Edit) (SpamVerdict, error)
+
+// SpamConfig enables the built-in spam checks. They, and the checks
+// registered with Hooks.OnSpamCheck, only judge anonymous edits.
+type SpamConfig struct {
+	// MaxLinks rejects edits adding more links than that. Zero disables it.
+	MaxLinks int `json:"max_links"`
+	// BlockedWords rejects edits adding any of them, ignoring case.
+	BlockedWords []string `json:"blocked_words"`
+	// AkismetKey flags the edits Akismet considers spam.
+	AkismetKey string `json:"akismet_key"`
+}
+
+var (
+	spamFlagged  = expvar.NewInt("spam_flagged")
+	spamRejected = expvar.NewInt("spam_rejected")
+)
+
+// checks returns the built-in checks the configuration enables.
+func (c SpamConfig) checks(publicURL string) []SpamCheck {
+	var checks []SpamCheck
+	if c.MaxLinks > 0 {
+		checks = append(checks, MaxLinks(c.MaxLinks))
+	}
+	if len(c.BlockedWords) > 0 {
+		checks = append(checks, BlockedWords(c.BlockedWords...))
+	}
+	if c.AkismetKey != "" {
+		checks = append(checks, Akismet(c.AkismetKey, publicURL))
+	}
+	return checks
+}
+
+// checkSpam runs the spam checks on an edit made from r and returns the
+// strongest verdict. Signed-in users are trusted and not checked.
+func (s *Server) checkSpam(r *http.Request, p *Page) (SpamVerdict, error) {
+	checks := append(append([]SpamCheck(nil), s.spamChecks...), s.hooks.spamCheck...)
+	if len(checks) == 0 || CurrentUser(r.Context()) != nil {
+		return SpamClean, nil
+	}
+
+	e := &Edit{
+		Page:      p,
+		UserAgent: r.UserAgent(),
+		Referer:   r.Referer(),
+	}
+	e.RemoteAddr, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	prev, err := s.loadPage(r.Context(), p.Title)
+	switch {
+	case err == nil:
+		e.Previous = prev
+	case !errors.Is(err, fs.ErrNotExist):
+		return SpamClean, err
+	}
+
+	verdict := SpamClean
+	for _, check := range checks {
+		v, err := check(r.Context(), e)
+		if err != nil {
+			logf(r.Context(), "spam check on %s: %v", p.Title, err)
+			continue
+		}
+		if v > verdict {
+			verdict = v
+		}
+		if verdict == SpamRejected {
+			break
+		}
+	}
+
+	switch verdict {
+	case SpamFlagged:
+		spamFlagged.Add(1)
+		logf(r.Context(), "edit of %s from %s flagged as spam", p.Title, e.RemoteAddr)
+	case SpamRejected:
+		spamRejected.Add(1)
+		logf(r.Context(), "edit of %s from %s rejected as spam", p.Title, e.RemoteAddr)
+	}
+	return verdict, nil
+}
+
+// added returns the text of the edit that is new, roughly: the whole body,
+// minus what the previous revision already had. Heuristics use it so that
+// fixing a typo on a link-heavy page isn't mistaken for spam.
+func (e *Edit) added(count func(string) int) int {
+	n := count(string(e.Page.Body))
+	if e.Previous != nil {
+		n -= count(string(e.Previous.Body))
+	}
+	return n
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://`)
+
+// MaxLinks rejects edits adding more than max links.
+func MaxLinks(max int) SpamCheck {
+	return func(ctx context.Context, e *Edit) (SpamVerdict, error) {
+		count := func(text string) int { return len(linkPattern.FindAllStringIndex(text, -1)) }
+		if e.added(count) > max {
+			return SpamRejected, nil
+		}
+		return SpamClean, nil
+	}
+}
+
+// BlockedWords rejects edits adding any of words, ignoring case.
+func BlockedWords(words ...string) SpamCheck {
+	lower := make([]string, len(words))
+	for i, w := range words {
+		lower[i] = strings.ToLower(w)
+	}
+	return func(ctx context.Context, e *Edit) (SpamVerdict, error) {
+		for _, w := range lower {
+			count := func(text string) int { return strings.Count(strings.ToLower(text), w) }
+			if e.added(count) > 0 {
+				return SpamRejected, nil
+			}
+		}
+		return SpamClean, nil
+	}
+}
+
+var akismetClient = &http.Client{Timeout: 5 * time.Second}
+
+// Akismet flags the edits the Akismet service considers spam. site is the
+// public URL of the wiki the key was registered for.
+func Akismet(key, site string) SpamCheck {
+	endpoint := "https://" + url.PathEscape(key) + ".rest.akismet.com/1.1/comment-check"
+
+	return func(ctx context.Context, e *Edit) (SpamVerdict, error) {
+		form := url.Values{
+			"blog":            {site},
+			"user_ip":         {e.RemoteAddr},
+			"user_agent":      {e.UserAgent},
+			"referrer":        {e.Referer},
+			"comment_type":    {"wiki-edit"},
+			"comment_content": {string(e.Page.Body)},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return SpamClean, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := akismetClient.Do(req)
+		if err != nil {
+			return SpamClean, err
+		}
+		defer resp.Body.Close()
+
+		answer, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+		if err != nil {
+			return SpamClean, err
+		}
+		switch string(bytes.TrimSpace(answer)) {
+		case "true":
+			return SpamFlagged, nil
+		case "false":
+			return SpamClean, nil
+		}
+		return SpamClean, fmt.Errorf("akismet: unexpected answer %q (%s)", answer, resp.Header.Get("X-akismet-debug-help"))
+	}
+}
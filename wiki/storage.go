@@ -0,0 +1,387 @@
+package wiki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage persists pages. Load and Delete report a missing page with an
+// error matching fs.ErrNotExist. Save fills in the metadata it owns
+// (ID, CreatedAt, UpdatedAt, Revision) on the page it is given. A page
+// keeps its ID across saves; a new page keeps the ID it is given, if any.
+//
+// List returns every page with its metadata but without its body, sorted
+// by title.
+type Storage interface {
+	Load(ctx context.Context, title string) (*Page, error)
+	Save(ctx context.Context, p *Page) error
+	Delete(ctx context.Context, title string) error
+	List(ctx context.Context) ([]*Page, error)
+}
+
+// FileStorage keeps each page as <title>.txt in a directory, next to a
+// <title>.meta.json file with its metadata. Pages written before metadata
+// existed are still read, using the file's modification time.
+//
+// It also counts page views, kept in memory and written to .views.json at
+// most every viewFlushInterval and on Close, keeps the edits held for
+// moderation in .pending, the notes on a page in <title>.notes.json, its
+// attachments in .attachments/<title>.files/ with their uploaders in
+// .uploaders.json there and their content in .attachments/.blobs/, user
+// accounts in .accounts.json, and notifications and watched pages in
+// .notifications and .watchers.json. The revisions of a page are kept in
+// .revisions/<title>.revs/; see RevisionStore.
+type FileStorage struct {
+	dir string
+
+	// mu serializes saves, so concurrent edits get distinct revisions
+	mu sync.Mutex
+
+	views     viewCounts
+	flushMu   sync.Mutex
+	lastFlush time.Time
+
+	// accounts is loaded on first use
+	accountsMu sync.Mutex
+	accounts   *accountList
+}
+
+// viewFlushInterval bounds how often view counts are written to disk.
+const viewFlushInterval = 30 * time.Second
+
+func NewFileStorage(dir string) *FileStorage {
+	st := &FileStorage{dir: dir, lastFlush: time.Now()}
+
+	data, err := ioutil.ReadFile(st.viewsPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &st.views.counts); err != nil {
+			log.Printf("%s: %v", st.viewsPath(), err)
+		}
+	}
+
+	return st
+}
+
+// pageMeta is the on-disk form of a page's metadata.
+type pageMeta struct {
+	ID        string     `json:"id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Author    string     `json:"author,omitempty"`
+	Revision  int        `json:"revision"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	Archived  bool       `json:"archived,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Head      *PageHead  `json:"head,omitempty"`
+}
+
+// optionalTime is t for a JSON field left out when it isn't set, which
+// omitzero only does from Go 1.24 on.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// timeOf is the time of a field optionalTime set, zero when it's missing.
+func timeOf(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func (st *FileStorage) generateArticlePath(title string) string {
+	return filepath.Join(st.dir, title+".txt")
+}
+
+func (st *FileStorage) metaPath(title string) string {
+	return filepath.Join(st.dir, title+".meta.json")
+}
+
+func (st *FileStorage) Load(ctx context.Context, title string) (*Page, error) {
+	filename := st.generateArticlePath(title)
+
+	body, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+
+		return nil, err
+
+	}
+
+	p := &Page{Title: title, Body: body}
+
+	meta, err := st.loadMeta(title)
+	if err != nil {
+		return nil, err
+	}
+	meta.apply(p)
+
+	return p, nil
+
+}
+
+func (meta *pageMeta) apply(p *Page) {
+	p.ID = meta.ID
+	p.CreatedAt = meta.CreatedAt
+	p.UpdatedAt = meta.UpdatedAt
+	p.Author = meta.Author
+	p.Revision = meta.Revision
+	p.PublishAt = timeOf(meta.PublishAt)
+	p.Archived = meta.Archived
+	p.Tags = meta.Tags
+	p.Head = meta.Head
+}
+
+// loadMeta reads the metadata of an existing page, falling back to the
+// body's modification time for pages that have none yet.
+func (st *FileStorage) loadMeta(title string) (*pageMeta, error) {
+	data, err := ioutil.ReadFile(st.metaPath(title))
+	if err == nil {
+		var meta pageMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, err
+		}
+		return &meta, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	info, err := os.Stat(st.generateArticlePath(title))
+	if err != nil {
+		return nil, err
+	}
+	return &pageMeta{CreatedAt: info.ModTime(), UpdatedAt: info.ModTime(), Revision: 1}, nil
+}
+
+func (st *FileStorage) Save(ctx context.Context, p *Page) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.save(p, nil)
+}
+
+// ImportRevisions implements RevisionStore.
+func (st *FileStorage) ImportRevisions(ctx context.Context, p *Page, earlier []*Page) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.save(p, earlier)
+}
+
+// save writes p as its next revision, after keeping earlier as the
+// revisions before it. It is called with st.mu held.
+func (st *FileStorage) save(p *Page, earlier []*Page) error {
+	filename := st.generateArticlePath(p.Title)
+
+	now := time.Now().UTC()
+	meta := &pageMeta{ID: p.ID, CreatedAt: now}
+	if old, err := st.loadMeta(p.Title); err == nil {
+		meta.CreatedAt = old.CreatedAt
+		meta.Revision = old.Revision
+		meta.Head = old.Head
+		if old.ID != "" {
+			meta.ID = old.ID
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if meta.ID == "" {
+		// pages from before IDs get one when next saved
+		meta.ID = newPageID()
+	}
+	meta.UpdatedAt = now
+	meta.Author = p.Author
+	meta.Revision++
+	meta.PublishAt = optionalTime(p.PublishAt)
+	meta.Archived = p.Archived
+	meta.Tags = p.Tags
+
+	// titles with slashes live in subdirectories
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+
+	before, err := ioutil.ReadFile(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, e := range earlier {
+		rev := *meta
+		rev.UpdatedAt, rev.Author = e.UpdatedAt.UTC(), e.Author
+		if err := st.keepRevision(p.Title, &rev, before, e.Body); err != nil {
+			return err
+		}
+		meta.Revision++
+		before = e.Body
+	}
+	if err := ioutil.WriteFile(filename, p.Body, 0600); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(st.metaPath(p.Title), data, 0600); err != nil {
+		return err
+	}
+	if err := st.keepRevision(p.Title, meta, before, p.Body); err != nil {
+		return err
+	}
+
+	p.ID = meta.ID
+	p.CreatedAt = meta.CreatedAt
+	p.UpdatedAt = meta.UpdatedAt
+	p.Revision = meta.Revision
+	return nil
+}
+
+func (st *FileStorage) Delete(ctx context.Context, title string) error {
+	if err := os.Remove(st.generateArticlePath(title)); err != nil {
+		return err
+	}
+	for _, file := range []string{st.metaPath(title), st.notesPath(title)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := st.releaseBlobs(title); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(st.revisionDir(title)); err != nil {
+		return err
+	}
+	return os.RemoveAll(st.attachmentDir(title))
+}
+
+func (st *FileStorage) List(ctx context.Context) ([]*Page, error) {
+	var pages []*Page
+
+	err := filepath.WalkDir(st.dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// .jobs and friends are not pages
+			if file != st.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(file, ".txt") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(st.dir, file)
+		if err != nil {
+			return err
+		}
+		p := &Page{Title: filepath.ToSlash(strings.TrimSuffix(rel, ".txt"))}
+
+		meta, err := st.loadMeta(p.Title)
+		if err != nil {
+			return err
+		}
+		meta.apply(p)
+		pages = append(pages, p)
+		return ctx.Err()
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Title < pages[j].Title })
+	return pages, err
+}
+
+func (st *FileStorage) viewsPath() string {
+	return filepath.Join(st.dir, ".views.json")
+}
+
+func (st *FileStorage) CountView(ctx context.Context, title string) (int64, error) {
+	n, err := st.views.CountView(ctx, title)
+	if err != nil {
+		return 0, err
+	}
+
+	st.flushMu.Lock()
+	due := time.Since(st.lastFlush) > viewFlushInterval
+	if due {
+		st.lastFlush = time.Now()
+	}
+	st.flushMu.Unlock()
+
+	if due {
+		go func() {
+			if err := st.flushViews(); err != nil {
+				log.Printf("saving view counts: %v", err)
+			}
+		}()
+	}
+	return n, nil
+}
+
+func (st *FileStorage) ViewCounts(ctx context.Context) (map[string]int64, error) {
+	return st.views.ViewCounts(ctx)
+}
+
+func (st *FileStorage) ForgetViews(ctx context.Context, title string) error {
+	return st.views.ForgetViews(ctx, title)
+}
+
+// flushViews writes the view counts if they changed since the last write.
+func (st *FileStorage) flushViews() error {
+	st.views.mu.Lock()
+	if !st.views.dirty {
+		st.views.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(st.views.counts)
+	st.views.dirty = false
+	st.views.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write then rename, so a crash never leaves a truncated file
+	tmp := st.viewsPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.viewsPath())
+}
+
+// Close writes the pending view counts.
+func (st *FileStorage) Close() error {
+	return st.flushViews()
+}
+
+var (
+	_ Storage           = (*FileStorage)(nil)
+	_ ViewCounter       = (*FileStorage)(nil)
+	_ ModerationQueue   = (*FileStorage)(nil)
+	_ AnnotationStore   = (*FileStorage)(nil)
+	_ AttachmentStore   = (*FileStorage)(nil)
+	_ Renamer           = (*FileStorage)(nil)
+	_ Sizer             = (*FileStorage)(nil)
+	_ AccountStore      = (*FileStorage)(nil)
+	_ NotificationStore = (*FileStorage)(nil)
+	_ UserDataStore     = (*FileStorage)(nil)
+	_ Reattributor      = (*FileStorage)(nil)
+	_ PageStreamer      = (*FileStorage)(nil)
+	_ HeadStore         = (*FileStorage)(nil)
+	_ ShareStore        = (*FileStorage)(nil)
+	_ RevisionStore     = (*FileStorage)(nil)
+)
@@ -0,0 +1,99 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSuggestions caps the "did you mean" list on missing pages.
+const maxSuggestions = 5
+
+// MissingData is the data handed to the missing.html template, shown
+// instead of the editor when a page doesn't exist but others are named
+// like it.
+type MissingData struct {
+	Common
+	Title       string
+	Suggestions []string
+}
+
+// missingPage offers the titles close to a missing one, or sends the user
+// straight to the editor when there are none. Mounted pages can't be
+// created.
+func (s *Server) missingPage(w http.ResponseWriter, r *http.Request, title string) error {
+	if s.mountOf(title) != nil {
+		return NotFound("There is no page called " + title + ".")
+	}
+	suggestions, err := s.suggestTitles(r.Context(), title)
+	if err != nil {
+		// not worth failing the request over
+		logf(r.Context(), "suggesting titles for %s: %v", title, err)
+	}
+	if len(suggestions) == 0 {
+		http.Redirect(w, r, s.pagePath("edit", title), http.StatusFound)
+		return nil
+	}
+	return s.writeTemplate(r.Context(), w, http.StatusNotFound, "missing.html",
+		&MissingData{Title: title, Suggestions: suggestions})
+}
+
+// suggestTitles returns the published titles within a small edit distance
+// of title, or sharing its beginning, closest first, among those the
+// user of ctx may read.
+func (s *Server) suggestTitles(ctx context.Context, title string) ([]string, error) {
+	pages, err := s.listPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages = s.readablePages(ctx, pages)
+
+	want := strings.ToLower(title)
+	// a typo or two, more for long titles
+	limit := max(2, utf8.RuneCountInString(want)/4)
+
+	type match struct {
+		title    string
+		distance int
+	}
+	var matches []match
+	for _, p := range pages {
+		have := strings.ToLower(p.Title)
+		d := editDistance(want, have)
+		if d > limit && !strings.HasPrefix(have, want) && !strings.HasPrefix(want, have) {
+			continue
+		}
+		matches = append(matches, match{p.Title, d})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var titles []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		titles = append(titles, matches[i].title)
+	}
+	return titles, nil
+}
+
+// editDistance is the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,66 @@
+package wiki
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxTags      = 20
+	maxTagLength = 40
+)
+
+// parseTags reads a comma-separated list of tags, as typed in the editor.
+// Tags are lower case, with dashes for spaces and without punctuation
+// other than -, _ and /; the list is sorted and without duplicates.
+func parseTags(list string) []string {
+	var tags []string
+	for _, field := range strings.Split(list, ",") {
+		tag := strings.Map(func(r rune) rune {
+			switch {
+			case unicode.IsLetter(r) || unicode.IsNumber(r):
+				return unicode.ToLower(r)
+			case r == '-' || r == '_' || r == '/':
+				return r
+			case unicode.IsSpace(r):
+				return '-'
+			}
+			return -1
+		}, strings.TrimSpace(field))
+		tag = strings.Trim(tag, "-/")
+		if tag == "" || len(tag) > maxTagLength {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	tags = slices.Compact(tags)
+	if len(tags) > maxTags {
+		tags = tags[:maxTags]
+	}
+	return tags
+}
+
+// checkTags reports the tags of list that parseTags would leave out, so the
+// editor hears of them instead.
+func checkTags(list string) error {
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		tags := parseTags(field)
+		if len(tags) == 0 {
+			return NewError(http.StatusBadRequest, fmt.Sprintf("The tag %q has no letters or digits, or is longer than %d bytes.", field, maxTagLength))
+		}
+		seen[tags[0]] = true
+	}
+	if len(seen) > maxTags {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("Pages can have at most %d tags.", maxTags))
+	}
+	return nil
+}
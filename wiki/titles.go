@@ -0,0 +1,130 @@
+package wiki
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultTitlePattern only allows letters and digits, as the wiki always has.
+const DefaultTitlePattern = "^[a-zA-Z0-9]+$"
+
+// DefaultMaxTitleLength is used when TitleRules.MaxLength is zero.
+const DefaultMaxTitleLength = 100
+
+// DefaultReservedTitles can't be used as page titles because they are, or
+// are likely to become, application routes.
+var DefaultReservedTitles = []string{"api", "static", "admin"}
+
+// SystemNamespaces hold no pages, whatever TitleRules say, so pages can't
+// be mistaken for the wiki's own routes and the pages it makes: no title
+// starts with one of them and a slash.
+var SystemNamespaces = []string{"special", "api", "static", "user"}
+
+// TitleRules decide which page titles are acceptable. The same rules are
+// applied when routing a request, when saving, and when templates build
+// links, so a title is either valid everywhere or nowhere.
+type TitleRules struct {
+	// Pattern is a regular expression the whole title has to match.
+	// Empty means DefaultTitlePattern. Allowing "/" lets titles be
+	// organized in folders like "team/Runbook".
+	Pattern string `json:"pattern"`
+	// MaxLength is the maximum length in characters. Zero means
+	// DefaultMaxTitleLength.
+	MaxLength int `json:"max_length"`
+	// Reserved titles are refused, compared case-insensitively. Nil means
+	// DefaultReservedTitles.
+	Reserved []string `json:"reserved"`
+	// ReservedNamespaces hold no pages, besides SystemNamespaces, for
+	// deployments adding routes of their own: "tools" refuses "tools/Foo",
+	// compared case-insensitively. They may be nested, as in "team/bots".
+	ReservedNamespaces []string `json:"reserved_namespaces"`
+	// Validate, when set, runs after the built-in checks for custom rules.
+	Validate func(title string) error `json:"-"`
+}
+
+// titleValidator is the compiled form of TitleRules.
+type titleValidator struct {
+	pattern   *regexp.Regexp
+	maxLength int
+	reserved  map[string]bool
+	// namespaces are the reserved ones, lower-cased and with a trailing
+	// slash
+	namespaces []string
+	validate   func(string) error
+}
+
+func newTitleValidator(rules TitleRules) (*titleValidator, error) {
+	if rules.Pattern == "" {
+		rules.Pattern = DefaultTitlePattern
+	}
+	if rules.MaxLength == 0 {
+		rules.MaxLength = DefaultMaxTitleLength
+	}
+	if rules.Reserved == nil {
+		rules.Reserved = DefaultReservedTitles
+	}
+
+	pattern, err := regexp.Compile(rules.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("title pattern: %v", err)
+	}
+
+	v := &titleValidator{
+		pattern:   pattern,
+		maxLength: rules.MaxLength,
+		reserved:  make(map[string]bool),
+		validate:  rules.Validate,
+	}
+	for _, name := range rules.Reserved {
+		v.reserved[strings.ToLower(name)] = true
+	}
+	for _, ns := range append(slices.Clone(SystemNamespaces), rules.ReservedNamespaces...) {
+		ns = strings.Trim(ns, "/")
+		if ns == "" {
+			return nil, errors.New("reserved namespaces can't be empty")
+		}
+		v.namespaces = append(v.namespaces, strings.ToLower(ns)+"/")
+	}
+	return v, nil
+}
+
+var errEmptyTitle = errors.New("the title is empty")
+
+// check returns a user-facing reason when title is not acceptable.
+func (v *titleValidator) check(title string) error {
+	if title == "" {
+		return errEmptyTitle
+	}
+	if n := utf8.RuneCountInString(title); n > v.maxLength {
+		return fmt.Errorf("the title is %d characters long, the limit is %d", n, v.maxLength)
+	}
+	// whatever the pattern allows, a title must stay inside the data
+	// directory once it is turned into a file name
+	for _, segment := range strings.Split(title, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.HasPrefix(segment, ".") {
+			return fmt.Errorf("%q is not a valid title", title)
+		}
+	}
+	if strings.ContainsAny(title, "\\\x00") {
+		return fmt.Errorf("%q is not a valid title", title)
+	}
+	if !v.pattern.MatchString(title) {
+		return fmt.Errorf("%q contains characters that are not allowed in titles", title)
+	}
+	if v.reserved[strings.ToLower(title)] {
+		return fmt.Errorf("%q is reserved and can't be used as a title", title)
+	}
+	for _, ns := range v.namespaces {
+		if strings.HasPrefix(strings.ToLower(title), ns) {
+			return fmt.Errorf("%q is reserved for the wiki and can't hold pages", ns)
+		}
+	}
+	if v.validate != nil {
+		return v.validate(title)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package wiki
+
+import "context"
+
+// Tracer starts spans around the interesting steps of a request (handler,
+// storage, render). The returned func ends the span.
+//
+// Without one in Config tracing is a no-op; the gowiki command ships an
+// OpenTelemetry implementation behind the "otel" build tag.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, func())
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+func (s *Server) startSpan(ctx context.Context, name string) (context.Context, func()) {
+	return s.tracer.Start(ctx, name)
+}
@@ -0,0 +1,60 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+)
+
+// Role is what a user is allowed to do.
+type Role string
+
+const (
+	RoleReader Role = "reader"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// User is the person making a request.
+type User struct {
+	Name string
+	Role Role
+}
+
+// IsAdmin reports whether u may use the admin pages. It is false for nil,
+// the anonymous user.
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
+}
+
+type userKey struct{}
+
+// WithUser returns a context identifying the user making the request.
+// Authentication middleware calls it so that edits are attributed and admin
+// pages can be reached; without it requests are anonymous.
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// CurrentUser returns the user set by WithUser, or nil for anonymous requests.
+func CurrentUser(ctx context.Context) *User {
+	u, _ := ctx.Value(userKey{}).(*User)
+	return u
+}
+
+// UserFrom returns the name of the current user, or "" for anonymous requests.
+func UserFrom(ctx context.Context) string {
+	if u := CurrentUser(ctx); u != nil {
+		return u.Name
+	}
+	return ""
+}
+
+// requireAdmin wraps fn so only admins reach it.
+func (s *Server) requireAdmin(fn appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !CurrentUser(r.Context()).IsAdmin() {
+			return Forbidden("Only administrators can see this page.")
+		}
+		return fn(w, r)
+	}
+}
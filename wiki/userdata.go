@@ -0,0 +1,112 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// UserData is what the wiki keeps for each signed-in user, whether their
+// account is managed by the wiki or not.
+type UserData struct {
+	SavedSearches []*SavedSearch `json:"saved_searches,omitempty"`
+	// Favorites are the titles of the pages the user starred, in the order
+	// they were starred.
+	Favorites []string `json:"favorites,omitempty"`
+
+	// How the user sees times, the wiki's way where empty; see DateConfig.
+	TimeZone   string `json:"time_zone,omitempty"`
+	DateFormat string `json:"date_format,omitempty"`
+	TimeFormat string `json:"time_format,omitempty"`
+	// Locale writes their dates and numbers, their browser's where empty.
+	Locale string `json:"locale,omitempty"`
+}
+
+// UserDataStore is implemented by storage that keeps UserData. Wikis whose
+// storage doesn't implement it keep it in memory.
+type UserDataStore interface {
+	// UserData returns the data of user, empty if there is none yet.
+	UserData(ctx context.Context, user string) (*UserData, error)
+	SaveUserData(ctx context.Context, user string, d *UserData) error
+	DeleteUserData(ctx context.Context, user string) error
+}
+
+// updateUserData changes a user's data with fn. Changes are serialized, so
+// two requests from the same user don't undo each other.
+func (s *Server) updateUserData(ctx context.Context, user string, fn func(d *UserData) error) error {
+	s.userDataMu.Lock()
+	defer s.userDataMu.Unlock()
+
+	d, err := s.userData.UserData(ctx, user)
+	if err != nil {
+		return err
+	}
+	if err := fn(d); err != nil {
+		return err
+	}
+	return s.userData.SaveUserData(ctx, user, d)
+}
+
+// userDataMap keeps UserData in memory.
+type userDataMap struct {
+	mu    sync.Mutex
+	users map[string]*UserData
+}
+
+func (um *userDataMap) UserData(ctx context.Context, user string) (*UserData, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	d := &UserData{}
+	if stored, ok := um.users[user]; ok {
+		*d = *stored
+		d.SavedSearches = slices.Clone(stored.SavedSearches)
+		d.Favorites = slices.Clone(stored.Favorites)
+	}
+	return d, nil
+}
+
+func (um *userDataMap) SaveUserData(ctx context.Context, user string, d *UserData) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if um.users == nil {
+		um.users = make(map[string]*UserData)
+	}
+	um.users[user] = d
+	return nil
+}
+
+func (um *userDataMap) DeleteUserData(ctx context.Context, user string) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	delete(um.users, user)
+	return nil
+}
+
+// userDataPath is escaped like notificationsPath.
+func (st *FileStorage) userDataPath(user string) string {
+	return filepath.Join(st.dir, ".users", url.PathEscape(user)+".json")
+}
+
+func (st *FileStorage) UserData(ctx context.Context, user string) (*UserData, error) {
+	d := &UserData{}
+	return d, readJSONFile(st.userDataPath(user), d)
+}
+
+func (st *FileStorage) SaveUserData(ctx context.Context, user string, d *UserData) error {
+	return writeJSONFile(st.userDataPath(user), d)
+}
+
+func (st *FileStorage) DeleteUserData(ctx context.Context, user string) error {
+	err := os.Remove(st.userDataPath(user))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package wiki
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// UserInfo is an account as the admin pages and the API show it, without
+// its password.
+type UserInfo struct {
+	Name      string     `json:"name"`
+	Email     string     `json:"email,omitempty"`
+	Role      Role       `json:"role"`
+	Disabled  bool       `json:"disabled"`
+	MustReset bool       `json:"must_reset"`
+	Verified  bool       `json:"verified"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastLogin *time.Time `json:"last_login,omitempty"`
+}
+
+func userInfo(a *Account) *UserInfo {
+	return &UserInfo{
+		Name:      a.Name,
+		Email:     a.Email,
+		Role:      a.Role,
+		Disabled:  a.Disabled,
+		MustReset: a.MustReset,
+		Verified:  !a.Unverified,
+		CreatedAt: a.CreatedAt,
+		LastLogin: a.LastLogin,
+	}
+}
+
+// UserChange is what an admin can change about an account. Nil fields are
+// left as they are.
+type UserChange struct {
+	Role      *Role `json:"role"`
+	Disabled  *bool `json:"disabled"`
+	MustReset *bool `json:"must_reset"`
+	// Verified confirms the account's email address for its owner.
+	Verified *bool `json:"verified"`
+}
+
+func validRole(role Role) bool {
+	return role == RoleReader || role == RoleEditor || role == RoleAdmin
+}
+
+// changeUser applies an admin's change to an account. Admins can't disable
+// or demote themselves, so there is always one left. Disabling an account
+// or forcing a new password signs it out everywhere.
+func (s *Server) changeUser(ctx context.Context, name string, change *UserChange) (*Account, error) {
+	a, err := s.accounts.Account(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, NotFound("There is no such user.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	self := name == UserFrom(ctx)
+	if change.Role != nil {
+		if !validRole(*change.Role) {
+			return nil, NewError(http.StatusBadRequest, "The role must be reader, editor or admin.")
+		}
+		if self && *change.Role != RoleAdmin {
+			return nil, Forbidden("You can't remove your own admin rights.")
+		}
+		a.Role = *change.Role
+	}
+	if change.Disabled != nil {
+		if self && *change.Disabled {
+			return nil, Forbidden("You can't disable your own account.")
+		}
+		a.Disabled = *change.Disabled
+	}
+	if change.MustReset != nil {
+		a.MustReset = *change.MustReset
+	}
+	if change.Verified != nil {
+		a.Unverified = !*change.Verified
+	}
+
+	if err := s.accounts.UpdateAccount(ctx, a); err != nil {
+		return nil, err
+	}
+	if a.Disabled || (a.MustReset && !self) {
+		if err := s.logins.endAll(ctx, a.Name); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// UsersData is the data handed to the admin/users.html template.
+type UsersData struct {
+	Common
+	Users []*UserInfo
+	Roles []Role
+}
+
+func (s *Server) usersHandler(w http.ResponseWriter, r *http.Request) error {
+	accounts, err := s.accounts.Accounts(r.Context())
+	if err != nil {
+		return err
+	}
+	data := &UsersData{Roles: []Role{RoleReader, RoleEditor, RoleAdmin}}
+	for _, a := range accounts {
+		data.Users = append(data.Users, userInfo(a))
+	}
+	return s.renderTemplate(r.Context(), w, "admin/users.html", data)
+}
+
+// changeUserHandler takes the forms of admin/users.html, each naming one
+// action: role (with the new role), disable, enable, reset or verify.
+func (s *Server) changeUserHandler(w http.ResponseWriter, r *http.Request) error {
+	var change UserChange
+	yes, no := true, false
+	switch r.PostFormValue("action") {
+	case "role":
+		role := Role(r.PostFormValue("role"))
+		change.Role = &role
+	case "disable":
+		change.Disabled = &yes
+	case "enable":
+		change.Disabled = &no
+	case "reset":
+		change.MustReset = &yes
+	case "verify":
+		change.Verified = &yes
+	default:
+		return NewError(http.StatusBadRequest, "Unknown action.")
+	}
+
+	if _, err := s.changeUser(r.Context(), r.PathValue("name"), &change); err != nil {
+		return err
+	}
+	s.flash(w, r, FlashInfo, "The account of "+r.PathValue("name")+" was changed.")
+	http.Redirect(w, r, s.pagePath("admin", "users"), http.StatusSeeOther)
+	return nil
+}
+
+func (s *Server) apiUsersHandler(w http.ResponseWriter, r *http.Request) error {
+	accounts, err := s.accounts.Accounts(r.Context())
+	if err != nil {
+		return err
+	}
+	users := make([]*UserInfo, 0, len(accounts))
+	for _, a := range accounts {
+		users = append(users, userInfo(a))
+	}
+	return writeJSON(w, http.StatusOK, users)
+}
+
+func (s *Server) apiUserHandler(w http.ResponseWriter, r *http.Request) error {
+	a, err := s.accounts.Account(r.Context(), r.PathValue("name"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("There is no such user.")
+	}
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, userInfo(a))
+}
+
+// apiChangeUserHandler applies a UserChange sent as JSON and answers with
+// the updated user.
+func (s *Server) apiChangeUserHandler(w http.ResponseWriter, r *http.Request) error {
+	var change UserChange
+	if err := readJSON(w, r, &change); err != nil {
+		return err
+	}
+	a, err := s.changeUser(r.Context(), r.PathValue("name"), &change)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, userInfo(a))
+}
@@ -0,0 +1,73 @@
+package wiki
+
+import (
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Forms are checked field by field, and what is wrong with them is shown
+// next to the fields, in the form shown again with what the user entered,
+// rather than on an error page. Handlers collect FieldErrors and hand them
+// to the template of the form in its data's Form.
+
+// FieldErrors is what is wrong with a submitted form: a message for each
+// field, keyed by the name of its input. It is an error, so checks can
+// return it.
+type FieldErrors map[string]string
+
+// Add records message for field, unless the field has one already.
+func (fe FieldErrors) Add(field, message string) {
+	if _, ok := fe[field]; !ok {
+		fe[field] = message
+	}
+}
+
+// Check records the message of err for field, if err is not nil. The
+// messages of *Error are meant for users; others are not, and are replaced
+// with a generic one.
+func (fe FieldErrors) Check(field string, err error) {
+	if err == nil {
+		return
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		fe.Add(field, e.Message)
+		return
+	}
+	fe.Add(field, "This is not valid.")
+}
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field := range fe {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString(fe[field])
+	}
+	return b.String()
+}
+
+// Form is embedded in the data of templates with forms, to show one again
+// with what was wrong with it.
+type Form struct {
+	// Errors are the messages of the fields that didn't validate, as in
+	// {{with .Errors.to}}.
+	Errors FieldErrors
+	// Values are what was submitted, to fill the form in again, as in
+	// {{.Values.Get "to"}}.
+	Values url.Values
+}
+
+// Invalid reports whether field has an error, for marking its input.
+func (f Form) Invalid(field string) bool {
+	_, ok := f.Errors[field]
+	return ok
+}
@@ -0,0 +1,686 @@
+package wiki
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// An admin moves notes kept in Obsidian or Notion into the wiki by posting
+// a zip of them to /api/v1/import/obsidian or /api/v1/import/notion: the
+// vault folder zipped, or the file Notion's Markdown & CSV export makes.
+// Each Markdown file becomes a page, its folders the namespaces of its
+// title, below the one given as into. The links between notes point at
+// the pages, and the files they link to or embed are attached to the
+// first page that does. What can't be imported is reported rather than
+// failing the rest.
+
+// maxVaultBytes is the largest zip accepted, and the most the zips inside
+// one may unpack to, together.
+const maxVaultBytes = 512 << 20
+
+// vaultFormat holds the conventions of the exports of one tool.
+type vaultFormat struct {
+	// name turns a segment of a file's path, without ".md", into the
+	// name of the page or namespace.
+	name func(segment string) string
+	// frontMatter is set when notes start with YAML front matter, whose
+	// tags become the page's.
+	frontMatter bool
+	// wikiLinks is set when notes link as [[Note]] and embed as
+	// ![[file.png]], besides Markdown links.
+	wikiLinks bool
+}
+
+// notionID is the ID Notion appends to the names of the files and folders
+// of its pages.
+var notionID = regexp.MustCompile(`^(.+?) ?[0-9a-f]{32}$`)
+
+var vaultFormats = map[string]*vaultFormat{
+	"obsidian": {
+		name:        func(segment string) string { return segment },
+		frontMatter: true,
+		wikiLinks:   true,
+	},
+	"notion": {
+		name: func(segment string) string {
+			if m := notionID.FindStringSubmatch(segment); m != nil {
+				return m[1]
+			}
+			return segment
+		},
+	},
+}
+
+// ImportReport is the answer of the vault imports.
+type ImportReport struct {
+	// Pages are the titles of the pages saved.
+	Pages []string `json:"pages"`
+	// Attachments are the files attached, as "<title>/<name>".
+	Attachments []string `json:"attachments"`
+	// Skipped are the files of the zip left out, with why.
+	Skipped []ImportSkip `json:"skipped,omitempty"`
+}
+
+// ImportSkip is a file of an import that was left out.
+type ImportSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// vaultNote is a Markdown file of an import.
+type vaultNote struct {
+	path  string // without ".md"
+	file  *zip.File
+	title string
+}
+
+// vaultFile is any other file of an import, attached to the page of the
+// first note linking to it.
+type vaultFile struct {
+	path  string
+	file  *zip.File
+	title string
+	name  string
+}
+
+// vaultImport is an import in progress.
+type vaultImport struct {
+	s       *Server
+	format  *vaultFormat
+	into    string
+	replace bool
+
+	notes     map[string]*vaultNote
+	noteNames map[string]string // lower-cased base name to path
+	files     map[string]*vaultFile
+	fileNames map[string]string
+	// attached are the names given to files, by page, so two files of a
+	// name don't overwrite one another
+	attached map[string]map[string]bool
+
+	report ImportReport
+}
+
+// importVaultHandler imports the zip in the body, in the format of the
+// path, below the namespace of the into parameter. It refuses to replace
+// pages unless replace=1.
+func (s *Server) importVaultHandler(w http.ResponseWriter, r *http.Request) error {
+	format := vaultFormats[r.PathValue("format")]
+	if format == nil {
+		return NotFound("Imports are of obsidian or notion exports.")
+	}
+	into := strings.Trim(r.FormValue("into"), "/")
+	if into != "" {
+		if err := s.titles.check(into); err != nil {
+			return NewError(http.StatusBadRequest, "Invalid namespace: "+err.Error()+".")
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "gowiki-import-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxVaultBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("The export is too large; the limit is %d bytes.", tooLarge.Limit))
+		}
+		return err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return NewError(http.StatusBadRequest, "The body is not a zip file.")
+	}
+
+	vi := &vaultImport{
+		s:         s,
+		format:    format,
+		into:      into,
+		replace:   r.FormValue("replace") == "1",
+		notes:     make(map[string]*vaultNote),
+		noteNames: make(map[string]string),
+		files:     make(map[string]*vaultFile),
+		fileNames: make(map[string]string),
+		attached:  make(map[string]map[string]bool),
+		report:    ImportReport{Pages: []string{}, Attachments: []string{}},
+	}
+	entries, cleanup, err := vaultEntries(zr)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	vi.collect(entries)
+	if err := vi.run(r.Context()); err != nil {
+		return err
+	}
+	logf(r.Context(), "%d pages and %d files imported by %q", len(vi.report.Pages), len(vi.report.Attachments), UserFrom(r.Context()))
+	return writeJSON(w, http.StatusOK, &vi.report)
+}
+
+// vaultEntries returns the files of an export by their paths, those of the
+// zips inside it when it only holds zips, as Notion splits large exports.
+// A folder all the files are in, as when a vault is zipped, is left out of
+// the paths. cleanup removes the nested zips, which are refused past
+// maxVaultBytes so a small export can't fill the disk.
+func vaultEntries(zr *zip.Reader) (entries map[string]*zip.File, cleanup func(), err error) {
+	var tmps []*os.File
+	cleanup = func() {
+		for _, f := range tmps {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+
+	files := regularFiles(zr)
+	nested := len(files) > 0
+	for _, f := range files {
+		nested = nested && strings.EqualFold(path.Ext(f.Name), ".zip")
+	}
+	if nested {
+		var inner []*zip.File
+		budget := int64(maxVaultBytes)
+		for _, f := range files {
+			tmp, err := os.CreateTemp("", "gowiki-import-*.zip")
+			if err != nil {
+				return nil, cleanup, err
+			}
+			tmps = append(tmps, tmp)
+			rc, err := f.Open()
+			if err != nil {
+				return nil, cleanup, NewError(http.StatusBadRequest, "The zip "+f.Name+" can't be read.")
+			}
+			size, err := io.Copy(tmp, io.LimitReader(rc, budget+1))
+			rc.Close()
+			if err != nil {
+				return nil, cleanup, NewError(http.StatusBadRequest, "The zip "+f.Name+" can't be read.")
+			}
+			if size > budget {
+				return nil, cleanup, NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("The zips in the export are too large; together they may hold %d bytes.", maxVaultBytes))
+			}
+			budget -= size
+			part, err := zip.NewReader(tmp, size)
+			if err != nil {
+				return nil, cleanup, NewError(http.StatusBadRequest, f.Name+" is not a zip file.")
+			}
+			inner = append(inner, regularFiles(part)...)
+		}
+		files = inner
+	}
+
+	root := ""
+	for i, f := range files {
+		top, _, ok := strings.Cut(f.Name, "/")
+		if !ok || i > 0 && top != root {
+			root = ""
+			break
+		}
+		root = top
+	}
+	entries = make(map[string]*zip.File, len(files))
+	for _, f := range files {
+		name := f.Name
+		if root != "" {
+			name = strings.TrimPrefix(name, root+"/")
+		}
+		entries[name] = f
+	}
+	return entries, cleanup, nil
+}
+
+// regularFiles returns the files of a zip, leaving out folders and what
+// hides in them: the settings and trash of a vault, the resource forks of
+// zips made on a Mac.
+func regularFiles(zr *zip.Reader) []*zip.File {
+	var files []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.Contains(f.Name, `\`) {
+			continue
+		}
+		hidden := false
+		for _, segment := range strings.Split(f.Name, "/") {
+			if segment == "" || segment == ".." || strings.HasPrefix(segment, ".") || segment == "__MACOSX" {
+				hidden = true
+			}
+		}
+		if !hidden {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// collect sorts the entries into notes and files, and titles the notes.
+// Notes whose titles the wiki refuses, or taken by another note, are
+// skipped.
+func (vi *vaultImport) collect(entries map[string]*zip.File) {
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	titled := make(map[string]string)
+	for _, p := range paths {
+		if !strings.EqualFold(path.Ext(p), ".md") {
+			vi.files[p] = &vaultFile{path: p, file: entries[p]}
+			if base := strings.ToLower(path.Base(p)); vi.fileNames[base] == "" {
+				vi.fileNames[base] = p
+			}
+			continue
+		}
+		n := &vaultNote{path: p[:len(p)-len(".md")], file: entries[p]}
+		title, err := vi.title(n.path)
+		if err != nil {
+			vi.skip(p, "The page can't be titled: "+err.Error()+".")
+			continue
+		}
+		if other, ok := titled[strings.ToLower(title)]; ok {
+			vi.skip(p, fmt.Sprintf("%s.md is imported as %s already.", other, title))
+			continue
+		}
+		titled[strings.ToLower(title)] = n.path
+		n.title = title
+		vi.notes[n.path] = n
+		if base := strings.ToLower(path.Base(n.path)); vi.noteNames[base] == "" {
+			vi.noteNames[base] = n.path
+		}
+	}
+}
+
+// title returns the title of the page of the note at p, below into. When
+// the wiki refuses the names as they are, as its default pattern of
+// letters and digits does, they are squashed into CamelCase.
+func (vi *vaultImport) title(p string) (string, error) {
+	segments := strings.Split(p, "/")
+	names := make([]string, len(segments))
+	squashed := make([]string, len(segments))
+	for i, segment := range segments {
+		names[i] = vi.format.name(segment)
+		squashed[i] = squashTitle(names[i])
+	}
+	title := strings.Join(names, "/")
+	if vi.into != "" {
+		title = vi.into + "/" + title
+	}
+	if vi.s.titles.check(title) == nil {
+		return title, nil
+	}
+	title = strings.Join(squashed, "/")
+	if vi.into != "" {
+		title = vi.into + "/" + title
+	}
+	if err := vi.s.titles.check(title); err != nil {
+		return "", err
+	}
+	return title, nil
+}
+
+// squashTitle keeps the letters and digits of name, capitalizing the words
+// they form: "meeting notes (2)" is "MeetingNotes2".
+func squashTitle(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		r := []rune(word)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+func (vi *vaultImport) skip(p, reason string) {
+	vi.report.Skipped = append(vi.report.Skipped, ImportSkip{Path: p, Reason: reason})
+}
+
+// run saves the pages of the notes, then attaches the files they link to.
+func (vi *vaultImport) run(ctx context.Context) error {
+	paths := make([]string, 0, len(vi.notes))
+	for p := range vi.notes {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	saved := make(map[string]bool)
+	for _, p := range paths {
+		n := vi.notes[p]
+		if err := vi.importNote(ctx, n); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			vi.skip(p+".md", userError(err).Message)
+			continue
+		}
+		saved[n.title] = true
+		vi.report.Pages = append(vi.report.Pages, n.title)
+	}
+
+	paths = paths[:0]
+	for p := range vi.files {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	recount := make(map[string]bool)
+	for _, p := range paths {
+		f := vi.files[p]
+		switch {
+		case f.title == "":
+			vi.skip(p, "No page links to the file.")
+		case !saved[f.title]:
+			vi.skip(p, "The page linking to the file, "+f.title+", wasn't imported.")
+		default:
+			if err := vi.attach(ctx, f); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				vi.skip(p, userError(err).Message)
+				continue
+			}
+			recount[f.title] = true
+			vi.report.Attachments = append(vi.report.Attachments, f.title+"/"+f.name)
+		}
+	}
+	for title := range recount {
+		if err := vi.s.recountAttachments(ctx, title); err != nil {
+			logf(ctx, "counting attachments of %s: %v", title, err)
+		}
+	}
+	return nil
+}
+
+// importNote saves the page of n, as a batch operation would.
+func (vi *vaultImport) importNote(ctx context.Context, n *vaultNote) error {
+	body, err := readZipFile(n.file, vi.s.cfg.MaxBodyBytes)
+	if err != nil {
+		return err
+	}
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+	var tags []string
+	if vi.format.frontMatter {
+		tags, text = splitFrontMatter(text)
+	}
+	op := &BatchOperation{Op: OpCreate, Title: n.title, Body: vi.relink(n, text), Tags: tags}
+	if _, err := vi.s.loadPage(ctx, n.title); err == nil {
+		if !vi.replace {
+			return NewError(http.StatusConflict, "The page "+n.title+" already exists; add replace=1 to replace it.")
+		}
+		op.Op = OpUpdate
+	}
+	_, err = vi.s.applyOperation(ctx, op)
+	return err
+}
+
+// attach attaches f to its page, as uploading it would.
+func (vi *vaultImport) attach(ctx context.Context, f *vaultFile) error {
+	as, err := vi.s.attachmentStore()
+	if err != nil {
+		return err
+	}
+	max := vi.s.cfg.Attachments.maxBytes()
+	if int64(f.file.UncompressedSize64) > max {
+		return NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("The file is too large; the limit is %d bytes.", max))
+	}
+	data, err := readZipFile(f.file, max)
+	if err != nil {
+		return err
+	}
+	if err := vi.s.cfg.Attachments.checkType(f.name, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if err := vi.s.checkQuota(ctx, usageItem{Title: f.title, File: f.name, Owner: UserFrom(ctx), Bytes: int64(len(data))}); err != nil {
+		return err
+	}
+	u := &Upload{Title: f.title, Name: f.name, Size: int64(len(data)), Content: bytes.NewReader(data)}
+	if err := vi.s.uploading(ctx, u); err != nil {
+		return err
+	}
+	return as.Attach(ctx, f.title, f.name, bytes.NewReader(data))
+}
+
+// readZipFile reads f, refusing it past max bytes unless max is zero.
+func readZipFile(f *zip.File, max int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, "The file can't be read from the zip.")
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if max > 0 {
+		r = io.LimitReader(rc, max+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, "The file can't be read from the zip.")
+	}
+	if max > 0 && int64(len(data)) > max {
+		return nil, NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("The file is too large; the limit is %d bytes.", max))
+	}
+	return data, nil
+}
+
+// splitFrontMatter returns the tags of a note's YAML front matter, and the
+// note without it. Only the tags are understood; the rest of the front
+// matter is dropped.
+func splitFrontMatter(text string) (tags []string, rest string) {
+	if !strings.HasPrefix(text, "---\n") {
+		return nil, text
+	}
+	head, rest, ok := strings.Cut(text[len("---\n"):], "\n---")
+	if !ok {
+		return nil, text
+	}
+	if _, rest, ok = strings.Cut(rest, "\n"); !ok {
+		rest = ""
+	}
+
+	inTags := false
+	for _, line := range strings.Split(head, "\n") {
+		if inTags {
+			if item, ok := strings.CutPrefix(strings.TrimSpace(line), "- "); ok {
+				tags = append(tags, item)
+				continue
+			}
+			inTags = false
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key != "tags" && key != "tag" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			inTags = true
+			continue
+		}
+		value = strings.Trim(value, "[]")
+		tags = append(tags, strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })...)
+	}
+	for i, t := range tags {
+		tags[i] = strings.TrimPrefix(strings.Trim(strings.TrimSpace(t), `"'`), "#")
+	}
+	return slices.DeleteFunc(tags, func(t string) bool { return t == "" }), strings.TrimLeft(rest, "\n")
+}
+
+var (
+	// vaultWikiLink is an Obsidian link or embed: [[Note]], [[Note|text]],
+	// [[Note#Heading]] or ![[image.png]].
+	vaultWikiLink = regexp.MustCompile(`(!?)\[\[([^\[\]|]+)(?:\|([^\[\]]*))?\]\]`)
+	// vaultMdLink is a Markdown link or image, its target escaped or in
+	// angle brackets.
+	vaultMdLink = regexp.MustCompile(`(!?)\[([^\[\]]*)\]\((<[^<>]+>|[^()\s]+)\)`)
+)
+
+// relink points the links of note n at the pages and attachments they
+// become, leaving code alone. Links to notes that aren't in the export,
+// such as ones not written yet, point where their pages would be.
+func (vi *vaultImport) relink(n *vaultNote, text string) string {
+	lines := strings.Split(text, "\n")
+	inCode := false
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		// the odd pieces are code spans
+		pieces := strings.Split(line, "`")
+		for j := 0; j < len(pieces); j += 2 {
+			if vi.format.wikiLinks {
+				pieces[j] = vaultWikiLink.ReplaceAllStringFunc(pieces[j], func(m string) string {
+					parts := vaultWikiLink.FindStringSubmatch(m)
+					return vi.wikiLink(n, parts[1] == "!", parts[2], parts[3], m)
+				})
+			}
+			pieces[j] = vaultMdLink.ReplaceAllStringFunc(pieces[j], func(m string) string {
+				parts := vaultMdLink.FindStringSubmatch(m)
+				return vi.mdLink(n, parts[1], parts[2], parts[3], m)
+			})
+		}
+		lines[i] = strings.Join(pieces, "`")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (vi *vaultImport) wikiLink(n *vaultNote, embed bool, target, text, m string) string {
+	target, heading, _ := strings.Cut(strings.TrimSpace(target), "#")
+	if text == "" {
+		text = path.Base(target)
+		if target == "" {
+			text = heading
+		} else if heading != "" {
+			text += " > " + heading
+		}
+	}
+	if target == "" {
+		return text
+	}
+	if ext := path.Ext(target); ext != "" && !strings.EqualFold(ext, ".md") {
+		href := vi.fileLink(n, target)
+		if href == "" {
+			return m
+		}
+		if embed {
+			return "![" + text + "](" + href + ")"
+		}
+		return "[" + text + "](" + href + ")"
+	}
+	href := vi.pageLink(n, target)
+	if href == "" {
+		return text
+	}
+	return "[" + text + "](" + href + ")"
+}
+
+func (vi *vaultImport) mdLink(n *vaultNote, bang, text, target, m string) string {
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	if strings.HasPrefix(target, "/") || strings.HasPrefix(target, "#") || strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:") {
+		return m
+	}
+	if t, err := url.PathUnescape(target); err == nil {
+		target = t
+	}
+	target, _, _ = strings.Cut(target, "#")
+	var href string
+	if ext := path.Ext(target); ext != "" && !strings.EqualFold(ext, ".md") {
+		href = vi.fileLink(n, target)
+	} else if vi.findNote(n, target) != nil {
+		href = vi.pageLink(n, target)
+	}
+	if href == "" {
+		return m
+	}
+	return bang + "[" + text + "](" + href + ")"
+}
+
+// findNote returns the note target names from n: a path relative to n or
+// to the export, or a note's name alone, as Obsidian allows.
+func (vi *vaultImport) findNote(n *vaultNote, target string) *vaultNote {
+	target = strings.TrimSuffix(target, ".md")
+	for _, p := range []string{path.Join(path.Dir(n.path), target), path.Clean(target)} {
+		if note := vi.notes[p]; note != nil {
+			return note
+		}
+	}
+	if p := vi.noteNames[strings.ToLower(path.Base(target))]; p != "" {
+		return vi.notes[p]
+	}
+	return nil
+}
+
+// pageLink returns the link to the page of the note target names from n,
+// or to where it would be when the export hasn't a note of that name. It
+// is empty when the target can't be a title.
+func (vi *vaultImport) pageLink(n *vaultNote, target string) string {
+	title := ""
+	if note := vi.findNote(n, target); note != nil {
+		title = note.title
+	} else if t, err := vi.title(path.Clean(strings.TrimSuffix(target, ".md"))); err == nil {
+		title = t
+	}
+	if title == "" {
+		return ""
+	}
+	return vi.s.pagePath("view", "") + "/" + escapeTitle(title)
+}
+
+// fileLink returns the link to the attachment target names from n,
+// attaching the file to n's page unless a page linked to it before. It is
+// empty when the export hasn't the file.
+func (vi *vaultImport) fileLink(n *vaultNote, target string) string {
+	var f *vaultFile
+	for _, p := range []string{path.Join(path.Dir(n.path), target), path.Clean(target)} {
+		if f = vi.files[p]; f != nil {
+			break
+		}
+	}
+	if f == nil {
+		if p := vi.fileNames[strings.ToLower(path.Base(target))]; p != "" {
+			f = vi.files[p]
+		}
+	}
+	if f == nil {
+		return ""
+	}
+	if f.title == "" {
+		name, err := cleanAttachmentName(path.Base(f.path))
+		if err != nil {
+			return ""
+		}
+		names := vi.attached[n.title]
+		if names == nil {
+			names = make(map[string]bool)
+			vi.attached[n.title] = names
+		}
+		base, ext := strings.TrimSuffix(name, path.Ext(name)), path.Ext(name)
+		for i := 2; names[strings.ToLower(name)]; i++ {
+			name = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		names[strings.ToLower(name)] = true
+		f.title, f.name = n.title, name
+	}
+	return vi.s.pagePath("attachment", "") + "/" + escapeTitle(f.title) + "/" + url.PathEscape(f.name)
+}
+
+// escapeTitle escapes the segments of a title for a path, so titles with
+// spaces make links Markdown can tell apart.
+func escapeTitle(title string) string {
+	segments := strings.Split(title, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
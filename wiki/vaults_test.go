@@ -0,0 +1,165 @@
+package wiki_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ondoheer/gowiki/wiki"
+	"github.com/ondoheer/gowiki/wiki/wikitest"
+)
+
+// zipOf returns a zip of the files, by path.
+func zipOf(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// importVault posts the body to the import path and returns the status
+// and report.
+func importVault(t *testing.T, c *wikitest.Client, p string, body []byte) (int, *wiki.ImportReport) {
+	t.Helper()
+	resp, err := c.HTTP.Post(c.URL(p), "application/zip", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := wikitest.ReadBody(t, resp)
+	var report wiki.ImportReport
+	if resp.StatusCode == http.StatusOK {
+		if err := json.Unmarshal([]byte(data), &report); err != nil {
+			t.Fatalf("%s: %v: %s", p, err, data)
+		}
+	}
+	return resp.StatusCode, &report
+}
+
+func newImportWiki(t *testing.T) (*wikitest.Wiki, *wikitest.Client) {
+	w := wikitest.New(t, func(cfg *wiki.Config) {
+		cfg.Storage = wiki.NewFileStorage(t.TempDir())
+		cfg.Titles.Pattern = `^[a-zA-Z0-9/]+$`
+	})
+	return w, w.Login("ann", wiki.RoleAdmin)
+}
+
+func TestImportObsidian(t *testing.T) {
+	w, c := newImportWiki(t)
+	vault := zipOf(t, map[string]string{
+		"Vault/.obsidian/app.json":   "{}",
+		"Vault/Home.md":              "---\ntags: [a, \"b\"]\n---\n# Hi\nSee [[Daily notes/Today|today]], [[Other#Sec]] and [[Missing note]].\n`[[Home]]`",
+		"Vault/Daily notes/Today.md": "back to [[Home]] ![[pic.png]]",
+		"Vault/Other.md":             "x",
+		"Vault/pic.png":              "\x89PNG\r\n\x1a\nxxxx",
+		"Vault/unused.pdf":           "x",
+	})
+
+	status, report := importVault(t, c, "/api/v1/import/obsidian?into=Notes", vault)
+	if status != http.StatusOK {
+		t.Fatalf("importing: %d", status)
+	}
+	if got := strings.Join(report.Pages, " "); got != "Notes/DailyNotes/Today Notes/Home Notes/Other" {
+		t.Errorf("pages imported: %s", got)
+	}
+	if got := strings.Join(report.Attachments, " "); got != "Notes/DailyNotes/Today/pic.png" {
+		t.Errorf("files attached: %s", got)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Path != "unused.pdf" {
+		t.Errorf("skipped: %+v, want unused.pdf", report.Skipped)
+	}
+
+	ctx := w.Context(nil)
+	home, err := w.Server.GetPage(ctx, "Notes/Home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"[today](/view/Notes/DailyNotes/Today)", "[Other > Sec](/view/Notes/Other)", "[Missing note](/view/Notes/MissingNote)", "`[[Home]]`"} {
+		if !strings.Contains(string(home.Body), want) {
+			t.Errorf("Notes/Home has no %s: %s", want, home.Body)
+		}
+	}
+	if strings.Contains(string(home.Body), "tags:") || strings.Join(home.Tags, " ") != "a b" {
+		t.Errorf("Notes/Home has tags %v: %s", home.Tags, home.Body)
+	}
+	if html := c.GetRendered("Notes/DailyNotes/Today"); !strings.Contains(html, "/attachment/Notes/DailyNotes/Today/pic.png") {
+		t.Errorf("Notes/DailyNotes/Today doesn't show pic.png: %s", html)
+	}
+
+	// importing again replaces nothing without replace=1
+	status, report = importVault(t, c, "/api/v1/import/obsidian?into=Notes", vault)
+	if status != http.StatusOK || len(report.Pages) != 0 || len(report.Attachments) != 0 {
+		t.Errorf("importing again: %d: %+v", status, report)
+	}
+	status, report = importVault(t, c, "/api/v1/import/obsidian?into=Notes&replace=1", vault)
+	if status != http.StatusOK || len(report.Pages) != 3 {
+		t.Errorf("importing again with replace=1: %d: %+v", status, report)
+	}
+}
+
+func TestImportNotion(t *testing.T) {
+	w, c := newImportWiki(t)
+	export := zipOf(t, map[string]string{
+		"Project Plan 0123456789abcdef0123456789abcdef.md":                                        "# Project Plan\n[Tasks](Project%20Plan%200123456789abcdef0123456789abcdef/Tasks%20fedcba9876543210fedcba9876543210.md)\n![diagram.png](Project%20Plan%200123456789abcdef0123456789abcdef/diagram.png)",
+		"Project Plan 0123456789abcdef0123456789abcdef/Tasks fedcba9876543210fedcba9876543210.md": "# Tasks",
+		"Project Plan 0123456789abcdef0123456789abcdef/diagram.png":                               "\x89PNG\r\n\x1a\nzz",
+	})
+	// Notion splits large exports into zips inside the zip
+	outer := zipOf(t, map[string]string{"Export-1-Part-1.zip": string(export)})
+
+	status, report := importVault(t, c, "/api/v1/import/notion", outer)
+	if status != http.StatusOK {
+		t.Fatalf("importing: %d", status)
+	}
+	if got := strings.Join(report.Pages, " "); got != "ProjectPlan ProjectPlan/Tasks" {
+		t.Errorf("pages imported: %s", got)
+	}
+	p, err := w.Server.GetPage(w.Context(nil), "ProjectPlan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"[Tasks](/view/ProjectPlan/Tasks)", "(/attachment/ProjectPlan/diagram.png)"} {
+		if !strings.Contains(string(p.Body), want) {
+			t.Errorf("ProjectPlan has no %s: %s", want, p.Body)
+		}
+	}
+	resp := c.Get("/attachment/ProjectPlan/diagram.png")
+	if body := wikitest.ReadBody(t, resp); resp.StatusCode != http.StatusOK || !strings.HasSuffix(body, "zz") {
+		t.Errorf("GET the diagram: %s", resp.Status)
+	}
+}
+
+func TestImportRefused(t *testing.T) {
+	w, admin := newImportWiki(t)
+	vault := zipOf(t, map[string]string{"Home.md": "x"})
+	for _, tc := range []struct {
+		name string
+		c    *wikitest.Client
+		path string
+		body []byte
+		want int
+	}{
+		{"not a zip", admin, "/api/v1/import/obsidian", []byte("hello"), http.StatusBadRequest},
+		{"a bad namespace", admin, "/api/v1/import/obsidian?into=..", vault, http.StatusBadRequest},
+		{"by an editor", w.Login("ed", wiki.RoleEditor), "/api/v1/import/obsidian", vault, http.StatusForbidden},
+	} {
+		if status, _ := importVault(t, tc.c, tc.path, tc.body); status != tc.want {
+			t.Errorf("%s: %d, want %d", tc.name, status, tc.want)
+		}
+	}
+	if _, err := w.Server.GetPage(w.Context(nil), "Home"); err == nil {
+		t.Error("a refused import saved Home")
+	}
+}
@@ -0,0 +1,206 @@
+package wiki
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With AccountsConfig.VerifyEmail, accounts made by signing up start out
+// unverified: they can read, but not change anything, until their owner
+// opens the link mailed to them. The link is signed and names the address
+// it was sent to, so it stops working once the address changes, and it
+// expires after verifyTTL.
+
+const verifyTTL = 48 * time.Hour
+
+// JobVerifyEmail mails an account the link confirming its address. Its
+// payload is a VerifyEvent.
+const JobVerifyEmail = "account.verify"
+
+// VerifyEvent is the payload of JobVerifyEmail.
+type VerifyEvent struct {
+	Name string `json:"name"`
+}
+
+// verifyLimit is the verification mails one account can ask for.
+var verifyLimit = rateLimit{Name: "verify", Max: 3, Window: time.Hour}
+
+// VerifyData is the data handed to the verify.html template.
+type VerifyData struct {
+	Common
+	Name  string
+	Email string
+	// Verified is set once the link was opened.
+	Verified bool
+	Error    string
+}
+
+func checkEmail(email string) error {
+	if a, err := mail.ParseAddress(email); err != nil || a.Address != email {
+		return NewError(http.StatusBadRequest, "That is not an email address.")
+	}
+	return nil
+}
+
+// verifyToken returns the token of the link verifying a's address.
+func (s *Server) verifyToken(a *Account, expires time.Time) string {
+	value := a.Name + "\n" + a.Email + "\n" + strconv.FormatInt(expires.Unix(), 10)
+	return s.keys.signValue("verify", base64.RawURLEncoding.EncodeToString([]byte(value)))
+}
+
+// sendVerification mails the link of a JobVerifyEmail.
+func (s *Server) sendVerification(ctx context.Context, job *Job) error {
+	var ev VerifyEvent
+	if err := job.Decode(&ev); err != nil {
+		return err
+	}
+	a, err := s.accounts.Account(ctx, ev.Name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !a.Unverified || a.Email == "" {
+		return nil
+	}
+
+	link := s.absoluteURL(s.pagePath("account", "verify")) + "?token=" + url.QueryEscape(s.verifyToken(a, time.Now().Add(verifyTTL)))
+	body := fmt.Sprintf("Hello %s,\n\nopen this link to confirm your address and start editing the wiki:\n%s\n\nIt works for %d hours. If you didn't sign up, ignore this mail.\n",
+		a.Name, link, int(verifyTTL.Hours()))
+	return s.mailer.Send(ctx, []string{a.Email}, "Confirm your email address", body)
+}
+
+// verifyFormHandler verifies the address of the token in the link, or
+// shows the signed-in user where their link went.
+func (s *Server) verifyFormHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if token := r.FormValue("token"); token != "" {
+		return s.verifyEmail(w, r, token)
+	}
+	a, err := s.currentAccount(ctx)
+	if err != nil {
+		return err
+	}
+	return s.renderTemplate(ctx, w, "verify.html", &VerifyData{Name: a.Name, Email: a.Email, Verified: !a.Unverified})
+}
+
+// verifyEmail marks the account of token verified. Opening the link
+// doesn't need the user signed in, as mail is often read elsewhere.
+func (s *Server) verifyEmail(w http.ResponseWriter, r *http.Request, token string) error {
+	ctx := r.Context()
+	signed, _ := s.keys.openValue("verify", token)
+	value, _ := base64.RawURLEncoding.DecodeString(signed)
+	parts := strings.Split(string(value), "\n")
+	if len(parts) != 3 {
+		return NotFound("This link is not valid.")
+	}
+	name, email := parts[0], parts[1]
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return NotFound("This link is not valid.")
+	}
+
+	a, err := s.accounts.Account(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NotFound("This account no longer exists.")
+	}
+	if err != nil {
+		return err
+	}
+	data := &VerifyData{Name: a.Name, Email: a.Email, Verified: !a.Unverified}
+	switch {
+	case data.Verified:
+	case a.Email != email:
+		data.Error = "This link was sent to an address the account no longer has."
+	case time.Now().Unix() > expires:
+		data.Error = "This link expired."
+	default:
+		a.Unverified = false
+		if err := s.accounts.UpdateAccount(ctx, a); err != nil {
+			return err
+		}
+		data.Verified = true
+	}
+	status := http.StatusOK
+	if data.Error != "" {
+		status = http.StatusGone
+	}
+	return s.writeTemplate(ctx, w, status, "verify.html", data)
+}
+
+// resendHandler mails the signed-in user a new link, to a new address if
+// they give one.
+func (s *Server) resendHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	a, err := s.currentAccount(ctx)
+	if err != nil {
+		return err
+	}
+	data := &VerifyData{Name: a.Name, Email: a.Email, Verified: !a.Unverified}
+	fail := func(status int, message string) error {
+		data.Error = message
+		return s.writeTemplate(ctx, w, status, "verify.html", data)
+	}
+	if !a.Unverified {
+		return fail(http.StatusConflict, "Your address is confirmed already.")
+	}
+
+	limited, err := s.overLimit(ctx, verifyLimit, a.Name)
+	if err != nil {
+		return err
+	}
+	if limited {
+		return fail(http.StatusTooManyRequests, "Too many mails were sent; try again later.")
+	}
+	if email := strings.TrimSpace(r.PostFormValue("email")); email != "" && email != a.Email {
+		if err := checkEmail(email); err != nil {
+			data.Email = email
+			return fail(http.StatusBadRequest, err.(*Error).Message)
+		}
+		a.Email = email
+		if err := s.accounts.UpdateAccount(ctx, a); err != nil {
+			return err
+		}
+	}
+	if err := s.countAttempt(ctx, verifyLimit, a.Name); err != nil {
+		return err
+	}
+	s.enqueue(ctx, JobVerifyEmail, VerifyEvent{Name: a.Name})
+	s.flash(w, r, FlashInfo, "A new link is on its way to "+a.Email+".")
+	http.Redirect(w, r, s.pagePath("account", "verify"), http.StatusSeeOther)
+	return nil
+}
+
+// unverifiedAllowed reports whether a user whose address isn't verified
+// yet may make r: reading, and managing their own account. The editor and
+// the WebSocket of live editing are refused though opened with GET.
+func (s *Server) unverifiedAllowed(r *http.Request) bool {
+	path := r.URL.Path
+	if path == s.pagePath("logout", "") || path == s.cfg.BasePath+apiPrefix+"/session" || strings.HasPrefix(path, s.pagePath("account", "")+"/") {
+		return true
+	}
+	if strings.HasPrefix(path, s.pagePath("edit", "")+"/") || strings.HasPrefix(path, s.pagePath("collab", "")+"/") {
+		return false
+	}
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// refuseUnverified answers the requests unverifiedAllowed refuses.
+func (s *Server) refuseUnverified(w http.ResponseWriter, r *http.Request) {
+	err := Forbidden("Confirm your email address before changing the wiki; the link is in the mail you were sent.")
+	if strings.HasPrefix(r.URL.Path, s.cfg.BasePath+apiPrefix+"/") {
+		writeJSON(w, err.Status, apiError{Error: err.Message})
+		return
+	}
+	s.renderError(w, r, err)
+}
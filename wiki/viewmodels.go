@@ -0,0 +1,75 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+)
+
+// Every template is handed one of the *Data types of the package as its
+// dot, a different one for each template and named after it, and every
+// one of them embeds Common. writeTemplate fills Common in, so layouts
+// and themes can count on its fields whichever page they wrap.
+
+// Common is what every template is handed besides its own data.
+type Common struct {
+	// User is who is reading, nil when they aren't signed in.
+	User *User
+	// Flash holds the messages left for the reader by what they did last,
+	// shown once.
+	Flash []Flash
+	// BaseURL is the address the wiki is reached at, Config.PublicURL, or
+	// empty when that isn't set.
+	BaseURL string
+	// BasePath is the prefix of the wiki's routes, empty at the root.
+	BasePath string
+}
+
+func (c *Common) common() *Common { return c }
+
+// templateData is the data of a template: a *Data type, embedding Common.
+type templateData interface {
+	common() *Common
+}
+
+// commonData returns the Common fields of a template rendered for ctx into
+// w, taking the flash messages it shows.
+func (s *Server) commonData(ctx context.Context, w http.ResponseWriter) Common {
+	return Common{
+		User:     CurrentUser(ctx),
+		Flash:    s.takeFlashes(ctx, w),
+		BaseURL:  s.cfg.PublicURL,
+		BasePath: s.cfg.BasePath,
+	}
+}
+
+// ViewPageData is the data handed to the view.html template: the page,
+// whose fields it takes on.
+type ViewPageData struct {
+	Common
+	*Page
+	// Form holds what was wrong with one of the page's forms, such as
+	// renaming it or attaching a file.
+	Form
+}
+
+// EditPageData is the data handed to the edit.html template, and to
+// held.html once the edit is held for review.
+type EditPageData struct {
+	Common
+	*Page
+	Form
+}
+
+// pageOf returns the page a template shows, for the template functions
+// taking its dot, or nil if it doesn't show one.
+func pageOf(data interface{}) *Page {
+	switch d := data.(type) {
+	case *ViewPageData:
+		return d.Page
+	case *EditPageData:
+		return d.Page
+	case *Page:
+		return d
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package wiki
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ViewCounter is implemented by storages that persist page view counts.
+// Servers whose storage doesn't implement it count views in memory.
+type ViewCounter interface {
+	// CountView records one view of title and returns the new total.
+	CountView(ctx context.Context, title string) (int64, error)
+	// ViewCounts returns the totals of every page viewed at least once.
+	ViewCounts(ctx context.Context) (map[string]int64, error)
+	// ForgetViews drops the count of a deleted page.
+	ForgetViews(ctx context.Context, title string) error
+}
+
+// viewCounts is an in-memory ViewCounter, also used by FileStorage.
+type viewCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	dirty  bool
+}
+
+func (vc *viewCounts) CountView(ctx context.Context, title string) (int64, error) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	if vc.counts == nil {
+		vc.counts = make(map[string]int64)
+	}
+	vc.counts[title]++
+	vc.dirty = true
+	return vc.counts[title], nil
+}
+
+func (vc *viewCounts) ViewCounts(ctx context.Context) (map[string]int64, error) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	counts := make(map[string]int64, len(vc.counts))
+	for title, n := range vc.counts {
+		counts[title] = n
+	}
+	return counts, nil
+}
+
+func (vc *viewCounts) ForgetViews(ctx context.Context, title string) error {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+
+	delete(vc.counts, title)
+	vc.dirty = true
+	return nil
+}
+
+// countView records a view of p. Counting is best effort: a failure is
+// logged and the page is shown anyway. Only the title is recorded, nothing
+// about the reader.
+func (s *Server) countView(r *http.Request, p *Page) {
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, err := s.views.CountView(r.Context(), p.Title)
+	if err != nil {
+		logf(r.Context(), "counting view of %s: %v", p.Title, err)
+		return
+	}
+	p.Views = n
+}
+
+// popularLimit is how many pages /special/popular lists.
+const popularLimit = 50
+
+// PopularData is the data handed to the special/popular.html template.
+type PopularData struct {
+	Common
+	Pages []*Page
+}
+
+func (s *Server) popularHandler(w http.ResponseWriter, r *http.Request) error {
+	counts, err := s.views.ViewCounts(r.Context())
+	if err != nil {
+		return err
+	}
+	pages, err := s.listPages(r.Context())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var popular []*Page
+	for _, p := range s.readablePages(r.Context(), pages) {
+		if n := counts[p.Title]; n > 0 && !s.isArchived(p, now) {
+			p.Views = n
+			popular = append(popular, p)
+		}
+	}
+	sort.SliceStable(popular, func(i, j int) bool { return popular[i].Views > popular[j].Views })
+	if len(popular) > popularLimit {
+		popular = popular[:popularLimit]
+	}
+
+	return s.renderTemplate(r.Context(), w, "special/popular.html", &PopularData{Pages: popular})
+}
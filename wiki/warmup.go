@@ -0,0 +1,121 @@
+package wiki
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+// WarmUpConfig has the wiki get ready before taking traffic: when Handler
+// starts it, the search index is built and the most viewed pages are
+// rendered into the cache, so the first readers after a deploy don't wait
+// for either. Ready reports false until it is done.
+type WarmUpConfig struct {
+	Enabled bool `json:"enabled"`
+	// Pages is how many of the most viewed pages are rendered. Zero means
+	// defaultWarmUpPages.
+	Pages int `json:"pages"`
+	// MaxSeconds bounds the warm-up; the wiki is ready once it's past,
+	// warm or not. Zero means defaultWarmUpSeconds.
+	MaxSeconds int `json:"max_seconds"`
+}
+
+const (
+	defaultWarmUpPages   = 100
+	defaultWarmUpSeconds = 60
+)
+
+func (c WarmUpConfig) pages() int {
+	if c.Pages > 0 {
+		return c.Pages
+	}
+	return defaultWarmUpPages
+}
+
+func (c WarmUpConfig) timeout() time.Duration {
+	if c.MaxSeconds > 0 {
+		return time.Duration(c.MaxSeconds) * time.Second
+	}
+	return defaultWarmUpSeconds * time.Second
+}
+
+// Ready reports whether the wiki is done warming up, which it always is
+// without WarmUpConfig. Load balancers learn it from /readyz.
+func (s *Server) Ready() bool {
+	return !s.cfg.WarmUp.Enabled || s.warm.Load()
+}
+
+// warmUp builds the search index and renders the most viewed pages anyone
+// may read, then marks the wiki ready, even if some of it failed: a cold
+// wiki is slow, not broken.
+func (s *Server) warmUp() {
+	defer s.warm.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.WarmUp.timeout())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	start := time.Now()
+	if err := s.ensureIndex(ctx); err != nil {
+		log.Printf("warm-up: building the search index: %v", err)
+	}
+	rendered, err := s.warmPages(ctx)
+	if err != nil {
+		log.Printf("warm-up: rendering pages: %v", err)
+	}
+	log.Printf("warm-up: ready in %v, %d pages rendered", time.Since(start).Round(time.Millisecond), rendered)
+}
+
+// warmPages renders the most viewed pages into the cache, like their view
+// would, and returns how many it did.
+func (s *Server) warmPages(ctx context.Context) (int, error) {
+	counts, err := s.views.ViewCounts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	pages, err := s.listPages(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var popular []*Page
+	for _, p := range s.readablePages(ctx, pages) {
+		if n := counts[p.Title]; n > 0 && !s.isArchived(p, now) {
+			p.Views = n
+			popular = append(popular, p)
+		}
+	}
+	sort.SliceStable(popular, func(i, j int) bool { return popular[i].Views > popular[j].Views })
+	if n := s.cfg.WarmUp.pages(); len(popular) > n {
+		popular = popular[:n]
+	}
+
+	rendered := 0
+	for _, meta := range popular {
+		if err := ctx.Err(); err != nil {
+			return rendered, err
+		}
+		p, err := s.loadPageToShow(ctx, meta.Title)
+		if err != nil {
+			logf(ctx, "warm-up: %s: %v", meta.Title, err)
+			continue
+		}
+		if p.TooLarge {
+			continue
+		}
+		if _, err := s.markdown(p.Body); err != nil {
+			logf(ctx, "warm-up: %s: %v", meta.Title, err)
+			continue
+		}
+		rendered++
+	}
+	return rendered, nil
+}
@@ -0,0 +1,127 @@
+package wiki
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// JobWebhook delivers an event to one webhook. Its payload is a
+// WebhookDelivery. Each webhook gets its own job, so one that is down is
+// retried alone, without notifying or indexing again.
+const JobWebhook = "webhook.deliver"
+
+// WebhookConfig has the wiki post its events to a URL, as the JSON of an
+// Event. Requests carry the kind of event in X-Wiki-Event and, with a
+// secret, the hex HMAC-SHA256 of the body in X-Wiki-Signature, as
+// "sha256=<hmac>". Answers other than 2xx are retried.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Events are the kinds posted, such as "page.saved". None posts them
+	// all.
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+func (c WebhookConfig) check() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhooks: %q is not an http or https URL", c.URL)
+	}
+	for _, kind := range c.Events {
+		if !slices.Contains(eventKinds, kind) {
+			return fmt.Errorf("webhooks: %s: unknown event %q", c.URL, kind)
+		}
+	}
+	return nil
+}
+
+// WebhookDelivery is the payload of JobWebhook: the event, and the index
+// of the webhook in Config.Webhooks.
+type WebhookDelivery struct {
+	Webhook int   `json:"webhook"`
+	Event   Event `json:"event"`
+}
+
+const webhookTimeout = 10 * time.Second
+
+// webhookClient doesn't follow redirects: a webhook that moved is
+// configured again.
+var webhookClient = &http.Client{
+	Timeout:       webhookTimeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// subscribeWebhooks checks the webhooks and has their deliveries run.
+func (s *Server) subscribeWebhooks() error {
+	if len(s.cfg.Webhooks) == 0 {
+		return nil
+	}
+	for _, wh := range s.cfg.Webhooks {
+		if err := wh.check(); err != nil {
+			return err
+		}
+	}
+	s.jobs.Handle(JobWebhook, s.deliverWebhook)
+	return nil
+}
+
+// queueWebhooks enqueues a delivery of ev to each webhook taking it, as
+// it is published rather than from a subscriber, so a delivery is never
+// queued twice.
+func (s *Server) queueWebhooks(ctx context.Context, ev *Event) {
+	for i, wh := range s.cfg.Webhooks {
+		if len(wh.Events) > 0 && !slices.Contains(wh.Events, ev.Kind) {
+			continue
+		}
+		s.enqueue(ctx, JobWebhook, WebhookDelivery{Webhook: i, Event: *ev})
+	}
+}
+
+func (s *Server) deliverWebhook(ctx context.Context, job *Job) error {
+	var d WebhookDelivery
+	if err := job.Decode(&d); err != nil {
+		return err
+	}
+	if d.Webhook < 0 || d.Webhook >= len(s.cfg.Webhooks) {
+		// the webhooks changed since the job was enqueued
+		return nil
+	}
+	wh := s.cfg.Webhooks[d.Webhook]
+
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gowiki-webhook")
+	req.Header.Set("X-Wiki-Event", d.Event.Kind)
+	if wh.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(wh.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Wiki-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", wh.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,578 @@
+// Package wiki implements the wiki as an http.Handler that can be embedded
+// into any Go program:
+//
+//	srv, err := wiki.New(wiki.Config{DataDir: "data", ...})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.Handle("/", srv.Handler())
+package wiki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TemplateConfig locates the templates. Each is handed the *Data type
+// named after it as its dot, all of them embedding Common.
+type TemplateConfig struct {
+	TemplateLayoutPath  string `json:"layout_path"`
+	TemplateIncludePath string `json:"include_path"`
+}
+
+// Config is the configuration of a single wiki.
+type Config struct {
+	// BasePath is the sub-path the wiki is mounted at when it runs behind a
+	// reverse proxy, e.g. "/wiki". It is empty when served from the root.
+	BasePath string `json:"base_path"`
+	DataDir  string `json:"data_dir"`
+
+	// Storage holds the pages. Nil means a FileStorage in DataDir.
+	Storage Storage `json:"-"`
+
+	TemplateConfig
+
+	// StaticDir is served under /static/ and linked with the asset
+	// template function. Empty disables it.
+	StaticDir string `json:"static_dir"`
+
+	// Funcs are extra template functions, added to (or replacing) the
+	// default link, asset, markdown and date functions before templates are
+	// parsed.
+	Funcs template.FuncMap `json:"-"`
+
+	// Titles are the rules page titles have to follow.
+	Titles TitleRules `json:"titles"`
+
+	// MaxBodyBytes caps the size of a page submitted to /save/, 1 MiB by
+	// default.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxPageBytes is the largest page shown and edited in the browser.
+	// Larger ones, saved before the limit or by other means, are only
+	// offered as raw text. Zero means no limit.
+	MaxPageBytes int64 `json:"max_page_bytes"`
+	// Limits cap the links, directives and rendering time of pages.
+	Limits LimitsConfig `json:"limits"`
+
+	// Render tunes the buffers pages are rendered into.
+	Render RenderConfig `json:"render"`
+
+	// Tracer receives spans for handler, storage and render steps. Nil
+	// disables tracing.
+	Tracer Tracer `json:"-"`
+
+	// Redis holds sessions, rendered pages and rate limits when set, so
+	// replicas of the wiki share them. Cache, if set, is used instead.
+	Redis RedisConfig `json:"redis"`
+	Cache Cache       `json:"-"`
+
+	// Hooks are the extensions taking part in page operations. Nil means
+	// DefaultHooks.
+	Hooks *Hooks `json:"-"`
+
+	// ArchiveAfterDays archives pages that were not edited for that many
+	// days. Zero only archives pages by hand.
+	ArchiveAfterDays int `json:"archive_after_days"`
+
+	// JobWorkers is the number of goroutines running background jobs.
+	// Zero means one.
+	JobWorkers int `json:"job_workers"`
+	// JobDir keeps pending jobs across restarts. Empty keeps them in
+	// memory only.
+	JobDir string `json:"job_dir"`
+
+	// PublicURL is the scheme and host readers reach the wiki at, e.g.
+	// "https://wiki.example.com", used for links in mail.
+	PublicURL string `json:"public_url"`
+
+	// Mail is the SMTP server used for outgoing mail, unless Mailer is set.
+	Mail   MailConfig `json:"mail"`
+	Mailer Mailer     `json:"-"`
+
+	// Digest mails a summary of the changed pages every day or week.
+	Digest DigestConfig `json:"digest"`
+
+	// Search weighs where the words of a search are found.
+	Search SearchConfig `json:"search"`
+
+	// Dates sets the time zone and formats times are shown in.
+	Dates DateConfig `json:"dates"`
+
+	// Namespaces override settings for the pages they match. The first
+	// one matching a page applies.
+	Namespaces []NamespaceConfig `json:"namespaces"`
+
+	// Secrets sign session cookies, CSRF tokens and share links. The
+	// first one signs and all are accepted, so a secret is rotated by
+	// adding a new one in front. Without any, a secret is generated on the
+	// first run and kept in .secret in DataDir, one per line likewise.
+	Secrets []string `json:"secrets"`
+
+	// Shares sets up the links that let people without an account read
+	// single pages of namespaces requiring it.
+	Shares ShareConfig `json:"shares"`
+
+	// Anonymous sets how edits made without signing in are attributed.
+	Anonymous AnonymousConfig `json:"anonymous"`
+
+	// Spam enables the built-in checks on anonymous edits.
+	Spam SpamConfig `json:"spam"`
+
+	// Accounts lets users sign up and sign in to the wiki itself.
+	Accounts AccountsConfig `json:"accounts"`
+
+	// Moderation holds some edits for an admin to approve.
+	Moderation ModerationConfig `json:"moderation"`
+
+	// LinkCheck periodically looks for external links that stopped working.
+	LinkCheck LinkCheckConfig `json:"link_check"`
+
+	// WarmUp builds the search index and renders the popular pages before
+	// the wiki reports itself ready.
+	WarmUp WarmUpConfig `json:"warm_up"`
+
+	// Inbox files mail as pages.
+	Inbox InboxConfig `json:"inbox"`
+
+	// Webhooks are posted the wiki's events, such as pages being saved.
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	// Attachments limits uploads and enables virus scanning.
+	Attachments AttachmentConfig `json:"attachments"`
+
+	// Quotas cap the storage users and namespaces take up.
+	Quotas QuotaConfig `json:"quotas"`
+
+	// Mounts show external sources as read-only pages.
+	Mounts []MountConfig `json:"mounts"`
+
+	// Rewrites send the addresses of pages in the system the wiki was
+	// migrated from to their pages here; the first matching rule applies.
+	Rewrites []RewriteConfig `json:"rewrites"`
+
+	// ReadOnly starts the wiki in read-only mode; see Server.SetReadOnly.
+	ReadOnly bool `json:"read_only"`
+
+	// Collaboration lets signed-in users edit a page together, seeing each
+	// other's changes live. Without it, concurrent edits are caught when
+	// saving and the later one is sent back to be merged.
+	Collaboration bool `json:"collaboration"`
+
+	// Headless serves only the JSON API and attachments, for a front end
+	// of its own: no templates are loaded, so the template paths needn't
+	// exist, and errors everywhere are answered as the API answers them.
+	// Users are those Middleware sets with WithUser, or sign in to their
+	// accounts through /api/v1/session.
+	Headless bool `json:"headless"`
+
+	// Middleware is applied around every route, outermost first. It runs
+	// after the request ID is assigned and inside panic recovery.
+	Middleware []Middleware `json:"-"`
+}
+
+// Server is one wiki: its pages, templates and settings.
+type Server struct {
+	cfg Config
+
+	templates     templateRegistry
+	bufpool       bufferPool
+	tracer        Tracer
+	hooks         *Hooks
+	titles        *titleValidator
+	anonAuthor    func(netip.Addr) string
+	readOnly      atomic.Bool
+	warm          atomic.Bool
+	store         Storage
+	views         ViewCounter
+	moderation    ModerationQueue
+	annotations   AnnotationStore
+	accounts      AccountStore
+	notifications NotificationStore
+	userData      UserDataStore
+	shares        ShareStore
+	keys          *keyring
+	mailer        Mailer
+	cache         Cache
+	spamChecks    []SpamCheck
+	scanners      []UploadHook
+	jobs          *Queue
+	startJobs     sync.Once
+	stop          chan struct{}
+
+	// collaborative editing sessions, by page title
+	collabMu sync.Mutex
+	sessions map[string]*collabSession
+
+	presenceTracker presenceTracker
+
+	logins       loginSessions
+	failedLogins atomic.Int64
+
+	linksMu    sync.Mutex
+	linkReport *BrokenLinksReport
+
+	index      searchIndex
+	attached   attachmentIndex
+	mounts     []*mount
+	rewrites   []*rewriteRule
+	patchMu    sync.Mutex
+	userDataMu sync.Mutex
+}
+
+// defaultMaxBodyBytes is Config.MaxBodyBytes when it is left zero.
+const defaultMaxBodyBytes = 1 << 20
+
+// New builds a wiki from cfg, loading its templates up front so that
+// mistakes in them are reported here rather than on the first request.
+func New(cfg Config) (*Server, error) {
+	cfg.BasePath = CleanBasePath(cfg.BasePath)
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	s := &Server{
+		cfg:        cfg,
+		tracer:     cfg.Tracer,
+		hooks:      cfg.Hooks,
+		store:      cfg.Storage,
+		mailer:     cfg.Mailer,
+		spamChecks: cfg.Spam.checks(cfg.PublicURL),
+		stop:       make(chan struct{}),
+	}
+	if s.tracer == nil {
+		s.tracer = noopTracer{}
+	}
+	if s.hooks == nil {
+		s.hooks = DefaultHooks
+	}
+	if s.store == nil {
+		s.store = NewFileStorage(cfg.DataDir)
+	}
+	if s.mailer == nil {
+		s.mailer = &smtpMailer{cfg: cfg.Mail}
+	}
+	switch {
+	case cfg.Cache != nil:
+		s.cache, s.logins.cache = cfg.Cache, cfg.Cache
+	case cfg.Redis.Addr != "":
+		rc := newRedisCache(cfg.Redis)
+		s.cache, s.logins.cache = rc, rc
+	default:
+		s.cache, s.logins.cache = &memoryCache{max: maxCacheEntries}, &memoryCache{}
+	}
+	if vc, ok := s.store.(ViewCounter); ok {
+		s.views = vc
+	} else {
+		s.views = &viewCounts{}
+	}
+	if mq, ok := s.store.(ModerationQueue); ok {
+		s.moderation = mq
+	} else {
+		s.moderation = &pendingEdits{}
+	}
+	if as, ok := s.store.(AnnotationStore); ok {
+		s.annotations = as
+	} else {
+		s.annotations = &pageNotes{}
+	}
+	if ns, ok := s.store.(NotificationStore); ok {
+		s.notifications = ns
+	} else {
+		s.notifications = &userNotifications{}
+	}
+	if us, ok := s.store.(UserDataStore); ok {
+		s.userData = us
+	} else {
+		s.userData = &userDataMap{}
+	}
+	if ss, ok := s.store.(ShareStore); ok {
+		s.shares = ss
+	} else {
+		s.shares = &shareList{}
+	}
+	if as, ok := s.store.(AccountStore); ok {
+		s.accounts = as
+	} else {
+		s.accounts = &accountList{}
+	}
+
+	if cfg.Dates.TimeZone != "" {
+		if _, err := loadLocation(cfg.Dates.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if s.keys, err = newKeyring(cfg.Secrets, cfg.DataDir); err != nil {
+		return nil, err
+	}
+	if s.bufpool, err = cfg.Render.pool(); err != nil {
+		return nil, err
+	}
+	if s.titles, err = newTitleValidator(cfg.Titles); err != nil {
+		return nil, err
+	}
+	if s.anonAuthor, err = cfg.Anonymous.attributor(); err != nil {
+		return nil, err
+	}
+	s.readOnly.Store(cfg.ReadOnly)
+	if s.scanners, err = cfg.Attachments.scanners(); err != nil {
+		return nil, err
+	}
+	if !cfg.Headless {
+		if err := s.loadTemplates(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Accounts.VerifyEmail && (cfg.PublicURL == "" || cfg.Mailer == nil && cfg.Mail.Addr == "") {
+		return nil, errors.New("accounts: verify_email needs mail and public_url, for the links it sends")
+	}
+
+	s.jobs = NewQueue(cfg.JobWorkers, cfg.JobDir)
+	if len(cfg.Digest.Recipients) > 0 {
+		if _, err := cfg.Digest.interval(); err != nil {
+			return nil, err
+		}
+		s.jobs.Handle(JobDigest, s.sendDigest)
+	}
+	if _, err := cfg.LinkCheck.interval(); err != nil {
+		return nil, err
+	}
+	s.jobs.Handle(JobLinkCheck, s.checkLinks)
+	s.jobs.Handle(JobNoteAdded, s.notifyNoteAdded)
+	s.jobs.Handle(JobVerifyEmail, s.sendVerification)
+	if err := s.subscribe(); err != nil {
+		return nil, err
+	}
+	// after subscribe, for the admin's user.created
+	if cfg.Accounts.Enabled {
+		if err := s.createFirstAdmin(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	for _, mc := range cfg.Mounts {
+		if err := mc.check(); err != nil {
+			return nil, err
+		}
+		for _, m := range s.mounts {
+			if m.contains(mc.Prefix+"/") || strings.HasPrefix(m.cfg.Prefix+"/", mc.Prefix+"/") {
+				return nil, fmt.Errorf("mounts %s and %s overlap", m.cfg.Prefix, mc.Prefix)
+			}
+		}
+		s.mounts = append(s.mounts, &mount{cfg: mc})
+	}
+	s.loadMounts()
+	s.jobs.Handle(JobMountRefresh, s.refreshMount)
+	if err := s.checkInbox(); err != nil {
+		return nil, err
+	}
+	if cfg.Inbox.IMAP != "" {
+		s.jobs.Handle(JobInboxPoll, s.fetchInbox)
+	}
+	for _, rc := range cfg.Rewrites {
+		rule, err := newRewriteRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		s.rewrites = append(s.rewrites, rule)
+	}
+
+	return s, nil
+}
+
+// Jobs returns the background queue, so extensions can handle the jobs
+// the wiki enqueues (JobNoteAdded, JobDigest, JobLinkCheck,
+// JobMountRefresh, JobWebhook, JobInboxPoll, and the events, see
+// Subscribe) or add their own. Handlers must be registered before Handler
+// is called, which starts the queue and replays the jobs persisted by a
+// previous run.
+func (s *Server) Jobs() *Queue {
+	return s.jobs
+}
+
+// Close stops background work and closes the storage if it needs closing.
+// The handler must not be used afterwards.
+func (s *Server) Close() error {
+	close(s.stop)
+	s.closeSessions()
+	s.jobs.Close()
+	if c, ok := s.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Handler returns the routes of this wiki, mounted under its base path.
+func (s *Server) Handler() http.Handler {
+	s.startJobs.Do(func() {
+		if err := s.jobs.Start(); err != nil {
+			log.Printf("jobs: %v", err)
+		}
+		if len(s.cfg.Digest.Recipients) > 0 {
+			interval, _ := s.cfg.Digest.interval()
+			go s.scheduleDigests(interval)
+		}
+		s.refreshMounts()
+		if s.cfg.LinkCheck.Enabled {
+			interval, _ := s.cfg.LinkCheck.interval()
+			go s.schedule("links", interval, func(last, now time.Time) error {
+				return s.jobs.Enqueue(JobLinkCheck, struct{}{})
+			})
+		}
+		if s.cfg.Inbox.IMAP != "" {
+			go s.pollInbox()
+		}
+		if s.cfg.WarmUp.Enabled {
+			go s.warmUp()
+		}
+	})
+
+	mux := http.NewServeMux()
+	s.apiRoutes(mux)
+	if !s.cfg.Headless {
+		s.pageRoutes(mux)
+	}
+
+	middleware := append([]Middleware{RequestID, s.traceRequests, s.recoverPanics, s.readFlash, negotiateLocale}, s.cfg.Middleware...)
+	if s.cfg.Accounts.Enabled {
+		// after the program's middleware, which may have identified the user
+		middleware = append(middleware, s.authenticate)
+	}
+	middleware = append(middleware, s.guardReadOnly)
+
+	return Chain(s.rewriteLegacy(s.themedNotFound(mux)), middleware...)
+}
+
+// pageRoutes adds the HTML pages of the wiki to mux. Patterns carry the
+// method, so the mux answers other methods with 405 and an Allow header.
+// GET also matches HEAD.
+func (s *Server) pageRoutes(mux *http.ServeMux) {
+	base := s.cfg.BasePath
+	mux.HandleFunc("GET "+base+"/{$}", s.handle(s.indexHandler))
+	mux.HandleFunc("GET "+base+"/view/{title...}", s.makeHandler(s.viewHandler))
+	mux.HandleFunc("GET "+base+"/edit/{title...}", s.makeHandler(s.editing(s.editHandler)))
+	mux.HandleFunc("GET "+base+"/raw/{title...}", s.makeHandler(s.rawHandler))
+	mux.HandleFunc("POST "+base+"/save/{title...}", s.makeHandler(s.editing(s.saveHandler)))
+	mux.HandleFunc("POST "+base+"/delete/{title...}", s.makeHandler(s.editing(s.deleteHandler)))
+	mux.HandleFunc("POST "+base+"/rename/{title...}", s.makeHandler(s.editing(s.renameHandler)))
+	mux.HandleFunc("GET "+base+"/p/{id}", s.handle(s.permalinkHandler))
+	mux.HandleFunc("GET "+base+"/compare", s.handle(s.compareHandler))
+	mux.HandleFunc("GET "+base+"/book/{title...}", s.makeHandler(s.bookHandler))
+	mux.HandleFunc("GET "+base+"/share/{title...}", s.makeHandler(s.shareFormHandler))
+	mux.HandleFunc("POST "+base+"/share/{title...}", s.makeHandler(s.shareHandler))
+	mux.HandleFunc("POST "+base+"/unshare/{title...}", s.makeHandler(s.unshareHandler))
+	mux.HandleFunc("GET "+base+"/shared/{token}", s.handle(s.sharedHandler))
+	if s.cfg.Collaboration {
+		mux.HandleFunc("GET "+base+"/collab/{title...}", s.makeHandler(s.collabHandler))
+	}
+	mux.HandleFunc("POST "+base+"/upload/{title...}", s.makeHandler(s.editing(s.uploadHandler)))
+	mux.HandleFunc("POST "+base+"/detach/{title...}", s.makeHandler(s.editing(s.detachHandler)))
+	mux.HandleFunc("POST "+base+"/presence/{title...}", s.makeHandler(s.presenceHandler))
+	mux.HandleFunc("POST "+base+"/annotate/{title...}", s.makeHandler(s.noting(s.annotateHandler)))
+	mux.HandleFunc("POST "+base+"/resolve/{title...}", s.makeHandler(s.noting(s.resolveHandler)))
+	mux.HandleFunc("GET "+base+"/user/{name}", s.handle(s.userHandler))
+	mux.HandleFunc("POST "+base+"/watch/{title...}", s.makeHandler(s.watchHandler))
+	mux.HandleFunc("POST "+base+"/star/{title...}", s.makeHandler(s.starHandler))
+	mux.HandleFunc("GET "+base+"/favorites", s.handle(s.favoritesHandler))
+	mux.HandleFunc("GET "+base+"/account", s.handle(s.accountHandler))
+	mux.HandleFunc("GET "+base+"/account/export", s.handle(s.exportHandler))
+	mux.HandleFunc("POST "+base+"/account/delete", s.handle(s.deleteAccountHandler))
+	mux.HandleFunc("GET "+base+"/account/dates", s.handle(s.datesFormHandler))
+	mux.HandleFunc("POST "+base+"/account/dates", s.handle(s.datesHandler))
+	mux.HandleFunc("GET "+base+"/notifications", s.handle(s.notificationsHandler))
+	mux.HandleFunc("POST "+base+"/notifications/read", s.handle(s.markReadHandler))
+	mux.HandleFunc("GET "+base+"/special/popular", s.handle(s.popularHandler))
+	mux.HandleFunc("GET "+base+"/graph", s.handle(s.graphHandler))
+	mux.HandleFunc("GET "+base+"/search", s.handle(s.searchHandler))
+	mux.HandleFunc("GET "+base+"/searches", s.handle(s.savedSearchesHandler))
+	mux.HandleFunc("POST "+base+"/searches", s.handle(s.saveSearchHandler))
+	mux.HandleFunc("GET "+base+"/export/{title...}", s.handle(s.pageExportHandler))
+	mux.HandleFunc("GET "+base+"/export/namespace/{name...}", s.handle(s.namespaceExportHandler))
+	mux.HandleFunc("POST "+base+"/searches/pin", s.handle(s.pinSearchHandler))
+	mux.HandleFunc("POST "+base+"/searches/delete", s.handle(s.deleteSearchHandler))
+	mux.HandleFunc("GET "+base+"/admin", s.handle(s.requireAdmin(s.dashboardHandler)))
+	mux.HandleFunc("GET "+base+"/admin/bundle", s.handle(s.requireAdmin(s.bundleHandler)))
+	mux.HandleFunc("POST "+base+"/admin/read-only", s.handle(s.requireAdmin(s.readOnlyHandler)))
+	mux.HandleFunc("GET "+base+"/admin/head/{title...}", s.handle(s.requireAdmin(s.withTitle(s.headFormHandler))))
+	mux.HandleFunc("POST "+base+"/admin/head/{title...}", s.handle(s.requireAdmin(s.withTitle(s.headHandler))))
+	mux.HandleFunc("GET "+base+"/admin/moderation", s.handle(s.requireAdmin(s.moderationHandler)))
+	mux.HandleFunc("POST "+base+"/admin/moderation/{id}", s.handle(s.requireAdmin(s.moderateHandler)))
+	mux.HandleFunc("GET "+base+"/special/broken-links", s.handle(s.brokenLinksHandler))
+	mux.HandleFunc("POST "+base+"/admin/check-links", s.handle(s.requireAdmin(s.checkLinksHandler)))
+	mux.HandleFunc("GET "+base+"/admin/replace", s.handle(s.requireAdmin(s.replaceHandler)))
+	mux.HandleFunc("POST "+base+"/admin/replace", s.handle(s.requireAdmin(s.applyReplaceHandler)))
+	mux.HandleFunc("GET "+base+"/admin/archive", s.handle(s.requireAdmin(s.archiveReportHandler)))
+	mux.HandleFunc("POST "+base+"/admin/archive/{title...}", s.handle(s.requireAdmin(s.withTitle(s.archiveHandler))))
+	if s.cfg.StaticDir != "" {
+		mux.Handle("GET "+base+"/static/", http.StripPrefix(base+"/static/", http.FileServer(http.Dir(s.cfg.StaticDir))))
+	}
+	if s.cfg.Accounts.Enabled {
+		mux.HandleFunc("GET "+base+"/login", s.handle(s.loginFormHandler))
+		mux.HandleFunc("POST "+base+"/login", s.handle(s.loginHandler))
+		mux.HandleFunc("POST "+base+"/logout", s.handle(s.logoutHandler))
+		mux.HandleFunc("GET "+base+"/account/password", s.handle(s.passwordFormHandler))
+		mux.HandleFunc("POST "+base+"/account/password", s.handle(s.passwordHandler))
+		mux.HandleFunc("GET "+base+"/account/verify", s.handle(s.verifyFormHandler))
+		mux.HandleFunc("POST "+base+"/account/verify", s.handle(s.resendHandler))
+		if s.cfg.Accounts.Signup {
+			mux.HandleFunc("GET "+base+"/signup", s.handle(s.signupFormHandler))
+			mux.HandleFunc("POST "+base+"/signup", s.handle(s.signupHandler))
+		}
+		mux.HandleFunc("GET "+base+"/admin/users", s.handle(s.requireAdmin(s.usersHandler)))
+		mux.HandleFunc("POST "+base+"/admin/users/{name}", s.handle(s.requireAdmin(s.changeUserHandler)))
+	}
+}
+
+// apiRoutes adds the JSON API to mux, and the attachments it links to,
+// which headless wikis serve alone.
+func (s *Server) apiRoutes(mux *http.ServeMux) {
+	base := s.cfg.BasePath
+	mux.HandleFunc("GET "+base+"/attachment/{path...}", s.handle(s.attachmentHandler))
+	mux.HandleFunc("GET "+base+"/notifications/unread", s.handleAPI(s.unreadHandler))
+	mux.HandleFunc("GET "+base+apiPrefix+"/graph", s.handleAPI(s.apiGraphHandler))
+	mux.HandleFunc("GET "+base+apiPrefix+"/search", s.handleAPI(s.apiSearchHandler))
+	mux.HandleFunc("GET "+base+apiPrefix+"/quickopen", s.handleAPI(s.quickOpenHandler))
+	mux.HandleFunc("POST "+base+apiPrefix+"/batch", s.handleAPI(s.batchHandler))
+	mux.HandleFunc("GET "+base+apiPrefix+"/pages", s.handleAPI(s.apiPagesHandler))
+	mux.HandleFunc("GET "+base+apiPrefix+"/pages/{title...}", s.handleAPI(s.apiPageHandler))
+	mux.HandleFunc("PATCH "+base+apiPrefix+"/pages/{title...}", s.handleAPI(s.patchHandler))
+	mux.HandleFunc("POST "+base+apiPrefix+"/import", s.handleAPI(s.requireAdmin(s.importPageHandler)))
+	mux.HandleFunc("POST "+base+apiPrefix+"/import/{format}", s.handleAPI(s.requireAdmin(s.importVaultHandler)))
+	mux.HandleFunc("POST "+base+apiPrefix+"/inbox", s.handleAPI(s.inboxHandler))
+	mux.HandleFunc("GET "+base+apiPrefix+"/bundle", s.handleAPI(s.requireAdmin(s.bundleHandler)))
+	mux.HandleFunc("POST "+base+apiPrefix+"/bundle/verify", s.handleAPI(s.requireAdmin(s.verifyBundleHandler)))
+	mux.HandleFunc("GET "+base+apiPrefix+"/read-only", s.handleAPI(s.apiReadOnlyHandler))
+	mux.HandleFunc("PUT "+base+apiPrefix+"/read-only", s.handleAPI(s.requireAdmin(s.setReadOnlyHandler)))
+	if s.cfg.Accounts.Enabled {
+		mux.HandleFunc("GET "+base+apiPrefix+"/session", s.handleAPI(s.sessionHandler))
+		mux.HandleFunc("POST "+base+apiPrefix+"/session", s.handleAPI(s.logInHandler))
+		mux.HandleFunc("DELETE "+base+apiPrefix+"/session", s.handleAPI(s.logOutHandler))
+		mux.HandleFunc("GET "+base+apiPrefix+"/users", s.handleAPI(s.requireAdmin(s.apiUsersHandler)))
+		mux.HandleFunc("GET "+base+apiPrefix+"/users/{name}", s.handleAPI(s.requireAdmin(s.apiUserHandler)))
+		mux.HandleFunc("PATCH "+base+apiPrefix+"/users/{name}", s.handleAPI(s.requireAdmin(s.apiChangeUserHandler)))
+	}
+}
+
+// BasePath returns the normalized prefix the wiki is mounted at.
+func (s *Server) BasePath() string {
+	return s.cfg.BasePath
+}
+
+// ReloadTemplates parses the templates again and switches to them without
+// interrupting requests being served. On error the current templates stay
+// in use. A headless wiki has none to reload.
+func (s *Server) ReloadTemplates() error {
+	if s.cfg.Headless {
+		return nil
+	}
+	return s.loadTemplates()
+}
@@ -0,0 +1,104 @@
+package wikitest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io/fs"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ondoheer/gowiki/wiki"
+)
+
+// MemoryStorage keeps pages in memory, maintaining their metadata as
+// wiki.FileStorage does. It implements wiki.Storage only, so the wiki
+// keeps accounts, shares and user data in memory too and has no notes or
+// attachments; tests needing those can embed it in a type adding the
+// optional interfaces, or use a FileStorage in t.TempDir().
+type MemoryStorage struct {
+	mu    sync.Mutex
+	pages map[string]*wiki.Page
+}
+
+// NewMemoryStorage returns an empty storage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{pages: make(map[string]*wiki.Page)}
+}
+
+// copyPage returns a copy of p that shares nothing with it the wiki may
+// change.
+func copyPage(p *wiki.Page) *wiki.Page {
+	c := *p
+	c.Body = append([]byte(nil), p.Body...)
+	c.Tags = append([]string(nil), p.Tags...)
+	return &c
+}
+
+func (st *MemoryStorage) Load(ctx context.Context, title string) (*wiki.Page, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	p, ok := st.pages[title]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return copyPage(p), nil
+}
+
+func (st *MemoryStorage) Save(ctx context.Context, p *wiki.Page) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now().UTC()
+	saved := copyPage(p)
+	saved.CreatedAt, saved.Revision, saved.Head = now, 0, nil
+	if old, ok := st.pages[p.Title]; ok {
+		saved.ID, saved.CreatedAt, saved.Revision, saved.Head = old.ID, old.CreatedAt, old.Revision, old.Head
+	}
+	if saved.ID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		saved.ID = hex.EncodeToString(b)
+	}
+	saved.UpdatedAt = now
+	saved.Revision++
+	// only what the storage keeps; the rest is filled in when shown
+	saved.Views, saved.Annotations, saved.Present, saved.Attachments = 0, nil, nil, nil
+	saved.Watching, saved.Starred, saved.TooLarge, saved.Section = false, false, false, nil
+	st.pages[p.Title] = saved
+
+	p.ID = saved.ID
+	p.CreatedAt = saved.CreatedAt
+	p.UpdatedAt = saved.UpdatedAt
+	p.Revision = saved.Revision
+	return nil
+}
+
+func (st *MemoryStorage) Delete(ctx context.Context, title string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.pages[title]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(st.pages, title)
+	return nil
+}
+
+// List returns the pages sorted by title, without their bodies, as
+// FileStorage does.
+func (st *MemoryStorage) List(ctx context.Context) ([]*wiki.Page, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	pages := make([]*wiki.Page, 0, len(st.pages))
+	for _, p := range st.pages {
+		c := copyPage(p)
+		c.Body = nil
+		pages = append(pages, c)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Title < pages[j].Title })
+	return pages, nil
+}
@@ -0,0 +1,238 @@
+// Package wikitest runs a wiki inside a test, for programs embedding the
+// wiki and authors of hooks, directives and middleware to test them with
+// the wiki around them:
+//
+//	func TestGreeting(t *testing.T) {
+//		w := wikitest.New(t, func(cfg *wiki.Config) {
+//			cfg.Hooks = &wiki.Hooks{}
+//			cfg.Hooks.OnPageRender(greet)
+//		})
+//		w.CreatePage("Home", "Welcome")
+//		if html := w.Login("ann", wiki.RoleEditor).GetRendered("Home"); !strings.Contains(html, "Hello") {
+//			t.Errorf("no greeting in %s", html)
+//		}
+//	}
+//
+// The wiki keeps its pages in a MemoryStorage and is served without a
+// network: Clients hand their requests straight to its handler.
+package wikitest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ondoheer/gowiki/wiki"
+)
+
+// baseURL is the address the requests of Clients go to; any would do, as
+// they never leave the process.
+const baseURL = "http://wiki.test"
+
+// Wiki is a wiki running for a test.
+type Wiki struct {
+	// Server is the wiki, for calling its methods directly.
+	Server *wiki.Server
+	// Storage holds its pages: a MemoryStorage, unless the configuration
+	// was given another.
+	Storage wiki.Storage
+
+	t       testing.TB
+	handler http.Handler
+}
+
+// Templates returns the directory of the wiki's own templates, next to
+// the source of this package.
+func Templates() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "templates")
+}
+
+// New starts a wiki with the configuration configure leaves, which starts
+// out with a MemoryStorage, the wiki's own templates and the limits of
+// the gowiki command, and stops it when the test ends. It fails the test if the wiki can't start.
+func New(t testing.TB, configure ...func(cfg *wiki.Config)) *Wiki {
+	t.Helper()
+	st := NewMemoryStorage()
+	templates := Templates()
+	// the limits are those of the gowiki command
+	cfg := wiki.Config{Storage: st, PublicURL: baseURL, MaxBodyBytes: 1 << 20, MaxPageBytes: 8 << 20}
+	cfg.TemplateLayoutPath = filepath.Join(templates, "layouts") + "/"
+	cfg.TemplateIncludePath = templates + "/"
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	srv, err := wiki.New(cfg)
+	if err != nil {
+		t.Fatalf("starting the wiki: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return &Wiki{Server: srv, Storage: cfg.Storage, t: t, handler: srv.Handler()}
+}
+
+// Context returns a context acting as user, nil for anonymous, for calling
+// the methods of Server.
+func (w *Wiki) Context(user *wiki.User) context.Context {
+	ctx := context.Background()
+	if user != nil {
+		ctx = wiki.WithUser(ctx, user)
+	}
+	return ctx
+}
+
+// admin is who the helpers of Wiki act as.
+var admin = &wiki.User{Name: "wikitest", Role: wiki.RoleAdmin}
+
+// CreatePage saves a page as an admin would, with its hooks, and returns
+// it once the jobs of saving it ran, so it is indexed for search. Existing
+// pages are replaced. It fails the test if the page can't be saved.
+func (w *Wiki) CreatePage(title, body string, tags ...string) *wiki.Page {
+	w.t.Helper()
+	ctx := w.Context(admin)
+	op := &wiki.BatchOperation{Op: wiki.OpCreate, Title: title, Body: body, Tags: tags}
+	if _, err := w.Storage.Load(ctx, title); err == nil {
+		op.Op = wiki.OpUpdate
+	}
+	if _, err := w.Server.ChangePage(ctx, op); err != nil {
+		w.t.Fatalf("saving %s: %v", title, err)
+	}
+	w.WaitJobs()
+	p, err := w.Server.GetPage(ctx, title)
+	if err != nil {
+		w.t.Fatalf("loading %s: %v", title, err)
+	}
+	return p
+}
+
+// WaitJobs waits for the background jobs enqueued so far, such as
+// notifications and indexing, failing the test after ten seconds.
+func (w *Wiki) WaitJobs() {
+	w.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := w.Server.Jobs().Wait(ctx); err != nil {
+		w.t.Fatalf("waiting for jobs: %v", err)
+	}
+}
+
+// Client returns an anonymous client.
+func (w *Wiki) Client() *Client {
+	return w.newClient(nil)
+}
+
+// Login returns a client signed in as name with role, as if the program's
+// authentication identified them. Wikis with accounts enabled don't need
+// the account to exist; use Client and post to /login to test signing in
+// itself.
+func (w *Wiki) Login(name string, role wiki.Role) *Client {
+	return w.newClient(&wiki.User{Name: name, Role: role})
+}
+
+func (w *Wiki) newClient(user *wiki.User) *Client {
+	jar, _ := cookiejar.New(nil)
+	c := &Client{wiki: w, user: user}
+	c.HTTP = &http.Client{
+		Transport: roundTripper{c},
+		Jar:       jar,
+		// the tests see the redirects the wiki answers with
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return c
+}
+
+// Client makes requests to the wiki without a network, keeping cookies as
+// a browser does. Redirects are not followed.
+type Client struct {
+	// HTTP sends the client's requests, for those the helpers don't cover.
+	// Their URLs are made with URL.
+	HTTP *http.Client
+
+	wiki *Wiki
+	user *wiki.User
+}
+
+// URL returns the absolute URL of a path below the wiki's base path, such
+// as "/view/Home".
+func (c *Client) URL(p string) string {
+	return baseURL + path.Join("/", c.wiki.Server.BasePath(), p)
+}
+
+// Get requests a path of the wiki and fails the test if it can't.
+func (c *Client) Get(p string) *http.Response {
+	c.wiki.t.Helper()
+	resp, err := c.HTTP.Get(c.URL(p))
+	if err != nil {
+		c.wiki.t.Fatalf("GET %s: %v", p, err)
+	}
+	return resp
+}
+
+// PostForm posts a form to a path of the wiki, as its pages do, and fails
+// the test if it can't.
+func (c *Client) PostForm(p string, form url.Values) *http.Response {
+	c.wiki.t.Helper()
+	resp, err := c.HTTP.PostForm(c.URL(p), form)
+	if err != nil {
+		c.wiki.t.Fatalf("POST %s: %v", p, err)
+	}
+	return resp
+}
+
+// GetRendered returns the page showing a wiki page, failing the test
+// unless it is shown.
+func (c *Client) GetRendered(title string) string {
+	c.wiki.t.Helper()
+	resp := c.Get("/view/" + title)
+	body := ReadBody(c.wiki.t, resp)
+	if resp.StatusCode != http.StatusOK {
+		c.wiki.t.Fatalf("GET /view/%s: %s: %s", title, resp.Status, body)
+	}
+	return body
+}
+
+// ReadBody returns the body of resp, closing it.
+func ReadBody(t testing.TB, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading the response: %v", err)
+	}
+	return string(b)
+}
+
+// roundTripper serves the requests of a client with the wiki's handler.
+type roundTripper struct {
+	client *Client
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	r.RequestURI = req.URL.RequestURI()
+	r.RemoteAddr = "192.0.2.1:1234"
+	// as a browser says of requests from the wiki's own pages
+	if r.Header.Get("Sec-Fetch-Site") == "" {
+		r.Header.Set("Sec-Fetch-Site", "same-origin")
+	}
+	if rt.client.user != nil {
+		r = r.WithContext(wiki.WithUser(r.Context(), rt.client.user))
+	}
+
+	rec := httptest.NewRecorder()
+	rt.client.wiki.handler.ServeHTTP(rec, r)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}